@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"clispot/internal/library"
+	"clispot/internal/ui"
+)
+
+// checkReport is the JSON form of a check run
+type checkReport struct {
+	Root     string                `json:"root"`
+	Files    int                   `json:"files"`
+	Errors   int                   `json:"errors"`
+	Warnings int                   `json:"warnings"`
+	Results  []library.CheckResult `json:"results"`
+}
+
+// runCheck decodes every file in the library and reports the ones that
+// are broken or badly tagged. It fails when any file has an error, so
+// scripts can use the exit status.
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	musicDir := fs.String("dir", defaultMusicDir(), "Directory containing MP3 files")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	all := fs.Bool("all", false, "Include files without problems in the JSON report")
+	quick := fs.Bool("quick", false, "Only walk the audio frames instead of decoding every file")
+	tui := fs.Bool("tui", false, "Show the report in an interactive panel")
+	maxArt := fs.Int("max-art", library.DefaultMaxArtSize>>10, "Warn about embedded pictures larger than this many KB")
+	fs.Parse(args)
+
+	root, err := filepath.Abs(*musicDir)
+	if err != nil {
+		return err
+	}
+
+	lib := library.NewLibrary(root)
+	results, err := lib.Check(library.CheckOptions{
+		MaxArtSize: *maxArt << 10,
+		Quick:      *quick,
+		Progress: func(done, total int, path string) {
+			fmt.Fprintf(os.Stderr, "\rChecking %d/%d", done, total)
+			if done == total {
+				fmt.Fprintln(os.Stderr)
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	report := checkReport{Root: root, Files: len(results)}
+	for _, result := range results {
+		switch {
+		case result.HasErrors():
+			report.Errors++
+		case len(result.Issues) > 0:
+			report.Warnings++
+		}
+		if *all || len(result.Issues) > 0 {
+			report.Results = append(report.Results, result)
+		}
+	}
+
+	switch {
+	case *tui:
+		if err := ui.RunCheckReport(root, results); err != nil {
+			return err
+		}
+	case *asJSON:
+		if report.Results == nil {
+			report.Results = []library.CheckResult{}
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshaling report: %v", err)
+		}
+		fmt.Println(string(data))
+	default:
+		printCheckReport(report)
+	}
+
+	if report.Errors > 0 {
+		return fmt.Errorf("%d of %d files have errors", report.Errors, report.Files)
+	}
+	return nil
+}
+
+func printCheckReport(report checkReport) {
+	for _, result := range report.Results {
+		if len(result.Issues) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s\n", relativePath(report.Root, result.Path))
+		for _, issue := range result.Issues {
+			fmt.Printf("  %-7s %-13s %s\n", issue.Severity, issue.Kind, issue.Message)
+		}
+	}
+	if report.Errors+report.Warnings > 0 {
+		fmt.Println()
+	}
+	fmt.Printf("Checked %d files: %d with errors, %d with warnings\n", report.Files, report.Errors, report.Warnings)
+}
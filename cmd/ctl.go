@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"clispot/internal/command"
+	"clispot/internal/daemon"
+	"clispot/internal/stream"
+)
+
+const ctlUsage = `usage: clispot ctl [-socket path] [-json] <command> [args]
+
+Commands:
+  play [path|url]    resume, or play a song, folder or stream
+  pause              pause
+  toggle             play or pause
+  stop               stop
+  next, prev         skip forward or back
+  status             show what is playing
+  enqueue <path>...  add songs or folders to the queue
+  seek <position>    jump to 2:30, or by +10 or -10 seconds
+  volume <level>     set the volume to 0-100, or change it by +5 or -5
+  repeat [mode]      set repeat to off, one or all, or cycle through them
+  quit               stop the daemon`
+
+// runCtl sends one command to a running daemon and prints the status it
+// replies with
+func runCtl(args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", daemon.SocketPath(), "Control socket of the daemon")
+	asJSON := fs.Bool("json", false, "Print the status as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), ctlUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	client, err := daemon.Dial(*socket)
+	if err != nil {
+		return fmt.Errorf("%v (is clispot daemon running?)", err)
+	}
+	defer client.Close()
+
+	status, err := ctlCall(client, fs.Arg(0), fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(status)
+	}
+	printStatus(status)
+	return nil
+}
+
+// ctlCall runs the command name with args on the daemon
+func ctlCall(client *daemon.Client, name string, args []string) (daemon.Status, error) {
+	noArgs := func(method string) (daemon.Status, error) {
+		if err := command.Expect(args, 0, 0, name); err != nil {
+			return daemon.Status{}, err
+		}
+		return client.Call(method, daemon.Args{})
+	}
+
+	switch name {
+	case "play":
+		if err := command.Expect(args, 0, 1, "play [path|url]"); err != nil {
+			return daemon.Status{}, err
+		}
+		var play daemon.PlayArgs
+		if len(args) == 1 {
+			path, err := ctlPath(args[0])
+			if err != nil {
+				return daemon.Status{}, err
+			}
+			play.Path = path
+		}
+		return client.Call("Play", play)
+	case "pause":
+		return noArgs("Pause")
+	case "toggle":
+		return noArgs("Toggle")
+	case "stop":
+		return noArgs("Stop")
+	case "next":
+		return noArgs("Next")
+	case "prev", "previous":
+		return noArgs("Previous")
+	case "status":
+		return noArgs("Status")
+	case "quit":
+		return noArgs("Quit")
+	case "enqueue":
+		if err := command.Expect(args, 1, math.MaxInt, "enqueue <path>..."); err != nil {
+			return daemon.Status{}, err
+		}
+		paths := make([]string, len(args))
+		for i, arg := range args {
+			path, err := ctlPath(arg)
+			if err != nil {
+				return daemon.Status{}, err
+			}
+			paths[i] = path
+		}
+		return client.Call("Enqueue", daemon.EnqueueArgs{Paths: paths})
+	case "seek":
+		if err := command.Expect(args, 1, 1, "seek <position>"); err != nil {
+			return daemon.Status{}, err
+		}
+		position, relative, err := command.ParseTime(args[0])
+		if err != nil {
+			return daemon.Status{}, err
+		}
+		return client.Call("Seek", daemon.SeekArgs{Position: position.Seconds(), Relative: relative})
+	case "volume":
+		if err := command.Expect(args, 1, 1, "volume <0-100|+n|-n>"); err != nil {
+			return daemon.Status{}, err
+		}
+		level, relative, err := command.ParseLevel(args[0])
+		if err != nil {
+			return daemon.Status{}, err
+		}
+		volume := float64(level) / 100
+		if relative {
+			status, err := client.Call("Status", daemon.Args{})
+			if err != nil {
+				return status, err
+			}
+			volume += status.Volume
+		}
+		return client.Call("SetVolume", daemon.VolumeArgs{Volume: math.Round(volume*100) / 100})
+	case "repeat":
+		if err := command.Expect(args, 0, 1, "repeat [off|one|all]"); err != nil {
+			return daemon.Status{}, err
+		}
+		var repeat daemon.RepeatArgs
+		if len(args) == 1 {
+			repeat.Mode = args[0]
+		}
+		return client.Call("SetRepeat", repeat)
+	}
+	return daemon.Status{}, fmt.Errorf("unknown command %q\n%s", name, ctlUsage)
+}
+
+// ctlPath makes a path absolute, since the daemon may run elsewhere, and
+// leaves stream URLs alone
+func ctlPath(arg string) (string, error) {
+	if stream.IsURL(arg) {
+		return arg, nil
+	}
+	return filepath.Abs(arg)
+}
+
+func printStatus(status daemon.Status) {
+	if status.File == "" {
+		fmt.Println("Stopped")
+	} else {
+		title := status.Title
+		if status.Artist != "" {
+			title = status.Artist + " - " + title
+		}
+		if status.Stream != nil && status.Stream.Name != "" {
+			if title == "" {
+				title = status.Stream.Name
+			} else {
+				title += " (" + status.Stream.Name + ")"
+			}
+		}
+		state := strings.ToUpper(status.State[:1]) + status.State[1:]
+		fmt.Printf("%s: %s\n", state, title)
+		if status.Album != "" {
+			fmt.Printf("Album: %s\n", status.Album)
+		}
+		if status.Stream != nil {
+			fmt.Printf("Time: %s\n", formatLength(secondsOf(status.Position)))
+		} else {
+			fmt.Printf("Time: %s / %s\n", formatLength(secondsOf(status.Position)), formatLength(secondsOf(status.Duration)))
+		}
+	}
+
+	shuffle := "off"
+	if status.Shuffle {
+		shuffle = "on"
+	}
+	fmt.Printf("Volume: %d%% | Repeat: %s | Shuffle: %s\n", int(math.Round(status.Volume*100)), status.Repeat, shuffle)
+	if status.Index >= 0 {
+		fmt.Printf("Track %d of %d", status.Index+1, status.Length)
+		if len(status.Queue) > 0 {
+			fmt.Printf(" | %d queued", len(status.Queue))
+		}
+		fmt.Println()
+	} else if len(status.Queue) > 0 {
+		fmt.Printf("%d queued\n", len(status.Queue))
+	}
+}
+
+func secondsOf(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
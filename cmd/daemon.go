@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"clispot/internal/daemon"
+	"clispot/internal/player"
+	"clispot/internal/server"
+)
+
+// runDaemon plays music with no interface until told to quit or
+// interrupted. clispot and clispot ctl control it over its socket.
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	musicDir := fs.String("dir", defaultMusicDir(), "Directory containing MP3 files")
+	socket := fs.String("socket", daemon.SocketPath(), "Control socket to listen on")
+	serveAddr := fs.String("serve", "", "Also serve the library and a radio of what plays over HTTP on this address, such as :8080")
+	fs.Parse(args)
+
+	root, err := filepath.Abs(*musicDir)
+	if err != nil {
+		return err
+	}
+	lib, err := newLibrary(root)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scanning for music in: %s\n", root)
+	songs, problems, err := lib.ScanDirectory()
+	if err != nil {
+		return fmt.Errorf("error scanning music directory: %v", err)
+	}
+	printScanProblems(problems)
+	fmt.Printf("Found %d songs\n", len(songs))
+
+	listener, err := daemon.Listen(*socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(*socket)
+
+	audioPlayer := player.NewPlayer()
+	defer audioPlayer.Close()
+
+	opts := daemon.Options{Log: log.New(os.Stderr, "", log.LstdFlags)}
+	if *serveAddr != "" {
+		opts.Radio = server.NewRadio()
+		httpListener, err := net.Listen("tcp", *serveAddr)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		srv := server.New(root, songs, server.Options{PlaylistDir: playlistDir(), Radio: opts.Radio})
+		go newHTTPServer(srv).Serve(httpListener)
+		fmt.Printf("Serving on http://%s\n", httpListener.Addr())
+	}
+	d := daemon.New(audioPlayer, lib, opts)
+
+	// Keep playing when the terminal it was started from closes
+	signal.Ignore(syscall.SIGHUP)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		d.Shutdown()
+	}()
+
+	fmt.Printf("Listening on %s\n", *socket)
+	return d.Serve(listener)
+}
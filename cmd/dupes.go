@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"clispot/internal/library"
+)
+
+// runDupes lists songs that are in the library more than once and, with
+// -remove, asks which copies to move to the trash folder
+func runDupes(args []string) error {
+	fs := flag.NewFlagSet("dupes", flag.ExitOnError)
+	musicDir := fs.String("dir", defaultMusicDir(), "Directory containing MP3 files")
+	exact := fs.Bool("exact", false, "Only report copies with identical audio")
+	tolerance := fs.Duration("tolerance", 3*time.Second, "How far apart the lengths of similar copies may be")
+	remove := fs.Bool("remove", false, "Ask, group by group, which copies to move to the trash folder")
+	trash := fs.String("trash", "", "Trash folder (default "+library.TrashDirName+" in the music directory)")
+	fs.Parse(args)
+
+	root, err := filepath.Abs(*musicDir)
+	if err != nil {
+		return err
+	}
+	trashDir := *trash
+	if trashDir == "" {
+		trashDir = filepath.Join(root, library.TrashDirName)
+	}
+
+	lib, err := newLibrary(root)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scanning %s\n", root)
+	_, problems, err := lib.ScanDirectory()
+	if err != nil {
+		return err
+	}
+	printScanProblems(problems)
+
+	groups, err := lib.FindDuplicates(library.DuplicateOptions{
+		Similar:   !*exact,
+		Tolerance: *tolerance,
+		Progress: func(done, total int) {
+			fmt.Printf("\rComparing audio: %d/%d", done, total)
+			if done == total {
+				fmt.Println()
+			}
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(groups) == 0 {
+		fmt.Println("No duplicates found")
+		return nil
+	}
+
+	if !*remove {
+		extra := 0
+		for i, group := range groups {
+			printGroup(root, i+1, group)
+			extra += len(group.Copies) - 1
+		}
+		fmt.Printf("\n%d groups, %d copies could go; run with -remove to clean up\n", len(groups), extra)
+		return nil
+	}
+	return removeDuplicates(lib, root, trashDir, groups)
+}
+
+// printGroup lists the copies of a group, marking the one to keep with *
+func printGroup(root string, n int, group library.DuplicateGroup) {
+	fmt.Printf("\n[%d] %s duplicates: %s - %s\n", n, group.Kind,
+		group.Copies[group.Keep].Song.Artist, group.Copies[group.Keep].Song.Title)
+	for i, c := range group.Copies {
+		mark := " "
+		if i == group.Keep {
+			mark = "*"
+		}
+		bitrate := "? kbps"
+		if c.Bitrate > 0 {
+			bitrate = fmt.Sprintf("%d kbps", c.Bitrate)
+		}
+		fmt.Printf("  %s %d) %s  (%s, %s, %d tags)\n", mark, i+1,
+			relativePath(root, c.Song.FilePath), formatLength(c.Song.Duration), bitrate, c.TagCount)
+	}
+}
+
+func formatLength(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// removeDuplicates goes through the groups asking which copy to keep, and
+// moves the others to trashDir. Playlists and listening history that
+// pointed at a removed copy are moved over to the kept one.
+func removeDuplicates(lib *library.Library, root, trashDir string, groups []library.DuplicateGroup) error {
+	in := bufio.NewScanner(os.Stdin)
+	replaced := make(map[string]string)
+	removed := 0
+
+	for i, group := range groups {
+		printGroup(root, i+1, group)
+
+		// Files trashed by an earlier group can show up again here
+		missing := false
+		for _, c := range group.Copies {
+			if _, err := os.Stat(c.Song.FilePath); err != nil {
+				missing = true
+			}
+		}
+		if missing {
+			fmt.Println("  Some copies were already removed; skipping")
+			continue
+		}
+
+		keep, quit := askKeep(in, group)
+		if quit {
+			break
+		}
+		if keep < 0 {
+			continue
+		}
+		group.Keep = keep
+
+		kept := group.Copies[keep].Song.FilePath
+		for _, c := range group.Removable() {
+			target, err := lib.TrashSong(c.Song.FilePath, trashDir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "  %v\n", err)
+				continue
+			}
+			fmt.Printf("  Moved %s to %s\n", relativePath(root, c.Song.FilePath), target)
+			replaced[c.Song.FilePath] = kept
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		fmt.Printf("\nMoved %d files to %s\n", removed, trashDir)
+		// Ratings of removed copies are merged into any the kept copy
+		// already has, so neither is lost
+		updateMovedPaths(replaced)
+	}
+	return nil
+}
+
+// askKeep prompts for what to do with a group. It returns the index of the
+// copy to keep, -1 to leave the group alone, or quit to stop asking.
+func askKeep(in *bufio.Scanner, group library.DuplicateGroup) (keep int, quit bool) {
+	for {
+		fmt.Printf("Keep %d and remove the rest? [Y]es, [n]o, [1-%d] keep another, [q]uit: ",
+			group.Keep+1, len(group.Copies))
+		if !in.Scan() {
+			return -1, true
+		}
+		answer := strings.ToLower(strings.TrimSpace(in.Text()))
+		switch answer {
+		case "", "y", "yes":
+			return group.Keep, false
+		case "n", "no":
+			return -1, false
+		case "q", "quit":
+			return -1, true
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(group.Copies) {
+			return n - 1, false
+		}
+	}
+}
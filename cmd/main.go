@@ -6,14 +6,71 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"clispot/internal/daemon"
 	"clispot/internal/library"
 	"clispot/internal/player"
+	"clispot/internal/settings"
 	"clispot/internal/ui"
 )
 
+// commands maps subcommand names to their entry points; each receives the
+// arguments that follow the subcommand name
+var commands = map[string]func(args []string) error{
+	"stats":    runStats,
+	"organize": runOrganize,
+	"dupes":    runDupes,
+	"check":    runCheck,
+	"serve":    runServe,
+	"daemon":   runDaemon,
+	"ctl":      runCtl,
+}
+
+// newLibrary opens the library at root, set up to fill in missing tags as
+// the settings ask
+func newLibrary(root string) (*library.Library, error) {
+	lib := library.NewLibrary(root)
+	s := settings.NewManager().Get()
+	if !s.InferTags {
+		return lib, nil
+	}
+
+	patterns, err := library.ParsePathPatterns(append(append([]string(nil), s.FilenamePatterns...), library.BuiltinPathPatterns...))
+	if err != nil {
+		return nil, fmt.Errorf("filename_patterns: %v", err)
+	}
+	lib.SetPathPatterns(patterns)
+	lib.SetWriteInferredTags(s.WriteInferredTags)
+	return lib, nil
+}
+
+// printScanProblems lists the files a scan could only read in part
+func printScanProblems(problems []error) {
+	for _, problem := range problems {
+		fmt.Printf("Warning: %v\n", problem)
+	}
+}
+
+// defaultMusicDir is where clispot looks for music without -dir
+func defaultMusicDir() string {
+	return filepath.Join(os.Getenv("HOME"), "Music", "spotify-cli")
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		}
+	}
+
 	var musicDir string
-	flag.StringVar(&musicDir, "dir", filepath.Join(os.Getenv("HOME"), "Music", "spotify-cli"), "Directory containing MP3 files")
+	var serveAddr string
+	var socket string
+	flag.StringVar(&musicDir, "dir", defaultMusicDir(), "Directory containing MP3 files")
+	flag.StringVar(&serveAddr, "serve", "", "Also serve the library and a radio of what plays over HTTP on this address, such as :8080")
+	flag.StringVar(&socket, "socket", daemon.SocketPath(), "Control socket of a running clispot daemon to play through")
 	flag.Parse()
 
 	
@@ -34,14 +91,18 @@ func main() {
 	}
 
 	
-	lib := library.NewLibrary(absPath)
+	lib, err := newLibrary(absPath)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
 	
 	
 	fmt.Printf("Scanning for music in: %s\n", absPath)
-	songs, err := lib.ScanDirectory()
+	songs, problems, err := lib.ScanDirectory()
 	if err != nil {
 		log.Fatalf("Error scanning music directory: %v", err)
 	}
+	printScanProblems(problems)
 
 	if len(songs) == 0 {
 		fmt.Printf("No MP3 files found in '%s'\n", absPath)
@@ -52,11 +113,28 @@ func main() {
 	fmt.Printf("Found %d songs\n", len(songs))
 
 	
-	audioPlayer := player.NewPlayer()
-	defer audioPlayer.Close()
+	// Play through the daemon when one is running, so music carries on
+	// after the app closes
+	var audioPlayer ui.Player
+	if remote, err := daemon.Connect(socket); err == nil {
+		fmt.Printf("Connected to the daemon at %s\n", socket)
+		defer remote.Close()
+		audioPlayer = remote
+	} else {
+		local := player.NewPlayer()
+		defer local.Close()
+		audioPlayer = local
+	}
 
 	
 	app := ui.NewApp(songs, audioPlayer, lib)
+	if serveAddr != "" {
+		srv, err := startServer(serveAddr, absPath, songs)
+		if err != nil {
+			log.Fatalf("Error starting server: %v", err)
+		}
+		app.SetServer(srv)
+	}
 	
 	
 	if err := app.Run(); err != nil {
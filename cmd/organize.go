@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"clispot/internal/history"
+	"clispot/internal/library"
+	"clispot/internal/lyrics"
+	"clispot/internal/playlist"
+	"clispot/internal/ratings"
+	"clispot/internal/session"
+	"clispot/internal/settings"
+)
+
+// runOrganize moves the library's files into a folder layout built from
+// their tags, or reverts the last run with -undo
+func runOrganize(args []string) error {
+	fs := flag.NewFlagSet("organize", flag.ExitOnError)
+	musicDir := fs.String("dir", defaultMusicDir(), "Directory containing MP3 files")
+	template := fs.String("template", library.DefaultOrganizeTemplate, "Path template; fields: {title} {artist} {album} {albumartist} {composer} {genre} {year} {track} {disc} {filename}, {track:02} pads numbers")
+	dryRun := fs.Bool("dry-run", false, "Show what would move without touching any files")
+	undo := fs.Bool("undo", false, "Move the files of the last organize run back")
+	fs.Parse(args)
+
+	logDir := filepath.Join(settings.ConfigDir(), "organize")
+	if *undo {
+		return undoOrganize(logDir, *dryRun)
+	}
+
+	tmpl, err := library.ParseTemplate(*template)
+	if err != nil {
+		return err
+	}
+	root, err := filepath.Abs(*musicDir)
+	if err != nil {
+		return err
+	}
+
+	lib, err := newLibrary(root)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scanning %s\n", root)
+	_, problems, err := lib.ScanDirectory()
+	if err != nil {
+		return err
+	}
+	printScanProblems(problems)
+
+	moves := lib.PlanOrganize(tmpl)
+	if len(moves) == 0 {
+		fmt.Println("Everything is already in place")
+		return nil
+	}
+	printMoves(root, moves)
+	if *dryRun {
+		fmt.Printf("\n%d files would move (dry run)\n", len(moves))
+		return nil
+	}
+
+	done, moveErr := lib.ApplyMoves(moves)
+	if len(done) > 0 {
+		logPath, err := library.SaveUndoLog(logDir, library.UndoLog{
+			Time:     time.Now(),
+			Root:     root,
+			Template: tmpl.String(),
+			Moves:    done,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			fmt.Printf("\nMoved %d files; undo log saved to %s\n", len(done), logPath)
+			fmt.Println("Run 'clispot organize -undo' to move them back")
+		}
+		updateMovedPaths(library.MovedPaths(done))
+	}
+	return moveErr
+}
+
+// undoOrganize reverts the newest undo log and removes it
+func undoOrganize(logDir string, dryRun bool) error {
+	logPath, log, err := library.LatestUndoLog(logDir)
+	if err != nil {
+		return err
+	}
+
+	moves := library.ReverseMoves(log.Moves)
+	fmt.Printf("Undoing organize run of %s\n", log.Time.Format("2006-01-02 15:04"))
+	printMoves(log.Root, moves)
+	if dryRun {
+		fmt.Printf("\n%d files would move back (dry run)\n", len(moves))
+		return nil
+	}
+
+	lib := library.NewLibrary(log.Root)
+	done, moveErr := lib.ApplyMoves(moves)
+	updateMovedPaths(library.MovedPaths(done))
+	if moveErr != nil {
+		// Rewrite the log with only what is still to be undone so a second
+		// -undo can finish the job
+		log.Moves = log.Moves[:len(log.Moves)-len(done)]
+		if _, err := library.SaveUndoLog(logDir, log); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		return moveErr
+	}
+
+	fmt.Printf("\nMoved %d files back\n", len(done))
+	return library.RemoveUndoLog(logPath)
+}
+
+// printMoves shows each move as a diff of paths relative to root
+func printMoves(root string, moves []library.Move) {
+	for _, move := range moves {
+		fmt.Printf("- %s\n+ %s\n", relativePath(root, move.From), relativePath(root, move.To))
+	}
+}
+
+func relativePath(root, path string) string {
+	if rel, err := filepath.Rel(root, path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// updateMovedPaths points playlists, ratings, lyrics offsets, listening
+// history, the saved session and resume positions at the new locations of
+// moved files
+func updateMovedPaths(paths map[string]string) {
+	if len(paths) == 0 {
+		return
+	}
+	configDir := settings.ConfigDir()
+
+	playlists := playlist.NewManager(filepath.Join(configDir, "playlists"))
+	if renamed, err := playlists.RenamePaths(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error updating playlists: %v\n", err)
+	} else if renamed > 0 {
+		fmt.Printf("Updated %d playlist entries\n", renamed)
+	}
+
+	if err := ratings.NewStore(configDir).RenamePaths(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error updating ratings: %v\n", err)
+	}
+	if err := history.NewStore(configDir).RenamePaths(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error updating history: %v\n", err)
+	}
+	if err := lyrics.NewOffsetStore(configDir).RenamePaths(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error updating lyrics offsets: %v\n", err)
+	}
+	if err := session.NewStore(configDir).RenamePaths(paths); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: error updating session: %v\n", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"clispot/internal/library"
+	"clispot/internal/server"
+	"clispot/internal/settings"
+)
+
+// defaultServeAddr is where serve listens without -addr: every interface,
+// so phones on the same network can connect
+const defaultServeAddr = ":8080"
+
+// runServe serves the library over HTTP until interrupted. There is no
+// player in this mode, so no radio; clispot -serve serves the library from
+// inside the player, with the radio playing along.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	musicDir := fs.String("dir", defaultMusicDir(), "Directory containing MP3 files")
+	addr := fs.String("addr", defaultServeAddr, "Address to listen on")
+	quiet := fs.Bool("quiet", false, "Don't log requests")
+	fs.Parse(args)
+
+	root, err := filepath.Abs(*musicDir)
+	if err != nil {
+		return err
+	}
+	lib, err := newLibrary(root)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Scanning for music in: %s\n", root)
+	songs, problems, err := lib.ScanDirectory()
+	if err != nil {
+		return fmt.Errorf("error scanning music directory: %v", err)
+	}
+	printScanProblems(problems)
+
+	opts := server.Options{PlaylistDir: playlistDir()}
+	if !*quiet {
+		opts.Log = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Serving %d songs on http://%s\n", len(songs), listener.Addr())
+	return newHTTPServer(server.New(root, songs, opts)).Serve(listener)
+}
+
+// startServer serves the library from inside the player, with a radio
+// that the app keeps up to date. Request logging is left off so it doesn't
+// write over the interface.
+func startServer(addr, root string, songs []library.Song) (*server.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := server.New(root, songs, server.Options{
+		PlaylistDir: playlistDir(),
+		Radio:       server.NewRadio(),
+	})
+	go newHTTPServer(srv).Serve(listener)
+	return srv, nil
+}
+
+func playlistDir() string {
+	return filepath.Join(settings.ConfigDir(), "playlists")
+}
+
+// newHTTPServer bounds how long a client may take to send its request.
+// There is no write timeout: songs and the radio stream for as long as
+// they are listened to.
+func newHTTPServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
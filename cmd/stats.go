@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"clispot/internal/history"
+	"clispot/internal/settings"
+)
+
+// runStats prints listening statistics from the play history
+func runStats(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	period := fs.String("period", "", "Only show one period: week, month or all")
+	limit := fs.Int("limit", 10, "Number of entries in each top list")
+	fs.Parse(args)
+
+	periods := []history.Period{history.PeriodWeek, history.PeriodMonth, history.PeriodAllTime}
+	if *period != "" {
+		p, err := history.ParsePeriod(*period)
+		if err != nil {
+			return err
+		}
+		periods = []history.Period{p}
+	}
+
+	store := history.NewStore(settings.ConfigDir())
+	plays, err := store.Plays()
+	if err != nil {
+		return err
+	}
+	if len(plays) == 0 {
+		fmt.Printf("No listening history yet in %s\n", store.Path())
+		return nil
+	}
+
+	for i, p := range periods {
+		stats, err := store.Stats(p, *limit)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printStats(stats)
+	}
+	return nil
+}
+
+func printStats(stats history.Stats) {
+	fmt.Printf("== %s ==\n", stats.Period)
+	fmt.Printf("Plays: %d\n", stats.Plays)
+	fmt.Printf("Listening time: %.1f hours\n", stats.TotalHours)
+
+	printTop("Top artists", stats.TopArtists)
+	printTop("Top albums", stats.TopAlbums)
+	printTop("Top tracks", stats.TopTracks)
+}
+
+func printTop(title string, entries []history.Entry) {
+	fmt.Printf("\n%s:\n", title)
+	if len(entries) == 0 {
+		fmt.Println("  (none)")
+		return
+	}
+	for i, entry := range entries {
+		fmt.Printf("  %2d. %s (%d plays, %.0f min)\n", i+1, entry.Name, entry.Plays, entry.Seconds/60)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/nfnt/resize"
+	"clispot/internal/theme"
 )
 
 
@@ -203,20 +204,21 @@ func CreatePlaceholderArt(width, height int) *ASCIIArt {
 func (a *ASCIIArt) GetColorizedASCII() string {
 	lines := strings.Split(a.Art, "\n")
 	var result strings.Builder
+	th := theme.Current()
 
 	for _, line := range lines {
 		for _, char := range line {
 			switch char {
 			case ' ', '.', ':':
-				result.WriteString(fmt.Sprintf("[gray]%c[white]", char))
+				result.WriteString(th.Paint(th.ArtShadow, string(char)))
 			case '-', '=', '+':
-				result.WriteString(fmt.Sprintf("[darkgray]%c[white]", char))
+				result.WriteString(th.Paint(th.ArtMid, string(char)))
 			case '*', '#', '%':
-				result.WriteString(fmt.Sprintf("[lightgray]%c[white]", char))
+				result.WriteString(th.Paint(th.ArtLight, string(char)))
 			case '@':
-				result.WriteString(fmt.Sprintf("[white]%c[white]", char))
+				result.WriteString(th.Paint(th.ArtHighlight, string(char)))
 			case '♪', '♫':
-				result.WriteString(fmt.Sprintf("[yellow]%c[white]", char))
+				result.WriteString(th.Paint(th.ArtNote, string(char)))
 			default:
 				result.WriteRune(char)
 			}
@@ -0,0 +1,53 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTime reads a position such as "2:30", "1:02:03" or "90" (seconds).
+// A leading "+" or "-" makes it relative to the current position, which is
+// reported by relative.
+func ParseTime(arg string) (d time.Duration, relative bool, err error) {
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(arg, "+"):
+		relative, arg = true, arg[1:]
+	case strings.HasPrefix(arg, "-"):
+		relative, sign, arg = true, -1, arg[1:]
+	}
+
+	parts := strings.Split(arg, ":")
+	if len(parts) > 3 || arg == "" {
+		return 0, false, fmt.Errorf("invalid time %q, use [h:]m:ss or seconds", arg)
+	}
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, false, fmt.Errorf("invalid time %q, use [h:]m:ss or seconds", arg)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	return sign * d * time.Second, relative, nil
+}
+
+// ParseLevel reads a value such as "40", "+5" or "-10". relative reports a
+// leading sign.
+func ParseLevel(arg string) (level int, relative bool, err error) {
+	relative = strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-")
+	level, err = strconv.Atoi(arg)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid number %q", arg)
+	}
+	return level, relative, nil
+}
+
+// Expect returns an error unless args has between min and max entries
+func Expect(args []string, min, max int, usage string) error {
+	if len(args) < min || len(args) > max {
+		return fmt.Errorf("usage: %s", usage)
+	}
+	return nil
+}
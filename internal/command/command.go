@@ -0,0 +1,206 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Command is a named operation that can be run from the command line, a key
+// binding, a script or a remote client
+type Command struct {
+	Name        string
+	Aliases     []string
+	Usage       string
+	Description string
+
+	// Run executes the command and returns a message to show the user
+	Run func(args []string) (string, error)
+
+	// Complete returns candidates for the last argument in args; it may be
+	// nil for commands without arguments
+	Complete func(args []string) []string
+}
+
+// Registry holds the available commands
+type Registry struct {
+	commands map[string]*Command
+	names    []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		commands: make(map[string]*Command),
+	}
+}
+
+// Register adds cmd, replacing any command with the same name or alias
+func (r *Registry) Register(cmd Command) {
+	c := &cmd
+	if _, exists := r.commands[cmd.Name]; !exists {
+		r.names = append(r.names, cmd.Name)
+		sort.Strings(r.names)
+	}
+	r.commands[cmd.Name] = c
+	for _, alias := range cmd.Aliases {
+		r.commands[alias] = c
+	}
+}
+
+// Lookup finds a command by name or alias
+func (r *Registry) Lookup(name string) (*Command, bool) {
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// Commands returns every registered command sorted by name
+func (r *Registry) Commands() []*Command {
+	commands := make([]*Command, 0, len(r.names))
+	for _, name := range r.names {
+		commands = append(commands, r.commands[name])
+	}
+	return commands
+}
+
+// Execute parses and runs one command line such as "seek 2:30". A leading
+// ":" is ignored.
+func (r *Registry) Execute(line string) (string, error) {
+	args, err := Split(strings.TrimPrefix(strings.TrimSpace(line), ":"))
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return "", nil
+	}
+
+	cmd, ok := r.Lookup(args[0])
+	if !ok {
+		return "", fmt.Errorf("unknown command: %s", args[0])
+	}
+	return cmd.Run(args[1:])
+}
+
+// RunScript executes each line of script in order, skipping blank lines and
+// lines starting with "#". It stops at the first failing line.
+func (r *Registry) RunScript(script io.Reader) error {
+	scanner := bufio.NewScanner(script)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := r.Execute(line); err != nil {
+			return fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// Complete returns the possible completions of line as whole command lines
+func (r *Registry) Complete(line string) []string {
+	hasColon := strings.HasPrefix(line, ":")
+	line = strings.TrimPrefix(line, ":")
+
+	args, err := Split(line)
+	if err != nil {
+		return nil
+	}
+	if len(args) == 0 || strings.HasSuffix(line, " ") {
+		args = append(args, "")
+	}
+
+	var candidates []string
+	if len(args) == 1 {
+		for name := range r.commands {
+			candidates = append(candidates, name)
+		}
+	} else if cmd, ok := r.Lookup(args[0]); ok && cmd.Complete != nil {
+		candidates = cmd.Complete(args[1:])
+	}
+
+	prefix := args[len(args)-1]
+	head := make([]string, 0, len(args))
+	for _, arg := range args[:len(args)-1] {
+		head = append(head, Quote(arg))
+	}
+
+	var lines []string
+	for _, candidate := range candidates {
+		if !strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(prefix)) {
+			continue
+		}
+		completed := strings.Join(append(head, Quote(candidate)), " ")
+		if hasColon {
+			completed = ":" + completed
+		}
+		lines = append(lines, completed)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// CommonPrefix returns the longest prefix shared by all of lines. It is
+// shortened a rune at a time, so it never ends inside a character.
+func CommonPrefix(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	prefix := lines[0]
+	for _, line := range lines[1:] {
+		for !strings.HasPrefix(line, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// Split breaks a command line into arguments. Double quotes group words
+// containing spaces and a backslash escapes the next character.
+func Split(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inQuotes, inArg, escaped := false, false, false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inArg = true, true
+		case r == '"':
+			inQuotes, inArg = !inQuotes, true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// Quote returns arg quoted if it would otherwise split into several words
+func Quote(arg string) string {
+	if !strings.ContainsAny(arg, " \t\"\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
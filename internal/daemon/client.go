@@ -0,0 +1,32 @@
+package daemon
+
+import (
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+)
+
+// Client talks to a running daemon over its control socket
+type Client struct {
+	rpc *rpc.Client
+}
+
+func Dial(path string) (*Client, error) {
+	client, err := jsonrpc.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the daemon at %s: %v", path, err)
+	}
+	return &Client{rpc: client}, nil
+}
+
+// Call runs one of the Player methods, such as "Next", and returns the
+// status after it
+func (c *Client) Call(method string, args any) (Status, error) {
+	var status Status
+	err := c.rpc.Call("Player."+method, args, &status)
+	return status, err
+}
+
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
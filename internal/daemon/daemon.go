@@ -0,0 +1,342 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"clispot/internal/history"
+	"clispot/internal/library"
+	"clispot/internal/player"
+	"clispot/internal/ratings"
+	"clispot/internal/scrobbler"
+	"clispot/internal/server"
+	"clispot/internal/settings"
+)
+
+// tickInterval is how often the daemon checks whether the song has ended
+const tickInterval = 250 * time.Millisecond
+
+// Options configures a Daemon. Zero fields leave things off.
+type Options struct {
+	// Radio, if not nil, is told what plays, as the app does with -serve
+	Radio *server.Radio
+	// Log gets errors nobody is there to see, such as failed scrobbles
+	Log *log.Logger
+}
+
+// Daemon plays music with no interface: the player, the list it plays
+// through and the up-next queue, controlled over a socket. It moves on
+// through the list by itself, so music keeps playing as clients come and
+// go.
+type Daemon struct {
+	library   *library.Library
+	settings  *settings.Manager
+	scrobbler *scrobbler.Scrobbler
+	opts      Options
+
+	// mu serializes use of the player, which is not safe for concurrent
+	// use, and guards the list and queue
+	mu     sync.Mutex
+	player *player.Player
+	list   []library.Song
+	index  int
+	queue  []library.Song
+
+	listener net.Listener
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New sets up a daemon playing songs of lib through p. It records plays in
+// the listening history and scrobbles them as the app would.
+func New(p *player.Player, lib *library.Library, opts Options) *Daemon {
+	if opts.Log == nil {
+		opts.Log = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	d := &Daemon{
+		library:  lib,
+		settings: settings.NewManager(),
+		opts:     opts,
+		player:   p,
+		index:    -1,
+		done:     make(chan struct{}),
+	}
+
+	historyStore := history.NewStore(settings.ConfigDir())
+	for path, entry := range ratings.NewStore(settings.ConfigDir()).All() {
+		if song, ok := lib.FindSong(path); ok && entry.Rating == 0 {
+			entry.Rating = song.Rating
+		}
+		lib.SetRating(path, entry.Rating, entry.Loved)
+	}
+	lib.SetPlayCounts(historyStore.PlayCounts())
+
+	recorder := history.NewRecorder(historyStore, lib.FindSong)
+	recorder.OnPlay(func(play history.Play) {
+		if play.Counts() {
+			lib.IncrementPlayCount(play.Path)
+		}
+	})
+	p.SetSections(lib.Section)
+	p.SetRepeatMode(d.settings.Get().RepeatMode)
+	p.AddListener(recorder.HandleEvent)
+
+	services, err := scrobbler.ServicesFromSettings(d.settings.Get().Scrobblers, nil)
+	if err != nil {
+		opts.Log.Printf("Scrobbling disabled: %v", err)
+	} else if len(services) > 0 {
+		d.scrobbler = scrobbler.New(settings.ConfigDir(), services, lib.FindSong)
+		d.scrobbler.OnError = func(service string, err error) {
+			opts.Log.Printf("Scrobbling to %s: %v", service, err)
+		}
+		p.AddListener(d.scrobbler.HandleEvent)
+	}
+	return d
+}
+
+// Serve answers JSON-RPC requests on listener until Shutdown, playing on
+// through the list in the meantime
+func (d *Daemon) Serve(listener net.Listener) error {
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("Player", &service{d: d}); err != nil {
+		return err
+	}
+	d.listener = listener
+	if d.scrobbler != nil {
+		d.scrobbler.Start()
+		defer d.scrobbler.Close()
+	}
+	go d.run()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-d.done:
+				return nil
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go rpcServer.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// Shutdown stops playback and makes Serve return
+func (d *Daemon) Shutdown() {
+	d.stopOnce.Do(func() {
+		close(d.done)
+		d.mu.Lock()
+		d.player.Stop()
+		d.mu.Unlock()
+		if d.listener != nil {
+			d.listener.Close()
+		}
+	})
+}
+
+// run moves on to the next song when one ends and keeps the radio up to
+// date
+func (d *Daemon) run() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			d.tick()
+			d.mu.Unlock()
+		}
+	}
+}
+
+func (d *Daemon) tick() {
+	d.player.SetNext(d.upcoming())
+	if d.player.GetCurrentSong() != "" && d.player.IsFinished() {
+		if err := d.finished(); err != nil {
+			d.opts.Log.Print(err)
+		}
+	}
+
+	if d.opts.Radio != nil {
+		var now server.NowPlaying
+		state := d.player.GetState()
+		if state.CurrentSong != "" && !d.player.IsStream() {
+			now = server.Playing(d.song(state.CurrentSong), state)
+		}
+		d.opts.Radio.Update(now)
+	}
+}
+
+// finished plays what comes after the song that just ended. Unlike Next
+// it stops at the end of the list unless the whole list repeats.
+func (d *Daemon) finished() error {
+	current := d.player.GetCurrentSong()
+	switch {
+	case d.player.IsStream():
+		// The stream gave up reconnecting
+		d.player.Stop()
+		return nil
+	case d.player.ShouldRepeat():
+		return d.player.Play(current)
+	case len(d.queue) == 0 && !d.shuffle() && d.index+1 >= len(d.list) && !d.player.ShouldRepeatPlaylist():
+		d.player.Stop()
+		return nil
+	}
+	return d.next()
+}
+
+// next plays the first queued song, or else the next song of the list
+func (d *Daemon) next() error {
+	if len(d.queue) > 0 {
+		song := d.queue[0]
+		d.queue = d.queue[1:]
+		return d.play(song)
+	}
+	if len(d.list) == 0 {
+		return fmt.Errorf("nothing to play next")
+	}
+	if d.shuffle() {
+		d.index = library.WeightedPick(d.list, d.index)
+	} else {
+		d.index = (d.index + 1) % len(d.list)
+	}
+	return d.play(d.list[d.index])
+}
+
+func (d *Daemon) previous() error {
+	if len(d.list) == 0 {
+		return fmt.Errorf("nothing to play")
+	}
+	d.index = (d.index - 1 + len(d.list)) % len(d.list)
+	return d.play(d.list[d.index])
+}
+
+func (d *Daemon) play(song library.Song) error {
+	if err := d.player.Play(song.FilePath); err != nil {
+		return fmt.Errorf("error playing %s: %v", song.Title, err)
+	}
+	return nil
+}
+
+// playList plays through songs from index
+func (d *Daemon) playList(songs []library.Song, index int) error {
+	if index < 0 || index >= len(songs) {
+		return fmt.Errorf("no song %d in a list of %d", index, len(songs))
+	}
+	d.list = songs
+	d.index = index
+	return d.play(songs[index])
+}
+
+// playPath plays a song, which plays on through the rest of its folder, or
+// every song in a folder. Streams are played by service.playStream.
+func (d *Daemon) playPath(path string) error {
+	songs, err := d.songsAt(path)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return d.playList(songs, 0)
+	}
+
+	song := songs[0]
+	folder := d.folderSongs(filepath.Dir(song.FilePath))
+	for i := range folder {
+		if folder[i].FilePath == song.FilePath {
+			return d.playList(folder, i)
+		}
+	}
+	return d.playList(songs, 0)
+}
+
+// upcoming returns the song that follows the current one when it ends, so
+// the player can run straight into it, or "" when that isn't known ahead.
+// It goes by the shuffle setting as last read, since it is asked often.
+func (d *Daemon) upcoming() string {
+	if len(d.queue) > 0 {
+		return d.queue[0].FilePath
+	}
+	if d.player.ShouldRepeat() || d.settings.Get().Shuffle || len(d.list) == 0 {
+		return ""
+	}
+	if d.index+1 >= len(d.list) && !d.player.ShouldRepeatPlaylist() {
+		return ""
+	}
+	return d.list[(d.index+1)%len(d.list)].FilePath
+}
+
+// shuffle reads the setting from disk each time, so toggling it in the app
+// takes effect here
+func (d *Daemon) shuffle() bool {
+	d.settings.Load()
+	return d.settings.Get().Shuffle
+}
+
+// song returns the library's song at path, or a bare one for a file the
+// library doesn't know, as when the app has rescanned and the daemon not
+func (d *Daemon) song(path string) library.Song {
+	if song, ok := d.library.FindSong(path); ok {
+		return song
+	}
+	return library.Song{FilePath: path, Title: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+}
+
+// songsAt returns the song at path, or every song under the folder at path
+func (d *Daemon) songsAt(path string) ([]library.Song, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if song, ok := d.library.FindSong(path); ok {
+		return []library.Song{song}, nil
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("%s is not in the library", path)
+	case info.IsDir():
+		var songs []library.Song
+		prefix := path + string(filepath.Separator)
+		for _, song := range d.library.GetSongs() {
+			if strings.HasPrefix(song.FilePath, prefix) {
+				songs = append(songs, song)
+			}
+		}
+		if len(songs) == 0 {
+			return nil, fmt.Errorf("no songs in %s", path)
+		}
+		library.SortTracks(songs)
+		return songs, nil
+	case strings.ToLower(filepath.Ext(path)) != ".mp3":
+		return nil, fmt.Errorf("%s is not an MP3 file", path)
+	}
+	return []library.Song{d.song(path)}, nil
+}
+
+// folderSongs returns the songs directly in dir, in track order
+func (d *Daemon) folderSongs(dir string) []library.Song {
+	var songs []library.Song
+	for _, song := range d.library.GetSongs() {
+		if filepath.Dir(song.FilePath) == dir {
+			songs = append(songs, song)
+		}
+	}
+	library.SortTracks(songs)
+	return songs
+}
@@ -0,0 +1,300 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"clispot/internal/player"
+	"clispot/internal/settings"
+	"clispot/internal/stream"
+)
+
+const (
+	// statusMaxAge is how long a status is used before it is asked for
+	// again
+	statusMaxAge = 250 * time.Millisecond
+	// finishedSlack is how close to its end a song must have been last seen
+	// to count as played to the end when the daemon moves on from it
+	finishedSlack = 2 * time.Second
+)
+
+// RemotePlayer is the player of a running daemon, used in place of a local
+// one. It reports songs starting and ending to its listeners as it notices
+// the daemon move on, so those arrive when the status is next read rather
+// than the moment they happen.
+//
+// The daemon steps through lists by itself, so IsFinished never reports
+// true and SetNext and SetSections do nothing.
+type RemotePlayer struct {
+	path string
+
+	mu        sync.Mutex
+	client    *Client
+	status    Status
+	fetched   time.Time
+	listeners []func(player.PlaybackEvent)
+}
+
+// Connect attaches to the daemon listening at path
+func Connect(path string) (*RemotePlayer, error) {
+	client, err := Dial(path)
+	if err != nil {
+		return nil, err
+	}
+	status, err := client.Call("Status", Args{})
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("error asking the daemon for its status: %v", err)
+	}
+	return &RemotePlayer{path: path, client: client, status: status, fetched: time.Now()}, nil
+}
+
+// Close disconnects, leaving the daemon playing
+func (r *RemotePlayer) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.client != nil {
+		r.client.Close()
+		r.client = nil
+	}
+}
+
+// call runs a daemon method and takes in the status it replies with,
+// telling listeners about any change of song
+func (r *RemotePlayer) call(method string, args any) error {
+	r.mu.Lock()
+	events, err := r.callLocked(method, args)
+	r.mu.Unlock()
+
+	for _, event := range events {
+		for _, listener := range r.listeners {
+			listener(event)
+		}
+	}
+	return err
+}
+
+func (r *RemotePlayer) callLocked(method string, args any) ([]player.PlaybackEvent, error) {
+	if r.client == nil {
+		// Reconnect, as to a daemon that has been restarted
+		client, err := Dial(r.path)
+		if err != nil {
+			return r.apply(Status{State: "stopped", Index: -1}), err
+		}
+		r.client = client
+	}
+
+	status, err := r.client.Call(method, args)
+	var serverErr rpc.ServerError
+	switch {
+	case errors.As(err, &serverErr):
+		// The daemon refused, and replies to refusals carry no status
+		status, err = r.client.Call("Status", Args{})
+		if err == nil {
+			return r.apply(status), serverErr
+		}
+	case err == nil:
+		return r.apply(status), nil
+	}
+
+	r.client.Close()
+	r.client = nil
+	return r.apply(Status{State: "stopped", Index: -1}), fmt.Errorf("lost the connection to the daemon: %v", err)
+}
+
+// apply replaces the status, returning the events a local player would
+// have sent getting from the old one to it. r.mu must be held.
+func (r *RemotePlayer) apply(status Status) []player.PlaybackEvent {
+	old := r.status
+	r.status = status
+	r.fetched = time.Now()
+	if old.File == status.File {
+		return nil
+	}
+
+	var events []player.PlaybackEvent
+	now := time.Now()
+	if old.File != "" {
+		position, duration := seconds(old.Position), seconds(old.Duration)
+		events = append(events, player.PlaybackEvent{
+			Type:     player.EventEnd,
+			Song:     old.File,
+			Time:     now,
+			Position: position,
+			Duration: duration,
+			Finished: duration > 0 && position >= duration-finishedSlack,
+			Stream:   old.Stream != nil,
+		})
+	}
+	if status.File != "" {
+		events = append(events, player.PlaybackEvent{
+			Type:     player.EventStart,
+			Song:     status.File,
+			Time:     now,
+			Position: seconds(status.Position),
+			Duration: seconds(status.Duration),
+			Stream:   status.Stream != nil,
+		})
+	}
+	return events
+}
+
+// current returns the status, asking the daemon again if it is out of date
+func (r *RemotePlayer) current() Status {
+	r.mu.Lock()
+	fresh := time.Since(r.fetched) < statusMaxAge
+	status := r.status
+	r.mu.Unlock()
+	if fresh {
+		return status
+	}
+
+	r.call("Status", Args{})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Play has the daemon play filePath. A stream URL is sent over a
+// connection of its own, as the daemon has to connect to the stream before
+// it replies: that way it may be called from any goroutine and leaves the
+// player usable meanwhile. The new song is then reported to listeners by
+// the next status read, on the goroutine doing the reading.
+func (r *RemotePlayer) Play(filePath string) error {
+	if !stream.IsURL(filePath) {
+		return r.call("Play", PlayArgs{Path: filePath})
+	}
+
+	client, err := Dial(r.path)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	_, err = client.Call("Play", PlayArgs{Path: filePath})
+
+	r.mu.Lock()
+	r.fetched = time.Time{}
+	r.mu.Unlock()
+	return err
+}
+
+// PlayList has the daemon play through paths from index
+func (r *RemotePlayer) PlayList(paths []string, index int) error {
+	return r.call("Play", PlayArgs{Paths: paths, Index: index})
+}
+
+// Load opens filePath paused at position, unless the daemon is already
+// playing something, which then carries on
+func (r *RemotePlayer) Load(filePath string, position time.Duration) error {
+	return r.call("Load", LoadArgs{Path: filePath, Position: position.Seconds()})
+}
+
+func (r *RemotePlayer) Seek(position time.Duration) error {
+	return r.call("Seek", SeekArgs{Position: position.Seconds()})
+}
+
+func (r *RemotePlayer) Stop() {
+	r.call("Stop", Args{})
+}
+
+func (r *RemotePlayer) TogglePlayPause() {
+	r.call("Toggle", Args{})
+}
+
+func (r *RemotePlayer) Next() error {
+	return r.call("Next", Args{})
+}
+
+func (r *RemotePlayer) Previous() error {
+	return r.call("Previous", Args{})
+}
+
+// Enqueue adds songs to the daemon's queue and returns its length
+func (r *RemotePlayer) Enqueue(paths ...string) (int, error) {
+	err := r.call("Enqueue", EnqueueArgs{Paths: paths})
+	return r.Queued(), err
+}
+
+func (r *RemotePlayer) Queued() int {
+	return len(r.current().Queue)
+}
+
+func (r *RemotePlayer) SetVolume(volume float64) {
+	r.call("SetVolume", VolumeArgs{Volume: volume})
+}
+
+func (r *RemotePlayer) GetVolume() float64 {
+	return r.current().Volume
+}
+
+func (r *RemotePlayer) GetState() player.PlaybackState {
+	status := r.current()
+	mode, _ := settings.ParseRepeatMode(status.Repeat)
+	return player.PlaybackState{
+		IsPlaying:   status.State == "playing",
+		IsPaused:    status.State == "paused",
+		CurrentSong: status.File,
+		Position:    seconds(status.Position),
+		Duration:    seconds(status.Duration),
+		Volume:      status.Volume,
+		RepeatMode:  mode,
+	}
+}
+
+func (r *RemotePlayer) GetCurrentSong() string {
+	return r.current().File
+}
+
+func (r *RemotePlayer) IsFinished() bool {
+	return false
+}
+
+func (r *RemotePlayer) SetNext(song string) {}
+
+func (r *RemotePlayer) SetSections(fn player.SectionFunc) {}
+
+func (r *RemotePlayer) AddListener(fn func(player.PlaybackEvent)) {
+	r.listeners = append(r.listeners, fn)
+}
+
+func (r *RemotePlayer) SetRepeatMode(mode settings.RepeatMode) {
+	r.call("SetRepeat", RepeatArgs{Mode: mode.String()})
+}
+
+func (r *RemotePlayer) CycleRepeatMode() settings.RepeatMode {
+	r.call("SetRepeat", RepeatArgs{})
+	return r.GetState().RepeatMode
+}
+
+func (r *RemotePlayer) ShouldRepeat() bool {
+	return r.GetState().RepeatMode == settings.RepeatSingle
+}
+
+func (r *RemotePlayer) ShouldRepeatPlaylist() bool {
+	return r.GetState().RepeatMode == settings.RepeatAll
+}
+
+func (r *RemotePlayer) IsStream() bool {
+	return r.current().Stream != nil
+}
+
+func (r *RemotePlayer) StreamInfo() (stream.Info, bool) {
+	if info := r.current().Stream; info != nil {
+		return *info, true
+	}
+	return stream.Info{}, false
+}
+
+func (r *RemotePlayer) IsBuffering() bool {
+	return r.current().Buffering
+}
+
+func (r *RemotePlayer) StreamError() error {
+	if message := r.current().StreamError; message != "" {
+		return errors.New(message)
+	}
+	return nil
+}
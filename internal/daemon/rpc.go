@@ -0,0 +1,291 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"clispot/internal/library"
+	"clispot/internal/settings"
+	"clispot/internal/stream"
+)
+
+// Status is the daemon's state, returned by every call. Times are in
+// seconds and the volume runs from 0 to 1.
+type Status struct {
+	// State is "playing", "paused" or "stopped"
+	State    string  `json:"state"`
+	File     string  `json:"file,omitempty"`
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+	Volume   float64 `json:"volume"`
+	// Repeat is "off", "single" or "all"
+	Repeat  string `json:"repeat"`
+	Shuffle bool   `json:"shuffle"`
+	// Index is the playing song's place in the list being played
+	// through, -1 when it isn't from the list
+	Index  int      `json:"index"`
+	Length int      `json:"length"`
+	Queue  []string `json:"queue"`
+	// Stream is set while internet radio plays
+	Stream      *stream.Info `json:"stream,omitempty"`
+	Buffering   bool         `json:"buffering,omitempty"`
+	StreamError string       `json:"stream_error,omitempty"`
+}
+
+// Args takes no arguments, for calls such as Player.Status
+type Args struct{}
+
+// PlayArgs chooses what Player.Play plays. With Paths it plays through
+// that list from Index; with Path a stream URL, a song, which plays on
+// through the rest of its folder, or every song in a folder. With neither
+// it resumes, or starts the list again after a stop.
+type PlayArgs struct {
+	Path  string   `json:"path,omitempty"`
+	Paths []string `json:"paths,omitempty"`
+	Index int      `json:"index,omitempty"`
+}
+
+// LoadArgs opens Path paused at Position, unless something is playing
+type LoadArgs struct {
+	Path     string  `json:"path"`
+	Position float64 `json:"position"`
+}
+
+// SeekArgs moves to Position, or by it when Relative
+type SeekArgs struct {
+	Position float64 `json:"position"`
+	Relative bool    `json:"relative,omitempty"`
+}
+
+type VolumeArgs struct {
+	Volume float64 `json:"volume"`
+}
+
+// RepeatArgs sets the repeat mode to "off", "one" or "all"; empty cycles
+// through them
+type RepeatArgs struct {
+	Mode string `json:"mode,omitempty"`
+}
+
+// EnqueueArgs adds songs, or every song in folders, to the queue
+type EnqueueArgs struct {
+	Paths []string `json:"paths"`
+}
+
+// service is the daemon's JSON-RPC API, registered as "Player". Every
+// method holds the lock for its whole run, apart from connecting to a
+// stream, and replies with the status after it.
+type service struct {
+	d *Daemon
+}
+
+// call runs fn under the lock and fills in reply
+func (s *service) call(reply *Status, fn func(d *Daemon) error) error {
+	d := s.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err := fn(d)
+	*reply = d.status()
+	return err
+}
+
+func (s *service) Status(args Args, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error { return nil })
+}
+
+func (s *service) Play(args PlayArgs, reply *Status) error {
+	if len(args.Paths) == 0 && stream.IsURL(args.Path) {
+		return s.playStream(args.Path, reply)
+	}
+	return s.call(reply, func(d *Daemon) error {
+		switch {
+		case len(args.Paths) > 0:
+			songs := make([]library.Song, len(args.Paths))
+			for i, path := range args.Paths {
+				songs[i] = d.song(path)
+			}
+			return d.playList(songs, args.Index)
+		case args.Path != "":
+			return d.playPath(args.Path)
+		case d.player.IsPaused():
+			d.player.Resume()
+			return nil
+		case d.player.GetCurrentSong() != "":
+			return nil
+		case len(d.queue) > 0:
+			return d.next()
+		case len(d.list) > 0:
+			return d.play(d.list[max(d.index, 0)])
+		}
+		return fmt.Errorf("nothing to play")
+	})
+}
+
+// playStream connects to the stream at url before taking the lock, as
+// that waits on the network and everything else would wait with it
+func (s *service) playStream(url string, reply *Status) error {
+	conn, err := s.d.player.ConnectStream(url)
+	return s.call(reply, func(d *Daemon) error {
+		if err != nil {
+			return err
+		}
+		d.list = nil
+		d.index = -1
+		return d.player.PlayStream(conn)
+	})
+}
+
+func (s *service) Load(args LoadArgs, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error {
+		if d.player.GetCurrentSong() != "" {
+			return nil
+		}
+		return d.player.Load(args.Path, seconds(args.Position))
+	})
+}
+
+func (s *service) Pause(args Args, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error {
+		if d.player.IsPlaying() && !d.player.IsPaused() {
+			d.player.Pause()
+		}
+		return nil
+	})
+}
+
+func (s *service) Toggle(args Args, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error {
+		d.player.TogglePlayPause()
+		return nil
+	})
+}
+
+func (s *service) Stop(args Args, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error {
+		d.player.Stop()
+		return nil
+	})
+}
+
+func (s *service) Next(args Args, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error { return d.next() })
+}
+
+func (s *service) Previous(args Args, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error { return d.previous() })
+}
+
+func (s *service) Seek(args SeekArgs, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error {
+		position := seconds(args.Position)
+		if args.Relative {
+			position += d.player.GetState().Position
+		}
+		return d.player.Seek(max(position, 0))
+	})
+}
+
+func (s *service) SetVolume(args VolumeArgs, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error {
+		d.player.SetVolume(min(max(args.Volume, 0), 1))
+		return nil
+	})
+}
+
+func (s *service) SetRepeat(args RepeatArgs, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error {
+		var mode settings.RepeatMode
+		if args.Mode == "" {
+			mode = d.player.CycleRepeatMode()
+		} else {
+			var err error
+			if mode, err = settings.ParseRepeatMode(args.Mode); err != nil {
+				return err
+			}
+			d.player.SetRepeatMode(mode)
+		}
+		// Read the settings first so changes made elsewhere are kept
+		d.settings.Load()
+		return d.settings.Update(func(s *settings.Settings) {
+			s.RepeatMode = mode
+		})
+	})
+}
+
+func (s *service) Enqueue(args EnqueueArgs, reply *Status) error {
+	return s.call(reply, func(d *Daemon) error {
+		var songs []library.Song
+		for _, path := range args.Paths {
+			found, err := d.songsAt(path)
+			if err != nil {
+				return err
+			}
+			songs = append(songs, found...)
+		}
+		d.queue = append(d.queue, songs...)
+		return nil
+	})
+}
+
+// Quit stops the daemon, once the reply is on its way
+func (s *service) Quit(args Args, reply *Status) error {
+	err := s.call(reply, func(d *Daemon) error { return nil })
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.d.Shutdown()
+	}()
+	return err
+}
+
+// status reports the daemon's state. d.mu must be held.
+func (d *Daemon) status() Status {
+	state := d.player.GetState()
+	status := Status{
+		State:    "stopped",
+		File:     state.CurrentSong,
+		Position: state.Position.Seconds(),
+		Duration: state.Duration.Seconds(),
+		Volume:   state.Volume,
+		Repeat:   strings.ToLower(state.RepeatMode.String()),
+		Shuffle:  d.settings.Get().Shuffle,
+		Index:    d.index,
+		Length:   len(d.list),
+		Queue:    make([]string, 0, len(d.queue)),
+	}
+	switch {
+	case state.IsPlaying:
+		status.State = "playing"
+	case state.CurrentSong != "":
+		status.State = "paused"
+	}
+	// Queued songs and streams play outside the list
+	if len(d.list) == 0 || d.index < 0 || d.list[d.index].FilePath != state.CurrentSong {
+		status.Index = -1
+	}
+	for _, song := range d.queue {
+		status.Queue = append(status.Queue, song.FilePath)
+	}
+
+	if info, ok := d.player.StreamInfo(); ok {
+		status.Stream = &info
+		status.Title = info.Title
+		status.Buffering = d.player.IsBuffering()
+		if err := d.player.StreamError(); err != nil {
+			status.StreamError = err.Error()
+		}
+	} else if state.CurrentSong != "" {
+		song := d.song(state.CurrentSong)
+		status.Title = song.Title
+		status.Artist = song.Artist
+		status.Album = song.Album
+	}
+	return status
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,62 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SocketPath is where the daemon listens: clispot.sock in
+// $XDG_RUNTIME_DIR, or in a private folder under the temporary directory
+// when that isn't set
+func SocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "clispot.sock")
+	}
+	return filepath.Join(privateDir(), "clispot.sock")
+}
+
+// privateDir is the folder under the temporary directory that holds the
+// socket when there is no runtime directory. Other users can create
+// folders there too, so Listen checks it before trusting it.
+func privateDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("clispot-%d", os.Getuid()))
+}
+
+// Listen opens the control socket at path. A socket left behind by a
+// daemon that didn't shut down cleanly is replaced; one that still answers
+// means a daemon is already running.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if dir == privateDir() {
+		if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+			return nil, fmt.Errorf("error creating socket directory: %v", err)
+		}
+		if err := checkPrivateDir(dir); err != nil {
+			return nil, err
+		}
+	} else if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating socket directory: %v", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %s", path)
+		}
+		os.Remove(path)
+	}
+
+	// Only the user running the daemon may control it, from the moment the
+	// socket exists
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %v", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error securing %s: %v", path, err)
+	}
+	return listener, nil
+}
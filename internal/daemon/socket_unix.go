@@ -0,0 +1,37 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkPrivateDir makes sure dir is a real folder of our own that nobody
+// else can use, so no one can have planted a socket in it
+func checkPrivateDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("error checking socket directory: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("socket directory %s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("socket directory %s belongs to another user", dir)
+	}
+	if mode := info.Mode().Perm(); mode != 0700 {
+		return fmt.Errorf("socket directory %s has mode %#o, want 0700", dir, mode)
+	}
+	return nil
+}
+
+// listenPrivate creates the socket at path with no permissions for
+// anyone but its owner
+func listenPrivate(path string) (net.Listener, error) {
+	old := syscall.Umask(0077)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package daemon
+
+import "net"
+
+// checkPrivateDir does nothing on Windows, where the temporary directory
+// is already private to the user
+func checkPrivateDir(dir string) error {
+	return nil
+}
+
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
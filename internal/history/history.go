@@ -0,0 +1,224 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"clispot/internal/library"
+	"clispot/internal/persist"
+	"clispot/internal/player"
+)
+
+// MinCountedListen is how long a song must play to count as a play when it
+// was not listened to the end
+const MinCountedListen = 30 * time.Second
+
+// Play is a single listening session of one song
+type Play struct {
+	Path   string `json:"path"`
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+	// AlbumArtist is the artist the album is filed under, which differs
+	// from Artist on compilations
+	AlbumArtist string    `json:"album_artist,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	Seconds     float64   `json:"seconds_listened"`
+	Skipped     bool      `json:"skipped"`
+	Finished    bool      `json:"finished"`
+}
+
+// Counts reports whether the play is long enough to count towards play counts
+func (p Play) Counts() bool {
+	return p.Finished || time.Duration(p.Seconds*float64(time.Second)) >= MinCountedListen
+}
+
+// Store is an append-only log of plays, one JSON object per line
+type Store struct {
+	path string
+}
+
+func NewStore(configDir string) *Store {
+	return &Store{
+		path: filepath.Join(configDir, "history.jsonl"),
+	}
+}
+
+// Path returns the location of the history log
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Record appends a play to the log and syncs it to disk
+func (s *Store) Record(play Play) error {
+	data, err := json.Marshal(play)
+	if err != nil {
+		return fmt.Errorf("error marshaling play: %v", err)
+	}
+	data = append(data, '\n')
+
+	lock, err := persist.Lock(s.path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening history: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("error writing history: %v", err)
+	}
+	return file.Sync()
+}
+
+// Plays returns every recorded play in the order they were recorded.
+// Lines that fail to parse, such as one torn by a crash, are skipped.
+func (s *Store) Plays() ([]Play, error) {
+	lock, err := persist.RLock(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading history: %v", err)
+	}
+
+	var plays []Play
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var play Play
+		if err := json.Unmarshal(line, &play); err != nil {
+			continue
+		}
+		plays = append(plays, play)
+	}
+
+	return plays, scanner.Err()
+}
+
+// RenamePaths rewrites the log so plays of files that were moved point at
+// their new paths; paths maps old paths to new ones. Lines that fail to
+// parse are kept as they are.
+func (s *Store) RenamePaths(paths map[string]string) error {
+	return persist.Update(s.path, 0644, nil, func(data []byte) ([]byte, error) {
+		var out bytes.Buffer
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			var play Play
+			if err := json.Unmarshal(line, &play); err == nil {
+				if to, ok := paths[play.Path]; ok {
+					play.Path = to
+					if line, err = json.Marshal(play); err != nil {
+						return nil, fmt.Errorf("error marshaling play: %v", err)
+					}
+				}
+			}
+			out.Write(line)
+			out.WriteByte('\n')
+		}
+		return out.Bytes(), scanner.Err()
+	})
+}
+
+// PlayCounts returns the number of counted plays per file path
+func (s *Store) PlayCounts() map[string]int {
+	counts := make(map[string]int)
+	plays, err := s.Plays()
+	if err != nil {
+		return counts
+	}
+	for _, play := range plays {
+		if play.Counts() {
+			counts[play.Path]++
+		}
+	}
+	return counts
+}
+
+// Recorder turns player start and end transitions into recorded plays
+type Recorder struct {
+	store   *Store
+	lookup  func(path string) (library.Song, bool)
+	started map[string]time.Time
+	onPlay  func(Play)
+}
+
+// NewRecorder creates a recorder that fills in song details through lookup
+func NewRecorder(store *Store, lookup func(path string) (library.Song, bool)) *Recorder {
+	return &Recorder{
+		store:   store,
+		lookup:  lookup,
+		started: make(map[string]time.Time),
+	}
+}
+
+// OnPlay registers fn to be called after each play is recorded
+func (r *Recorder) OnPlay(fn func(Play)) {
+	r.onPlay = fn
+}
+
+// HandleEvent is a player listener; register it with player.AddListener.
+// Internet radio is not recorded, as there is no song to count a play of.
+func (r *Recorder) HandleEvent(event player.PlaybackEvent) {
+	if event.Stream {
+		return
+	}
+	switch event.Type {
+	case player.EventStart:
+		r.started[event.Song] = event.Time
+	case player.EventEnd:
+		startedAt, ok := r.started[event.Song]
+		if !ok {
+			startedAt = event.Time.Add(-event.Listened)
+		}
+		delete(r.started, event.Song)
+
+		// A song loaded but never played, such as a restored session
+		// that was not resumed, is not a play
+		if event.Listened < time.Second && !event.Finished {
+			return
+		}
+
+		play := Play{
+			Path:      event.Song,
+			StartedAt: startedAt,
+			Seconds:   event.Listened.Seconds(),
+			Finished:  event.Finished,
+			Skipped:   !event.Finished,
+		}
+		if song, ok := r.lookup(event.Song); ok {
+			play.Title = song.Title
+			play.Artist = song.Artist
+			play.Album = song.Album
+			play.AlbumArtist = song.GroupArtist()
+		}
+
+		if err := r.store.Record(play); err != nil {
+			return
+		}
+		if r.onPlay != nil {
+			r.onPlay(play)
+		}
+	}
+}
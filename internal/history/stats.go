@@ -0,0 +1,155 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Period selects the time window statistics are computed over
+type Period int
+
+const (
+	PeriodWeek Period = iota
+	PeriodMonth
+	PeriodAllTime
+)
+
+func (p Period) String() string {
+	switch p {
+	case PeriodWeek:
+		return "This week"
+	case PeriodMonth:
+		return "This month"
+	default:
+		return "All time"
+	}
+}
+
+// Since returns the start of the period relative to now
+func (p Period) Since(now time.Time) time.Time {
+	switch p {
+	case PeriodWeek:
+		return now.AddDate(0, 0, -7)
+	case PeriodMonth:
+		return now.AddDate(0, -1, 0)
+	default:
+		return time.Time{}
+	}
+}
+
+// ParsePeriod accepts "week", "month" or "all"
+func ParsePeriod(s string) (Period, error) {
+	switch strings.ToLower(s) {
+	case "week", "w":
+		return PeriodWeek, nil
+	case "month", "m":
+		return PeriodMonth, nil
+	case "all", "alltime", "all-time", "a":
+		return PeriodAllTime, nil
+	}
+	return PeriodAllTime, fmt.Errorf("unknown period %q (want week, month or all)", s)
+}
+
+// Entry is one row of a top list
+type Entry struct {
+	Name    string
+	Plays   int
+	Seconds float64
+}
+
+// Stats summarises listening over a period
+type Stats struct {
+	Period     Period
+	Plays      int
+	TotalHours float64
+	TopArtists []Entry
+	TopAlbums  []Entry
+	TopTracks  []Entry
+}
+
+// Stats computes listening statistics for period, keeping limit entries per list
+func (s *Store) Stats(period Period, limit int) (Stats, error) {
+	plays, err := s.Plays()
+	if err != nil {
+		return Stats{}, err
+	}
+	return Compute(plays, period, limit, time.Now()), nil
+}
+
+// Compute builds statistics from plays as seen at now
+func Compute(plays []Play, period Period, limit int, now time.Time) Stats {
+	since := period.Since(now)
+	artists := make(map[string]*Entry)
+	albums := make(map[string]*Entry)
+	tracks := make(map[string]*Entry)
+
+	stats := Stats{Period: period}
+	var seconds float64
+	for _, play := range plays {
+		if play.StartedAt.Before(since) {
+			continue
+		}
+		seconds += play.Seconds
+		if !play.Counts() {
+			continue
+		}
+		stats.Plays++
+
+		artist := orUnknown(play.Artist, "Unknown Artist")
+		album := orUnknown(play.Album, "Unknown Album")
+		title := play.Title
+		if title == "" {
+			title = play.Path
+		}
+
+		tally(artists, artist, artist, play.Seconds)
+		albumArtist := orUnknown(play.AlbumArtist, artist)
+		tally(albums, album+"\x00"+albumArtist, fmt.Sprintf("%s — %s", album, albumArtist), play.Seconds)
+		tally(tracks, play.Path, fmt.Sprintf("%s — %s", artist, title), play.Seconds)
+	}
+
+	stats.TotalHours = seconds / 3600
+	stats.TopArtists = top(artists, limit)
+	stats.TopAlbums = top(albums, limit)
+	stats.TopTracks = top(tracks, limit)
+	return stats
+}
+
+func tally(entries map[string]*Entry, key, name string, seconds float64) {
+	entry, ok := entries[key]
+	if !ok {
+		entry = &Entry{Name: name}
+		entries[key] = entry
+	}
+	entry.Plays++
+	entry.Seconds += seconds
+}
+
+func top(entries map[string]*Entry, limit int) []Entry {
+	result := make([]Entry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Plays != result[j].Plays {
+			return result[i].Plays > result[j].Plays
+		}
+		if result[i].Seconds != result[j].Seconds {
+			return result[i].Seconds > result[j].Seconds
+		}
+		return result[i].Name < result[j].Name
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+func orUnknown(value, unknown string) string {
+	if strings.TrimSpace(value) == "" {
+		return unknown
+	}
+	return value
+}
@@ -0,0 +1,88 @@
+package keymap
+
+import "strings"
+
+// Dispatcher turns key presses into actions, buffering keys while they form
+// the start of a longer sequence such as "g g"
+type Dispatcher struct {
+	keymap  *Keymap
+	pending []string
+	// waiting is the action bound to the pending keys themselves, fired if
+	// the longer sequence is not completed
+	waiting string
+}
+
+// NewDispatcher creates a dispatcher for k
+func NewDispatcher(k *Keymap) *Dispatcher {
+	return &Dispatcher{keymap: k}
+}
+
+// SetKeymap replaces the keymap and drops any pending keys
+func (d *Dispatcher) SetKeymap(k *Keymap) {
+	d.keymap = k
+	d.Reset()
+}
+
+// Keymap returns the keymap in use
+func (d *Dispatcher) Keymap() *Keymap {
+	return d.keymap
+}
+
+// Feed handles one key in context. It returns the actions to run, in
+// order, and whether the key was consumed. A consumed key with no actions
+// is part of an unfinished sequence; call Flush after a timeout to give up
+// on it. A key that breaks off a sequence can return the action of the
+// keys before it along with its own, and unconsumed, it still goes to
+// the focused widget after that action has run.
+func (d *Dispatcher) Feed(context, key string) (actions []string, consumed bool) {
+	seq := strings.Join(append(d.pending, key), " ")
+	bound, exact, prefix := d.keymap.Lookup(context, seq)
+
+	if prefix {
+		d.pending = append(d.pending, key)
+		d.waiting = bound
+		return nil, true
+	}
+	if exact {
+		d.Reset()
+		return []string{bound}, true
+	}
+
+	// The sequence broke off: run what the pending keys alone were bound
+	// to, then treat this key on its own
+	hadPending := len(d.pending) > 0
+	waiting := d.waiting
+	d.Reset()
+	if !hadPending {
+		return nil, false
+	}
+	actions, consumed = d.Feed(context, key)
+	if waiting != "" {
+		actions = append([]string{waiting}, actions...)
+	}
+	return actions, consumed
+}
+
+// Pending reports whether keys are buffered waiting for a longer sequence
+func (d *Dispatcher) Pending() bool {
+	return len(d.pending) > 0
+}
+
+// PendingKeys returns the buffered keys, for display
+func (d *Dispatcher) PendingKeys() string {
+	return strings.Join(d.pending, " ")
+}
+
+// Flush abandons the pending sequence, returning the action bound to the
+// keys typed so far, if any
+func (d *Dispatcher) Flush() string {
+	waiting := d.waiting
+	d.Reset()
+	return waiting
+}
+
+// Reset drops pending keys
+func (d *Dispatcher) Reset() {
+	d.pending = nil
+	d.waiting = ""
+}
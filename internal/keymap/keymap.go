@@ -0,0 +1,346 @@
+package keymap
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Contexts group bindings by UI mode. Bindings in Global apply in every
+// context except text input ones, where unbound keys are typed instead.
+const (
+	Global  = "global"
+	Browser = "browser"
+	Search  = "search"
+	Command = "command"
+	Editor  = "editor"
+)
+
+// inputContexts are contexts where keys without a binding go to a text field
+var inputContexts = map[string]bool{
+	Search:  true,
+	Command: true,
+	Editor:  true,
+}
+
+// Action describes a named command that keys can be bound to
+type Action struct {
+	Name        string
+	Description string
+}
+
+// Actions lists every bindable action in the order the help panel shows them
+var Actions = []Action{
+	{"play-pause", "Play/Pause"},
+	{"select", "Play/Browse"},
+	{"next", "Next song"},
+	{"previous", "Previous song"},
+	{"stop", "Stop"},
+	{"seek-forward", "Seek +10s"},
+	{"seek-backward", "Seek -10s"},
+	{"next-chapter", "Next chapter"},
+	{"previous-chapter", "Previous chapter"},
+	{"enqueue", "Add to queue"},
+	{"search", "Search"},
+	{"back", "Go back"},
+	{"next-view", "Next browse view"},
+	{"previous-view", "Previous browse view"},
+	{"volume-up", "Volume up"},
+	{"volume-down", "Volume down"},
+	{"repeat", "Repeat mode"},
+	{"shuffle", "Shuffle"},
+	{"toggle-progress", "Toggle progress"},
+	{"sort", "Sort order"},
+	{"stats", "Listening stats"},
+	{"rate-0", "Clear rating"},
+	{"rate-1", "Rate 1 star"},
+	{"rate-2", "Rate 2 stars"},
+	{"rate-3", "Rate 3 stars"},
+	{"rate-4", "Rate 4 stars"},
+	{"rate-5", "Rate 5 stars"},
+	{"love", "Love song"},
+	{"edit-tags", "Edit tags"},
+	{"toggle-lyrics", "Show lyrics"},
+	{"toggle-radio", "Radio stations"},
+	{"toggle-podcasts", "Podcasts"},
+	{"lyrics-scroll-down", "Scroll lyrics down"},
+	{"lyrics-scroll-up", "Scroll lyrics up"},
+	{"lyrics-earlier", "Show lyrics earlier"},
+	{"lyrics-later", "Show lyrics later"},
+	{"cycle-theme", "Cycle theme"},
+	{"cycle-layout", "Cycle layout"},
+	{"settings-info", "Settings info"},
+	{"down", "Move down"},
+	{"up", "Move up"},
+	{"top", "Go to top"},
+	{"bottom", "Go to bottom"},
+	{"page-down", "Page down"},
+	{"page-up", "Page up"},
+	{"command-line", "Command line"},
+	{"search-submit", "Run search"},
+	{"search-cancel", "Exit search"},
+	{"command-submit", "Run command"},
+	{"command-complete", "Complete command"},
+	{"command-cancel", "Exit command line"},
+	{"editor-save", "Save tags"},
+	{"editor-cancel", "Close tag editor"},
+	{"quit", "Quit"},
+}
+
+// defaults reproduces the keys clispot has always used
+var defaults = map[string]map[string]string{
+	Global: {
+		"q":         "quit",
+		"Q":         "quit",
+		"Space":     "play-pause",
+		"n":         "next",
+		"N":         "next",
+		"p":         "previous",
+		"P":         "previous",
+		"s":         "stop",
+		"S":         "stop",
+		"/":         "search",
+		"+":         "volume-up",
+		"=":         "volume-up",
+		"-":         "volume-down",
+		"l":         "repeat",
+		"L":         "repeat",
+		"b":         "toggle-progress",
+		"B":         "toggle-progress",
+		"?":         "settings-info",
+		"o":         "sort",
+		"O":         "sort",
+		"t":         "stats",
+		"T":         "stats",
+		"0":         "rate-0",
+		"1":         "rate-1",
+		"2":         "rate-2",
+		"3":         "rate-3",
+		"4":         "rate-4",
+		"5":         "rate-5",
+		"f":         "love",
+		"F":         "love",
+		"r":         "shuffle",
+		"R":         "shuffle",
+		"c":         "cycle-theme",
+		"C":         "cycle-theme",
+		".":         "seek-forward",
+		",":         "seek-backward",
+		"]":         "next-chapter",
+		"[":         "previous-chapter",
+		"e":         "enqueue",
+		"m":         "cycle-layout",
+		"M":         "cycle-layout",
+		":":         "command-line",
+		"E":         "enqueue",
+		"i":         "edit-tags",
+		"y":         "toggle-lyrics",
+		"Y":         "toggle-lyrics",
+		"a":         "toggle-radio",
+		"A":         "toggle-radio",
+		"d":         "toggle-podcasts",
+		"D":         "toggle-podcasts",
+		"J":         "lyrics-scroll-down",
+		"K":         "lyrics-scroll-up",
+		"<":         "lyrics-earlier",
+		">":         "lyrics-later",
+		"Enter":     "select",
+		"Backspace": "back",
+		"Tab":       "next-view",
+		"Backtab":   "previous-view",
+	},
+	Search: {
+		"Esc":   "search-cancel",
+		"Enter": "search-submit",
+	},
+	Command: {
+		"Esc":   "command-cancel",
+		"Enter": "command-submit",
+		"Tab":   "command-complete",
+	},
+	Editor: {
+		"Esc":    "editor-cancel",
+		"Ctrl+s": "editor-save",
+	},
+}
+
+// Keymap maps key sequences to action names per context
+type Keymap struct {
+	contexts map[string]map[string]string
+}
+
+// Binding is one key sequence bound to an action
+type Binding struct {
+	Keys   string
+	Action string
+}
+
+// Default returns a keymap with clispot's built-in bindings
+func Default() *Keymap {
+	k := &Keymap{contexts: make(map[string]map[string]string)}
+	for context, bindings := range defaults {
+		for keys, action := range bindings {
+			k.Bind(context, keys, action)
+		}
+	}
+	return k
+}
+
+// Path returns the location of the user's keybindings file
+func Path(configDir string) string {
+	return filepath.Join(configDir, "keybindings.json")
+}
+
+// Load returns the default keymap with the overrides in path applied. The
+// file maps contexts to {"keys": "action"} objects; an action of "none"
+// removes a default binding. A missing file is not an error.
+func Load(path string) (*Keymap, error) {
+	k := Default()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return k, nil
+		}
+		return k, fmt.Errorf("error reading keybindings: %v", err)
+	}
+
+	var overrides map[string]map[string]string
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return k, fmt.Errorf("error parsing keybindings: %v", err)
+	}
+
+	for context, bindings := range overrides {
+		for keys, action := range bindings {
+			if err := k.Bind(context, keys, action); err != nil {
+				return Default(), fmt.Errorf("keybindings %s %q: %v", context, keys, err)
+			}
+		}
+	}
+	return k, nil
+}
+
+// Bind binds keys to action in context. keys is a space separated sequence
+// such as "g g" or "Ctrl+d"; "gg" is shorthand for "g g". An action of
+// "none" or "" removes the binding, and an action starting with ":" is a
+// command line such as ":vol 40".
+func (k *Keymap) Bind(context, keys, action string) error {
+	seq, err := ParseKeys(keys)
+	if err != nil {
+		return err
+	}
+	if k.contexts[context] == nil {
+		k.contexts[context] = make(map[string]string)
+	}
+
+	if action == "" || action == "none" {
+		// An empty action masks a binding inherited from Global
+		if context == Global {
+			delete(k.contexts[context], seq)
+		} else {
+			k.contexts[context][seq] = ""
+		}
+		return nil
+	}
+	if !IsAction(action) && !IsCommandLine(action) {
+		return fmt.Errorf("unknown action %q", action)
+	}
+	k.contexts[context][seq] = action
+	return nil
+}
+
+// RegisterAction adds an action so it can be bound; actions that already
+// exist are left alone
+func RegisterAction(name, description string) {
+	if IsAction(name) {
+		return
+	}
+	Actions = append(Actions, Action{Name: name, Description: description})
+}
+
+// IsAction reports whether name is a known action
+func IsAction(name string) bool {
+	for _, action := range Actions {
+		if action.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCommandLine reports whether a bound action is a command line
+func IsCommandLine(action string) bool {
+	return strings.HasPrefix(action, ":") && len(action) > 1
+}
+
+// Lookup finds seq in context. exact reports a binding for seq itself and
+// prefix reports that longer sequences start with seq.
+func (k *Keymap) Lookup(context, seq string) (action string, exact bool, prefix bool) {
+	masked := false
+	for _, ctx := range k.chain(context) {
+		for keys, bound := range k.contexts[ctx] {
+			if keys == seq && !exact && !masked {
+				action, exact, masked = bound, bound != "", true
+			} else if bound != "" && strings.HasPrefix(keys, seq+" ") {
+				prefix = true
+			}
+		}
+	}
+	return action, exact, prefix
+}
+
+// chain lists the contexts searched for context, most specific first
+func (k *Keymap) chain(context string) []string {
+	if context == Global {
+		return []string{Global}
+	}
+	if inputContexts[context] {
+		return []string{context}
+	}
+	return []string{context, Global}
+}
+
+// Bindings returns the bindings active in context, in Actions order
+func (k *Keymap) Bindings(context string) []Binding {
+	// Apply the chain from least to most specific so overrides win
+	effective := make(map[string]string)
+	chain := k.chain(context)
+	for i := len(chain) - 1; i >= 0; i-- {
+		for keys, action := range k.contexts[chain[i]] {
+			effective[keys] = action
+		}
+	}
+
+	var result []Binding
+	for _, action := range Actions {
+		for _, keys := range sortedKeys(effective) {
+			if effective[keys] == action.Name {
+				result = append(result, Binding{Keys: keys, Action: action.Name})
+			}
+		}
+	}
+	return result
+}
+
+// KeysFor returns the sequences bound to action in context
+func (k *Keymap) KeysFor(context, action string) []string {
+	var keys []string
+	for _, binding := range k.Bindings(context) {
+		if binding.Action == action {
+			keys = append(keys, binding.Keys)
+		}
+	}
+	return keys
+}
+
+// Describe returns the help text of an action
+func Describe(action string) string {
+	for _, a := range Actions {
+		if a.Name == action {
+			return a.Description
+		}
+	}
+	return action
+}
@@ -0,0 +1,180 @@
+package keymap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// specialKeys names non-rune keys. Keys that tcell reports as control
+// characters (Enter is Ctrl+M, Tab is Ctrl+I) are listed so they keep
+// their usual names.
+var specialKeys = map[tcell.Key]string{
+	tcell.KeyEnter:      "Enter",
+	tcell.KeyTab:        "Tab",
+	tcell.KeyBacktab:    "Backtab",
+	tcell.KeyEscape:     "Esc",
+	tcell.KeyBackspace:  "Backspace",
+	tcell.KeyBackspace2: "Backspace",
+	tcell.KeyDelete:     "Delete",
+	tcell.KeyInsert:     "Insert",
+	tcell.KeyUp:         "Up",
+	tcell.KeyDown:       "Down",
+	tcell.KeyLeft:       "Left",
+	tcell.KeyRight:      "Right",
+	tcell.KeyHome:       "Home",
+	tcell.KeyEnd:        "End",
+	tcell.KeyPgUp:       "PgUp",
+	tcell.KeyPgDn:       "PgDn",
+	tcell.KeyF1:         "F1",
+	tcell.KeyF2:         "F2",
+	tcell.KeyF3:         "F3",
+	tcell.KeyF4:         "F4",
+	tcell.KeyF5:         "F5",
+	tcell.KeyF6:         "F6",
+	tcell.KeyF7:         "F7",
+	tcell.KeyF8:         "F8",
+	tcell.KeyF9:         "F9",
+	tcell.KeyF10:        "F10",
+	tcell.KeyF11:        "F11",
+	tcell.KeyF12:        "F12",
+}
+
+// KeyName returns the name of the key in ev as used in keymaps, e.g. "q",
+// "Space", "Enter", "Ctrl+d" or "Alt+x"
+func KeyName(ev *tcell.EventKey) string {
+	if ev.Key() == tcell.KeyRune {
+		name := string(ev.Rune())
+		if ev.Rune() == ' ' {
+			name = "Space"
+		}
+		if ev.Modifiers()&tcell.ModAlt != 0 {
+			name = "Alt+" + name
+		}
+		return name
+	}
+
+	name, ok := specialKeys[ev.Key()]
+	if !ok && ev.Key() >= tcell.KeyCtrlA && ev.Key() <= tcell.KeyCtrlZ {
+		return "Ctrl+" + string(rune('a'+ev.Key()-tcell.KeyCtrlA))
+	}
+	if !ok {
+		return ev.Name()
+	}
+
+	var prefix string
+	if ev.Modifiers()&tcell.ModCtrl != 0 {
+		prefix += "Ctrl+"
+	}
+	if ev.Modifiers()&tcell.ModAlt != 0 {
+		prefix += "Alt+"
+	}
+	if ev.Modifiers()&tcell.ModShift != 0 {
+		prefix += "Shift+"
+	}
+	return prefix + name
+}
+
+// ParseKeys normalizes a key sequence to space separated key names. A word
+// that is not a key name and has no modifier, like "gg", is read as one key
+// per character.
+func ParseKeys(keys string) (string, error) {
+	var seq []string
+	for _, word := range strings.Fields(keys) {
+		if name, ok := normalizeKey(word); ok {
+			seq = append(seq, name)
+			continue
+		}
+		if strings.Contains(word, "+") && utf8.RuneCountInString(word) > 1 {
+			return "", fmt.Errorf("unknown key %q", word)
+		}
+		for _, r := range word {
+			seq = append(seq, string(r))
+		}
+	}
+	if len(seq) == 0 {
+		return "", fmt.Errorf("empty key sequence")
+	}
+	return strings.Join(seq, " "), nil
+}
+
+// normalizeKey returns the canonical form of a single key name
+func normalizeKey(word string) (string, bool) {
+	if utf8.RuneCountInString(word) == 1 {
+		return word, true
+	}
+
+	var mods []string
+	base := word
+	for {
+		head, rest, ok := strings.Cut(base, "+")
+		if !ok || rest == "" {
+			break
+		}
+		switch strings.ToLower(head) {
+		case "ctrl", "c":
+			mods = append(mods, "Ctrl")
+		case "alt", "a", "meta", "m":
+			mods = append(mods, "Alt")
+		case "shift", "s":
+			mods = append(mods, "Shift")
+		default:
+			return "", false
+		}
+		base = rest
+	}
+
+	if utf8.RuneCountInString(base) == 1 {
+		if len(mods) == 1 && mods[0] == "Ctrl" {
+			base = strings.ToLower(base)
+		}
+	} else {
+		found := false
+		for _, name := range specialKeyNames() {
+			if strings.EqualFold(name, base) {
+				base, found = name, true
+				break
+			}
+		}
+		switch strings.ToLower(base) {
+		case "space":
+			base, found = "Space", true
+		case "escape":
+			base, found = "Esc", true
+		case "return":
+			base, found = "Enter", true
+		case "pageup":
+			base, found = "PgUp", true
+		case "pagedown":
+			base, found = "PgDn", true
+		}
+		if !found {
+			return "", false
+		}
+	}
+
+	if len(mods) == 0 {
+		return base, true
+	}
+	return strings.Join(mods, "+") + "+" + base, true
+}
+
+func specialKeyNames() []string {
+	names := make([]string, 0, len(specialKeys))
+	for _, name := range specialKeys {
+		names = append(names, name)
+	}
+	return names
+}
+
+func sortedKeys(bindings map[string]string) []string {
+	keys := make([]string, 0, len(bindings))
+	for key := range bindings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
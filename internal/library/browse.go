@@ -0,0 +1,411 @@
+package library
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BrowseMode selects how the library is presented: as folders on disk or as
+// a virtual tree built from the tags
+type BrowseMode int
+
+const (
+	BrowseFolders BrowseMode = iota
+	BrowseArtists
+	BrowseAlbums
+	BrowseGenres
+	BrowseYears
+)
+
+// BrowseModes lists the modes in the order their tabs are shown
+var BrowseModes = []BrowseMode{BrowseFolders, BrowseArtists, BrowseAlbums, BrowseGenres, BrowseYears}
+
+func (m BrowseMode) String() string {
+	switch m {
+	case BrowseArtists:
+		return "Artists"
+	case BrowseAlbums:
+		return "Albums"
+	case BrowseGenres:
+		return "Genres"
+	case BrowseYears:
+		return "Years"
+	default:
+		return "Folders"
+	}
+}
+
+// ParseBrowseMode reads a mode name such as "artists"
+func ParseBrowseMode(name string) (BrowseMode, error) {
+	for _, mode := range BrowseModes {
+		if strings.EqualFold(name, mode.String()) {
+			return mode, nil
+		}
+	}
+	return BrowseFolders, fmt.Errorf("unknown view %q", name)
+}
+
+// Next returns the mode whose tab follows m
+func (m BrowseMode) Next() BrowseMode {
+	return (m + 1) % BrowseMode(len(BrowseModes))
+}
+
+// Previous returns the mode whose tab precedes m
+func (m BrowseMode) Previous() BrowseMode {
+	return (m + BrowseMode(len(BrowseModes)) - 1) % BrowseMode(len(BrowseModes))
+}
+
+// grouping is one level of a virtual tree: the key songs are grouped by and
+// how a group is labelled
+type grouping struct {
+	key   func(Song) string
+	label func(key string, songs []Song) string
+	less  func(a, b group) bool
+}
+
+type group struct {
+	key   string
+	label string
+	songs []Song
+}
+
+// levels returns the groupings of each level of mode's tree. Below the
+// last level are the tracks themselves.
+func (m BrowseMode) levels() []grouping {
+	switch m {
+	case BrowseArtists:
+		return []grouping{byArtist, byAlbumOfArtist}
+	case BrowseAlbums:
+		return []grouping{byAlbum}
+	case BrowseGenres:
+		return []grouping{byGenre, byAlbum}
+	case BrowseYears:
+		return []grouping{byYear, byAlbum}
+	}
+	return nil
+}
+
+var byArtist = grouping{
+	key: func(s Song) string { return orUnknown(s.GroupArtist(), "Unknown Artist") },
+	label: func(key string, songs []Song) string {
+		return key
+	},
+	less: byLabel,
+}
+
+var byAlbumOfArtist = grouping{
+	key: func(s Song) string { return orUnknown(s.Album, "Unknown Album") },
+	label: func(key string, songs []Song) string {
+		if year := albumYear(songs); year != "" {
+			return fmt.Sprintf("%s (%s)", key, year)
+		}
+		return key
+	},
+	less: byYearThenLabel,
+}
+
+var byAlbum = grouping{
+	key: func(s Song) string { return AlbumKey(s) },
+	label: func(key string, songs []Song) string {
+		label := fmt.Sprintf("%s — %s", orUnknown(songs[0].Album, "Unknown Album"), orUnknown(songs[0].GroupArtist(), "Unknown Artist"))
+		if year := albumYear(songs); year != "" {
+			label += fmt.Sprintf(" (%s)", year)
+		}
+		return label
+	},
+	less: byYearThenLabel,
+}
+
+var byGenre = grouping{
+	key: func(s Song) string { return orUnknown(s.Genre, "Unknown Genre") },
+	label: func(key string, songs []Song) string {
+		return key
+	},
+	less: byLabel,
+}
+
+var byYear = grouping{
+	key: func(s Song) string { return orUnknown(songYear(s), "Unknown Year") },
+	label: func(key string, songs []Song) string {
+		return key
+	},
+	less: func(a, b group) bool {
+		return yearLess(a.key, b.key)
+	},
+}
+
+func byLabel(a, b group) bool {
+	return strings.ToLower(a.label) < strings.ToLower(b.label)
+}
+
+func byYearThenLabel(a, b group) bool {
+	ya, yb := albumYear(a.songs), albumYear(b.songs)
+	if ya != yb {
+		return yearLess(ya, yb)
+	}
+	return byLabel(a, b)
+}
+
+// yearLess orders years numerically with unknown years last
+func yearLess(a, b string) bool {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return na < nb
+	case errA == nil:
+		return true
+	case errB == nil:
+		return false
+	}
+	return a < b
+}
+
+func orUnknown(value, unknown string) string {
+	if strings.TrimSpace(value) == "" {
+		return unknown
+	}
+	return value
+}
+
+// albumYear is the earliest year among an album's songs
+func albumYear(songs []Song) string {
+	year := ""
+	for _, song := range songs {
+		if y := songYear(song); y != "" && (year == "" || yearLess(y, year)) {
+			year = y
+		}
+	}
+	return year
+}
+
+// songYear returns the year of a song's date tag, which may be a full
+// timestamp such as "2012-09-28T15:01:12"
+func songYear(s Song) string {
+	year := strings.TrimSpace(s.Year)
+	if len(year) > 4 {
+		if _, err := strconv.Atoi(year[:4]); err == nil {
+			return year[:4]
+		}
+	}
+	return year
+}
+
+// VariousArtists is the artist compilations are filed under
+const VariousArtists = "Various Artists"
+
+// GroupArtist is the artist an album is filed under: Various Artists for
+// compilations, otherwise the album artist if set, or the track artist
+func (s Song) GroupArtist() string {
+	if s.Compilation {
+		return VariousArtists
+	}
+	if s.AlbumArtist != "" {
+		return s.AlbumArtist
+	}
+	return s.Artist
+}
+
+// AlbumKey identifies the album a song belongs to, keeping albums with the
+// same title by different artists apart
+func AlbumKey(s Song) string {
+	return orUnknown(s.GroupArtist(), "Unknown Artist") + "\x1e" + orUnknown(s.Album, "Unknown Album")
+}
+
+// virtualSeparator joins the levels of a virtual path in LibraryItem.Path
+const virtualSeparator = "\x1f"
+
+// SetBrowseMode switches to mode at the top of its tree
+func (l *Library) SetBrowseMode(mode BrowseMode) {
+	l.mode = mode
+	l.virtualPath = nil
+}
+
+// BrowseMode returns the current browse mode
+func (l *Library) BrowseMode() BrowseMode {
+	return l.mode
+}
+
+// VirtualPath returns the keys chosen at each level of a virtual tree
+func (l *Library) VirtualPath() []string {
+	return append([]string(nil), l.virtualPath...)
+}
+
+// SetVirtualPath opens a node of the current virtual tree, as saved from
+// VirtualPath
+func (l *Library) SetVirtualPath(path []string) error {
+	if len(path) > len(l.mode.levels()) {
+		return fmt.Errorf("path too deep for %s view", l.mode)
+	}
+	l.virtualPath = append([]string(nil), path...)
+	return nil
+}
+
+// Open enters a folder item, whether a directory or a virtual group;
+// ".." goes back up
+func (l *Library) Open(item LibraryItem) error {
+	if item.Type != ItemTypeFolder {
+		return fmt.Errorf("%s is not a folder", item.Name)
+	}
+	if l.mode == BrowseFolders {
+		return l.NavigateToFolder(item.Path)
+	}
+	if item.Name == ".." {
+		return l.GoBack()
+	}
+	return l.SetVirtualPath(strings.Split(item.Path, virtualSeparator))
+}
+
+// GoBack moves to the parent of the current folder or group
+func (l *Library) GoBack() error {
+	if !l.CanGoBack() {
+		return nil
+	}
+	if l.mode == BrowseFolders {
+		return l.NavigateToFolder(filepath.Dir(l.currentPath))
+	}
+	l.virtualPath = l.virtualPath[:len(l.virtualPath)-1]
+	return nil
+}
+
+// Breadcrumb returns the labels of the current location, starting from the
+// top of the tree
+func (l *Library) Breadcrumb() []string {
+	if l.mode == BrowseFolders {
+		rel := strings.Trim(filepath.ToSlash(l.GetRelativePath()), "/")
+		if rel == "" {
+			return nil
+		}
+		return strings.Split(rel, "/")
+	}
+
+	var crumbs []string
+	songs := l.songs
+	for i, key := range l.virtualPath {
+		level := l.mode.levels()[i]
+		songs = filterByKey(songs, level, key)
+		if len(songs) == 0 {
+			crumbs = append(crumbs, key)
+			continue
+		}
+		crumbs = append(crumbs, level.label(key, songs))
+	}
+	return crumbs
+}
+
+// nodeSongs returns the songs under the current virtual node
+func (l *Library) nodeSongs() []Song {
+	songs := append([]Song(nil), l.songs...)
+	levels := l.mode.levels()
+	for i, key := range l.virtualPath {
+		songs = filterByKey(songs, levels[i], key)
+	}
+	return songs
+}
+
+func filterByKey(songs []Song, level grouping, key string) []Song {
+	var matched []Song
+	for _, song := range songs {
+		if level.key(song) == key {
+			matched = append(matched, song)
+		}
+	}
+	return matched
+}
+
+// virtualItems lists the groups or tracks at the current virtual node
+func (l *Library) virtualItems() []LibraryItem {
+	var items []LibraryItem
+	if len(l.virtualPath) > 0 {
+		items = append(items, LibraryItem{Type: ItemTypeFolder, Name: ".."})
+	}
+
+	songs := l.nodeSongs()
+	levels := l.mode.levels()
+	if len(l.virtualPath) >= len(levels) {
+		SortTracks(songs)
+		for i := range songs {
+			song := songs[i]
+			items = append(items, LibraryItem{
+				Type: ItemTypeSong,
+				Name: fmt.Sprintf("%s - %s", song.Artist, song.Title),
+				Path: song.FilePath,
+				Song: &song,
+			})
+		}
+		return items
+	}
+
+	level := levels[len(l.virtualPath)]
+	index := make(map[string]int)
+	var groups []group
+	for _, song := range songs {
+		key := level.key(song)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, group{key: key})
+		}
+		groups[i].songs = append(groups[i].songs, song)
+	}
+	for i := range groups {
+		groups[i].label = level.label(groups[i].key, groups[i].songs)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return level.less(groups[i], groups[j])
+	})
+
+	for _, g := range groups {
+		path := append(l.VirtualPath(), g.key)
+		items = append(items, LibraryItem{
+			Type:      ItemTypeFolder,
+			Name:      g.label,
+			Path:      strings.Join(path, virtualSeparator),
+			SongCount: len(g.songs),
+		})
+	}
+	return items
+}
+
+// ItemSongs returns the songs an item stands for: the song itself, or every
+// song inside a folder or virtual group
+func (l *Library) ItemSongs(item LibraryItem) []Song {
+	if item.Type == ItemTypeSong {
+		if item.Song == nil {
+			return nil
+		}
+		if song, ok := l.FindSong(item.Path); ok {
+			return []Song{song}
+		}
+		return []Song{*item.Song}
+	}
+	if item.Name == ".." {
+		return nil
+	}
+
+	var songs []Song
+	if l.mode == BrowseFolders {
+		prefix := item.Path + string(filepath.Separator)
+		for _, song := range l.songs {
+			if strings.HasPrefix(song.FilePath, prefix) {
+				songs = append(songs, song)
+			}
+		}
+		return songs
+	}
+
+	songs = append([]Song(nil), l.songs...)
+	levels := l.mode.levels()
+	for i, key := range strings.Split(item.Path, virtualSeparator) {
+		if i >= len(levels) {
+			break
+		}
+		songs = filterByKey(songs, levels[i], key)
+	}
+	SortTracks(songs)
+	return songs
+}
@@ -0,0 +1,157 @@
+package library
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// Chapter is one CHAP frame of a podcast or audiobook: a titled stretch
+// of the file
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// readChapters returns the chapters of an ID3v2 tag in play order. The
+// top-level CTOC frame gives the order when there is one; otherwise the
+// chapters are sorted by start time.
+func readChapters(tag *id3v2.Tag) []Chapter {
+	frames := tag.GetFrames("CHAP")
+	if len(frames) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]id3v2.ChapterFrame)
+	var ids []string
+	for _, frame := range frames {
+		if chap, ok := frame.(id3v2.ChapterFrame); ok {
+			if _, seen := byID[chap.ElementID]; !seen {
+				ids = append(ids, chap.ElementID)
+			}
+			byID[chap.ElementID] = chap
+		}
+	}
+
+	if order := tocOrder(tag, byID); len(order) > 0 {
+		ids = order
+	} else {
+		sort.SliceStable(ids, func(i, j int) bool {
+			return byID[ids[i]].StartTime < byID[ids[j]].StartTime
+		})
+	}
+
+	chapters := make([]Chapter, 0, len(ids))
+	for _, id := range ids {
+		chap := byID[id]
+		title := id
+		if chap.Title != nil && strings.TrimSpace(chap.Title.Text) != "" {
+			title = strings.TrimSpace(chap.Title.Text)
+		}
+		chapters = append(chapters, Chapter{Title: title, Start: chap.StartTime, End: chap.EndTime})
+	}
+	return chapters
+}
+
+// tocEntry is a parsed CTOC frame
+type tocEntry struct {
+	topLevel bool
+	children []string
+}
+
+// tocOrder flattens the CTOC frames, starting at the top-level one, into
+// the chapter IDs they list. It returns nil when there is no usable table
+// of contents.
+func tocOrder(tag *id3v2.Tag, chapters map[string]id3v2.ChapterFrame) []string {
+	tocs := make(map[string]tocEntry)
+	root := ""
+	for _, frame := range tag.GetFrames("CTOC") {
+		unknown, ok := frame.(id3v2.UnknownFrame)
+		if !ok {
+			continue
+		}
+		id, entry, ok := parseCTOC(unknown.Body)
+		if !ok {
+			continue
+		}
+		tocs[id] = entry
+		if entry.topLevel && root == "" {
+			root = id
+		}
+	}
+	if root == "" {
+		return nil
+	}
+
+	var order []string
+	seen := make(map[string]bool)
+	var walk func(id string)
+	walk = func(id string) {
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		if _, ok := chapters[id]; ok {
+			order = append(order, id)
+			return
+		}
+		for _, child := range tocs[id].children {
+			walk(child)
+		}
+	}
+	walk(root)
+	return order
+}
+
+// parseCTOC reads a CTOC frame body: a terminated element ID, flags, the
+// entry count and that many terminated child IDs. Embedded sub-frames
+// after the children are ignored.
+func parseCTOC(body []byte) (string, tocEntry, bool) {
+	end := bytes.IndexByte(body, 0)
+	if end < 0 || len(body) < end+3 {
+		return "", tocEntry{}, false
+	}
+	id := string(body[:end])
+	entry := tocEntry{topLevel: body[end+1]&0x02 != 0}
+	count := int(body[end+2])
+	rest := body[end+3:]
+	for i := 0; i < count; i++ {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return "", tocEntry{}, false
+		}
+		entry.children = append(entry.children, string(rest[:end]))
+		rest = rest[end+1:]
+	}
+	return id, entry, true
+}
+
+// fixChapterEnds fills in end times that are missing or out of order with
+// the start of the next chapter, or the end of the song for the last one
+func fixChapterEnds(chapters []Chapter, duration time.Duration) {
+	for i := range chapters {
+		next := duration
+		if i+1 < len(chapters) {
+			next = chapters[i+1].Start
+		}
+		if chapters[i].End <= chapters[i].Start || (next > chapters[i].Start && chapters[i].End > next) {
+			chapters[i].End = next
+		}
+	}
+}
+
+// ChapterAt returns the index of the chapter playing at position, or -1
+// when the song has no chapters or position is before the first one
+func (s Song) ChapterAt(position time.Duration) int {
+	current := -1
+	for i, chapter := range s.Chapters {
+		if position >= chapter.Start && (current < 0 || chapter.Start >= s.Chapters[current].Start) {
+			current = i
+		}
+	}
+	return current
+}
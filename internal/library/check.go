@@ -0,0 +1,517 @@
+package library
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// IssueKind names a kind of problem Check can find
+type IssueKind string
+
+const (
+	IssueUnreadable   IssueKind = "unreadable"
+	IssueCorrupt      IssueKind = "corrupt"
+	IssueTruncated    IssueKind = "truncated"
+	IssueZeroDuration IssueKind = "zero-duration"
+	IssueMissingTags  IssueKind = "missing-tags"
+	IssueInvalidTags  IssueKind = "invalid-tags"
+	IssueLargeArt     IssueKind = "large-art"
+)
+
+// Severity tells whether an issue stops a file from playing properly
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Severity returns how serious issues of this kind are: broken audio is
+// an error, tag problems are warnings
+func (k IssueKind) Severity() Severity {
+	switch k {
+	case IssueMissingTags, IssueInvalidTags, IssueLargeArt:
+		return SeverityWarning
+	}
+	return SeverityError
+}
+
+// Issue is one problem found in a file
+type Issue struct {
+	Kind     IssueKind `json:"kind"`
+	Severity Severity  `json:"severity"`
+	Message  string    `json:"message"`
+}
+
+// CheckResult is what Check found in one file
+type CheckResult struct {
+	Path string `json:"path"`
+	// Duration is the length of the audio that decoded, in seconds, or
+	// of the frames found in a quick check
+	Duration float64 `json:"duration"`
+	Issues   []Issue `json:"issues,omitempty"`
+}
+
+func (r *CheckResult) add(kind IssueKind, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, Issue{Kind: kind, Severity: kind.Severity(), Message: fmt.Sprintf(format, args...)})
+}
+
+// HasErrors reports whether any of the file's issues is an error
+func (r CheckResult) HasErrors() bool {
+	for _, issue := range r.Issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultMaxArtSize is the embedded picture size above which Check warns.
+// Large covers slow down every scan and bloat each copy of the file.
+const DefaultMaxArtSize = 1 << 20
+
+// CheckOptions controls Check
+type CheckOptions struct {
+	// MaxArtSize is the largest embedded picture, in bytes, that isn't
+	// reported
+	MaxArtSize int
+	// Quick skips the full decode and relies on walking the frame
+	// headers, which still finds damaged and truncated audio
+	Quick bool
+	// Progress, if set, is called as each file is done. Calls don't
+	// overlap, but come from several goroutines.
+	Progress func(done, total int, path string)
+}
+
+// Check decodes every MP3 file under the library root, without relying on
+// a scan, and returns a result for each in path order
+func (l *Library) Check(opts CheckOptions) ([]CheckResult, error) {
+	var paths []string
+	err := filepath.Walk(l.rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() && info.Name() == TrashDirName {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".mp3" {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Decoding is CPU bound, so files are checked on every core
+	results := make([]CheckResult, len(paths))
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := 0
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = CheckFile(paths[i], opts)
+				if opts.Progress != nil {
+					mu.Lock()
+					done++
+					opts.Progress(done, len(paths), paths[i])
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results, nil
+}
+
+// CheckFile checks a single file: its frames, a full decode, its tags and
+// their embedded art
+func CheckFile(path string, opts CheckOptions) CheckResult {
+	result := CheckResult{Path: path}
+	if opts.MaxArtSize <= 0 {
+		opts.MaxArtSize = DefaultMaxArtSize
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		result.add(IssueUnreadable, "%v", err)
+		return result
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		result.add(IssueUnreadable, "%v", err)
+		return result
+	}
+
+	p, err := audioPayload(path)
+	if err != nil {
+		result.add(IssueCorrupt, "%v", err)
+	} else {
+		// Audiobooks can run to gigabytes, so the audio is streamed
+		// through both checks rather than read in whole
+		checkFrames(&result, io.NewSectionReader(file, p.start, p.size()), int(p.size()))
+		if !opts.Quick {
+			checkDecode(&result, io.NewSectionReader(file, p.start, p.size()))
+		}
+	}
+
+	checkTags(&result, file, info.Size(), opts.MaxArtSize)
+	return result
+}
+
+// checkFrames walks the MPEG frame headers of the audio and reports data
+// that isn't part of any frame and a last frame that is cut short
+func checkFrames(result *CheckResult, audio io.Reader, size int) {
+	scan := scanFrames(audio, size)
+	result.Duration = scan.duration.Seconds()
+	if scan.frames == 0 {
+		result.add(IssueZeroDuration, "no audio frames found")
+		return
+	}
+	if scan.junk > 0 {
+		places := "1 place"
+		if scan.gaps > 1 {
+			places = fmt.Sprintf("%d places", scan.gaps)
+		}
+		result.add(IssueCorrupt, "%d bytes of damaged audio in %s, first at byte %d",
+			scan.junk, places, scan.firstGap)
+	}
+	if scan.missing > 0 {
+		result.add(IssueTruncated, "last frame is missing %d bytes; the file was cut off after %s",
+			scan.missing, formatSeconds(scan.duration))
+	}
+}
+
+// checkDecode decodes the whole stream, as playback would
+func checkDecode(result *CheckResult, audio io.ReadSeeker) {
+	defer func() {
+		// The decoder panics on some malformed frames
+		if r := recover(); r != nil {
+			result.add(IssueCorrupt, "decoder crashed: %v", r)
+		}
+	}()
+
+	decoder, err := mp3.NewDecoder(audio)
+	if err != nil {
+		if !hasIssue(*result, IssueZeroDuration) {
+			result.add(IssueCorrupt, "can't decode: %v", err)
+		}
+		return
+	}
+	n, err := io.Copy(io.Discard, decoder)
+	result.Duration = float64(n/4) / float64(decoder.SampleRate())
+	if err != nil {
+		result.add(IssueCorrupt, "decoding stopped after %s: %v",
+			formatSeconds(time.Duration(result.Duration*float64(time.Second))), err)
+	}
+	if n == 0 && !hasIssue(*result, IssueZeroDuration) {
+		result.add(IssueZeroDuration, "no audio decoded")
+	}
+}
+
+// checkTags reports files without tags, values that can't be right and
+// oversized pictures
+func checkTags(result *CheckResult, file *os.File, size int64, maxArt int) {
+	readings, _, err := readTags(file, size)
+	if err != nil {
+		result.add(IssueInvalidTags, "ID3v2 tag can't be read: %v", err)
+	}
+
+	var song Song
+	song.mergeTags(readings)
+	if len(readings) == 0 {
+		result.add(IssueMissingTags, "no ID3v2, APEv2 or ID3v1 tag")
+		return
+	}
+	var missing []string
+	for _, field := range []TagField{FieldTitle, FieldArtist, FieldAlbum} {
+		if _, ok := song.Sources[field]; !ok {
+			missing = append(missing, strings.ToLower(field.Label()))
+		}
+	}
+	if len(missing) > 0 {
+		result.add(IssueMissingTags, "no %s", strings.Join(missing, ", "))
+	}
+
+	for _, reading := range readings {
+		for _, field := range mergedFields {
+			value := strings.TrimSpace(reading.values[field])
+			if value == "" {
+				continue
+			}
+			if problem := invalidValue(field, value); problem != "" {
+				result.add(IssueInvalidTags, "%s %s %q %s", reading.source, field, value, problem)
+			}
+		}
+		if len(reading.picture) > maxArt {
+			result.add(IssueLargeArt, "%s picture is %s", reading.source, formatBytes(len(reading.picture)))
+		}
+	}
+}
+
+// invalidValue describes what is wrong with a tag value, or returns ""
+func invalidValue(field TagField, value string) string {
+	if !utf8.ValidString(value) || strings.ContainsRune(value, utf8.RuneError) {
+		return "has broken characters"
+	}
+	switch field {
+	case FieldTrack, FieldDisc:
+		if numberInSet(value) <= 0 {
+			return "is not a number"
+		}
+	case FieldYear:
+		// ID3v2.4 dates may add a month, day and time after the year
+		if len(value) < 4 || strings.Trim(value[:4], "0123456789") != "" {
+			return "is not a year"
+		}
+	}
+	return ""
+}
+
+func hasIssue(result CheckResult, kind IssueKind) bool {
+	for _, issue := range result.Issues {
+		if issue.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func formatSeconds(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func formatBytes(n int) string {
+	if n >= 1<<20 {
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%d KB", n>>10)
+}
+
+// frameScan is the outcome of walking a stream's frame headers
+type frameScan struct {
+	frames   int
+	duration time.Duration
+	// junk bytes matched no frame, in gaps separate stretches,
+	// the first starting at firstGap
+	junk, gaps, firstGap int
+	// missing is how many bytes the last frame lacks
+	missing int
+}
+
+// scanFrames walks the MPEG audio frames of the size bytes r holds. A
+// stretch that isn't a frame counts as junk up to the next header that is
+// followed by another one. Only a few frames are held in memory at a time.
+func scanFrames(r io.Reader, size int) frameScan {
+	w := &frameWindow{r: r}
+	header := func(at int) (frameHeader, bool) {
+		b := w.peek(at, 4)
+		if len(b) < 4 {
+			return frameHeader{}, false
+		}
+		return parseFrameHeader(b)
+	}
+
+	var scan frameScan
+	var first frameHeader
+	pos := 0
+	for pos+4 <= size {
+		h, ok := header(pos)
+		if ok && scan.frames > 0 && !h.sameStream(first) {
+			ok = false
+		}
+		if ok && scan.frames == 0 {
+			// Don't lock onto a false sync in leading junk: the next
+			// frame has to follow
+			next := pos + h.length
+			if next+4 <= size {
+				if nh, nok := header(next); !nok || !nh.sameStream(h) {
+					ok = false
+				}
+			}
+		}
+		if !ok {
+			start := pos
+			zero := true
+			for {
+				if b := w.peek(pos, 1); len(b) == 0 || b[0] != 0 {
+					zero = false
+				}
+				pos++
+				if pos+4 > size {
+					break
+				}
+				if h, ok := header(pos); ok && (scan.frames == 0 || h.sameStream(first)) {
+					if next := pos + h.length; next+4 > size {
+						break
+					} else if nh, nok := header(next); nok && nh.sameStream(h) {
+						break
+					}
+				}
+			}
+			if pos+4 > size {
+				for ; pos < size; pos++ {
+					if b := w.peek(pos, 1); len(b) == 0 || b[0] != 0 {
+						zero = false
+					}
+				}
+			}
+			// Zero padding after the last frame is common and harmless
+			if pos == size && zero {
+				break
+			}
+			if scan.gaps == 0 {
+				scan.firstGap = start
+			}
+			scan.junk += pos - start
+			scan.gaps++
+			continue
+		}
+
+		if scan.frames == 0 {
+			first = h
+		}
+		if pos+h.length > size {
+			scan.missing = pos + h.length - size
+			break
+		}
+		scan.frames++
+		scan.duration += time.Duration(h.samples) * time.Second / time.Duration(h.sampleRate)
+		pos += h.length
+	}
+	return scan
+}
+
+// frameWindowChunk is how much frameWindow reads at once
+const frameWindowChunk = 64 << 10
+
+// frameWindowKeep is how far behind the latest position a frameWindow can
+// still look, more than the longest frame
+const frameWindowKeep = 8 << 10
+
+// frameWindow gives scanFrames random access to a stream that it reads
+// forwards, keeping only the bytes near where it is looking
+type frameWindow struct {
+	r    io.Reader
+	buf  []byte
+	base int // stream offset of buf[0]
+	eof  bool
+}
+
+// peek returns n bytes from offset at, fewer at the end of the stream.
+// Offsets far behind the latest one asked for are no longer available.
+func (w *frameWindow) peek(at, n int) []byte {
+	if drop := at - frameWindowKeep - w.base; drop > frameWindowChunk {
+		drop = min(drop, len(w.buf))
+		w.buf = append(w.buf[:0], w.buf[drop:]...)
+		w.base += drop
+	}
+	for !w.eof && w.base+len(w.buf) < at+n {
+		chunk := make([]byte, frameWindowChunk)
+		read, err := io.ReadFull(w.r, chunk)
+		w.buf = append(w.buf, chunk[:read]...)
+		if err != nil {
+			w.eof = true
+		}
+	}
+	from := min(max(at-w.base, 0), len(w.buf))
+	return w.buf[from:min(from+n, len(w.buf))]
+}
+
+// frameHeader is the part of an MPEG audio frame header needed to find
+// the next frame
+type frameHeader struct {
+	version    int // 1, 2, or 25 for MPEG 2.5
+	layer      int
+	sampleRate int
+	samples    int
+	length     int
+}
+
+func (h frameHeader) sameStream(o frameHeader) bool {
+	return h.version == o.version && h.layer == o.layer && h.sampleRate == o.sampleRate
+}
+
+var (
+	frameBitrates = map[[2]int][15]int{
+		{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	}
+	frameSampleRates = map[int][3]int{
+		1:  {44100, 48000, 32000},
+		2:  {22050, 24000, 16000},
+		25: {11025, 12000, 8000},
+	}
+)
+
+// parseFrameHeader reads the four byte header at the start of b. Free
+// format frames (bitrate index 0) are not supported and count as invalid.
+func parseFrameHeader(b []byte) (frameHeader, bool) {
+	if b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return frameHeader{}, false
+	}
+	var h frameHeader
+	switch (b[1] >> 3) & 0x3 {
+	case 0:
+		h.version = 25
+	case 2:
+		h.version = 2
+	case 3:
+		h.version = 1
+	default:
+		return frameHeader{}, false
+	}
+	h.layer = 4 - int((b[1]>>1)&0x3)
+	if h.layer == 4 {
+		return frameHeader{}, false
+	}
+	bitrateIndex := int(b[2] >> 4)
+	rateIndex := int((b[2] >> 2) & 0x3)
+	if bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return frameHeader{}, false
+	}
+	padding := int((b[2] >> 1) & 0x1)
+
+	tableVersion := h.version
+	if tableVersion == 25 {
+		tableVersion = 2
+	}
+	bitrate := frameBitrates[[2]int{tableVersion, h.layer}][bitrateIndex] * 1000
+	h.sampleRate = frameSampleRates[h.version][rateIndex]
+
+	switch {
+	case h.layer == 1:
+		h.samples = 384
+		h.length = (12*bitrate/h.sampleRate + padding) * 4
+	case h.layer == 3 && h.version != 1:
+		h.samples = 576
+		h.length = 72*bitrate/h.sampleRate + padding
+	default:
+		h.samples = 1152
+		h.length = 144*bitrate/h.sampleRate + padding
+	}
+	return h, true
+}
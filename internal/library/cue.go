@@ -0,0 +1,354 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SourceCue marks values in Song.Sources that were read from a CUE sheet
+const SourceCue = "CUE"
+
+// CueSheet is a parsed .cue file: an album's worth of tracks laid out in
+// one or more audio files
+type CueSheet struct {
+	Title     string
+	Performer string
+	Genre     string
+	Date      string
+	Tracks    []CueTrack
+}
+
+// CueTrack is one TRACK of a CUE sheet. File is as written in the sheet,
+// usually relative to it; Start is its INDEX 01 within that file.
+type CueTrack struct {
+	Number    int
+	Title     string
+	Performer string
+	Composer  string
+	File      string
+	Start     time.Duration
+}
+
+// cueFramesPerSecond is the CD frame rate CUE time stamps count in
+const cueFramesPerSecond = 75
+
+// ParseCue reads a CUE sheet. Sheets written by older rippers are often
+// Latin-1 rather than UTF-8; those are converted.
+func ParseCue(r io.Reader) (*CueSheet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CUE sheet: %v", err)
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !utf8.Valid(data) {
+		data = []byte(Latin1(data))
+	}
+
+	sheet := &CueSheet{}
+	var track *CueTrack
+	file := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		fields := cueFields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		keyword := strings.ToUpper(fields[0])
+		arg := func(i int) string {
+			if i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+
+		switch keyword {
+		case "FILE":
+			file = arg(1)
+		case "TRACK":
+			number, err := strconv.Atoi(arg(1))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad track number %q", line, arg(1))
+			}
+			sheet.Tracks = append(sheet.Tracks, CueTrack{Number: number, File: file, Start: -1})
+			track = &sheet.Tracks[len(sheet.Tracks)-1]
+		case "INDEX":
+			if track == nil || arg(1) != "01" {
+				continue
+			}
+			start, err := parseCueTime(arg(2))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			track.Start = start
+		case "TITLE":
+			if track != nil {
+				track.Title = arg(1)
+			} else {
+				sheet.Title = arg(1)
+			}
+		case "PERFORMER":
+			if track != nil {
+				track.Performer = arg(1)
+			} else {
+				sheet.Performer = arg(1)
+			}
+		case "SONGWRITER":
+			if track != nil {
+				track.Composer = arg(1)
+			}
+		case "REM":
+			switch strings.ToUpper(arg(1)) {
+			case "GENRE":
+				sheet.Genre = arg(2)
+			case "DATE":
+				sheet.Date = arg(2)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading CUE sheet: %v", err)
+	}
+
+	// A track without INDEX 01 can't be placed in its file
+	tracks := sheet.Tracks[:0]
+	for _, t := range sheet.Tracks {
+		if t.Start >= 0 {
+			tracks = append(tracks, t)
+		}
+	}
+	sheet.Tracks = tracks
+	if len(sheet.Tracks) == 0 {
+		return nil, fmt.Errorf("CUE sheet has no tracks")
+	}
+	return sheet, nil
+}
+
+// cueFields splits a CUE line into words, keeping quoted strings whole
+func cueFields(line string) []string {
+	var fields []string
+	line = strings.TrimSpace(line)
+	for line != "" {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				fields = append(fields, line[1:])
+				break
+			}
+			fields = append(fields, line[1:end+1])
+			line = strings.TrimSpace(line[end+2:])
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			fields = append(fields, line)
+			break
+		}
+		fields = append(fields, line[:end])
+		line = strings.TrimSpace(line[end:])
+	}
+	return fields
+}
+
+// parseCueTime reads an mm:ss:ff time stamp, ff being 1/75 s frames
+func parseCueTime(text string) (time.Duration, error) {
+	parts := strings.Split(text, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("bad time %q, expected mm:ss:ff", text)
+	}
+	var values [3]int
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("bad time %q, expected mm:ss:ff", text)
+		}
+		values[i] = v
+	}
+	frames := (values[0]*60+values[1])*cueFramesPerSecond + values[2]
+	return time.Duration(frames) * time.Second / cueFramesPerSecond, nil
+}
+
+// CueTrackPath is the path a CUE track goes by in the library: the audio
+// file it is part of with "#" and the track number appended, such as
+// "/music/Live/show.mp3#03". It keys playlists, ratings and history like
+// the path of an ordinary file.
+func CueTrackPath(audioFile string, number int) string {
+	return fmt.Sprintf("%s#%02d", audioFile, number)
+}
+
+// IsCueTrack reports whether the song is a track of a CUE sheet rather
+// than a file of its own
+func (s Song) IsCueTrack() bool {
+	return s.AudioFile != ""
+}
+
+// AudioPath returns the file holding the song's audio
+func (s Song) AudioPath() string {
+	if s.AudioFile != "" {
+		return s.AudioFile
+	}
+	return s.FilePath
+}
+
+// Section is the part of an audio file a song covers. Songs from a CUE
+// sheet share one file; End 0 means the song runs to the end of it.
+type Section struct {
+	File  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// Section tells where in their shared file the track of a CUE sheet at
+// path is. ok is false for songs that are whole files of their own.
+func (l *Library) Section(path string) (section Section, ok bool) {
+	song, found := l.FindSong(path)
+	if !found || !song.IsCueTrack() {
+		return Section{}, false
+	}
+	return Section{File: song.AudioFile, Start: song.Start, End: song.End}, true
+}
+
+// cueSongs turns the sheet at cuePath into songs, one per track. files
+// holds the scanned songs of the MP3 files next to it; each track takes
+// the art, duration and any tags the sheet lacks from its file. The files
+// used are returned so the caller can drop them from the library.
+func (l *Library) cueSongs(cuePath string, files map[string]Song) ([]Song, []string, error) {
+	f, err := os.Open(cuePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	sheet, err := ParseCue(f)
+	f.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var songs []Song
+	var used []string
+	dir := filepath.Dir(cuePath)
+	for i, track := range sheet.Tracks {
+		audioFile := filepath.Join(dir, track.File)
+		if filepath.IsAbs(track.File) {
+			audioFile = track.File
+		}
+		file, ok := files[audioFile]
+		if !ok {
+			// Sheets often still name the .wav that was ripped
+			// before it was encoded
+			stem := strings.TrimSuffix(audioFile, filepath.Ext(audioFile))
+			if file, ok = files[stem+".mp3"]; !ok {
+				continue
+			}
+			audioFile = file.FilePath
+		}
+		if len(used) == 0 || used[len(used)-1] != audioFile {
+			used = append(used, audioFile)
+		}
+
+		song := Song{
+			FilePath:    CueTrackPath(audioFile, track.Number),
+			AudioFile:   audioFile,
+			Start:       track.Start,
+			Track:       track.Number,
+			Disc:        file.Disc,
+			Compilation: file.Compilation,
+			FileSize:    file.FileSize,
+			AlbumArt:    file.AlbumArt,
+			Playlist:    file.Playlist,
+			Sources:     map[TagField]string{FieldTrack: SourceCue},
+		}
+		// The last track of each file runs to its end
+		end := file.Duration
+		if i+1 < len(sheet.Tracks) && sheet.Tracks[i+1].File == track.File {
+			end = sheet.Tracks[i+1].Start
+			song.End = end
+		}
+		if end > track.Start {
+			song.Duration = end - track.Start
+		}
+
+		// Values come from the sheet where it has them, else from the file
+		set := func(field TagField, fromSheet, fromFile string) {
+			value, source := fromSheet, SourceCue
+			if strings.TrimSpace(value) == "" {
+				value, source = fromFile, file.Sources[field]
+			}
+			if strings.TrimSpace(value) == "" {
+				return
+			}
+			setTagValue(&song, field, value)
+			if source != "" {
+				song.Sources[field] = source
+			}
+		}
+		set(FieldTitle, track.Title, fmt.Sprintf("Track %02d", track.Number))
+		set(FieldArtist, firstNonEmpty(track.Performer, sheet.Performer), file.Artist)
+		set(FieldAlbum, sheet.Title, file.Album)
+		set(FieldAlbumArtist, sheet.Performer, file.AlbumArtist)
+		set(FieldComposer, track.Composer, file.Composer)
+		set(FieldYear, sheet.Date, file.Year)
+		set(FieldGenre, sheet.Genre, file.Genre)
+		songs = append(songs, song)
+	}
+	return songs, used, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// splitCueSheets replaces each file that a CUE sheet in cuePaths lays out
+// with the sheet's tracks, in track order. A file named by two sheets is
+// split by the first. Sheets that can't be read are returned as problems
+// and leave their files whole.
+func (l *Library) splitCueSheets(songs []Song, cuePaths []string) ([]Song, []error) {
+	if len(cuePaths) == 0 {
+		return songs, nil
+	}
+
+	files := make(map[string]Song, len(songs))
+	for _, song := range songs {
+		files[song.FilePath] = song
+	}
+	tracks := make(map[string][]Song)
+	var problems []error
+	for _, cuePath := range cuePaths {
+		cueTracks, used, err := l.cueSongs(cuePath, files)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("error reading CUE sheet %s: %v", filepath.Base(cuePath), err))
+			continue
+		}
+		for _, file := range used {
+			if _, taken := tracks[file]; taken {
+				continue
+			}
+			for _, track := range cueTracks {
+				if track.AudioFile == file {
+					tracks[file] = append(tracks[file], track)
+				}
+			}
+		}
+	}
+
+	split := make([]Song, 0, len(songs))
+	for _, song := range songs {
+		if fileTracks, ok := tracks[song.FilePath]; ok {
+			split = append(split, fileTracks...)
+		} else {
+			split = append(split, song)
+		}
+	}
+	return split, problems
+}
@@ -0,0 +1,357 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// TrashDirName is the folder under the library root that removed
+// duplicates are moved to. Scans skip it.
+const TrashDirName = ".clispot-trash"
+
+// DuplicateKind tells how the copies in a DuplicateGroup were matched
+type DuplicateKind int
+
+const (
+	// DuplicateExact copies have byte-identical audio and differ at most
+	// in their tags
+	DuplicateExact DuplicateKind = iota
+	// DuplicateSimilar copies have the same artist, title and about the
+	// same length, such as one recording ripped at different bitrates
+	DuplicateSimilar
+)
+
+// String returns a short description of the kind
+func (k DuplicateKind) String() string {
+	if k == DuplicateExact {
+		return "exact"
+	}
+	return "similar"
+}
+
+// DuplicateCopy is one file of a DuplicateGroup
+type DuplicateCopy struct {
+	Song Song
+	// Bitrate is the average bitrate of the audio in kbps, 0 if the
+	// song's length is unknown
+	Bitrate int
+	// TagCount is the number of fields read from the file's own tags
+	TagCount int
+}
+
+// DuplicateGroup is a set of files holding the same song. Keep is the
+// index in Copies of the one to keep: the highest bitrate, then the most
+// complete tags.
+type DuplicateGroup struct {
+	Kind   DuplicateKind
+	Copies []DuplicateCopy
+	Keep   int
+}
+
+// Removable returns the copies other than the one to keep
+func (g DuplicateGroup) Removable() []DuplicateCopy {
+	copies := make([]DuplicateCopy, 0, len(g.Copies)-1)
+	for i, c := range g.Copies {
+		if i != g.Keep {
+			copies = append(copies, c)
+		}
+	}
+	return copies
+}
+
+// DuplicateOptions controls FindDuplicates
+type DuplicateOptions struct {
+	// Similar also looks for copies that are not byte-identical
+	Similar bool
+	// Tolerance is how far apart the lengths of similar copies may be
+	Tolerance time.Duration
+	// Progress, if set, is called as files are hashed
+	Progress func(done, total int)
+}
+
+// FindDuplicates groups the scanned songs that are copies of each other.
+// Exact groups come first. Only files whose audio is the same size are
+// hashed, so the scan stays quick on large libraries. Tracks of CUE
+// sheets are left out, as they can't be trashed on their own.
+func (l *Library) FindDuplicates(opts DuplicateOptions) ([]DuplicateGroup, error) {
+	var files []Song
+	for _, song := range l.songs {
+		if !song.IsCueTrack() {
+			files = append(files, song)
+		}
+	}
+
+	payloads := make(map[string]payload, len(files))
+	bySize := make(map[int64][]Song)
+	for _, song := range files {
+		p, err := audioPayload(song.FilePath)
+		if err != nil {
+			continue
+		}
+		payloads[song.FilePath] = p
+		bySize[p.size()] = append(bySize[p.size()], song)
+	}
+
+	var candidates []Song
+	for _, songs := range bySize {
+		if len(songs) > 1 {
+			candidates = append(candidates, songs...)
+		}
+	}
+	sortSongsByPath(candidates)
+
+	hashes := make(map[string]string)
+	byHash := make(map[string][]Song)
+	for i, song := range candidates {
+		if opts.Progress != nil {
+			opts.Progress(i, len(candidates))
+		}
+		hash, err := payloads[song.FilePath].hash(song.FilePath)
+		if err != nil {
+			continue
+		}
+		hashes[song.FilePath] = hash
+		byHash[hash] = append(byHash[hash], song)
+	}
+	if opts.Progress != nil && len(candidates) > 0 {
+		opts.Progress(len(candidates), len(candidates))
+	}
+
+	var groups []DuplicateGroup
+	for _, songs := range byHash {
+		if len(songs) > 1 {
+			groups = append(groups, newDuplicateGroup(DuplicateExact, songs, payloads))
+		}
+	}
+
+	if opts.Similar {
+		for _, songs := range similarSongs(files, opts.Tolerance) {
+			// A set of copies that are all exact duplicates is reported once
+			distinct := make(map[string]bool)
+			for _, song := range songs {
+				if hash, ok := hashes[song.FilePath]; ok {
+					distinct[hash] = true
+				} else {
+					distinct[song.FilePath] = true
+				}
+			}
+			if len(distinct) > 1 {
+				groups = append(groups, newDuplicateGroup(DuplicateSimilar, songs, payloads))
+			}
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Kind != groups[j].Kind {
+			return groups[i].Kind < groups[j].Kind
+		}
+		return groups[i].Copies[0].Song.FilePath < groups[j].Copies[0].Song.FilePath
+	})
+	return groups, nil
+}
+
+func newDuplicateGroup(kind DuplicateKind, songs []Song, payloads map[string]payload) DuplicateGroup {
+	sortSongsByPath(songs)
+	group := DuplicateGroup{Kind: kind}
+	for _, song := range songs {
+		c := DuplicateCopy{Song: song, TagCount: tagCount(song)}
+		if p, ok := payloads[song.FilePath]; ok && song.Duration > 0 {
+			c.Bitrate = int(float64(p.size()*8) / song.Duration.Seconds() / 1000)
+		}
+		group.Copies = append(group.Copies, c)
+	}
+
+	for i, c := range group.Copies {
+		best := group.Copies[group.Keep]
+		if c.Bitrate > best.Bitrate || c.Bitrate == best.Bitrate && c.TagCount > best.TagCount {
+			group.Keep = i
+		}
+	}
+	return group
+}
+
+// tagCount counts the fields read from the song's tags rather than
+// guessed from its path
+func tagCount(song Song) int {
+	count := 0
+	for _, source := range song.Sources {
+		if source != SourceFilename {
+			count++
+		}
+	}
+	return count
+}
+
+func sortSongsByPath(songs []Song) {
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].FilePath < songs[j].FilePath
+	})
+}
+
+// similarSongs groups songs by normalized artist and title, then splits
+// each group where the lengths are more than tolerance apart
+func similarSongs(songs []Song, tolerance time.Duration) [][]Song {
+	byKey := make(map[string][]Song)
+	for _, song := range songs {
+		title := normalizeTitle(TagValue(song, FieldTitle))
+		artist := normalizeTitle(TagValue(song, FieldArtist))
+		if title == "" || artist == "" {
+			continue
+		}
+		key := artist + "\x00" + title
+		byKey[key] = append(byKey[key], song)
+	}
+
+	var groups [][]Song
+	for _, songs := range byKey {
+		if len(songs) < 2 {
+			continue
+		}
+		sort.Slice(songs, func(i, j int) bool {
+			return songs[i].Duration < songs[j].Duration
+		})
+		start := 0
+		for i := 1; i <= len(songs); i++ {
+			if i == len(songs) || songs[i].Duration-songs[i-1].Duration > tolerance {
+				if i-start > 1 {
+					groups = append(groups, songs[start:i])
+				}
+				start = i
+			}
+		}
+	}
+	return groups
+}
+
+var (
+	// titleExtras matches bracketed asides such as "(Remastered 2011)" and
+	// "[Live]" and trailing featured-artist credits
+	titleExtras = regexp.MustCompile(`\([^)]*\)|\[[^\]]*\]|\s(feat\.?|ft\.|featuring)\s.*$`)
+	leadingThe  = regexp.MustCompile(`^the\s+`)
+)
+
+// normalizeTitle reduces a title or artist name to a form that differently
+// tagged copies share: lower case, without accents, punctuation,
+// bracketed asides, featured artists or a leading "The"
+func normalizeTitle(text string) string {
+	text = strings.ToLower(text)
+	text = titleExtras.ReplaceAllString(text, " ")
+	text = strings.ReplaceAll(text, "&", " and ")
+
+	var b strings.Builder
+	for _, r := range norm.NFD.String(text) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Drop the accents NFD split off their letters
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	text = strings.Join(strings.Fields(b.String()), " ")
+	return leadingThe.ReplaceAllString(text, "")
+}
+
+// payload is the byte range of a file holding audio, between any ID3v2
+// tag at the start and APEv2 and ID3v1 tags at the end
+type payload struct {
+	start, end int64
+}
+
+func (p payload) size() int64 {
+	return p.end - p.start
+}
+
+// hash returns the SHA-256 of the payload of the file at path
+func (p payload) hash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, p.start, p.size())); err != nil {
+		return "", fmt.Errorf("error reading %s: %v", filepath.Base(path), err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// audioPayload finds where the audio of the file at path starts and ends,
+// so that copies with different tags hash the same
+func audioPayload(path string) (payload, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return payload{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return payload{}, err
+	}
+
+	p := payload{end: info.Size()}
+	header := make([]byte, 10)
+	if _, err := file.ReadAt(header, 0); err == nil && string(header[:3]) == "ID3" {
+		p.start = 10 + int64(syncsafe(header[6:10]))
+		if header[5]&0x10 != 0 {
+			// ID3v2.4 footer
+			p.start += 10
+		}
+	}
+
+	trailer := make([]byte, 3)
+	if _, err := file.ReadAt(trailer, p.end-128); err == nil && string(trailer) == "TAG" {
+		p.end -= 128
+	}
+	if footer, at, ok := findAPEFooter(file, info.Size()); ok && at+32 <= p.end {
+		size := int64(binary.LittleEndian.Uint32(footer[12:16]))
+		if binary.LittleEndian.Uint32(footer[20:24])&(1<<31) != 0 {
+			// The tag also has a 32 byte header
+			size += 32
+		}
+		p.end = at + 32 - size
+	}
+
+	if p.start > p.end {
+		return payload{}, fmt.Errorf("%s has no audio", filepath.Base(path))
+	}
+	return p, nil
+}
+
+// TrashSong moves a song's file into trashDir, keeping its path relative
+// to the library root so it can be put back by hand, and drops it from
+// the library. It returns the file's new path.
+func (l *Library) TrashSong(filePath, trashDir string) (string, error) {
+	rel, err := filepath.Rel(l.rootPath, filePath)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(filePath)
+	}
+	target := freePath(filepath.Join(trashDir, rel), "", map[string]bool{})
+	if err := moveFile(filePath, target); err != nil {
+		return "", err
+	}
+
+	songs := l.songs[:0]
+	for _, song := range l.songs {
+		if song.FilePath != filePath {
+			songs = append(songs, song)
+		}
+	}
+	l.songs = songs
+	l.removeEmptyDirs(filepath.Dir(filePath))
+	return target, nil
+}
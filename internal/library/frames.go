@@ -0,0 +1,135 @@
+package library
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+// FrameReader reads an MP3 stream one MPEG audio frame at a time, skipping
+// the ID3v2 tag in front and anything between frames that isn't one, such
+// as an ID3v1 or APE tag at the end. The frames can be sent on as they are
+// to make a stream other players decode.
+type FrameReader struct {
+	r       *bufio.Reader
+	started bool
+	first   frameHeader
+	frames  int
+}
+
+func NewFrameReader(r io.Reader) *FrameReader {
+	return &FrameReader{r: bufio.NewReaderSize(r, 64<<10)}
+}
+
+// Next returns the next frame, header included, and how long it plays. It
+// returns io.EOF after the last whole frame.
+func (f *FrameReader) Next() ([]byte, time.Duration, error) {
+	if !f.started {
+		f.started = true
+		if err := f.skipID3v2(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	for {
+		head, err := f.r.Peek(4)
+		if len(head) < 4 {
+			if err == nil || err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return nil, 0, err
+		}
+		h, ok := parseFrameHeader(head)
+		if ok && f.frames > 0 && !h.sameStream(f.first) {
+			ok = false
+		}
+		if ok && f.frames == 0 && !f.followed(h) {
+			// Don't lock onto a false sync in leading junk
+			ok = false
+		}
+		if !ok {
+			f.r.Discard(1)
+			continue
+		}
+
+		frame := make([]byte, h.length)
+		if _, err := io.ReadFull(f.r, frame); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			return nil, 0, err
+		}
+		if f.frames == 0 {
+			f.first = h
+		}
+		f.frames++
+		return frame, time.Duration(h.samples) * time.Second / time.Duration(h.sampleRate), nil
+	}
+}
+
+// Skip reads past the frames in the first d of audio and returns how much
+// was skipped, which falls short of d only at the end of the stream
+func (f *FrameReader) Skip(d time.Duration) (time.Duration, error) {
+	var skipped time.Duration
+	for skipped < d {
+		_, length, err := f.Next()
+		if err != nil {
+			return skipped, err
+		}
+		skipped += length
+	}
+	return skipped, nil
+}
+
+// followed reports whether another frame of the same stream starts right
+// after the one with header h, or the data ends there
+func (f *FrameReader) followed(h frameHeader) bool {
+	next, err := f.r.Peek(h.length + 4)
+	if len(next) < h.length+4 {
+		return err != nil
+	}
+	nh, ok := parseFrameHeader(next[h.length:])
+	return ok && nh.sameStream(h)
+}
+
+func (f *FrameReader) skipID3v2() error {
+	header, err := f.r.Peek(10)
+	if len(header) < 10 || string(header[:3]) != "ID3" {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	size := 10 + syncsafe(header[6:10])
+	if header[5]&0x10 != 0 {
+		// ID3v2.4 footer
+		size += 10
+	}
+	_, err = f.r.Discard(size)
+	return err
+}
+
+// silentHeader is an MPEG-1 Layer III frame header for 128 kbit/s stereo at
+// 44.1 kHz, without CRC
+var silentHeader = []byte{0xff, 0xfb, 0x90, 0x00}
+
+// SilentFrame returns a frame of silence in the format of like, a frame
+// from FrameReader, so it can go between that stream's frames; with like
+// nil it is 128 kbit/s stereo at 44.1 kHz. A frame whose side information
+// is all zero carries no audio data, which decoders play as silence.
+func SilentFrame(like []byte) ([]byte, time.Duration) {
+	header := append([]byte(nil), silentHeader...)
+	if len(like) >= 4 {
+		if _, ok := parseFrameHeader(like); ok {
+			copy(header, like[:4])
+		}
+	}
+	// No CRC and no padding, so the length is the same for every frame
+	header[1] |= 0x01
+	header[2] &^= 0x02
+
+	h, _ := parseFrameHeader(header)
+	frame := make([]byte, h.length)
+	copy(frame, header)
+	return frame, time.Duration(h.samples) * time.Second / time.Duration(h.sampleRate)
+}
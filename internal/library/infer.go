@@ -0,0 +1,202 @@
+package library
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SourceFilename marks values in Song.Sources that were read from the
+// file's path by a PathPattern
+const SourceFilename = "filename"
+
+// BuiltinPathPatterns are tried after the configured patterns, most
+// specific first. They cover the layouts most rippers and download tools
+// use.
+var BuiltinPathPatterns = []string{
+	"{artist}/{year} - {album}/{disc}-{track} - {title}",
+	"{artist}/{year} - {album}/{track} - {title}",
+	"{artist}/{album}/{disc}-{track} - {title}",
+	"{artist}/{album}/{track} - {artist} - {title}",
+	"{artist}/{album}/{track} - {title}",
+	"{artist}/{album}/{track}. {title}",
+	"{artist}/{album}/{track} {title}",
+	"{artist} - {album} - {track} - {title}",
+	"{artist} - {album}/{track} - {title}",
+	"{track} - {artist} - {title}",
+	"{track} - {title}",
+	"{track}. {title}",
+	"{artist} - {title}",
+}
+
+// PathPattern matches the end of a song's path, relative to the library
+// root and without the extension, and pulls tag values out of it. Fields
+// are written like template fields, {artist}/{album}/{track} - {title};
+// {*} matches text that is ignored. Each "/" stands for a folder, so a
+// pattern with two of them looks at the file name and its two parent
+// folders.
+type PathPattern struct {
+	text   string
+	depth  int
+	re     *regexp.Regexp
+	fields []TagField
+}
+
+// ParsePathPattern compiles a pattern such as "{artist}/{album}/{title}"
+func ParsePathPattern(text string) (*PathPattern, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+
+	p := &PathPattern{text: text, depth: strings.Count(text, "/") + 1}
+	var expr strings.Builder
+	expr.WriteString("^")
+	rest := text
+	for rest != "" {
+		open := strings.Index(rest, "{")
+		if open < 0 {
+			expr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		expr.WriteString(regexp.QuoteMeta(rest[:open]))
+		end := strings.Index(rest[open:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in %q", text)
+		}
+		name := rest[open+1 : open+end]
+		rest = rest[open+end+1:]
+
+		if name == "*" {
+			expr.WriteString("[^/]*?")
+			continue
+		}
+		field, ok := ParseTagField(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown field {%s} in %q", name, text)
+		}
+		switch field {
+		case FieldTrack, FieldDisc:
+			expr.WriteString(`(\d{1,3})`)
+		case FieldYear:
+			expr.WriteString(`(\d{4})`)
+		default:
+			expr.WriteString(`([^/]+?)`)
+		}
+		p.fields = append(p.fields, field)
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %v", text, err)
+	}
+	p.re = re
+	return p, nil
+}
+
+// ParsePathPatterns compiles a list of patterns, stopping at the first
+// invalid one
+func ParsePathPatterns(texts []string) ([]*PathPattern, error) {
+	patterns := make([]*PathPattern, 0, len(texts))
+	for _, text := range texts {
+		p, err := ParsePathPattern(text)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// String returns the pattern text
+func (p *PathPattern) String() string {
+	return p.text
+}
+
+// Match returns the values the pattern finds in relPath, a slash-separated
+// path relative to the library root without the extension. Underscores,
+// which many rippers use for spaces, are turned back into spaces.
+func (p *PathPattern) Match(relPath string) (map[TagField]string, bool) {
+	parts := strings.Split(relPath, "/")
+	if len(parts) < p.depth {
+		return nil, false
+	}
+	tail := strings.Join(parts[len(parts)-p.depth:], "/")
+
+	match := p.re.FindStringSubmatch(tail)
+	if match == nil {
+		return nil, false
+	}
+
+	values := make(map[TagField]string)
+	for i, field := range p.fields {
+		value := strings.TrimSpace(strings.ReplaceAll(match[i+1], "_", " "))
+		if value == "" {
+			return nil, false
+		}
+		// A field used twice, like {artist} in folder and file name, must
+		// agree with itself
+		if previous, ok := values[field]; ok && !strings.EqualFold(previous, value) {
+			return nil, false
+		}
+		values[field] = value
+	}
+	return values, true
+}
+
+// SetPathPatterns sets the patterns tried, in order, to fill in tags that
+// files are missing; nil turns inference off
+func (l *Library) SetPathPatterns(patterns []*PathPattern) {
+	l.pathPatterns = patterns
+}
+
+// SetWriteInferredTags makes scans write values found in file names back
+// to the files' tags
+func (l *Library) SetWriteInferredTags(write bool) {
+	l.writeInferred = write
+}
+
+// inferFromPath returns the values of the first pattern that matches the
+// song's path
+func (l *Library) inferFromPath(filePath string) map[TagField]string {
+	rel, err := filepath.Rel(l.rootPath, filePath)
+	if err != nil {
+		rel = filepath.Base(filePath)
+	}
+	rel = filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+
+	for _, p := range l.pathPatterns {
+		if values, ok := p.Match(rel); ok {
+			return values
+		}
+	}
+	return nil
+}
+
+// fillFromPath sets the fields the song's tags left empty from its path,
+// recording them in song.Sources
+func (l *Library) fillFromPath(song *Song) {
+	for field, value := range l.inferFromPath(song.FilePath) {
+		if TagValue(*song, field) != "" {
+			continue
+		}
+		setTagValue(song, field, value)
+		if song.Sources == nil {
+			song.Sources = make(map[TagField]string)
+		}
+		song.Sources[field] = SourceFilename
+	}
+}
+
+// inferredTags returns the values of song that came from its path, as a
+// TagEdit that writes them to the file
+func inferredTags(song Song) TagEdit {
+	edit := TagEdit{Values: make(map[TagField]string)}
+	for field, source := range song.Sources {
+		if source == SourceFilename {
+			edit.Values[field] = TagValue(song, field)
+		}
+	}
+	return edit
+}
@@ -38,15 +38,52 @@ type Song struct {
 	Duration time.Duration
 	Genre    string
 	Track    int
+	
+	// AlbumArtist (TPE2) and Compilation (TCMP) decide which artist an
+	// album is filed under; Disc (TPOS) orders the tracks of multi-disc sets
+	AlbumArtist string
+	Disc        int
+	Composer    string
+	Compilation bool
+	
 	FileSize int64
 	AlbumArt *albumart.ASCIIArt 
 	Playlist string // The folder/playlist this song belongs to
+	
+	// Chapters come from ID3v2 CHAP frames, in play order
+	Chapters []Chapter
+	
+	// Tracks of a CUE sheet share one audio file: AudioFile is that file
+	// and the track plays from Start to End in it, End 0 meaning the end
+	// of the file. FilePath is then a CueTrackPath.
+	AudioFile string
+	Start     time.Duration
+	End       time.Duration
+	
+	// Sources records which tag each value was read from, such as
+	// SourceID3v23 or SourceAPEv2, or SourceFilename for inferred ones
+	Sources map[TagField]string `json:"-"`
+	
+	// Listening data from clispot's own stores, not from the file's tags
+	PlayCount int  `json:"-"`
+	Rating    int  `json:"-"` // 0–5 stars, 0 when unrated
+	Loved     bool `json:"-"`
 }
 
 type Library struct {
 	rootPath    string
 	songs       []Song
 	currentPath string
+	
+	// Virtual views browse by tags instead of folders; virtualPath holds
+	// the group keys chosen so far
+	mode        BrowseMode
+	virtualPath []string
+	
+	// Patterns that fill in tags missing from files, and whether the
+	// values found are written back to them
+	pathPatterns  []*PathPattern
+	writeInferred bool
 }
 
 
@@ -59,36 +96,67 @@ func NewLibrary(rootPath string) *Library {
 }
 
 
-func (l *Library) ScanDirectory() ([]Song, error) {
-	l.songs = make([]Song, 0)
+// ScanDirectory scans the root folder and replaces the library's songs with
+// what it finds. problems lists the files that were read only in part,
+// such as ones with unreadable tags; they are in songs all the same.
+func (l *Library) ScanDirectory() (songs []Song, problems []error, err error) {
+	songs, problems, err = l.Scan()
+	l.songs = songs
+	return songs, problems, err
+}
+
+// Scan reads the songs under the root folder without changing the library,
+// so it can run in the background while the library is in use; pass the
+// result to SetSongs. problems are as for ScanDirectory.
+func (l *Library) Scan() (songs []Song, problems []error, err error) {
+	songs = make([]Song, 0)
+	var cuePaths []string
 
-	err := filepath.Walk(l.rootPath, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(l.rootPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
 		}
+		if info.IsDir() && info.Name() == TrashDirName {
+			return filepath.SkipDir
+		}
 
 		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".mp3" {
 			song, err := l.extractMetadata(path, info)
 			if err != nil {
-				fmt.Printf("Error reading metadata for %s: %v\n", filepath.Base(path), err)
+				problems = append(problems, fmt.Errorf("error reading metadata for %s: %v", filepath.Base(path), err))
 				song = Song{
 					FilePath: path,
-					Title:    strings.TrimSuffix(filepath.Base(path), ".mp3"),
-					Artist:   "Unknown Artist",
-					Album:    "Unknown Album",
 					Duration: 0,
 				}
+				l.fillFromPath(&song)
+				l.setPlaceholders(&song)
+			} else if l.writeInferred {
+				if edit := inferredTags(song); !edit.IsEmpty() {
+					if err := WriteTags(path, edit); err != nil {
+						problems = append(problems, fmt.Errorf("error writing tags for %s: %v", filepath.Base(path), err))
+					}
+				}
 			}
-			l.songs = append(l.songs, song)
+			songs = append(songs, song)
+		}
+		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".cue" {
+			cuePaths = append(cuePaths, path)
 		}
 		return nil
 	})
 
-	return l.songs, err
+	// Albums ripped to one file are split into the tracks of their sheet
+	songs, cueProblems := l.splitCueSheets(songs, cuePaths)
+	return songs, append(problems, cueProblems...), err
 }
 
-// GetCurrentItems returns items (folders and songs) in the current directory
+// GetCurrentItems returns items (folders and songs) in the current directory,
+// or the groups and tracks at the current node of a virtual view
 func (l *Library) GetCurrentItems() ([]LibraryItem, error) {
+	if l.mode != BrowseFolders {
+		return l.virtualItems(), nil
+	}
+	
 	var items []LibraryItem
 	
 	// If not at root, add ".." to go back
@@ -108,7 +176,7 @@ func (l *Library) GetCurrentItems() ([]LibraryItem, error) {
 	
 	// Add folders first
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() && entry.Name() != TrashDirName {
 			fullPath := filepath.Join(l.currentPath, entry.Name())
 			songCount := l.countSongsInFolder(fullPath)
 			
@@ -126,16 +194,19 @@ func (l *Library) GetCurrentItems() ([]LibraryItem, error) {
 		if !entry.IsDir() && strings.ToLower(filepath.Ext(entry.Name())) == ".mp3" {
 			fullPath := filepath.Join(l.currentPath, entry.Name())
 			
-			// Find the song in our scanned songs
+			// Find the song in our scanned songs; a file split by a CUE
+			// sheet is listed as its tracks
 			for _, song := range l.songs {
-				if song.FilePath == fullPath {
+				if song.AudioPath() == fullPath {
 					items = append(items, LibraryItem{
 						Type: ItemTypeSong,
 						Name: fmt.Sprintf("%s - %s", song.Artist, song.Title),
-						Path: fullPath,
+						Path: song.FilePath,
 						Song: &song,
 					})
-					break
+					if !song.IsCueTrack() {
+						break
+					}
 				}
 			}
 		}
@@ -182,6 +253,9 @@ func (l *Library) GetRootPath() string {
 
 // CanGoBack returns true if we can navigate to parent directory
 func (l *Library) CanGoBack() bool {
+	if l.mode != BrowseFolders {
+		return len(l.virtualPath) > 0
+	}
 	return l.currentPath != l.rootPath
 }
 
@@ -197,23 +271,31 @@ func (l *Library) GetRelativePath() string {
 	return "/" + rel
 }
 
-// countSongsInFolder recursively counts MP3 files in a folder
+// countSongsInFolder recursively counts the scanned songs in a folder,
+// each track of a CUE sheet counting as one
 func (l *Library) countSongsInFolder(folderPath string) int {
 	count := 0
-	filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if !info.IsDir() && strings.ToLower(filepath.Ext(path)) == ".mp3" {
+	prefix := folderPath + string(filepath.Separator)
+	for _, song := range l.songs {
+		if strings.HasPrefix(song.FilePath, prefix) {
 			count++
 		}
-		return nil
-	})
+	}
 	return count
 }
 
-// GetSongsInCurrentFolder returns only songs in the current folder (no subfolders)
+// GetSongsInCurrentFolder returns only songs in the current folder (no subfolders).
+// In a virtual view it returns the tracks of the current album, if one is open.
 func (l *Library) GetSongsInCurrentFolder() []Song {
+	if l.mode != BrowseFolders {
+		if len(l.virtualPath) < len(l.mode.levels()) {
+			return nil
+		}
+		songs := l.nodeSongs()
+		SortTracks(songs)
+		return songs
+	}
+	
 	var songs []Song
 	
 	for _, song := range l.songs {
@@ -228,78 +310,90 @@ func (l *Library) GetSongsInCurrentFolder() []Song {
 
 func (l *Library) extractMetadata(filePath string, fileInfo os.FileInfo) (Song, error) {
 	
-	tag, err := id3v2.Open(filePath, id3v2.Options{Parse: true})
+	file, err := os.Open(filePath)
 	if err != nil {
 		return Song{}, err
 	}
-	defer tag.Close()
-
-	
-	title := tag.Title()
-	artist := tag.Artist()
-	album := tag.Album()
-	year := tag.Year()
-	genre := tag.Genre()
+	defer file.Close()
 
-	
-	if title == "" {
-		title = l.getFileNameWithoutExt(fileInfo.Name())
+	readings, rating, err := readTags(file, fileInfo.Size())
+	if err != nil && len(readings) == 0 {
+		return Song{}, err
 	}
 
-	
-	if artist == "" {
-		artist = "Unknown Artist"
+	song := Song{
+		FilePath: filePath,
+		FileSize: fileInfo.Size(),
+		Duration: l.getActualDuration(filePath),
+		// Determine playlist from folder structure
+		Playlist: l.getPlaylistName(filePath),
+		Rating:   rating,
 	}
-
+	picture := song.mergeTags(readings)
 	
-	if album == "" {
-		album = "Unknown Album"
-	}
+	// Fill what the tags leave out from the file's path
+	l.fillFromPath(&song)
+	l.setPlaceholders(&song)
+	song.AlbumArt = l.extractAlbumArt(picture, song.Title, song.Artist)
 
-	
-	trackText := tag.GetTextFrame(tag.CommonID("Track number/Position in set")).Text
-	track := 0
-	if trackText != "" {
-		
-		if idx := strings.Index(trackText, "/"); idx > 0 {
-			trackText = trackText[:idx]
-		}
-		fmt.Sscanf(trackText, "%d", &track)
+	return song, nil
+}
+
+
+// setPlaceholders names songs that have no title, artist or album at all
+func (l *Library) setPlaceholders(song *Song) {
+	if song.Title == "" {
+		song.Title = l.getFileNameWithoutExt(song.FilePath)
+	}
+	if song.Artist == "" {
+		song.Artist = "Unknown Artist"
+	}
+	if song.Album == "" {
+		song.Album = "Unknown Album"
 	}
+}
 
-	// Determine playlist from folder structure
-	playlistName := l.getPlaylistName(filePath)
 
-	song := Song{
-		FilePath: filePath,
-		Title:    title,
-		Artist:   artist,
-		Album:    album,
-		Year:     year,
-		Genre:    genre,
-		Track:    track,
-		FileSize: fileInfo.Size(),
-		Duration: l.getActualDuration(filePath),
-		AlbumArt: l.extractAlbumArt(tag, title, artist),
-		Playlist: playlistName,
+// numberInSet reads the number from a "3" or "3/12" style frame
+func numberInSet(text string) int {
+	if idx := strings.Index(text, "/"); idx >= 0 {
+		text = text[:idx]
 	}
+	n := 0
+	fmt.Sscanf(strings.TrimSpace(text), "%d", &n)
+	return n
+}
 
-	return song, nil
+
+// isCompilation reports whether the tag marks the file as part of a
+// various-artists compilation: iTunes' TCMP frame, or the TXXX frames that
+// other taggers write
+func isCompilation(tag *id3v2.Tag) bool {
+	if flag := strings.TrimSpace(tag.GetTextFrame("TCMP").Text); flag != "" {
+		return flag == "1"
+	}
+	for _, frame := range tag.GetFrames(tag.CommonID("User defined text information frame")) {
+		udtf, ok := frame.(id3v2.UserDefinedTextFrame)
+		if !ok {
+			continue
+		}
+		switch strings.ToUpper(udtf.Description) {
+		case "COMPILATION", "ITUNESCOMPILATION":
+			return strings.TrimSpace(udtf.Value) == "1"
+		}
+	}
+	return false
 }
 
 
-func (l *Library) extractAlbumArt(tag *id3v2.Tag, title, artist string) *albumart.ASCIIArt {
+func (l *Library) extractAlbumArt(picture []byte, title, artist string) *albumart.ASCIIArt {
 	
 	converter := albumart.NewConverter(32, 16)
 	
 	
-	pictures := tag.GetFrames(tag.CommonID("Attached picture"))
-	if len(pictures) > 0 {
-		if picture, ok := pictures[0].(id3v2.PictureFrame); ok {
-			
-			if art, err := converter.ConvertImageToASCII(picture.Picture); err == nil {
-				return art
-			}
+	if len(picture) > 0 {
+		if art, err := converter.ConvertImageToASCII(picture); err == nil {
+			return art
 		}
 	}
 	
@@ -383,6 +477,48 @@ func (l *Library) GetSongs() []Song {
 	return l.songs
 }
 
+// SetSongs replaces the songs without scanning, so a second library can
+// browse what another one scanned with browse state of its own
+func (l *Library) SetSongs(songs []Song) {
+	l.songs = append([]Song(nil), songs...)
+}
+
+// FindSong returns the scanned song stored at filePath
+func (l *Library) FindSong(filePath string) (Song, bool) {
+	for _, song := range l.songs {
+		if song.FilePath == filePath {
+			return song, true
+		}
+	}
+	return Song{}, false
+}
+
+// SetPlayCounts replaces the play count of every song from a path-keyed map
+func (l *Library) SetPlayCounts(counts map[string]int) {
+	for i := range l.songs {
+		l.songs[i].PlayCount = counts[l.songs[i].FilePath]
+	}
+}
+
+// SetRating updates the rating and loved flag of the song at filePath
+func (l *Library) SetRating(filePath string, rating int, loved bool) {
+	for i := range l.songs {
+		if l.songs[i].FilePath == filePath {
+			l.songs[i].Rating = rating
+			l.songs[i].Loved = loved
+		}
+	}
+}
+
+// IncrementPlayCount adds one play to the song at filePath
+func (l *Library) IncrementPlayCount(filePath string) {
+	for i := range l.songs {
+		if l.songs[i].FilePath == filePath {
+			l.songs[i].PlayCount++
+		}
+	}
+}
+
 
 func (l *Library) GetSongsByArtist(artist string) []Song {
 	var result []Song
@@ -402,22 +538,14 @@ func (l *Library) GetSongsByAlbum(album string) []Song {
 			result = append(result, song)
 		}
 	}
+	SortTracks(result)
 	return result
 }
 
 
+// SearchSongs returns the songs matching query; see Query for the syntax
 func (l *Library) SearchSongs(query string) []Song {
-	var result []Song
-	query = strings.ToLower(query)
-	
-	for _, song := range l.songs {
-		if strings.Contains(strings.ToLower(song.Title), query) ||
-		   strings.Contains(strings.ToLower(song.Artist), query) ||
-		   strings.Contains(strings.ToLower(song.Album), query) {
-			result = append(result, song)
-		}
-	}
-	return result
+	return ParseQuery(query).Filter(l.songs)
 }
 
 
@@ -435,15 +563,17 @@ func (l *Library) GetUniqueArtists() []string {
 }
 
 
+// GetUniqueAlbums returns one "Album — Album Artist" name per album, so
+// compilations and multi-disc sets are listed once
 func (l *Library) GetUniqueAlbums() []string {
 	albumMap := make(map[string]bool)
-	for _, song := range l.songs {
-		albumMap[song.Album] = true
-	}
-	
 	var albums []string
-	for album := range albumMap {
-		albums = append(albums, album)
+	for _, song := range l.songs {
+		key := AlbumKey(song)
+		if !albumMap[key] {
+			albumMap[key] = true
+			albums = append(albums, fmt.Sprintf("%s — %s", song.Album, song.GroupArtist()))
+		}
 	}
 	return albums
 }
@@ -0,0 +1,417 @@
+package library
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"clispot/internal/persist"
+)
+
+// DefaultOrganizeTemplate files songs by album artist and album
+const DefaultOrganizeTemplate = "{albumartist}/{year} - {album}/{disc}-{track:02} {title}.mp3"
+
+// templateFields are the values a template can use
+var templateFields = map[string]func(Song) string{
+	"title":       func(s Song) string { return s.Title },
+	"artist":      func(s Song) string { return s.Artist },
+	"album":       func(s Song) string { return s.Album },
+	"albumartist": func(s Song) string { return s.GroupArtist() },
+	"composer":    func(s Song) string { return s.Composer },
+	"genre":       func(s Song) string { return s.Genre },
+	"year":        songYear,
+	"track":       func(s Song) string { return strconv.Itoa(s.Track) },
+	"disc": func(s Song) string {
+		if s.Disc == 0 {
+			return "1"
+		}
+		return strconv.Itoa(s.Disc)
+	},
+	"filename": func(s Song) string {
+		return strings.TrimSuffix(filepath.Base(s.FilePath), filepath.Ext(s.FilePath))
+	},
+}
+
+// Template builds a relative file path from a song's tags. Fields are
+// written as {name}, or {name:02} to zero-pad numbers to two digits, and
+// "/" separates folders.
+type Template struct {
+	text     string
+	segments [][]templatePart
+}
+
+type templatePart struct {
+	literal string
+	field   string
+	width   int
+}
+
+// ParseTemplate parses a template such as DefaultOrganizeTemplate
+func ParseTemplate(text string) (*Template, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("empty template")
+	}
+	if strings.HasPrefix(text, "/") {
+		return nil, fmt.Errorf("template must be relative to the library root")
+	}
+
+	t := &Template{text: text}
+	for _, segment := range strings.Split(text, "/") {
+		parts, err := parseSegment(segment)
+		if err != nil {
+			return nil, err
+		}
+		if len(parts) == 0 {
+			return nil, fmt.Errorf("template %q has an empty folder name", text)
+		}
+		t.segments = append(t.segments, parts)
+	}
+	return t, nil
+}
+
+func parseSegment(segment string) ([]templatePart, error) {
+	var parts []templatePart
+	for segment != "" {
+		open := strings.Index(segment, "{")
+		if open < 0 {
+			parts = append(parts, templatePart{literal: segment})
+			break
+		}
+		if open > 0 {
+			parts = append(parts, templatePart{literal: segment[:open]})
+		}
+		end := strings.Index(segment[open:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed { in %q", segment)
+		}
+		name := segment[open+1 : open+end]
+		part := templatePart{}
+		if idx := strings.Index(name, ":"); idx >= 0 {
+			width, err := strconv.Atoi(name[idx+1:])
+			if err != nil || width < 0 {
+				return nil, fmt.Errorf("bad width in {%s}", name)
+			}
+			part.width = width
+			name = name[:idx]
+		}
+		part.field = strings.ToLower(name)
+		if _, ok := templateFields[part.field]; !ok {
+			return nil, fmt.Errorf("unknown field {%s}", name)
+		}
+		parts = append(parts, part)
+		segment = segment[open+end+1:]
+	}
+	return parts, nil
+}
+
+// String returns the template text
+func (t *Template) String() string {
+	return t.text
+}
+
+// Render returns the relative path for song, with every folder and file
+// name made safe for the file system
+func (t *Template) Render(song Song) string {
+	names := make([]string, 0, len(t.segments))
+	for i, parts := range t.segments {
+		var name strings.Builder
+		for _, part := range parts {
+			if part.field == "" {
+				name.WriteString(part.literal)
+				continue
+			}
+			value := templateFields[part.field](song)
+			if part.width > 0 {
+				if n, err := strconv.Atoi(value); err == nil {
+					value = fmt.Sprintf("%0*d", part.width, n)
+				}
+			}
+			name.WriteString(sanitizeName(value))
+		}
+		names = append(names, cleanName(name.String(), i == len(t.segments)-1))
+	}
+	return filepath.Join(names...)
+}
+
+// maxNameBytes keeps file names under the 255 byte limit of common file
+// systems, leaving room for a collision suffix
+const maxNameBytes = 200
+
+// sanitizeName replaces characters that are not allowed in file names on
+// Linux, macOS or Windows
+func sanitizeName(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// cleanName tidies a rendered file or folder name: empty fields can leave
+// dangling separators such as " - Album", and Windows rejects names ending
+// in a dot or space or reserved device names. The extension of a file
+// name is kept as it is.
+func cleanName(name string, isFile bool) string {
+	ext := ""
+	if e := filepath.Ext(name); isFile && e != "" {
+		ext = e
+		name = strings.TrimSuffix(name, e)
+	}
+	name = strings.Trim(name, " -_.")
+	if name == "" {
+		name = "Unknown"
+	}
+
+	for len(name)+len(ext) > maxNameBytes {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	name = strings.TrimRight(name, " .")
+
+	switch strings.ToUpper(name) {
+	case "CON", "PRN", "AUX", "NUL",
+		"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+		"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9":
+		name += "_"
+	}
+	return name + ext
+}
+
+// SafeName turns value into a file or folder name that is allowed on
+// Linux, macOS and Windows and fits common file systems. The extension of
+// a file name is kept as it is.
+func SafeName(value string, isFile bool) string {
+	return cleanName(sanitizeName(value), isFile)
+}
+
+// PodcastDirName is the folder under the library root that podcast
+// episodes are downloaded into. The podcast store keeps their paths, so
+// organizing leaves it alone.
+const PodcastDirName = "Podcasts"
+
+// Move is one file rename of an organize run
+type Move struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PlanOrganize works out where every scanned song goes under t, without
+// touching any files. Songs already in place and podcast episodes are left
+// out. When two songs would land on the same name, or the name is taken by
+// another file, the later one gets a " (2)", " (3)" … suffix.
+func (l *Library) PlanOrganize(t *Template) []Move {
+	songs := append([]Song(nil), l.songs...)
+	sort.SliceStable(songs, func(i, j int) bool {
+		return songs[i].FilePath < songs[j].FilePath
+	})
+
+	// Names are compared case-insensitively so plans are safe on macOS
+	// and Windows file systems too
+	taken := make(map[string]bool)
+	for _, song := range songs {
+		taken[strings.ToLower(song.FilePath)] = true
+	}
+
+	podcasts := filepath.Join(l.rootPath, PodcastDirName) + string(filepath.Separator)
+	var moves []Move
+	for _, song := range songs {
+		// A file split by a CUE sheet stays with its sheet
+		if song.IsCueTrack() || strings.HasPrefix(song.FilePath, podcasts) {
+			continue
+		}
+		target := freePath(filepath.Join(l.rootPath, t.Render(song)), song.FilePath, taken)
+		if target == song.FilePath {
+			continue
+		}
+		taken[strings.ToLower(target)] = true
+		moves = append(moves, Move{From: song.FilePath, To: target})
+	}
+	return moves
+}
+
+// freePath returns path, or path with a number added before the extension,
+// such that it is neither in taken nor on disk. The song's own path counts
+// as free, so songs organized before keep their numbered names.
+func freePath(path, own string, taken map[string]bool) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for n := 2; ; n++ {
+		if strings.EqualFold(candidate, own) {
+			return candidate
+		}
+		if !taken[strings.ToLower(candidate)] {
+			if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+				return candidate
+			}
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+}
+
+// ApplyMoves renames the files in moves, creating folders as needed and
+// removing folders left empty, and updates the scanned songs to their new
+// paths. It stops at the first failure and returns the moves that were
+// done, which is what an undo log should record.
+func (l *Library) ApplyMoves(moves []Move) ([]Move, error) {
+	var done []Move
+	for _, move := range moves {
+		if err := moveFile(move.From, move.To); err != nil {
+			return done, err
+		}
+		done = append(done, move)
+
+		for i := range l.songs {
+			if l.songs[i].FilePath == move.From {
+				l.songs[i].FilePath = move.To
+				l.songs[i].Playlist = l.getPlaylistName(move.To)
+			}
+		}
+		l.removeEmptyDirs(filepath.Dir(move.From))
+	}
+	return done, nil
+}
+
+// moveFile renames from to to, taking along a .lrc lyrics file of the same
+// name so the lyrics stay with the song
+func moveFile(from, to string) error {
+	if _, err := os.Lstat(to); err == nil && !strings.EqualFold(from, to) {
+		return fmt.Errorf("%s already exists", to)
+	}
+	lrcFrom, lrcTo, hasLyrics := lyricsSidecar(from, to)
+	if hasLyrics {
+		if _, err := os.Lstat(lrcTo); err == nil && !strings.EqualFold(lrcFrom, lrcTo) {
+			return fmt.Errorf("%s already exists", lrcTo)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return fmt.Errorf("error creating folder: %v", err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		return fmt.Errorf("error moving %s: %v", filepath.Base(from), err)
+	}
+	if hasLyrics {
+		if err := os.Rename(lrcFrom, lrcTo); err != nil {
+			// Put the song back so it is not parted from its lyrics
+			os.Rename(to, from)
+			return fmt.Errorf("error moving %s: %v", filepath.Base(lrcFrom), err)
+		}
+	}
+	return nil
+}
+
+// lyricsSidecar returns the .lrc file next to the audio file from, if
+// there is one, and where it goes when the audio file moves to to
+func lyricsSidecar(from, to string) (string, string, bool) {
+	base := strings.TrimSuffix(from, filepath.Ext(from))
+	for _, ext := range []string{".lrc", ".LRC", ".Lrc"} {
+		if info, err := os.Stat(base + ext); err == nil && !info.IsDir() {
+			return base + ext, strings.TrimSuffix(to, filepath.Ext(to)) + ext, true
+		}
+	}
+	return "", "", false
+}
+
+// removeEmptyDirs removes dir and its parents up to the library root for
+// as long as they are empty
+func (l *Library) removeEmptyDirs(dir string) {
+	for dir != l.rootPath && strings.HasPrefix(dir, l.rootPath+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// ReverseMoves returns the moves that undo moves, in the order to apply them
+func ReverseMoves(moves []Move) []Move {
+	reversed := make([]Move, 0, len(moves))
+	for i := len(moves) - 1; i >= 0; i-- {
+		reversed = append(reversed, Move{From: moves[i].To, To: moves[i].From})
+	}
+	return reversed
+}
+
+// MovedPaths maps the old path of each move to its new one
+func MovedPaths(moves []Move) map[string]string {
+	paths := make(map[string]string, len(moves))
+	for _, move := range moves {
+		paths[move.From] = move.To
+	}
+	return paths
+}
+
+// UndoLog records an organize run so it can be reverted
+type UndoLog struct {
+	Time     time.Time `json:"time"`
+	Root     string    `json:"root"`
+	Template string    `json:"template"`
+	Moves    []Move    `json:"moves"`
+}
+
+// undoLogPrefix starts the name of every undo log file
+const undoLogPrefix = "organize-"
+
+// SaveUndoLog writes log to a new timestamped file in dir and returns its path
+func SaveUndoLog(dir string, log UndoLog) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating undo log folder: %v", err)
+	}
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error marshaling undo log: %v", err)
+	}
+	path := filepath.Join(dir, undoLogPrefix+log.Time.Format("20060102-150405")+".json")
+	if err := persist.WriteFile(path, data, 0644, nil); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// LatestUndoLog reads the newest undo log in dir
+func LatestUndoLog(dir string) (string, UndoLog, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return "", UndoLog{}, fmt.Errorf("error reading undo logs: %v", err)
+	}
+
+	latest := ""
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, undoLogPrefix) && filepath.Ext(name) == ".json" && name > latest {
+			latest = name
+		}
+	}
+	if latest == "" {
+		return "", UndoLog{}, fmt.Errorf("nothing to undo")
+	}
+
+	path := filepath.Join(dir, latest)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", UndoLog{}, fmt.Errorf("error reading undo log: %v", err)
+	}
+	var log UndoLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return "", UndoLog{}, fmt.Errorf("error parsing %s: %v", latest, err)
+	}
+	return path, log, nil
+}
+
+// RemoveUndoLog deletes an undo log that has been applied, along with the
+// lock and backup files written next to it
+func RemoveUndoLog(path string) error {
+	os.Remove(path + persist.LockSuffix)
+	os.Remove(path + persist.BackupSuffix)
+	return os.Remove(path)
+}
@@ -0,0 +1,170 @@
+package library
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed search string. Plain words are matched as one phrase
+// against title, artist and album, as before. Terms of the form field:value
+// narrow the result further, e.g. "jazz plays:>=5 year:<1970 loved:yes".
+//
+// Numeric fields accept the comparisons >, >=, <, <= and = (the default).
+// Text fields match case-insensitively as substrings.
+type Query struct {
+	Text  string
+	Rules []Rule
+}
+
+// Rule is a single field:value term of a query
+type Rule struct {
+	Field string
+	Op    string
+	Value string
+}
+
+var numericFields = map[string]func(Song) (float64, bool){
+	"plays":  func(s Song) (float64, bool) { return float64(s.PlayCount), true },
+	"rating": func(s Song) (float64, bool) { return float64(s.Rating), true },
+	"loved": func(s Song) (float64, bool) {
+		if s.Loved {
+			return 1, true
+		}
+		return 0, true
+	},
+	"track": func(s Song) (float64, bool) { return float64(s.Track), true },
+	"disc":  func(s Song) (float64, bool) { return float64(s.Disc), true },
+	"year": func(s Song) (float64, bool) {
+		year, err := strconv.Atoi(songYear(s))
+		return float64(year), err == nil
+	},
+	"compilation": func(s Song) (float64, bool) {
+		if s.Compilation {
+			return 1, true
+		}
+		return 0, true
+	},
+	"duration": func(s Song) (float64, bool) { return s.Duration.Seconds(), true },
+}
+
+var textFields = map[string]func(Song) string{
+	"title":  func(s Song) string { return s.Title },
+	"artist": func(s Song) string { return s.Artist },
+	"album":  func(s Song) string { return s.Album },
+	"genre":  func(s Song) string { return s.Genre },
+
+	"albumartist": func(s Song) string { return s.GroupArtist() },
+	"composer":    func(s Song) string { return s.Composer },
+}
+
+// ParseQuery splits text into free-text words and field rules. Terms naming
+// an unknown field are treated as free text.
+func ParseQuery(text string) Query {
+	var query Query
+	var words []string
+
+	for _, term := range strings.Fields(text) {
+		field, value, ok := strings.Cut(term, ":")
+		field = strings.ToLower(field)
+		if !ok || value == "" || !isQueryField(field) {
+			words = append(words, term)
+			continue
+		}
+
+		op := "="
+		for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+			if strings.HasPrefix(value, candidate) {
+				op = candidate
+				value = strings.TrimPrefix(value, candidate)
+				break
+			}
+		}
+		query.Rules = append(query.Rules, Rule{Field: field, Op: op, Value: value})
+	}
+
+	query.Text = strings.ToLower(strings.Join(words, " "))
+	return query
+}
+
+func isQueryField(field string) bool {
+	_, numeric := numericFields[field]
+	_, text := textFields[field]
+	return numeric || text
+}
+
+// IsEmpty reports whether the query matches every song
+func (q Query) IsEmpty() bool {
+	return q.Text == "" && len(q.Rules) == 0
+}
+
+// Match reports whether song satisfies the free text and every rule
+func (q Query) Match(song Song) bool {
+	if q.Text != "" &&
+		!strings.Contains(strings.ToLower(song.Title), q.Text) &&
+		!strings.Contains(strings.ToLower(song.Artist), q.Text) &&
+		!strings.Contains(strings.ToLower(song.Album), q.Text) {
+		return false
+	}
+
+	for _, rule := range q.Rules {
+		if !rule.Match(song) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether song satisfies the rule
+func (r Rule) Match(song Song) bool {
+	if get, ok := textFields[r.Field]; ok {
+		return strings.Contains(strings.ToLower(get(song)), strings.ToLower(r.Value))
+	}
+
+	get, ok := numericFields[r.Field]
+	if !ok {
+		return true
+	}
+	want, err := strconv.ParseFloat(boolValue(r.Value), 64)
+	if err != nil {
+		return false
+	}
+	have, ok := get(song)
+	if !ok {
+		return false
+	}
+
+	switch r.Op {
+	case ">":
+		return have > want
+	case ">=":
+		return have >= want
+	case "<":
+		return have < want
+	case "<=":
+		return have <= want
+	default:
+		return have == want
+	}
+}
+
+// boolValue maps yes/no style values to 1 and 0 so flags compare as numbers
+func boolValue(value string) string {
+	switch strings.ToLower(value) {
+	case "yes", "true", "y":
+		return "1"
+	case "no", "false", "n":
+		return "0"
+	}
+	return value
+}
+
+// Filter returns the songs matching the query
+func (q Query) Filter(songs []Song) []Song {
+	result := make([]Song, 0)
+	for _, song := range songs {
+		if q.Match(song) {
+			result = append(result, song)
+		}
+	}
+	return result
+}
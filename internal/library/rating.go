@@ -0,0 +1,136 @@
+package library
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// POPMEmail identifies the POPM frame clispot writes ratings to
+const POPMEmail = "clispot"
+
+// popmSteps are the POPM byte values commonly used for one to five stars
+var popmSteps = [...]uint8{0, 1, 64, 128, 196, 255}
+
+// RatingFromPOPM converts a 0–255 POPM rating to 0–5 stars
+func RatingFromPOPM(value uint8) int {
+	switch {
+	case value == 0:
+		return 0
+	case value < 32:
+		return 1
+	case value < 96:
+		return 2
+	case value < 160:
+		return 3
+	case value < 224:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// POPMFromRating converts 0–5 stars to a POPM rating byte
+func POPMFromRating(rating int) uint8 {
+	if rating < 0 {
+		rating = 0
+	} else if rating >= len(popmSteps) {
+		rating = len(popmSteps) - 1
+	}
+	return popmSteps[rating]
+}
+
+// WriteRatingTag stores rating in the file's POPM frame, replacing any POPM
+// frame clispot wrote before. A rating of 0 removes clispot's frame.
+func WriteRatingTag(filePath string, rating int) error {
+	tag, err := id3v2.Open(filePath, id3v2.Options{Parse: true})
+	if err != nil {
+		return fmt.Errorf("error opening tags: %v", err)
+	}
+	defer tag.Close()
+
+	popmID := tag.CommonID("Popularimeter")
+	var keep []id3v2.Framer
+	for _, frame := range tag.GetFrames(popmID) {
+		if popm, ok := frame.(id3v2.PopularimeterFrame); ok && popm.Email == POPMEmail {
+			continue
+		}
+		keep = append(keep, frame)
+	}
+
+	tag.DeleteFrames(popmID)
+	for _, frame := range keep {
+		tag.AddFrame(popmID, frame)
+	}
+	if rating > 0 {
+		tag.AddFrame(popmID, id3v2.PopularimeterFrame{
+			Email:  POPMEmail,
+			Rating: POPMFromRating(rating),
+		})
+	}
+
+	if err := tag.Save(); err != nil {
+		return fmt.Errorf("error saving tags: %v", err)
+	}
+	return nil
+}
+
+// ratingFromTag reads the star rating from the first POPM frame, preferring
+// the one clispot wrote
+func ratingFromTag(tag *id3v2.Tag) int {
+	rating := 0
+	for _, frame := range tag.GetFrames(tag.CommonID("Popularimeter")) {
+		popm, ok := frame.(id3v2.PopularimeterFrame)
+		if !ok {
+			continue
+		}
+		if popm.Email == POPMEmail {
+			return RatingFromPOPM(popm.Rating)
+		}
+		if rating == 0 {
+			rating = RatingFromPOPM(popm.Rating)
+		}
+	}
+	return rating
+}
+
+// ShuffleWeight is the relative chance of song being picked by shuffle.
+// Unrated songs sit in the middle, higher ratings play more often and loved
+// songs twice as often again.
+func ShuffleWeight(song Song) float64 {
+	weights := [...]float64{3, 1, 2, 3, 5, 8}
+	weight := weights[0]
+	if song.Rating > 0 && song.Rating < len(weights) {
+		weight = weights[song.Rating]
+	}
+	if song.Loved {
+		weight *= 2
+	}
+	return weight
+}
+
+// WeightedPick picks the index of one of songs at random, weighted by
+// ShuffleWeight. The song at exclude is skipped when there are others, so
+// shuffle does not repeat the song that just played; -1 excludes none.
+func WeightedPick(songs []Song, exclude int) int {
+	total := 0.0
+	for i, song := range songs {
+		if i == exclude && len(songs) > 1 {
+			continue
+		}
+		total += ShuffleWeight(song)
+	}
+
+	pick := rand.Float64() * total
+	for i, song := range songs {
+		if i == exclude && len(songs) > 1 {
+			continue
+		}
+		pick -= ShuffleWeight(song)
+		if pick < 0 {
+			return i
+		}
+	}
+	return len(songs) - 1
+}
@@ -0,0 +1,102 @@
+package library
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SortOrder selects how song lists are ordered in the browser
+type SortOrder int
+
+const (
+	SortDefault SortOrder = iota
+	SortMostPlayed
+	SortLeastPlayed
+	SortTopRated
+)
+
+func (o SortOrder) String() string {
+	switch o {
+	case SortMostPlayed:
+		return "Most played"
+	case SortLeastPlayed:
+		return "Least played"
+	case SortTopRated:
+		return "Top rated"
+	default:
+		return "Default"
+	}
+}
+
+// Next returns the order that follows o when cycling through them
+func (o SortOrder) Next() SortOrder {
+	return (o + 1) % (SortTopRated + 1)
+}
+
+// SortSongs orders songs in place. SortDefault keeps the existing order.
+func SortSongs(songs []Song, order SortOrder) {
+	if order == SortDefault {
+		return
+	}
+	sort.SliceStable(songs, func(i, j int) bool {
+		return songLess(songs[i], songs[j], order)
+	})
+}
+
+// SortItems orders the songs among items in place, leaving folders first
+func SortItems(items []LibraryItem, order SortOrder) {
+	if order == SortDefault {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Type != b.Type {
+			return a.Type == ItemTypeFolder
+		}
+		if a.Song == nil || b.Song == nil {
+			return false
+		}
+		return songLess(*a.Song, *b.Song, order)
+	})
+}
+
+func songLess(a, b Song, order SortOrder) bool {
+	switch order {
+	case SortMostPlayed:
+		if a.PlayCount != b.PlayCount {
+			return a.PlayCount > b.PlayCount
+		}
+	case SortLeastPlayed:
+		if a.PlayCount != b.PlayCount {
+			return a.PlayCount < b.PlayCount
+		}
+	case SortTopRated:
+		if a.Loved != b.Loved {
+			return a.Loved
+		}
+		if a.Rating != b.Rating {
+			return a.Rating > b.Rating
+		}
+	}
+	return strings.ToLower(a.Artist+a.Title) < strings.ToLower(b.Artist+b.Title)
+}
+
+// SortTracks orders the songs of an album by disc and track number, falling
+// back to the file name for untagged tracks
+func SortTracks(songs []Song) {
+	sort.SliceStable(songs, func(i, j int) bool {
+		a, b := songs[i], songs[j]
+		if a.Disc != b.Disc {
+			return a.Disc < b.Disc
+		}
+		if a.Track != b.Track {
+			// Untagged tracks go after numbered ones
+			if a.Track == 0 || b.Track == 0 {
+				return b.Track == 0
+			}
+			return a.Track < b.Track
+		}
+		return filepath.Base(a.FilePath) < filepath.Base(b.FilePath)
+	})
+}
@@ -0,0 +1,518 @@
+package library
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// Tag sources recorded in Song.Sources, besides SourceFilename
+const (
+	SourceID3v24 = "ID3v2.4"
+	SourceID3v23 = "ID3v2.3"
+	SourceID3v22 = "ID3v2.2"
+	SourceAPEv2  = "APEv2"
+	SourceID3v11 = "ID3v1.1"
+	SourceID3v1  = "ID3v1"
+)
+
+// FieldComposer is read from tags like the editable fields, so its source
+// can be reported, but is not offered by the tag editor
+const FieldComposer TagField = "composer"
+
+// mergedFields are the fields taken from whichever tag has them first
+var mergedFields = append(append([]TagField(nil), TagFields...), FieldComposer)
+
+// tagReading is what one tag in a file holds. A file can carry several:
+// an ID3v2 tag at the start, and APEv2 and ID3v1 tags at the end.
+type tagReading struct {
+	source      string
+	values      map[TagField]string
+	compilation bool
+	picture     []byte
+	chapters    []Chapter
+}
+
+// readTags reads every tag in file, in priority order: ID3v2 at the start
+// of the file, then the APEv2 and ID3v1 tags some rippers append at the
+// end. The rating comes from an ID3v2.3 or v2.4 POPM frame. The error
+// tells of a broken ID3v2 tag; the other tags are still read.
+func readTags(file *os.File, size int64) ([]tagReading, int, error) {
+	var readings []tagReading
+	rating := 0
+	tag, err := id3v2.ParseReader(file, id3v2.Options{Parse: true})
+	switch {
+	case err == nil:
+		if tag.Count() > 0 {
+			readings = append(readings, readID3v2(tag))
+			rating = ratingFromTag(tag)
+		}
+	case err == id3v2.ErrUnsupportedVersion:
+		if reading, ok := readID3v22(file); ok {
+			readings = append(readings, reading)
+			err = nil
+		}
+	}
+	if reading, ok := readAPEv2(file, size); ok {
+		readings = append(readings, reading)
+	}
+	if reading, ok := readID3v1(file, size); ok {
+		readings = append(readings, reading)
+	}
+	return readings, rating, err
+}
+
+// mergeTags fills the song from readings, which are in priority order: for
+// each field the first tag that has a value wins, and its source is
+// recorded. The picture of the first tag that has one is returned.
+func (s *Song) mergeTags(readings []tagReading) []byte {
+	for _, field := range mergedFields {
+		for _, reading := range readings {
+			value := strings.TrimSpace(reading.values[field])
+			if value == "" {
+				continue
+			}
+			setTagValue(s, field, value)
+			if s.Sources == nil {
+				s.Sources = make(map[TagField]string)
+			}
+			s.Sources[field] = reading.source
+			break
+		}
+	}
+
+	var picture []byte
+	for _, reading := range readings {
+		s.Compilation = s.Compilation || reading.compilation
+		if picture == nil && len(reading.picture) > 0 {
+			picture = reading.picture
+		}
+		if s.Chapters == nil && len(reading.chapters) > 0 {
+			s.Chapters = reading.chapters
+			fixChapterEnds(s.Chapters, s.Duration)
+		}
+	}
+	return picture
+}
+
+// setTagValue stores a tag value in the matching Song field
+func setTagValue(song *Song, field TagField, value string) {
+	switch field {
+	case FieldTitle:
+		song.Title = value
+	case FieldArtist:
+		song.Artist = value
+	case FieldAlbum:
+		song.Album = value
+	case FieldAlbumArtist:
+		song.AlbumArtist = value
+	case FieldYear:
+		song.Year = value
+	case FieldGenre:
+		song.Genre = value
+	case FieldTrack:
+		song.Track = numberInSet(value)
+	case FieldDisc:
+		song.Disc = numberInSet(value)
+	case FieldComposer:
+		song.Composer = value
+	}
+}
+
+// TagSources lists the tags the song's values were read from, in priority
+// order, for showing where its metadata came from
+func (s Song) TagSources() []string {
+	order := []string{SourceCue, SourceID3v24, SourceID3v23, SourceID3v22, SourceAPEv2, SourceID3v11, SourceID3v1, SourceFilename}
+	used := make(map[string]bool)
+	for _, source := range s.Sources {
+		used[source] = true
+	}
+	var sources []string
+	for _, source := range order {
+		if used[source] {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// readID3v2 collects the values of an ID3v2.3 or v2.4 tag
+func readID3v2(tag *id3v2.Tag) tagReading {
+	source := SourceID3v24
+	if tag.Version() == 3 {
+		source = SourceID3v23
+	}
+
+	reading := tagReading{
+		source:      source,
+		values:      make(map[TagField]string),
+		compilation: isCompilation(tag),
+	}
+	text := func(name string) string {
+		return tag.GetTextFrame(tag.CommonID(name)).Text
+	}
+	reading.values[FieldTitle] = tag.Title()
+	reading.values[FieldArtist] = tag.Artist()
+	reading.values[FieldAlbum] = tag.Album()
+	reading.values[FieldAlbumArtist] = text("Band/Orchestra/Accompaniment")
+	reading.values[FieldYear] = tag.Year()
+	reading.values[FieldGenre] = genreName(tag.Genre())
+	reading.values[FieldTrack] = text("Track number/Position in set")
+	reading.values[FieldDisc] = text("Part of a set")
+	reading.values[FieldComposer] = text("Composer")
+
+	if pictures := tag.GetFrames(tag.CommonID("Attached picture")); len(pictures) > 0 {
+		if picture, ok := pictures[0].(id3v2.PictureFrame); ok {
+			reading.picture = picture.Picture
+		}
+	}
+	reading.chapters = readChapters(tag)
+	return reading
+}
+
+// id3v22Frames maps the three-character frame IDs of ID3v2.2 to fields
+var id3v22Frames = map[string]TagField{
+	"TT2": FieldTitle,
+	"TP1": FieldArtist,
+	"TAL": FieldAlbum,
+	"TP2": FieldAlbumArtist,
+	"TYE": FieldYear,
+	"TCO": FieldGenre,
+	"TRK": FieldTrack,
+	"TPA": FieldDisc,
+	"TCM": FieldComposer,
+}
+
+// maxTagSize guards against corrupt size fields asking for huge reads
+const maxTagSize = 16 << 20
+
+// readID3v22 parses an ID3v2.2 tag at the start of r, which the id3v2
+// package doesn't support. ok is false if there is none or it can't be read.
+func readID3v22(r io.ReaderAt) (tagReading, bool) {
+	header := make([]byte, 10)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return tagReading{}, false
+	}
+	if string(header[:3]) != "ID3" || header[3] != 2 {
+		return tagReading{}, false
+	}
+	flags := header[5]
+	size := syncsafe(header[6:10])
+	if flags&0x40 != 0 || size > maxTagSize {
+		// v2.2 compression was never specified, so such tags can't be read
+		return tagReading{}, false
+	}
+
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 10); err != nil {
+		return tagReading{}, false
+	}
+	if flags&0x80 != 0 {
+		data = bytes.ReplaceAll(data, []byte{0xff, 0x00}, []byte{0xff})
+	}
+
+	reading := tagReading{source: SourceID3v22, values: make(map[TagField]string)}
+	for len(data) >= 6 && data[0] != 0 {
+		id := string(data[:3])
+		frameSize := int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		if frameSize > len(data)-6 {
+			break
+		}
+		body := data[6 : 6+frameSize]
+		data = data[6+frameSize:]
+
+		switch {
+		case id == "TCP":
+			reading.compilation = strings.TrimSpace(decodeText(body)) == "1"
+		case id == "PIC":
+			if reading.picture == nil {
+				reading.picture = pictureV22(body)
+			}
+		default:
+			if field, ok := id3v22Frames[id]; ok {
+				value := decodeText(body)
+				if field == FieldGenre {
+					value = genreName(value)
+				}
+				reading.values[field] = value
+			}
+		}
+	}
+	return reading, true
+}
+
+// pictureV22 returns the image data of a PIC frame: encoding, a three
+// letter image format, picture type and a terminated description
+func pictureV22(body []byte) []byte {
+	if len(body) < 5 {
+		return nil
+	}
+	encoding := body[0]
+	rest := body[5:]
+	terminator := []byte{0}
+	if encoding == 1 || encoding == 2 {
+		terminator = []byte{0, 0}
+	}
+	for i := 0; i+len(terminator) <= len(rest); i += len(terminator) {
+		if bytes.Equal(rest[i:i+len(terminator)], terminator) {
+			return rest[i+len(terminator):]
+		}
+	}
+	return nil
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// decodeText decodes the body of an ID3v2 text frame, whose first byte
+// gives the encoding: ISO-8859-1, UTF-16 with BOM, UTF-16BE or UTF-8
+func decodeText(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	encoding, data := body[0], body[1:]
+	var text string
+	switch encoding {
+	case 1, 2:
+		text = DecodeUTF16(data, encoding == 2)
+	case 3:
+		text = string(data)
+	default:
+		text = Latin1(data)
+	}
+	// Multiple values are separated by NULs; the first is enough here
+	if idx := strings.IndexRune(text, 0); idx >= 0 {
+		text = text[:idx]
+	}
+	return strings.TrimSpace(text)
+}
+
+// DecodeUTF16 decodes UTF-16 text, big endian or not as a byte order mark
+// says and otherwise as bigEndian says
+func DecodeUTF16(data []byte, bigEndian bool) string {
+	if len(data) >= 2 {
+		switch {
+		case data[0] == 0xfe && data[1] == 0xff:
+			bigEndian, data = true, data[2:]
+		case data[0] == 0xff && data[1] == 0xfe:
+			bigEndian, data = false, data[2:]
+		}
+	}
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, binary.BigEndian.Uint16(data[i:]))
+		} else {
+			units = append(units, binary.LittleEndian.Uint16(data[i:]))
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// Latin1 converts ISO 8859-1 text to UTF-8
+func Latin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// findAPEFooter locates the 32 byte footer of an APEv2 tag at the end of a
+// file of the given size, either last or just before an ID3v1 trailer
+func findAPEFooter(r io.ReaderAt, size int64) ([]byte, int64, bool) {
+	footer := make([]byte, 32)
+	for _, offset := range []int64{size - 32, size - 128 - 32} {
+		if offset < 0 {
+			continue
+		}
+		if _, err := r.ReadAt(footer, offset); err == nil && string(footer[:8]) == "APETAGEX" {
+			return footer, offset, true
+		}
+	}
+	return nil, 0, false
+}
+
+// readAPEv2 parses an APEv2 tag at the end of a file of the given size
+func readAPEv2(r io.ReaderAt, size int64) (tagReading, bool) {
+	footer, footerAt, found := findAPEFooter(r, size)
+	if !found {
+		return tagReading{}, false
+	}
+
+	tagSize := int64(binary.LittleEndian.Uint32(footer[12:16]))
+	itemCount := int(binary.LittleEndian.Uint32(footer[16:20]))
+	if tagSize < 32 || tagSize > maxTagSize || tagSize-32 > footerAt {
+		return tagReading{}, false
+	}
+	items := make([]byte, tagSize-32)
+	if _, err := r.ReadAt(items, footerAt+32-tagSize); err != nil {
+		return tagReading{}, false
+	}
+
+	reading := tagReading{source: SourceAPEv2, values: make(map[TagField]string)}
+	for i := 0; i < itemCount && len(items) >= 8; i++ {
+		valueSize := int(binary.LittleEndian.Uint32(items[0:4]))
+		itemFlags := binary.LittleEndian.Uint32(items[4:8])
+		items = items[8:]
+		end := bytes.IndexByte(items, 0)
+		if end < 0 || valueSize > len(items)-end-1 {
+			break
+		}
+		key := string(items[:end])
+		value := items[end+1 : end+1+valueSize]
+		items = items[end+1+valueSize:]
+
+		// Bits 1-2 give the item type; only UTF-8 text items (0) matter here
+		if itemFlags&0x6 != 0 {
+			if strings.EqualFold(key, "Cover Art (Front)") && reading.picture == nil {
+				if idx := bytes.IndexByte(value, 0); idx >= 0 {
+					reading.picture = value[idx+1:]
+				}
+			}
+			continue
+		}
+		text := string(value)
+		// List values are NUL separated; keep the first
+		if idx := strings.IndexByte(text, 0); idx >= 0 {
+			text = text[:idx]
+		}
+
+		switch strings.ToLower(key) {
+		case "title":
+			reading.values[FieldTitle] = text
+		case "artist":
+			reading.values[FieldArtist] = text
+		case "album":
+			reading.values[FieldAlbum] = text
+		case "album artist", "albumartist":
+			reading.values[FieldAlbumArtist] = text
+		case "year", "date":
+			reading.values[FieldYear] = text
+		case "genre":
+			reading.values[FieldGenre] = genreName(text)
+		case "track":
+			reading.values[FieldTrack] = text
+		case "disc":
+			reading.values[FieldDisc] = text
+		case "composer":
+			reading.values[FieldComposer] = text
+		case "compilation":
+			reading.compilation = strings.TrimSpace(text) == "1"
+		}
+	}
+	return reading, true
+}
+
+// readID3v1 parses the 128 byte ID3v1 trailer of a file of the given size.
+// ID3v1.1 uses the last two bytes of the comment for a track number.
+func readID3v1(r io.ReaderAt, size int64) (tagReading, bool) {
+	if size < 128 {
+		return tagReading{}, false
+	}
+	data := make([]byte, 128)
+	if _, err := r.ReadAt(data, size-128); err != nil || string(data[:3]) != "TAG" {
+		return tagReading{}, false
+	}
+
+	field := func(b []byte) string {
+		if idx := bytes.IndexByte(b, 0); idx >= 0 {
+			b = b[:idx]
+		}
+		return strings.TrimSpace(Latin1(b))
+	}
+
+	reading := tagReading{source: SourceID3v1, values: make(map[TagField]string)}
+	reading.values[FieldTitle] = field(data[3:33])
+	reading.values[FieldArtist] = field(data[33:63])
+	reading.values[FieldAlbum] = field(data[63:93])
+	reading.values[FieldYear] = field(data[93:97])
+	comment := data[97:127]
+	if comment[28] == 0 && comment[29] != 0 {
+		reading.source = SourceID3v11
+		reading.values[FieldTrack] = strconv.Itoa(int(comment[29]))
+	}
+	if genre := int(data[127]); genre < len(id3v1Genres) {
+		reading.values[FieldGenre] = id3v1Genres[genre]
+	}
+	return reading, true
+}
+
+// genreName turns ID3 genre references such as "17", "(17)" or
+// "(17)Rock" into names, keeping any refinement text
+func genreName(text string) string {
+	text = strings.TrimSpace(text)
+	if n, err := strconv.Atoi(text); err == nil {
+		if n >= 0 && n < len(id3v1Genres) {
+			return id3v1Genres[n]
+		}
+		return text
+	}
+	if !strings.HasPrefix(text, "(") {
+		return text
+	}
+
+	end := strings.Index(text, ")")
+	if end < 0 {
+		return text
+	}
+	ref, rest := text[1:end], strings.TrimSpace(text[end+1:])
+	if rest != "" && !strings.HasPrefix(rest, "(") {
+		return rest
+	}
+	switch ref {
+	case "RX":
+		return "Remix"
+	case "CR":
+		return "Cover"
+	}
+	if n, err := strconv.Atoi(ref); err == nil && n >= 0 && n < len(id3v1Genres) {
+		return id3v1Genres[n]
+	}
+	return text
+}
+
+// id3v1Genres is the ID3v1 genre table with the Winamp extensions
+var id3v1Genres = [...]string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge",
+	"Hip-Hop", "Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B",
+	"Rap", "Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska",
+	"Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient",
+	"Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical",
+	"Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative",
+	"Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic", "Darkwave",
+	"Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap",
+	"Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave",
+	"Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock", "Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion",
+	"Bebop", "Latin", "Revival", "Celtic", "Bluegrass", "Avantgarde",
+	"Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock",
+	"Slow Rock", "Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour",
+	"Speech", "Chanson", "Opera", "Chamber Music", "Sonata", "Symphony",
+	"Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam", "Club",
+	"Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul",
+	"Freestyle", "Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House",
+	"Dance Hall", "Goa", "Drum & Bass", "Club-House", "Hardcore Techno",
+	"Terror", "Indie", "BritPop", "Negerpunk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover",
+	"Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "Jpop", "Synthpop", "Abstract", "Art Rock",
+	"Baroque", "Bhangra", "Big Beat", "Breakbeat", "Chillout", "Downtempo",
+	"Dub", "EBM", "Eclectic", "Electro", "Electroclash", "Emo", "Experimental",
+	"Garage", "Global", "IDM", "Illbient", "Industro-Goth", "Jam Band",
+	"Krautrock", "Leftfield", "Lounge", "Math Rock", "New Romantic",
+	"Nu-Breakz", "Post-Punk", "Post-Rock", "Psytrance", "Shoegaze",
+	"Space Rock", "Trop Rock", "World Music", "Neoclassical", "Audiobook",
+	"Audio Theatre", "Neue Deutsche Welle", "Podcast", "Indie Rock",
+	"G-Funk", "Dubstep", "Garage Rock", "Psybient",
+}
@@ -0,0 +1,327 @@
+package library
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2/v2"
+)
+
+// TagField names a tag the editor can change
+type TagField string
+
+const (
+	FieldTitle       TagField = "title"
+	FieldArtist      TagField = "artist"
+	FieldAlbum       TagField = "album"
+	FieldAlbumArtist TagField = "albumartist"
+	FieldYear        TagField = "year"
+	FieldGenre       TagField = "genre"
+	FieldTrack       TagField = "track"
+	FieldDisc        TagField = "disc"
+)
+
+// TagFields lists the editable fields in the order the editor shows them
+var TagFields = []TagField{
+	FieldTitle, FieldArtist, FieldAlbum, FieldAlbumArtist,
+	FieldYear, FieldGenre, FieldTrack, FieldDisc,
+}
+
+// Label returns the field name as shown to the user
+func (f TagField) Label() string {
+	switch f {
+	case FieldAlbumArtist:
+		return "Album Artist"
+	default:
+		return strings.ToUpper(string(f[:1])) + string(f[1:])
+	}
+}
+
+// ParseTagField looks a field up by name, ignoring case and spaces
+func ParseTagField(name string) (TagField, bool) {
+	name = strings.ToLower(strings.ReplaceAll(name, " ", ""))
+	for _, field := range TagFields {
+		if string(field) == name {
+			return field, true
+		}
+	}
+	return "", false
+}
+
+// frameName returns the id3v2 common name of the frame a field is stored
+// in, which maps to the right frame ID for both v2.3 and v2.4 tags
+func (f TagField) frameName() string {
+	switch f {
+	case FieldTitle:
+		return "Title/Songname/Content description"
+	case FieldArtist:
+		return "Lead artist/Lead performer/Soloist/Performing group"
+	case FieldAlbum:
+		return "Album/Movie/Show title"
+	case FieldAlbumArtist:
+		return "Band/Orchestra/Accompaniment"
+	case FieldYear:
+		return "Year"
+	case FieldGenre:
+		return "Content type"
+	case FieldTrack:
+		return "Track number/Position in set"
+	case FieldDisc:
+		return "Part of a set"
+	}
+	return ""
+}
+
+// TagValue returns the value of field as the editor shows it, with the
+// "Unknown" placeholders left empty
+func TagValue(song Song, field TagField) string {
+	switch field {
+	case FieldTitle:
+		return song.Title
+	case FieldArtist:
+		if song.Artist == "Unknown Artist" {
+			return ""
+		}
+		return song.Artist
+	case FieldAlbum:
+		if song.Album == "Unknown Album" {
+			return ""
+		}
+		return song.Album
+	case FieldAlbumArtist:
+		return song.AlbumArtist
+	case FieldYear:
+		return song.Year
+	case FieldGenre:
+		return song.Genre
+	case FieldTrack:
+		return numberText(song.Track)
+	case FieldDisc:
+		return numberText(song.Disc)
+	case FieldComposer:
+		return song.Composer
+	}
+	return ""
+}
+
+func numberText(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// TagEdit is a set of changes to a file's tags. Only the fields in Values
+// are written, so one edit can be applied to many files; an empty value
+// removes the frame.
+type TagEdit struct {
+	Values map[TagField]string
+
+	// Cover replaces the front cover picture when set
+	Cover     []byte
+	CoverMIME string
+}
+
+// IsEmpty reports whether the edit changes nothing
+func (e TagEdit) IsEmpty() bool {
+	return len(e.Values) == 0 && len(e.Cover) == 0
+}
+
+// Validate checks the values before any file is touched: track and disc
+// must be "3" or "3/12" style numbers and the year a number
+func (e TagEdit) Validate() error {
+	for field, value := range e.Values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		switch field {
+		case FieldTrack, FieldDisc:
+			for _, part := range strings.SplitN(value, "/", 2) {
+				if n, err := strconv.Atoi(part); err != nil || n < 0 {
+					return fmt.Errorf("%s must be a number like 3 or 3/12, not %q", field.Label(), value)
+				}
+			}
+		case FieldYear:
+			if len(value) < 4 {
+				return fmt.Errorf("year must have four digits, not %q", value)
+			}
+			if _, err := strconv.Atoi(value[:4]); err != nil {
+				return fmt.Errorf("year must start with four digits, not %q", value)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadCover reads an image file for TagEdit.Cover; only JPEG and PNG are
+// accepted since those are what players understand in APIC frames
+func LoadCover(path string) ([]byte, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading cover: %v", err)
+	}
+	mime := http.DetectContentType(data)
+	if mime != "image/jpeg" && mime != "image/png" {
+		return nil, "", fmt.Errorf("%s is not a JPEG or PNG image", path)
+	}
+	return data, mime, nil
+}
+
+// coverFileNames are the images looked for beside a song that has no
+// embedded picture
+var coverFileNames = []string{"cover.jpg", "folder.jpg", "front.jpg", "cover.png", "folder.png", "front.png"}
+
+// ReadCover returns the song's cover art and its MIME type: the front
+// cover embedded in the file, else its first picture, else an image such
+// as cover.jpg in the song's folder
+func ReadCover(song Song) ([]byte, string, error) {
+	path := song.AudioPath()
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true, ParseFrames: []string{"Attached picture"}})
+	if err == nil {
+		var picture []byte
+		for _, frame := range tag.GetFrames(tag.CommonID("Attached picture")) {
+			p, ok := frame.(id3v2.PictureFrame)
+			if !ok || len(p.Picture) == 0 {
+				continue
+			}
+			if picture == nil || p.PictureType == id3v2.PTFrontCover {
+				picture = p.Picture
+			}
+			if p.PictureType == id3v2.PTFrontCover {
+				break
+			}
+		}
+		tag.Close()
+		if picture != nil {
+			return picture, http.DetectContentType(picture), nil
+		}
+	}
+
+	dir := filepath.Dir(path)
+	for _, name := range coverFileNames {
+		if data, mime, err := LoadCover(filepath.Join(dir, name)); err == nil {
+			return data, mime, nil
+		}
+	}
+	return nil, "", fmt.Errorf("%s has no cover art", filepath.Base(path))
+}
+
+// WriteTags applies edit to the ID3v2 tag of the file at filePath, leaving
+// every other frame as it was
+func WriteTags(filePath string, edit TagEdit) error {
+	if err := edit.Validate(); err != nil {
+		return err
+	}
+
+	tag, err := id3v2.Open(filePath, id3v2.Options{Parse: true})
+	if err != nil {
+		return fmt.Errorf("error opening tags: %v", err)
+	}
+	defer tag.Close()
+
+	// UTF-8 only exists from v2.4; v2.3 files keep their version and get
+	// UTF-16 so other players can still read them
+	encoding := id3v2.EncodingUTF8
+	if tag.Version() < 4 {
+		encoding = id3v2.EncodingUTF16
+	}
+
+	for field, value := range edit.Values {
+		id := tag.CommonID(field.frameName())
+		value = strings.TrimSpace(value)
+		tag.DeleteFrames(id)
+		if value != "" {
+			tag.AddTextFrame(id, encoding, value)
+		}
+	}
+
+	if len(edit.Cover) > 0 {
+		pictureID := tag.CommonID("Attached picture")
+		var keep []id3v2.Framer
+		for _, frame := range tag.GetFrames(pictureID) {
+			if picture, ok := frame.(id3v2.PictureFrame); ok && picture.PictureType == id3v2.PTFrontCover {
+				continue
+			}
+			keep = append(keep, frame)
+		}
+		tag.DeleteFrames(pictureID)
+		tag.AddAttachedPicture(id3v2.PictureFrame{
+			Encoding:    encoding,
+			MimeType:    edit.CoverMIME,
+			PictureType: id3v2.PTFrontCover,
+			Description: "Front cover",
+			Picture:     edit.Cover,
+		})
+		for _, frame := range keep {
+			tag.AddFrame(pictureID, frame)
+		}
+	}
+
+	if err := tag.Save(); err != nil {
+		return fmt.Errorf("error saving tags: %v", err)
+	}
+	return nil
+}
+
+// EditTags writes edit to each file in paths and rereads its metadata into
+// the library, keeping the listening data. Files that fail are skipped; the
+// returned error names them. The songs that were saved are returned.
+func (l *Library) EditTags(paths []string, edit TagEdit) ([]Song, error) {
+	if err := edit.Validate(); err != nil {
+		return nil, err
+	}
+
+	var saved []Song
+	var failed []string
+	for _, path := range paths {
+		if err := WriteTags(path, edit); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", filepath.Base(path), err))
+			continue
+		}
+		song, err := l.Reload(path)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", filepath.Base(path), err))
+			continue
+		}
+		saved = append(saved, song)
+	}
+
+	switch len(failed) {
+	case 0:
+		return saved, nil
+	case 1:
+		return saved, fmt.Errorf("%s", failed[0])
+	default:
+		return saved, fmt.Errorf("%d files failed, first %s", len(failed), failed[0])
+	}
+}
+
+// Reload rereads the tags of the file at filePath and replaces its entry in
+// the scanned songs, keeping play count, rating and loved flag
+func (l *Library) Reload(filePath string) (Song, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return Song{}, err
+	}
+	song, err := l.extractMetadata(filePath, info)
+	if err != nil {
+		return Song{}, err
+	}
+
+	for i := range l.songs {
+		if l.songs[i].FilePath == filePath {
+			song.PlayCount = l.songs[i].PlayCount
+			song.Rating = l.songs[i].Rating
+			song.Loved = l.songs[i].Loved
+			l.songs[i] = song
+			return song, nil
+		}
+	}
+	l.songs = append(l.songs, song)
+	return song, nil
+}
@@ -0,0 +1,290 @@
+package lyrics
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bogem/id3v2/v2"
+
+	"clispot/internal/library"
+)
+
+// Where lyrics were found
+const (
+	SourceLRC  = "lrc"
+	SourceSYLT = "SYLT"
+	SourceUSLT = "USLT"
+)
+
+// Line is one line of lyrics. Time is when it starts, for synced lyrics.
+type Line struct {
+	Time time.Duration
+	Text string
+}
+
+// Lyrics are the words of one song. Synced lyrics have a start time for
+// every line, in order; unsynced ones are plain text.
+type Lyrics struct {
+	Lines  []Line
+	Synced bool
+	Source string
+	// Offset is the LRC file's own [offset:] tag, already applied to
+	// the line times
+	Offset time.Duration
+}
+
+// Load finds the lyrics of the audio file at path: a .lrc file next to it
+// first, then the synced and plain lyrics frames of its ID3v2 tag. It
+// returns nil without an error when the song has none.
+func Load(path string) (*Lyrics, error) {
+	if lrcPath, ok := sidecar(path); ok {
+		file, err := os.Open(lrcPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading lyrics: %v", err)
+		}
+		defer file.Close()
+		lyrics, err := ParseLRC(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filepath.Base(lrcPath), err)
+		}
+		lyrics.Source = SourceLRC
+		return lyrics, nil
+	}
+
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true, ParseFrames: []string{"SYLT", "USLT"}})
+	if err != nil {
+		return nil, nil
+	}
+	defer tag.Close()
+	return fromTag(tag), nil
+}
+
+// sidecar returns the .lrc file with the same name as the audio file
+func sidecar(path string) (string, bool) {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for _, ext := range []string{".lrc", ".LRC", ".Lrc"} {
+		if info, err := os.Stat(base + ext); err == nil && !info.IsDir() {
+			return base + ext, true
+		}
+	}
+	return "", false
+}
+
+// fromTag reads SYLT lyrics with millisecond timestamps, then USLT ones,
+// which some taggers fill with LRC text
+func fromTag(tag *id3v2.Tag) *Lyrics {
+	for _, frame := range tag.GetFrames("SYLT") {
+		if unknown, ok := frame.(id3v2.UnknownFrame); ok {
+			if lyrics := parseSYLT(unknown.Body); lyrics != nil {
+				return lyrics
+			}
+		}
+	}
+	for _, frame := range tag.GetFrames(tag.CommonID("Unsynchronised lyrics/text transcription")) {
+		uslt, ok := frame.(id3v2.UnsynchronisedLyricsFrame)
+		if !ok || strings.TrimSpace(uslt.Lyrics) == "" {
+			continue
+		}
+		lyrics, err := ParseLRC(strings.NewReader(uslt.Lyrics))
+		if err != nil || len(lyrics.Lines) == 0 {
+			continue
+		}
+		lyrics.Source = SourceUSLT
+		return lyrics
+	}
+	return nil
+}
+
+// ParseLRC reads lyrics in LRC format: lines such as "[01:23.45]text",
+// where a line may carry several timestamps, plus [offset:+/-ms] and
+// ignored [ar:], [ti:] and similar tags. Text without any timestamps is
+// read as unsynced lyrics.
+func ParseLRC(r io.Reader) (*Lyrics, error) {
+	lyrics := &Lyrics{}
+	var plain []Line
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		line = strings.TrimPrefix(line, "\ufeff")
+
+		var times []time.Duration
+		rest := line
+		for strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				break
+			}
+			tag := rest[1:end]
+			if t, ok := parseTimestamp(tag); ok {
+				times = append(times, t)
+			} else if key, value, ok := strings.Cut(tag, ":"); ok && strings.EqualFold(strings.TrimSpace(key), "offset") {
+				if ms, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+					lyrics.Offset = time.Duration(ms) * time.Millisecond
+				}
+			} else if !ok {
+				break
+			}
+			rest = rest[end+1:]
+		}
+
+		text := strings.TrimSpace(rest)
+		if len(times) == 0 {
+			if rest == line {
+				plain = append(plain, Line{Text: text})
+			}
+			continue
+		}
+		for _, t := range times {
+			lyrics.Lines = append(lyrics.Lines, Line{Time: t, Text: text})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lyrics.Lines) == 0 {
+		lyrics.Lines = trimBlank(plain)
+		return lyrics, nil
+	}
+
+	lyrics.Synced = true
+	sort.SliceStable(lyrics.Lines, func(i, j int) bool {
+		return lyrics.Lines[i].Time < lyrics.Lines[j].Time
+	})
+	// A positive offset makes lyrics appear sooner
+	for i := range lyrics.Lines {
+		lyrics.Lines[i].Time -= lyrics.Offset
+	}
+	return lyrics, nil
+}
+
+// parseTimestamp reads mm:ss, mm:ss.xx or mm:ss.xxx
+func parseTimestamp(text string) (time.Duration, bool) {
+	minutes, rest, ok := strings.Cut(text, ":")
+	if !ok {
+		return 0, false
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil || m < 0 {
+		return 0, false
+	}
+	seconds, fraction, _ := strings.Cut(strings.Replace(rest, ":", ".", 1), ".")
+	s, err := strconv.Atoi(seconds)
+	if err != nil || s < 0 || s >= 60 {
+		return 0, false
+	}
+
+	t := time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if fraction != "" {
+		f, err := strconv.Atoi(fraction)
+		if err != nil || f < 0 {
+			return 0, false
+		}
+		// ".5" is half a second, ".05" and ".050" are 50 ms
+		for n := len(fraction); n < 3; n++ {
+			f *= 10
+		}
+		for n := len(fraction); n > 3; n-- {
+			f /= 10
+		}
+		t += time.Duration(f) * time.Millisecond
+	}
+	return t, true
+}
+
+// trimBlank drops blank lines at the start and end
+func trimBlank(lines []Line) []Line {
+	for len(lines) > 0 && lines[0].Text == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1].Text == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// parseSYLT reads a synchronised lyrics frame: encoding, language, time
+// stamp format, content type and a descriptor, then text and time pairs.
+// Only millisecond time stamps are supported; MPEG frame counts depend on
+// the stream and are rare.
+func parseSYLT(body []byte) *Lyrics {
+	if len(body) < 6 || body[4] != 2 {
+		return nil
+	}
+	encoding := body[0]
+	data := body[6:]
+	if _, rest, ok := cutText(data, encoding); ok {
+		data = rest
+	} else {
+		return nil
+	}
+
+	lyrics := &Lyrics{Synced: true, Source: SourceSYLT}
+	for len(data) > 0 {
+		text, rest, ok := cutText(data, encoding)
+		if !ok || len(rest) < 4 {
+			break
+		}
+		ms := binary.BigEndian.Uint32(rest[:4])
+		data = rest[4:]
+		// Many taggers start each line with a newline instead of ending
+		// the previous one
+		text = strings.TrimSpace(text)
+		lyrics.Lines = append(lyrics.Lines, Line{Time: time.Duration(ms) * time.Millisecond, Text: text})
+	}
+	if len(lyrics.Lines) == 0 {
+		return nil
+	}
+	sort.SliceStable(lyrics.Lines, func(i, j int) bool {
+		return lyrics.Lines[i].Time < lyrics.Lines[j].Time
+	})
+	return lyrics
+}
+
+// cutText splits a terminated string in the given ID3v2 encoding off the
+// front of data
+func cutText(data []byte, encoding byte) (string, []byte, bool) {
+	wide := encoding == 1 || encoding == 2
+	if !wide {
+		for i, b := range data {
+			if b == 0 {
+				if encoding == 0 {
+					return library.Latin1(data[:i]), data[i+1:], true
+				}
+				return string(data[:i]), data[i+1:], true
+			}
+		}
+		return "", nil, false
+	}
+
+	for i := 0; i+1 < len(data); i += 2 {
+		if data[i] == 0 && data[i+1] == 0 {
+			return library.DecodeUTF16(data[:i], encoding == 2), data[i+2:], true
+		}
+	}
+	return "", nil, false
+}
+
+// LineAt returns the index of the line being sung at position, shifted by
+// offset, or -1 before the first line. It is always -1 for unsynced
+// lyrics.
+func (l *Lyrics) LineAt(position, offset time.Duration) int {
+	if l == nil || !l.Synced {
+		return -1
+	}
+	position -= offset
+	// The first line that starts after position, minus one
+	return sort.Search(len(l.Lines), func(i int) bool {
+		return l.Lines[i].Time > position
+	}) - 1
+}
@@ -0,0 +1,107 @@
+package lyrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"clispot/internal/persist"
+)
+
+// OffsetStore keeps the lyrics offset of songs whose lyrics drift, keyed
+// by file path in lyrics_offsets.json. Offsets are in milliseconds on
+// disk; a positive offset shows lines later.
+type OffsetStore struct {
+	path    string
+	offsets map[string]int64
+}
+
+func NewOffsetStore(configDir string) *OffsetStore {
+	store := &OffsetStore{
+		path:    filepath.Join(configDir, "lyrics_offsets.json"),
+		offsets: make(map[string]int64),
+	}
+
+	store.Load()
+
+	return store
+}
+
+// Load reads the offsets file, falling back to its backup if it is corrupt
+func (s *OffsetStore) Load() error {
+	data, err := persist.ReadFile(s.path, validOffsets)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error loading lyrics offsets: %v", err)
+	}
+
+	offsets := make(map[string]int64)
+	if err := json.Unmarshal(data, &offsets); err != nil {
+		return fmt.Errorf("error parsing lyrics offsets: %v", err)
+	}
+	s.offsets = offsets
+	return nil
+}
+
+// Get returns the offset of filePath, 0 if none is set
+func (s *OffsetStore) Get(filePath string) time.Duration {
+	return time.Duration(s.offsets[filePath]) * time.Millisecond
+}
+
+// Set stores the offset of filePath, merging it into the file on disk so
+// changes made by another clispot instance in the meantime are kept
+func (s *OffsetStore) Set(filePath string, offset time.Duration) error {
+	return persist.Update(s.path, 0644, validOffsets, func(data []byte) ([]byte, error) {
+		offsets := make(map[string]int64)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &offsets); err != nil {
+				return nil, fmt.Errorf("error parsing lyrics offsets: %v", err)
+			}
+		}
+
+		if offset == 0 {
+			delete(offsets, filePath)
+		} else {
+			offsets[filePath] = offset.Milliseconds()
+		}
+		s.offsets = offsets
+
+		return json.MarshalIndent(offsets, "", "  ")
+	})
+}
+
+// RenamePaths moves the offsets of files that were moved to their new
+// paths; paths maps old paths to new ones. A new path that already has an
+// offset, as a kept duplicate can, keeps its own.
+func (s *OffsetStore) RenamePaths(paths map[string]string) error {
+	return persist.Update(s.path, 0644, validOffsets, func(data []byte) ([]byte, error) {
+		offsets := make(map[string]int64)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &offsets); err != nil {
+				return nil, fmt.Errorf("error parsing lyrics offsets: %v", err)
+			}
+		}
+
+		for from, to := range paths {
+			if offset, ok := offsets[from]; ok {
+				delete(offsets, from)
+				if _, taken := offsets[to]; !taken {
+					offsets[to] = offset
+				}
+			}
+		}
+		s.offsets = offsets
+
+		return json.MarshalIndent(offsets, "", "  ")
+	})
+}
+
+// validOffsets rejects files that do not decode, so Load falls back to the backup
+func validOffsets(data []byte) error {
+	var offsets map[string]int64
+	return json.Unmarshal(data, &offsets)
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package persist
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+func lockHandle(file *os.File, exclusive bool) error {
+	var flags uint32
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	ol := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, ol)
+}
+
+func unlockHandle(file *os.File) {
+	ol := new(windows.Overlapped)
+	windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, ol)
+}
+
+// syncDir is a no-op on Windows, where directories cannot be opened for syncing
+func syncDir(dir string) {}
@@ -0,0 +1,225 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// BackupSuffix is appended to a file name to form the path of its backup copy
+const BackupSuffix = ".bak"
+
+// LockSuffix is appended to a file name to form the path of its lock file
+const LockSuffix = ".lock"
+
+// FileLock is an advisory lock held on the sidecar lock file of a path.
+// The lock lives next to the data file rather than on it, because every
+// write replaces the data file with a new inode.
+type FileLock struct {
+	file *os.File
+}
+
+// Lock takes an exclusive advisory lock for path, blocking until it is available
+func Lock(path string) (*FileLock, error) {
+	return lockFile(path, true)
+}
+
+// RLock takes a shared advisory lock for path, blocking until it is available
+func RLock(path string) (*FileLock, error) {
+	return lockFile(path, false)
+}
+
+func lockFile(path string, exclusive bool) (*FileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating directory: %v", err)
+	}
+
+	file, err := os.OpenFile(path+LockSuffix, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %v", err)
+	}
+
+	if err := lockHandle(file, exclusive); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error locking %s: %v", filepath.Base(path), err)
+	}
+
+	return &FileLock{file: file}, nil
+}
+
+// Unlock releases the lock
+func (l *FileLock) Unlock() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	unlockHandle(l.file)
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// WriteFile replaces path with data atomically. The data is written to a
+// temporary file in the same directory, synced and renamed over path, so a
+// crash leaves either the old or the new contents but never a mix. The
+// previous contents are kept at path+BackupSuffix if valid accepts them, so
+// a corrupt file never replaces a good backup. valid may be nil.
+func WriteFile(path string, data []byte, perm os.FileMode, valid func([]byte) error) error {
+	lock, err := Lock(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	return writeFileLocked(path, data, perm, valid)
+}
+
+// WriteFileLocked is WriteFile for callers that already hold the lock for path
+func WriteFileLocked(path string, data []byte, perm os.FileMode, valid func([]byte) error) error {
+	return writeFileLocked(path, data, perm, valid)
+}
+
+func writeFileLocked(path string, data []byte, perm os.FileMode, valid func([]byte) error) error {
+	if err := backup(path, perm, valid); err != nil {
+		return err
+	}
+	return replace(path, data, perm)
+}
+
+// ReadFile returns the contents of path. If path is missing, unreadable or
+// rejected by valid, the backup copy is tried instead. valid may be nil.
+// The error of the main file is returned when neither copy can be used.
+func ReadFile(path string, valid func([]byte) error) ([]byte, error) {
+	lock, err := RLock(path)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	return ReadFileLocked(path, valid)
+}
+
+// ReadFileLocked is ReadFile for callers that already hold a lock for path
+func ReadFileLocked(path string, valid func([]byte) error) ([]byte, error) {
+	data, mainErr := readValid(path, valid)
+	if mainErr == nil {
+		return data, nil
+	}
+
+	data, err := readValid(path+BackupSuffix, valid)
+	if err == nil {
+		return data, nil
+	}
+
+	return nil, mainErr
+}
+
+// Update reads path, passes its contents to fn and writes the result back
+// while holding the lock, so concurrent writers in other processes do not
+// lose each other's changes. fn receives nil when the file does not exist yet.
+func Update(path string, perm os.FileMode, valid func([]byte) error, fn func([]byte) ([]byte, error)) error {
+	lock, err := Lock(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	current, err := ReadFileLocked(path, valid)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	data, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	return writeFileLocked(path, data, perm, valid)
+}
+
+func readValid(path string, valid func([]byte) error) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if valid != nil {
+		if err := valid(data); err != nil {
+			return nil, fmt.Errorf("corrupt file %s: %v", filepath.Base(path), err)
+		}
+	}
+	return data, nil
+}
+
+// backup copies the current contents of path to its backup file. A missing
+// or empty path is not an error, and neither is one valid rejects; there is
+// simply nothing worth keeping, and the backup that is there may be.
+func backup(path string, perm os.FileMode, valid func([]byte) error) error {
+	src, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error opening %s for backup: %v", filepath.Base(path), err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("error reading %s for backup: %v", filepath.Base(path), err)
+	}
+	if valid != nil && valid(data) != nil {
+		return nil
+	}
+
+	return replace(path+BackupSuffix, data, perm)
+}
+
+// replace writes data to a temporary file, syncs it and renames it over path
+func replace(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("error creating temp file: %v", err)
+	}
+	tmpPath := tmp.Name()
+
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmpPath)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		cleanup()
+		return fmt.Errorf("error writing %s: %v", filepath.Base(path), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		cleanup()
+		return fmt.Errorf("error syncing %s: %v", filepath.Base(path), err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		cleanup()
+		return fmt.Errorf("error setting permissions on %s: %v", filepath.Base(path), err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error closing %s: %v", filepath.Base(path), err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error replacing %s: %v", filepath.Base(path), err)
+	}
+
+	syncDir(dir)
+	return nil
+}
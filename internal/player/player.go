@@ -2,18 +2,24 @@ package player
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"github.com/hajimehoshi/go-mp3"
 	"github.com/hajimehoshi/oto/v2"
 	"clispot/internal/settings"
+	"clispot/internal/stream"
 )
 
 
 type Player struct {
 	context     *oto.Context
 	player      oto.Player
+	file        *os.File
+	reader      *sectionReader
+	radio       *streamSource
+	sampleRate  int
 	isPlaying   bool
 	isPaused    bool
 	currentSong string
@@ -21,8 +27,18 @@ type Player struct {
 	position    time.Duration
 	duration    time.Duration
 	startTime   time.Time
+	
+	// section is the part of the file the current song covers
+	sections     SectionFunc
+	section      Section
+	fileDuration time.Duration
 	pausedTime  time.Duration
 	repeatMode  settings.RepeatMode
+	
+	// Listening time is tracked separately from position so seeks don't count
+	listened    time.Duration
+	listenStart time.Time
+	listeners   []func(PlaybackEvent)
 }
 
 
@@ -37,6 +53,29 @@ type PlaybackState struct {
 }
 
 
+// EventType distinguishes the playback transitions reported to listeners
+type EventType int
+
+const (
+	EventStart EventType = iota
+	EventEnd
+)
+
+// PlaybackEvent is sent to listeners when a song starts or stops playing.
+// Listened and Finished are only meaningful for EventEnd.
+type PlaybackEvent struct {
+	Type     EventType
+	Song     string
+	Time     time.Time
+	Position time.Duration
+	Duration time.Duration
+	Listened time.Duration
+	Finished bool
+	// Stream is set for internet radio, which has no length and no tags
+	Stream   bool
+}
+
+
 func NewPlayer() *Player {
 	
 	ctx, ready, err := oto.NewContext(44100, 2, 2)
@@ -56,11 +95,72 @@ func NewPlayer() *Player {
 
 
 func (p *Player) Play(filePath string) error {
+	// The next track of a CUE sheet plays on from the one ending
+	if section := p.sectionOf(filePath); p.follows(section) && p.isPlaying && !p.isPaused &&
+		time.Since(p.startTime) >= p.duration-time.Second {
+		p.playOn(filePath, section)
+		return nil
+	}
+
+	if err := p.open(filePath); err != nil {
+		return err
+	}
+
+	p.start(filePath)
+	return nil
+}
+
+
+// start plays the song just opened from its beginning
+func (p *Player) start(filePath string) {
+	p.player.Play()
+	p.listenStart = p.startTime
+
+	p.emit(PlaybackEvent{
+		Type:     EventStart,
+		Song:     filePath,
+		Time:     p.startTime,
+		Duration: p.duration,
+		Stream:   p.radio != nil,
+	})
+}
+
+
+// Load opens filePath paused at position, so a later Resume continues from
+// there. It is used to restore the previous session on startup.
+func (p *Player) Load(filePath string, position time.Duration) error {
+	if err := p.open(filePath); err != nil {
+		return err
+	}
+	p.isPaused = true
+
+	p.emit(PlaybackEvent{
+		Type:     EventStart,
+		Song:     filePath,
+		Time:     p.startTime,
+		Duration: p.duration,
+		Stream:   p.radio != nil,
+	})
+
+	if position > 0 {
+		return p.Seek(position)
+	}
+	return nil
+}
+
+
+// open stops the current song and prepares filePath for playback without
+// starting it. A song that is part of a larger file plays only its section;
+// an http or https URL is played as internet radio.
+func (p *Player) open(filePath string) error {
 	
 	p.Stop()
+	if stream.IsURL(filePath) {
+		return p.openStream(filePath)
+	}
 
-	
-	file, err := os.Open(filePath)
+	section := p.sectionOf(filePath)
+	file, err := os.Open(section.File)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
@@ -77,14 +177,23 @@ func (p *Player) Play(filePath string) error {
 	sampleRate := decoder.SampleRate()
 	length := decoder.Length()
 	if length > 0 && sampleRate > 0 {
-		p.duration = time.Duration(length/int64(sampleRate)/4) * time.Second 
+		p.fileDuration = time.Duration(length/int64(sampleRate)/4) * time.Second 
 	} else {
-		p.duration = 0 
+		p.fileDuration = 0 
+	}
+	p.sampleRate = sampleRate
+
+	p.duration = p.fileDuration
+	if section.End > 0 {
+		p.duration = section.End
+	}
+	if section.Start > 0 {
+		p.duration = max(p.duration-section.Start, 0)
 	}
 
 	
 	file.Close()
-	file, err = os.Open(filePath)
+	file, err = os.Open(section.File)
 	if err != nil {
 		return fmt.Errorf("failed to reopen file: %v", err)
 	}
@@ -97,17 +206,68 @@ func (p *Player) Play(filePath string) error {
 	}
 
 	
-	p.player = p.context.NewPlayer(decoder)
-	p.currentSong = filePath
+	reader, err := newSectionReader(decoder, p.offsetOf(section.Start), p.offsetOf(section.End))
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to seek to the start of the track: %v", err)
+	}
+	
+	p.file = file
+	p.reader = reader
+	p.section = section
+	p.begin(filePath, reader)
+
+	return nil
+}
+
+
+// begin makes src the audio of song, ready to start from its beginning
+func (p *Player) begin(song string, src io.Reader) {
+	p.player = p.context.NewPlayer(src)
+	p.player.SetVolume(p.volume)
+	p.currentSong = song
 	p.isPlaying = true
 	p.isPaused = false
 	p.startTime = time.Now()
+	p.position = 0
 	p.pausedTime = 0
+	p.listened = 0
+	p.listenStart = time.Time{}
+}
 
-	
-	p.player.Play()
 
-	return nil
+// AddListener registers fn to be called on every start and end transition.
+// Listeners run synchronously on the goroutine that drives the player.
+func (p *Player) AddListener(fn func(PlaybackEvent)) {
+	p.listeners = append(p.listeners, fn)
+}
+
+
+func (p *Player) emit(event PlaybackEvent) {
+	for _, fn := range p.listeners {
+		fn(event)
+	}
+}
+
+
+// stopListening adds the time since playback last started to the listened total
+func (p *Player) stopListening() {
+	if !p.listenStart.IsZero() {
+		p.listened += time.Since(p.listenStart)
+		p.listenStart = time.Time{}
+	}
+}
+
+
+// hasFinished reports whether the loaded song played through to its end
+func (p *Player) hasFinished() bool {
+	if p.player == nil {
+		return false
+	}
+	if p.duration > 0 && p.position >= p.duration-time.Second {
+		return true
+	}
+	return p.isPlaying && !p.isPaused && !p.player.IsPlaying()
 }
 
 
@@ -117,6 +277,7 @@ func (p *Player) Pause() {
 		p.isPaused = true
 		
 		p.updatePosition()
+		p.stopListening()
 	}
 }
 
@@ -127,15 +288,39 @@ func (p *Player) Resume() {
 		p.isPaused = false
 		
 		p.startTime = time.Now().Add(-p.position)
+		p.listenStart = time.Now()
 	}
 }
 
 // Stop stops the current playback completely (original behavior)
 func (p *Player) Stop() {
+	if p.currentSong != "" {
+		p.updatePosition()
+		p.stopListening()
+		p.emit(PlaybackEvent{
+			Type:     EventEnd,
+			Song:     p.currentSong,
+			Time:     time.Now(),
+			Position: p.position,
+			Duration: p.duration,
+			Listened: p.listened,
+			Finished: p.hasFinished(),
+			Stream:   p.radio != nil,
+		})
+	}
 	if p.player != nil {
 		p.player.Close()
 		p.player = nil
 	}
+	if p.file != nil {
+		p.file.Close()
+		p.file = nil
+	}
+	if p.radio != nil {
+		p.radio.Close()
+		p.radio = nil
+	}
+	p.reader = nil
 	p.isPlaying = false
 	p.isPaused = false
 	p.currentSong = ""
@@ -148,6 +333,7 @@ func (p *Player) PauseAndStop() {
 	if p.player != nil && p.isPlaying {
 		// Update position before pausing
 		p.updatePosition()
+		p.stopListening()
 		p.player.Pause()
 		p.isPaused = true
 		p.isPlaying = false // Show as stopped but keep the song loaded
@@ -162,6 +348,7 @@ func (p *Player) ResumeFromStop() {
 		p.isPaused = false
 		// Adjust start time to account for the current position
 		p.startTime = time.Now().Add(-p.position)
+		p.listenStart = time.Now()
 	}
 }
 
@@ -243,9 +430,12 @@ func (p *Player) ShouldRepeatPlaylist() bool {
 
 
 func (p *Player) Seek(position time.Duration) error {
-	if p.player == nil || !p.isPlaying {
+	if p.player == nil {
 		return fmt.Errorf("no track currently playing")
 	}
+	if p.radio != nil {
+		return fmt.Errorf("can't seek in a live stream")
+	}
 	
 	if position < 0 {
 		position = 0
@@ -253,7 +443,13 @@ func (p *Player) Seek(position time.Duration) error {
 		position = p.duration
 	}
 	
-	
+	// The decoder produces 16-bit stereo PCM, so one sample is 4 bytes
+	if seeker, ok := p.player.(io.Seeker); ok && p.sampleRate > 0 {
+		offset := int64(position.Seconds()*float64(p.sampleRate)) * 4
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek: %v", err)
+		}
+	}
 	
 	p.position = position
 	p.startTime = time.Now().Add(-position)
@@ -265,7 +461,8 @@ func (p *Player) Seek(position time.Duration) error {
 func (p *Player) updatePosition() {
 	if p.isPlaying && !p.isPaused {
 		p.position = time.Since(p.startTime)
-		if p.position > p.duration {
+		// Streams have no length; their position is the time listened
+		if p.duration > 0 && p.position > p.duration {
 			p.position = p.duration
 		}
 	}
@@ -294,6 +491,14 @@ func (p *Player) IsFinished() bool {
 	if p.player == nil {
 		return true
 	}
+	// A paused player is not playing either, but it has not finished
+	if p.isPaused {
+		return false
+	}
+	// A section that plays on into the next one never stops the audio
+	if p.section.End > 0 && p.isPlaying && time.Since(p.startTime) >= p.duration {
+		return true
+	}
 	return !p.player.IsPlaying()
 }
 
@@ -0,0 +1,211 @@
+package player
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"clispot/internal/library"
+)
+
+// Section is the part of an audio file a song covers, as the library
+// works it out from CUE sheets
+type Section = library.Section
+
+// SectionFunc tells the player which part of which file a song plays.
+// ok is false for songs that are whole files of their own.
+// Library.Section is one.
+type SectionFunc func(song string) (section Section, ok bool)
+
+// bytesPerSample is the size of one frame of the decoder's 16-bit stereo PCM
+const bytesPerSample = 4
+
+// sectionReader reads one section of a decoded file, ending at the end of
+// the section unless the player has lined up the section that follows it
+// in the same file. Offsets passed to Seek are relative to the start of
+// the section.
+type sectionReader struct {
+	mu  sync.Mutex
+	src io.ReadSeeker
+
+	// start, end and pos are byte offsets into the decoded stream; end is
+	// -1 for a section that runs to the end of the file
+	start int64
+	end   int64
+	pos   int64
+
+	// follow is the end of the next section, read straight on into once
+	// end is reached so contiguous tracks play without a gap
+	follow    int64
+	hasFollow bool
+}
+
+func newSectionReader(src io.ReadSeeker, start, end int64) (*sectionReader, error) {
+	if start > 0 {
+		if _, err := src.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return &sectionReader{src: src, start: start, end: end, pos: start}, nil
+}
+
+func (r *sectionReader) Read(buf []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.end >= 0 && r.pos >= r.end {
+		if !r.hasFollow {
+			return 0, io.EOF
+		}
+		r.end, r.hasFollow = r.follow, false
+	}
+	if r.end >= 0 && int64(len(buf)) > r.end-r.pos {
+		buf = buf[:r.end-r.pos]
+	}
+	n, err := r.src.Read(buf)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *sectionReader) Seek(offset int64, whence int) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+		offset += r.start
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		if r.end >= 0 {
+			offset += r.end
+			break
+		}
+		end, err := r.src.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, err
+		}
+		offset += end
+	}
+	if offset < r.start {
+		offset = r.start
+	}
+	pos, err := r.src.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	r.pos = pos
+	return pos - r.start, nil
+}
+
+// setFollow lines up a section ending at end to be read straight after
+// this one; ok false cancels it
+func (r *sectionReader) setFollow(end int64, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.follow, r.hasFollow = end, ok
+}
+
+// advance makes the section from start to end, which follows this one,
+// the one being read
+func (r *sectionReader) advance(start, end int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start = start
+	r.end = end
+	r.hasFollow = false
+}
+
+// sectionOf returns the section song plays, the whole file when it is not
+// part of a shared one
+func (p *Player) sectionOf(song string) Section {
+	if p.sections != nil {
+		if section, ok := p.sections(song); ok {
+			return section
+		}
+	}
+	return Section{File: song}
+}
+
+// offsetOf converts a time in the file to a byte offset in the decoded
+// stream, -1 for a zero end time
+func (p *Player) offsetOf(t time.Duration) int64 {
+	if t <= 0 {
+		return -1
+	}
+	return int64(t.Seconds()*float64(p.sampleRate)) * bytesPerSample
+}
+
+// follows reports whether section starts where the playing one ends in
+// the same file, so it can be played on without reopening the file
+func (p *Player) follows(section Section) bool {
+	return p.reader != nil && p.section.End > 0 &&
+		section.File == p.section.File && section.Start == p.section.End
+}
+
+// SetSections sets how the player finds the part of a file a song covers
+func (p *Player) SetSections(fn SectionFunc) {
+	p.sections = fn
+}
+
+// SetNext tells the player which song comes after the current one. When
+// it is the next track in the same file the player reads on into it
+// without a gap; Play then switches to it without reopening the file.
+func (p *Player) SetNext(song string) {
+	if p.reader == nil {
+		return
+	}
+	if song == "" {
+		p.reader.setFollow(0, false)
+		return
+	}
+	section := p.sectionOf(song)
+	p.reader.setFollow(p.offsetOf(section.End), p.follows(section))
+}
+
+// playOn switches to song, whose section follows the current one in the
+// same file, without stopping the audio
+func (p *Player) playOn(song string, section Section) {
+	p.updatePosition()
+	p.stopListening()
+	p.emit(PlaybackEvent{
+		Type:     EventEnd,
+		Song:     p.currentSong,
+		Time:     time.Now(),
+		Position: p.duration,
+		Duration: p.duration,
+		Listened: p.listened,
+		Finished: true,
+	})
+
+	elapsed := time.Since(p.startTime) - p.duration
+	p.reader.advance(p.offsetOf(section.Start), p.offsetOf(section.End))
+	if !p.player.IsPlaying() {
+		// The previous section ran out before the switch; start the
+		// next one from its beginning
+		if seeker, ok := p.player.(io.Seeker); ok {
+			seeker.Seek(0, io.SeekStart)
+		}
+		p.player.Play()
+		elapsed = 0
+	}
+
+	end := section.End
+	if end <= 0 {
+		end = p.fileDuration
+	}
+	p.section = section
+	p.duration = end - section.Start
+	p.currentSong = song
+	p.startTime = time.Now().Add(-elapsed)
+	p.position = elapsed
+	p.listened = 0
+	p.listenStart = time.Now()
+
+	p.emit(PlaybackEvent{
+		Type:     EventStart,
+		Song:     song,
+		Time:     time.Now(),
+		Duration: p.duration,
+	})
+}
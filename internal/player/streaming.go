@@ -0,0 +1,241 @@
+package player
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/go-mp3"
+
+	"clispot/internal/stream"
+)
+
+const (
+	// streamPCMSeconds is how much decoded audio is kept ready for the
+	// audio device
+	streamPCMSeconds = 4
+	// streamPrebuffer is how much decoded audio must be ready before a
+	// stream starts, or restarts after the network fell behind
+	streamPrebuffer = 44100 * bytesPerSample
+	// streamConnectTimeout bounds the wait for the first frame of audio
+	streamConnectTimeout = 20 * time.Second
+)
+
+// streamSource feeds an internet radio stream to the audio device. The
+// device reads its source with a lock held and fills its whole buffer in
+// one go, so the source must never block on the network: the MP3 decoder
+// runs in a goroutine of its own into a buffer of PCM, and Read plays
+// silence while that buffer is empty.
+type streamSource struct {
+	stream     *stream.Stream
+	pcm        *stream.Ring
+	sampleRate int
+
+	mu        sync.Mutex
+	buffering bool
+	err       error
+}
+
+// newStreamSource connects to url and starts decoding it
+func newStreamSource(url string) (*streamSource, error) {
+	s, err := stream.Open(url, stream.Options{})
+	if err != nil {
+		return nil, err
+	}
+
+	// The decoder reads the first frame as it is created, which waits on
+	// the network
+	type result struct {
+		decoder *mp3.Decoder
+		err     error
+	}
+	ready := make(chan result, 1)
+	go func() {
+		decoder, err := mp3.NewDecoder(s)
+		ready <- result{decoder, err}
+	}()
+
+	var r result
+	select {
+	case r = <-ready:
+	case <-time.After(streamConnectTimeout):
+		r.err = fmt.Errorf("timed out waiting for audio")
+	}
+	if r.err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to decode stream: %v", r.err)
+	}
+
+	src := &streamSource{
+		stream:     s,
+		pcm:        stream.NewRing(streamPCMSeconds * r.decoder.SampleRate() * bytesPerSample),
+		sampleRate: r.decoder.SampleRate(),
+		buffering:  true,
+	}
+	go src.decode(r.decoder)
+	return src, nil
+}
+
+// decode fills the PCM buffer until the stream ends. A frame the decoder
+// can't read, usually where a reconnect joined the stream mid-frame, gets
+// a fresh decoder that finds the next frame.
+func (s *streamSource) decode(decoder *mp3.Decoder) {
+	buf := make([]byte, 8192)
+	for {
+		n, err := io.ReadFull(decoder, buf)
+		// Whole samples only, so the device never gets out of step
+		if _, werr := s.pcm.Write(buf[:n/bytesPerSample*bytesPerSample]); werr != nil {
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		if errors.Is(err, stream.ErrClosed) {
+			return
+		}
+		if streamErr := s.stream.Err(); streamErr != nil {
+			s.end(streamErr)
+			return
+		}
+		if decoder, err = mp3.NewDecoder(s.stream); err != nil {
+			s.end(fmt.Errorf("failed to decode stream: %v", err))
+			return
+		}
+	}
+}
+
+// end stops the source once the buffered audio has played, for err
+func (s *streamSource) end(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	s.pcm.CloseWithError(err)
+}
+
+func (s *streamSource) Read(buf []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf = buf[:len(buf)/bytesPerSample*bytesPerSample]
+	if s.buffering && s.pcm.Len() < streamPrebuffer && !s.pcm.Closed() {
+		return silence(buf), nil
+	}
+	s.buffering = false
+
+	n, err := s.pcm.ReadAvailable(buf)
+	if n == 0 && err == nil {
+		// The network fell behind; wait for a second of audio again
+		s.buffering = true
+		return silence(buf), nil
+	}
+	return n, err
+}
+
+// silence fills a short part of buf with silence, so the device keeps
+// going without getting far ahead of the stream
+func silence(buf []byte) int {
+	n := min(len(buf), 4096)
+	clear(buf[:n])
+	return n
+}
+
+// isBuffering reports whether the source is waiting on the network
+func (s *streamSource) isBuffering() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buffering
+}
+
+// error returns why the source ended, nil while it plays
+func (s *streamSource) error() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *streamSource) Close() error {
+	s.pcm.CloseWithError(stream.ErrClosed)
+	return s.stream.Close()
+}
+
+// StreamConnection is an internet radio stream that is connected and
+// decoding but not yet played
+type StreamConnection struct {
+	url string
+	src *streamSource
+}
+
+// ConnectStream connects to the stream at url and waits for its first
+// frame of audio, which can take as long as the network does. It leaves
+// the player alone, so unlike the other methods it may run on any
+// goroutine; PlayStream then plays the connection.
+func (p *Player) ConnectStream(url string) (*StreamConnection, error) {
+	src, err := newStreamSource(url)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamConnection{url: url, src: src}, nil
+}
+
+// Close disconnects a stream that is not going to be played
+func (c *StreamConnection) Close() error {
+	return c.src.Close()
+}
+
+// PlayStream stops the current song and plays a stream from ConnectStream
+func (p *Player) PlayStream(c *StreamConnection) error {
+	p.Stop()
+	p.useStream(c.url, c.src)
+	p.start(c.url)
+	return nil
+}
+
+// openStream prepares the stream at url for playback without starting it
+func (p *Player) openStream(url string) error {
+	src, err := newStreamSource(url)
+	if err != nil {
+		return err
+	}
+	p.useStream(url, src)
+	return nil
+}
+
+// useStream makes src, connected to url, the audio to play
+func (p *Player) useStream(url string, src *streamSource) {
+	p.sampleRate = src.sampleRate
+	p.fileDuration = 0
+	p.duration = 0
+	p.section = Section{File: url}
+	p.radio = src
+	p.begin(url, src)
+}
+
+// IsStream reports whether an internet radio stream is loaded
+func (p *Player) IsStream() bool {
+	return p.radio != nil
+}
+
+// StreamInfo returns the station details and current title of the loaded
+// stream; ok is false when no stream is loaded
+func (p *Player) StreamInfo() (info stream.Info, ok bool) {
+	if p.radio == nil {
+		return stream.Info{}, false
+	}
+	return p.radio.stream.Info(), true
+}
+
+// IsBuffering reports whether the loaded stream is waiting for the network
+func (p *Player) IsBuffering() bool {
+	return p.radio != nil && p.radio.isBuffering()
+}
+
+// StreamError returns why the loaded stream stopped, nil while it plays
+func (p *Player) StreamError() error {
+	if p.radio == nil {
+		return nil
+	}
+	return p.radio.error()
+}
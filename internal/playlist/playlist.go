@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"clispot/internal/library"
+	"clispot/internal/persist"
 )
 
 
@@ -30,10 +32,54 @@ func NewManager(playlistDir string) *Manager {
 	
 	os.MkdirAll(playlistDir, 0755)
 	
-	return &Manager{
+	manager := &Manager{
 		playlists:   make([]Playlist, 0),
 		playlistDir: playlistDir,
 	}
+	
+	
+	manager.Load()
+	
+	return manager
+}
+
+
+// Load reads every playlist file in the playlist directory, falling back to
+// the backup copy of any playlist whose file is corrupt
+func (m *Manager) Load() error {
+	entries, err := os.ReadDir(m.playlistDir)
+	if err != nil {
+		return fmt.Errorf("error reading playlist directory: %v", err)
+	}
+	
+	playlists := make([]Playlist, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+		
+		data, err := persist.ReadFile(filepath.Join(m.playlistDir, name), validPlaylist)
+		if err != nil {
+			continue
+		}
+		
+		var playlist Playlist
+		if err := json.Unmarshal(data, &playlist); err != nil {
+			continue
+		}
+		playlists = append(playlists, playlist)
+	}
+	
+	m.playlists = playlists
+	return nil
+}
+
+
+// validPlaylist rejects files that do not decode, so Load falls back to the backup
+func validPlaylist(data []byte) error {
+	var playlist Playlist
+	return json.Unmarshal(data, &playlist)
 }
 
 
@@ -53,13 +99,18 @@ func (m *Manager) CreatePlaylist(name, description string) (*Playlist, error) {
 		UpdatedAt:   time.Now(),
 	}
 	
-	m.playlists = append(m.playlists, playlist)
 	
-	
-	if err := m.savePlaylist(&playlist); err != nil {
+	err := persist.Update(m.getPlaylistFileName(name), 0644, validPlaylist, func(data []byte) ([]byte, error) {
+		if len(data) > 0 {
+			return nil, fmt.Errorf("playlist '%s' already exists", name)
+		}
+		return marshalPlaylist(&playlist)
+	})
+	if err != nil {
 		return nil, fmt.Errorf("error saving playlist: %v", err)
 	}
 	
+	m.playlists = append(m.playlists, playlist)
 	return &playlist, nil
 }
 
@@ -67,17 +118,17 @@ func (m *Manager) CreatePlaylist(name, description string) (*Playlist, error) {
 func (m *Manager) AddSongToPlaylist(playlistName string, song library.Song) error {
 	for i := range m.playlists {
 		if m.playlists[i].Name == playlistName {
-			
-			for _, existingSong := range m.playlists[i].Songs {
-				if existingSong.FilePath == song.FilePath {
-					return fmt.Errorf("song already exists in playlist")
+			return m.updatePlaylist(i, func(playlist *Playlist) error {
+				for _, existingSong := range playlist.Songs {
+					if existingSong.FilePath == song.FilePath {
+						return fmt.Errorf("song already exists in playlist")
+					}
 				}
-			}
-			
-			m.playlists[i].Songs = append(m.playlists[i].Songs, song)
-			m.playlists[i].UpdatedAt = time.Now()
-			
-			return m.savePlaylist(&m.playlists[i])
+				
+				playlist.Songs = append(playlist.Songs, song)
+				playlist.UpdatedAt = time.Now()
+				return nil
+			})
 		}
 	}
 	
@@ -85,6 +136,73 @@ func (m *Manager) AddSongToPlaylist(playlistName string, song library.Song) erro
 }
 
 
+// UpdateSong replaces the stored copy of song in every playlist holding it,
+// so edited tags show up in playlists too
+func (m *Manager) UpdateSong(song library.Song) error {
+	for i := range m.playlists {
+		if !holds(m.playlists[i], func(path string) bool { return path == song.FilePath }) {
+			continue
+		}
+		
+		err := m.updatePlaylist(i, func(playlist *Playlist) error {
+			for j := range playlist.Songs {
+				if playlist.Songs[j].FilePath == song.FilePath {
+					playlist.Songs[j] = song
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+
+// RenamePaths points songs at their new location after files were moved;
+// paths maps old paths to new ones. It returns how many entries changed.
+func (m *Manager) RenamePaths(paths map[string]string) (int, error) {
+	renamed := 0
+	for i := range m.playlists {
+		moved := holds(m.playlists[i], func(path string) bool {
+			_, ok := paths[path]
+			return ok
+		})
+		if !moved {
+			continue
+		}
+		
+		err := m.updatePlaylist(i, func(playlist *Playlist) error {
+			for j := range playlist.Songs {
+				if to, ok := paths[playlist.Songs[j].FilePath]; ok {
+					playlist.Songs[j].FilePath = to
+					renamed++
+				}
+			}
+			playlist.UpdatedAt = time.Now()
+			return nil
+		})
+		if err != nil {
+			return renamed, err
+		}
+	}
+	
+	return renamed, nil
+}
+
+
+// holds reports whether any song in playlist has a path match accepts
+func holds(playlist Playlist, match func(string) bool) bool {
+	for _, song := range playlist.Songs {
+		if match(song.FilePath) {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Manager) GetPlaylist(name string) (*Playlist, error) {
 	for i := range m.playlists {
 		if m.playlists[i].Name == name {
@@ -101,15 +219,38 @@ func (m *Manager) GetAllPlaylists() []Playlist {
 }
 
 
-func (m *Manager) savePlaylist(playlist *Playlist) error {
-	filename := m.getPlaylistFileName(playlist.Name)
+// updatePlaylist applies change to the copy of playlist i on disk, read
+// under its lock, so songs another clispot instance added are kept, and
+// stores the result in place of the copy in memory
+func (m *Manager) updatePlaylist(i int, change func(*Playlist) error) error {
+	filename := m.getPlaylistFileName(m.playlists[i].Name)
 	
+	return persist.Update(filename, 0644, validPlaylist, func(data []byte) ([]byte, error) {
+		playlist := m.playlists[i]
+		playlist.Songs = append([]library.Song(nil), playlist.Songs...)
+		if len(data) > 0 {
+			playlist = Playlist{}
+			if err := json.Unmarshal(data, &playlist); err != nil {
+				return nil, fmt.Errorf("error parsing playlist: %v", err)
+			}
+		}
+		
+		if err := change(&playlist); err != nil {
+			return nil, err
+		}
+		m.playlists[i] = playlist
+		
+		return marshalPlaylist(&playlist)
+	})
+}
+
+
+func marshalPlaylist(playlist *Playlist) ([]byte, error) {
 	data, err := json.MarshalIndent(playlist, "", "  ")
 	if err != nil {
-		return fmt.Errorf("error marshaling playlist: %v", err)
+		return nil, fmt.Errorf("error marshaling playlist: %v", err)
 	}
-	
-	return os.WriteFile(filename, data, 0644)
+	return data, nil
 }
 
 
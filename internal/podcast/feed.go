@@ -0,0 +1,420 @@
+package podcast
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Feed is what a podcast's RSS or Atom feed says about the show
+type Feed struct {
+	Title       string `json:"title"`
+	Author      string `json:"author,omitempty"`
+	Description string `json:"description,omitempty"`
+	Link        string `json:"link,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	// Episodes are newest first
+	Episodes []Episode `json:"episodes"`
+}
+
+// Episode is one item of a feed, along with what clispot keeps about it
+type Episode struct {
+	// GUID identifies the episode across refreshes; feeds without one use
+	// the enclosure URL
+	GUID        string        `json:"guid"`
+	Title       string        `json:"title"`
+	Description string        `json:"description,omitempty"`
+	Published   time.Time     `json:"published"`
+	Duration    time.Duration `json:"duration,omitempty"`
+	Season      int           `json:"season,omitempty"`
+	Number      int           `json:"number,omitempty"`
+	ImageURL    string        `json:"image_url,omitempty"`
+	// URL, Type and Length come from the enclosure
+	URL    string `json:"url"`
+	Type   string `json:"type,omitempty"`
+	Length int64  `json:"length,omitempty"`
+
+	// File is where the episode was downloaded, empty until it is
+	File string `json:"file,omitempty"`
+	// Played is set once the episode was heard to the end or marked played
+	Played bool `json:"played,omitempty"`
+	// Position is where listening was left off
+	Position time.Duration `json:"position,omitempty"`
+}
+
+// Downloaded reports whether the episode has been downloaded
+func (e Episode) Downloaded() bool {
+	return e.File != ""
+}
+
+// Playable reports whether the episode's enclosure looks like MP3 audio.
+// Enclosures that give neither a type nor a known extension are given the
+// benefit of the doubt.
+func (e Episode) Playable() bool {
+	if mediaType, _, err := mime.ParseMediaType(e.Type); err == nil {
+		switch mediaType {
+		case "audio/mpeg", "audio/mp3", "audio/mpeg3", "audio/x-mpeg", "audio/x-mp3":
+			return true
+		case "application/octet-stream", "binary/octet-stream":
+		default:
+			return false
+		}
+	}
+	switch strings.ToLower(path.Ext(urlPath(e.URL))) {
+	case ".mp3", "":
+		return true
+	}
+	return false
+}
+
+// ParseFeed reads an RSS 2.0 or Atom feed. Items without an audio
+// enclosure are left out, since there is nothing to play.
+func ParseFeed(r io.Reader) (*Feed, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading feed: %v", err)
+	}
+
+	root, err := rootElement(data)
+	if err != nil {
+		return nil, err
+	}
+	var feed *Feed
+	switch root {
+	case "rss":
+		var doc rssDocument
+		if err := decodeXML(data, &doc); err != nil {
+			return nil, err
+		}
+		feed = doc.feed()
+	case "feed":
+		var doc atomFeed
+		if err := decodeXML(data, &doc); err != nil {
+			return nil, err
+		}
+		feed = doc.feed()
+	default:
+		return nil, fmt.Errorf("not an RSS or Atom feed (root element <%s>)", root)
+	}
+
+	feed.Episodes = uniqueEpisodes(feed.Episodes)
+	sort.SliceStable(feed.Episodes, func(i, j int) bool {
+		return feed.Episodes[i].Published.After(feed.Episodes[j].Published)
+	})
+	if feed.Title == "" {
+		feed.Title = "Untitled podcast"
+	}
+	return feed, nil
+}
+
+// rootElement returns the local name of the document's first element
+func rootElement(data []byte) (string, error) {
+	decoder := newDecoder(data)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("error parsing feed: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func decodeXML(data []byte, v interface{}) error {
+	if err := newDecoder(data).Decode(v); err != nil {
+		return fmt.Errorf("error parsing feed: %v", err)
+	}
+	return nil
+}
+
+// newDecoder returns a lenient decoder that understands the encodings a
+// feed may declare, such as ISO-8859-1 or windows-1252
+func newDecoder(data []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported feed encoding %q", label)
+		}
+		return enc.NewDecoder().Reader(input), nil
+	}
+	return decoder
+}
+
+// uniqueEpisodes drops items without an enclosure and repeated GUIDs,
+// keeping the first of each
+func uniqueEpisodes(episodes []Episode) []Episode {
+	seen := make(map[string]bool)
+	var unique []Episode
+	for _, episode := range episodes {
+		if episode.URL == "" {
+			continue
+		}
+		if episode.GUID == "" {
+			episode.GUID = episode.URL
+		}
+		if seen[episode.GUID] {
+			continue
+		}
+		seen[episode.GUID] = true
+		if episode.Title == "" {
+			episode.Title = path.Base(urlPath(episode.URL))
+		}
+		unique = append(unique, episode)
+	}
+	return unique
+}
+
+// The feed structs below match Apple's podcast tags by their namespace,
+// http://www.itunes.com/dtds/podcast-1.0.dtd, which most feeds use for
+// artwork, episode numbers and durations.
+
+type rssDocument struct {
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title        string    `xml:"title"`
+	Link         string    `xml:"link"`
+	Description  string    `xml:"description"`
+	Author       string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	ITunesImage  itunesRef `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Image        rssImage  `xml:"image"`
+	ManagingEdit string    `xml:"managingEditor"`
+	Items        []rssItem `xml:"item"`
+}
+
+type rssImage struct {
+	URL string `xml:"url"`
+}
+
+type itunesRef struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	GUID        string       `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Description string       `xml:"description"`
+	Summary     string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Duration    string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Season      string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	Number      string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	Image       itunesRef    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+func (d rssDocument) feed() *Feed {
+	channel := d.Channel
+	feed := &Feed{
+		Title:       strings.TrimSpace(channel.Title),
+		Author:      strings.TrimSpace(firstNonEmpty(channel.Author, channel.ManagingEdit)),
+		Description: strings.TrimSpace(channel.Description),
+		Link:        strings.TrimSpace(channel.Link),
+		ImageURL:    strings.TrimSpace(firstNonEmpty(channel.ITunesImage.Href, channel.Image.URL)),
+	}
+	for _, item := range channel.Items {
+		length, _ := strconv.ParseInt(strings.TrimSpace(item.Enclosure.Length), 10, 64)
+		season, _ := strconv.Atoi(strings.TrimSpace(item.Season))
+		number, _ := strconv.Atoi(strings.TrimSpace(item.Number))
+		feed.Episodes = append(feed.Episodes, Episode{
+			GUID:        strings.TrimSpace(item.GUID),
+			Title:       strings.TrimSpace(item.Title),
+			Description: strings.TrimSpace(firstNonEmpty(item.Description, item.Summary)),
+			Published:   parseDate(item.PubDate),
+			Duration:    ParseDuration(item.Duration),
+			Season:      season,
+			Number:      number,
+			ImageURL:    strings.TrimSpace(item.Image.Href),
+			URL:         strings.TrimSpace(item.Enclosure.URL),
+			Type:        strings.TrimSpace(item.Enclosure.Type),
+			Length:      length,
+		})
+	}
+	return feed
+}
+
+type atomFeed struct {
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	Author   atomPerson  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Logo     string      `xml:"logo"`
+	Icon     string      `xml:"icon"`
+	Image    itunesRef   `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   string     `xml:"summary"`
+	Content   string     `xml:"content"`
+	Links     []atomLink `xml:"link"`
+	Duration  string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Season    string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	Number    string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	Image     itunesRef  `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+}
+
+func (d atomFeed) feed() *Feed {
+	feed := &Feed{
+		Title:       strings.TrimSpace(d.Title),
+		Author:      strings.TrimSpace(d.Author.Name),
+		Description: strings.TrimSpace(d.Subtitle),
+		ImageURL:    strings.TrimSpace(firstNonEmpty(d.Image.Href, d.Logo, d.Icon)),
+	}
+	for _, link := range d.Links {
+		if link.Rel == "" || link.Rel == "alternate" {
+			feed.Link = link.Href
+			break
+		}
+	}
+
+	for _, entry := range d.Entries {
+		episode := Episode{
+			GUID:        strings.TrimSpace(entry.ID),
+			Title:       strings.TrimSpace(entry.Title),
+			Description: strings.TrimSpace(firstNonEmpty(entry.Summary, entry.Content)),
+			Published:   parseDate(firstNonEmpty(entry.Published, entry.Updated)),
+			Duration:    ParseDuration(entry.Duration),
+			ImageURL:    strings.TrimSpace(entry.Image.Href),
+		}
+		episode.Season, _ = strconv.Atoi(strings.TrimSpace(entry.Season))
+		episode.Number, _ = strconv.Atoi(strings.TrimSpace(entry.Number))
+		for _, link := range entry.Links {
+			if link.Rel == "enclosure" {
+				episode.URL = strings.TrimSpace(link.Href)
+				episode.Type = strings.TrimSpace(link.Type)
+				episode.Length, _ = strconv.ParseInt(strings.TrimSpace(link.Length), 10, 64)
+				break
+			}
+		}
+		feed.Episodes = append(feed.Episodes, episode)
+	}
+	return feed
+}
+
+// ParseDuration reads an itunes:duration, which feeds write as seconds,
+// MM:SS or HH:MM:SS, sometimes with a fraction. It returns 0 for anything
+// else.
+func ParseDuration(text string) time.Duration {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0
+	}
+	parts := strings.Split(text, ":")
+	if len(parts) > 3 {
+		return 0
+	}
+	var seconds float64
+	for _, part := range parts {
+		value, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || value < 0 {
+			return 0
+		}
+		seconds = seconds*60 + value
+	}
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second)
+}
+
+// dateLayouts are the date formats seen in feeds: RFC 822 as RSS asks
+// for, with and without seconds or weekday, and RFC 3339 as Atom does
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 2006 15:04 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	"Mon, 2 January 2006 15:04:05 -0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseDate reads a feed date, returning the zero time if no known layout
+// fits
+func parseDate(text string) time.Time {
+	text = strings.Join(strings.Fields(text), " ")
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+var (
+	htmlTag   = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlBreak = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</li>`)
+)
+
+// PlainText turns an HTML show note into plain text for display
+func PlainText(text string) string {
+	text = htmlBreak.ReplaceAllString(text, "\n")
+	text = html.UnescapeString(htmlTag.ReplaceAllString(text, ""))
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" || len(lines) > 0 && lines[len(lines)-1] != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// urlPath returns the path of a URL without its query or fragment
+func urlPath(rawURL string) string {
+	if i := strings.IndexAny(rawURL, "?#"); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	return rawURL
+}
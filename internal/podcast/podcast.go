@@ -0,0 +1,349 @@
+package podcast
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clispot/internal/library"
+)
+
+// DirName is the folder under the library root that episodes are
+// downloaded into, one folder per show
+const DirName = library.PodcastDirName
+
+// maxFeedSize bounds how much of a feed is read; long-running shows can
+// have feeds of several megabytes
+const maxFeedSize = 32 << 20
+
+// Options configures how feeds and episodes are fetched. Zero fields take
+// the defaults.
+type Options struct {
+	// Client makes the requests; nil uses http.DefaultClient. Episodes can
+	// take a while to download, so it should not set an overall Timeout.
+	Client *http.Client
+	// FeedTimeout bounds fetching one feed, 30 seconds by default
+	FeedTimeout time.Duration
+	UserAgent   string
+}
+
+func (o Options) withDefaults() Options {
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+	if o.FeedTimeout <= 0 {
+		o.FeedTimeout = 30 * time.Second
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = "clispot"
+	}
+	return o
+}
+
+// Manager subscribes to feeds, refreshes them and downloads their episodes
+// into the library
+type Manager struct {
+	*Store
+	dir  string
+	opts Options
+
+	mu          sync.Mutex
+	downloading map[string]bool
+}
+
+// NewManager returns a manager keeping its subscriptions in store and
+// downloading into the Podcasts folder of libraryRoot
+func NewManager(store *Store, libraryRoot string, opts Options) *Manager {
+	return &Manager{
+		Store:       store,
+		dir:         filepath.Join(libraryRoot, DirName),
+		opts:        opts.withDefaults(),
+		downloading: make(map[string]bool),
+	}
+}
+
+// Dir returns the folder episodes are downloaded into
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// Fetch downloads and parses the feed at url
+func (m *Manager) Fetch(url string) (*Feed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), m.opts.FeedTimeout)
+	defer cancel()
+
+	resp, err := m.get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching feed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	feed, err := ParseFeed(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (m *Manager) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", m.opts.UserAgent)
+
+	resp, err := m.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// Subscribe fetches the feed at url and adds it to the subscriptions
+func (m *Manager) Subscribe(url string) (Show, error) {
+	if show, ok := m.Show(url); ok {
+		return Show{}, fmt.Errorf("already subscribed to %s", show.Title)
+	}
+	feed, err := m.Fetch(url)
+	if err != nil {
+		return Show{}, err
+	}
+
+	now := time.Now()
+	show := Show{URL: url, Feed: *feed, Subscribed: now, Refreshed: now}
+	if err := m.Put(show); err != nil {
+		return Show{}, err
+	}
+	return show, nil
+}
+
+// Refresh fetches the feed of the show at url again and returns the
+// episodes that are new since the last time. What is known about the
+// other episodes is kept, and downloaded episodes stay listed after they
+// drop out of the feed.
+func (m *Manager) Refresh(url string) ([]Episode, error) {
+	if _, ok := m.Show(url); !ok {
+		return nil, fmt.Errorf("not subscribed to %s", url)
+	}
+	feed, err := m.Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var added []Episode
+	err = m.update(func(shows []Show) ([]Show, error) {
+		i := indexOfShow(shows, url)
+		if i < 0 {
+			return nil, fmt.Errorf("not subscribed to %s", url)
+		}
+		shows[i], added = merge(shows[i], feed)
+		return shows, nil
+	})
+	return added, err
+}
+
+// merge replaces the feed of show, carrying over the state of the
+// episodes it already had
+func merge(show Show, feed *Feed) (Show, []Episode) {
+	previous := show.Episodes
+	show.Feed = *feed
+	show.Refreshed = time.Now()
+
+	var added []Episode
+	seen := make(map[string]bool)
+	for i, episode := range show.Episodes {
+		seen[episode.GUID] = true
+		j := indexOfEpisode(previous, episode.GUID)
+		if j < 0 {
+			added = append(added, episode)
+			continue
+		}
+		show.Episodes[i].File = previous[j].File
+		show.Episodes[i].Played = previous[j].Played
+		show.Episodes[i].Position = previous[j].Position
+	}
+	for _, episode := range previous {
+		if !seen[episode.GUID] && episode.Downloaded() {
+			show.Episodes = append(show.Episodes, episode)
+		}
+	}
+	sort.SliceStable(show.Episodes, func(i, j int) bool {
+		return show.Episodes[i].Published.After(show.Episodes[j].Published)
+	})
+	return show, added
+}
+
+func indexOfEpisode(episodes []Episode, guid string) int {
+	for i, episode := range episodes {
+		if episode.GUID == guid {
+			return i
+		}
+	}
+	return -1
+}
+
+// ToDownload returns up to n of episodes, in order, that can be played and
+// are not downloaded yet
+func ToDownload(episodes []Episode, n int) []Episode {
+	var pending []Episode
+	for _, episode := range episodes {
+		if len(pending) >= n {
+			break
+		}
+		if !episode.Downloaded() && episode.Playable() {
+			pending = append(pending, episode)
+		}
+	}
+	return pending
+}
+
+// Download saves an episode of the show at url under the show's folder,
+// named after its date and title, and records where. progress, if not
+// nil, is called as the file arrives with the bytes written so far and
+// the expected total, 0 when unknown.
+func (m *Manager) Download(url, guid string, progress func(written, total int64)) (Episode, error) {
+	show, ok := m.Show(url)
+	if !ok {
+		return Episode{}, fmt.Errorf("not subscribed to %s", url)
+	}
+	episode, ok := show.Episode(guid)
+	if !ok {
+		return Episode{}, fmt.Errorf("no such episode in %s", show.Title)
+	}
+	if episode.Downloaded() {
+		if _, err := os.Stat(episode.File); err == nil {
+			return episode, nil
+		}
+	}
+	if !episode.Playable() {
+		return Episode{}, fmt.Errorf("%s is not an MP3 episode (%s)", episode.Title, episode.Type)
+	}
+
+	key := url + "\x00" + guid
+	m.mu.Lock()
+	if m.downloading[key] {
+		m.mu.Unlock()
+		return Episode{}, fmt.Errorf("%s is already downloading", episode.Title)
+	}
+	m.downloading[key] = true
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.downloading, key)
+		m.mu.Unlock()
+	}()
+
+	path, err := m.fetchEpisode(show, episode, progress)
+	if err != nil {
+		return Episode{}, err
+	}
+	if err := m.UpdateEpisode(url, guid, func(e *Episode) { e.File = path }); err != nil {
+		os.Remove(path)
+		return Episode{}, err
+	}
+	episode.File = path
+	return episode, nil
+}
+
+// fetchEpisode downloads the enclosure to a temporary file beside its
+// final name, so a broken download never looks like a finished one
+func (m *Manager) fetchEpisode(show Show, episode Episode, progress func(written, total int64)) (string, error) {
+	dir := filepath.Join(m.dir, fileName(show.Title, false))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating %s: %v", dir, err)
+	}
+
+	resp, err := m.get(context.Background(), episode.URL)
+	if err != nil {
+		return "", fmt.Errorf("error downloading %s: %v", episode.Title, err)
+	}
+	defer resp.Body.Close()
+
+	total := resp.ContentLength
+	if total < 0 {
+		total = max(episode.Length, 0)
+	}
+
+	part, err := os.CreateTemp(dir, ".download-*.part")
+	if err != nil {
+		return "", fmt.Errorf("error creating download file: %v", err)
+	}
+	defer os.Remove(part.Name())
+	if err := part.Chmod(0644); err != nil {
+		part.Close()
+		return "", fmt.Errorf("error creating download file: %v", err)
+	}
+
+	var w io.Writer = part
+	if progress != nil {
+		w = &progressWriter{w: part, total: total, progress: progress}
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		part.Close()
+		return "", fmt.Errorf("error downloading %s: %v", episode.Title, err)
+	}
+	if err := part.Close(); err != nil {
+		return "", fmt.Errorf("error saving %s: %v", episode.Title, err)
+	}
+
+	path := freePath(filepath.Join(dir, episodeFileName(episode)))
+	if err := os.Rename(part.Name(), path); err != nil {
+		return "", fmt.Errorf("error saving %s: %v", episode.Title, err)
+	}
+	return path, nil
+}
+
+// progressWriter reports the bytes written through it
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	w.progress(w.written, w.total)
+	return n, err
+}
+
+// episodeFileName names a downloaded episode "2006-01-02 Title.mp3", so
+// the files of a show sort by date
+func episodeFileName(episode Episode) string {
+	name := episode.Title
+	if !episode.Published.IsZero() {
+		name = episode.Published.Format("2006-01-02") + " " + name
+	}
+	return fileName(name+".mp3", true)
+}
+
+// fileName turns a title into a file or folder name, first joining the
+// line breaks and runs of spaces that feeds often have into single spaces
+func fileName(title string, isFile bool) string {
+	return library.SafeName(strings.Join(strings.Fields(title), " "), isFile)
+}
+
+// freePath returns path, or path with " (2)", " (3)" … before the
+// extension if it is taken
+func freePath(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 2; ; n++ {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+}
@@ -0,0 +1,493 @@
+package podcast
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const rssFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+<channel>
+	<title> The Test Show </title>
+	<link>https://example.com/show</link>
+	<description>A show about tests</description>
+	<itunes:author>Jane Host</itunes:author>
+	<itunes:image href="https://example.com/show.jpg"/>
+	<image><url>https://example.com/fallback.jpg</url></image>
+	<item>
+		<title>First Steps</title>
+		<guid>ep-1</guid>
+		<pubDate>Fri, 1 Mar 2024 08:00:00 +0000</pubDate>
+		<itunes:summary>The very first one</itunes:summary>
+		<enclosure url="https://example.com/1.mp3" type="audio/mpeg" length="1000"/>
+		<itunes:duration>45:30</itunes:duration>
+		<itunes:season>1</itunes:season>
+		<itunes:episode>1</itunes:episode>
+	</item>
+	<item>
+		<title>Second &amp; Last</title>
+		<guid>ep-2</guid>
+		<pubDate>Fri, 08 Mar 2024 08:00:00 GMT</pubDate>
+		<description>&lt;p&gt;Notes&lt;/p&gt;</description>
+		<enclosure url="https://example.com/2.mp3?source=feed" type="audio/mpeg" length="2000"/>
+		<itunes:duration>1:02:03</itunes:duration>
+		<itunes:image href="https://example.com/2.jpg"/>
+	</item>
+	<item>
+		<title>Repeated</title>
+		<guid>ep-2</guid>
+		<enclosure url="https://example.com/2-again.mp3" type="audio/mpeg"/>
+	</item>
+	<item>
+		<title>Trailer without audio</title>
+		<guid>trailer</guid>
+	</item>
+	<item>
+		<pubDate>Thu, 29 Feb 2024 08:00:00 +0000</pubDate>
+		<enclosure url="https://example.com/bonus.mp3" type="audio/mpeg"/>
+		<itunes:duration>90</itunes:duration>
+	</item>
+</channel>
+</rss>`
+
+func TestParseRSS(t *testing.T) {
+	feed, err := ParseFeed(strings.NewReader(rssFeed))
+	if err != nil {
+		t.Fatalf("error parsing feed: %v", err)
+	}
+
+	show := Feed{
+		Title:       "The Test Show",
+		Author:      "Jane Host",
+		Description: "A show about tests",
+		Link:        "https://example.com/show",
+		ImageURL:    "https://example.com/show.jpg",
+	}
+	got := *feed
+	got.Episodes = nil
+	if !reflect.DeepEqual(got, show) {
+		t.Errorf("got show %+v, want %+v", got, show)
+	}
+
+	want := []Episode{
+		{
+			GUID:        "ep-2",
+			Title:       "Second & Last",
+			Description: "<p>Notes</p>",
+			Published:   time.Date(2024, 3, 8, 8, 0, 0, 0, time.UTC),
+			Duration:    time.Hour + 2*time.Minute + 3*time.Second,
+			ImageURL:    "https://example.com/2.jpg",
+			URL:         "https://example.com/2.mp3?source=feed",
+			Type:        "audio/mpeg",
+			Length:      2000,
+		},
+		{
+			GUID:        "ep-1",
+			Title:       "First Steps",
+			Description: "The very first one",
+			Published:   time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC),
+			Duration:    45*time.Minute + 30*time.Second,
+			Season:      1,
+			Number:      1,
+			URL:         "https://example.com/1.mp3",
+			Type:        "audio/mpeg",
+			Length:      1000,
+		},
+		{
+			GUID:      "https://example.com/bonus.mp3",
+			Title:     "bonus.mp3",
+			Published: time.Date(2024, 2, 29, 8, 0, 0, 0, time.UTC),
+			Duration:  90 * time.Second,
+			URL:       "https://example.com/bonus.mp3",
+			Type:      "audio/mpeg",
+		},
+	}
+	if len(feed.Episodes) != len(want) {
+		t.Fatalf("got %d episodes, want %d: %+v", len(feed.Episodes), len(want), feed.Episodes)
+	}
+	for i := range want {
+		got := feed.Episodes[i]
+		if !got.Published.Equal(want[i].Published) {
+			t.Errorf("episode %d: got published %v, want %v", i, got.Published, want[i].Published)
+		}
+		got.Published = want[i].Published
+		if got != want[i] {
+			t.Errorf("episode %d:\ngot  %+v\nwant %+v", i, got, want[i])
+		}
+	}
+}
+
+const atomFeedText = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+	<title>Caf` + "\xe9" + ` Talk</title>
+	<subtitle>Conversations over coffee</subtitle>
+	<author><name>Sam Barista</name></author>
+	<link rel="self" href="https://example.com/feed.atom"/>
+	<link href="https://example.com/cafe"/>
+	<logo>https://example.com/logo.png</logo>
+	<entry>
+		<id>urn:cafe:1</id>
+		<title>Espresso</title>
+		<updated>2024-01-02T10:00:00Z</updated>
+		<summary>Short and strong</summary>
+		<link rel="alternate" href="https://example.com/cafe/1"/>
+		<link rel="enclosure" href="https://example.com/cafe/1.mp3" type="audio/mpeg" length="3000"/>
+		<itunes:duration>600</itunes:duration>
+		<itunes:season>2</itunes:season>
+		<itunes:episode>7</itunes:episode>
+		<itunes:image href="https://example.com/cafe/1.jpg"/>
+	</entry>
+	<entry>
+		<id>urn:cafe:2</id>
+		<title>Latte</title>
+		<published>2024-01-09T10:00:00+01:00</published>
+		<updated>2024-02-01T10:00:00Z</updated>
+		<content>Long and milky</content>
+		<link rel="enclosure" href="https://example.com/cafe/2.m4a" type="audio/x-m4a"/>
+	</entry>
+</feed>`
+
+func TestParseAtom(t *testing.T) {
+	feed, err := ParseFeed(strings.NewReader(atomFeedText))
+	if err != nil {
+		t.Fatalf("error parsing feed: %v", err)
+	}
+	if feed.Title != "Café Talk" || feed.Author != "Sam Barista" || feed.Description != "Conversations over coffee" ||
+		feed.Link != "https://example.com/cafe" || feed.ImageURL != "https://example.com/logo.png" {
+		t.Errorf("got show %q by %q (%q, %q, %q)", feed.Title, feed.Author, feed.Description, feed.Link, feed.ImageURL)
+	}
+	if len(feed.Episodes) != 2 {
+		t.Fatalf("got %d episodes, want 2", len(feed.Episodes))
+	}
+
+	latte, espresso := feed.Episodes[0], feed.Episodes[1]
+	if latte.GUID != "urn:cafe:2" || latte.Description != "Long and milky" ||
+		!latte.Published.Equal(time.Date(2024, 1, 9, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("got first episode %+v, want the latte published 2024-01-09", latte)
+	}
+	if latte.Playable() {
+		t.Error("an M4A enclosure counts as playable")
+	}
+
+	want := Episode{
+		GUID:        "urn:cafe:1",
+		Title:       "Espresso",
+		Description: "Short and strong",
+		Published:   time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC),
+		Duration:    10 * time.Minute,
+		Season:      2,
+		Number:      7,
+		ImageURL:    "https://example.com/cafe/1.jpg",
+		URL:         "https://example.com/cafe/1.mp3",
+		Type:        "audio/mpeg",
+		Length:      3000,
+	}
+	if !espresso.Published.Equal(want.Published) {
+		t.Errorf("got published %v, want %v", espresso.Published, want.Published)
+	}
+	espresso.Published = want.Published
+	if espresso != want {
+		t.Errorf("got  %+v\nwant %+v", espresso, want)
+	}
+	if !espresso.Playable() {
+		t.Error("an MP3 enclosure does not count as playable")
+	}
+}
+
+func TestParseFeedErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"<html><body>Not a feed</body></html>",
+		`<?xml version="1.0" encoding="x-unknown"?><rss><channel/></rss>`,
+	} {
+		if _, err := ParseFeed(strings.NewReader(text)); err == nil {
+			t.Errorf("got no error for %q", text)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		text string
+		want time.Duration
+	}{
+		{"", 0},
+		{"90", 90 * time.Second},
+		{"12:34", 12*time.Minute + 34*time.Second},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{" 01:00:00.6 ", time.Hour + time.Second},
+		{"1:2:3:4", 0},
+		{"-5", 0},
+		{"an hour", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseDuration(tt.text); got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+// feedServer serves a feed that tests can change between requests, and the
+// audio of any enclosure under /audio/
+type feedServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	feed string
+}
+
+func newFeedServer(t *testing.T, audio func(w http.ResponseWriter, r *http.Request)) *feedServer {
+	t.Helper()
+	f := &feedServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "clispot" {
+			t.Errorf("got User-Agent %q, want clispot", r.Header.Get("User-Agent"))
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/rss+xml")
+		io.WriteString(w, f.feed)
+	})
+	if audio != nil {
+		mux.HandleFunc("/audio/", audio)
+	}
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *feedServer) setFeed(title string, items ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.feed = `<rss xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel><title>` + title + `</title>` +
+		strings.Join(items, "") + `</channel></rss>`
+}
+
+// feedItem writes an RSS item for episode guid published on day of March
+// 2024, with its audio under the server's /audio/
+func (f *feedServer) feedItem(guid, title string, day int) string {
+	return fmt.Sprintf(`<item><guid>%s</guid><title>%s</title><pubDate>%s</pubDate>`+
+		`<enclosure url="%s/audio/%s.mp3" type="audio/mpeg"/></item>`,
+		guid, title, time.Date(2024, 3, day, 8, 0, 0, 0, time.UTC).Format(time.RFC1123Z), f.URL, guid)
+}
+
+func newTestManager(t *testing.T, srv *feedServer) *Manager {
+	t.Helper()
+	return NewManager(NewStore(t.TempDir()), t.TempDir(), Options{Client: srv.Client()})
+}
+
+func TestRefreshFindsNewEpisodes(t *testing.T) {
+	srv := newFeedServer(t, nil)
+	srv.setFeed("Show", srv.feedItem("a", "A", 1), srv.feedItem("b", "B", 2), srv.feedItem("c", "C", 3))
+	m := newTestManager(t, srv)
+	url := srv.URL + "/feed.xml"
+
+	show, err := m.Subscribe(url)
+	if err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+	if len(show.Episodes) != 3 || show.Episodes[0].GUID != "c" {
+		t.Fatalf("got episodes %+v, want c, b and a", show.Episodes)
+	}
+	if _, err := m.Subscribe(url); err == nil {
+		t.Error("subscribed twice to the same feed")
+	}
+
+	// a was downloaded and is then dropped from the feed, b was half heard
+	if err := m.UpdateEpisode(url, "a", func(e *Episode) { e.File = "/music/Podcasts/Show/a.mp3"; e.Played = true }); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UpdateEpisode(url, "b", func(e *Episode) { e.Position = 5 * time.Minute }); err != nil {
+		t.Fatal(err)
+	}
+	srv.setFeed("Show Renamed", srv.feedItem("b", "B edited", 2), srv.feedItem("d", "D", 4), srv.feedItem("e", "E", 5))
+
+	added, err := m.Refresh(url)
+	if err != nil {
+		t.Fatalf("error refreshing: %v", err)
+	}
+	var guids []string
+	for _, episode := range added {
+		guids = append(guids, episode.GUID)
+	}
+	if want := []string{"e", "d"}; !reflect.DeepEqual(guids, want) {
+		t.Errorf("got new episodes %q, want %q", guids, want)
+	}
+
+	show, _ = m.Show(url)
+	guids = nil
+	for _, episode := range show.Episodes {
+		guids = append(guids, episode.GUID)
+	}
+	if want := []string{"e", "d", "b", "a"}; !reflect.DeepEqual(guids, want) {
+		t.Errorf("got episodes %q after refreshing, want %q", guids, want)
+	}
+	if show.Title != "Show Renamed" {
+		t.Errorf("got title %q, want the feed's new one", show.Title)
+	}
+	if b, _ := show.Episode("b"); b.Title != "B edited" || b.Position != 5*time.Minute {
+		t.Errorf("got %+v, want the new title and the old position", b)
+	}
+	if a, _ := show.Episode("a"); !a.Played || a.File == "" {
+		t.Errorf("got %+v, want the downloaded episode kept as it was", a)
+	}
+
+	// Nothing is new the second time
+	if added, err := m.Refresh(url); err != nil || len(added) != 0 {
+		t.Errorf("got %d new episodes and error %v refreshing again", len(added), err)
+	}
+}
+
+func TestDownloadWritesPartFileFirst(t *testing.T) {
+	audio := bytes.Repeat([]byte("mp3 "), 16<<10)
+	halfway := make(chan struct{})
+	resume := make(chan struct{})
+	srv := newFeedServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(audio)))
+		w.Write(audio[:len(audio)/2])
+		w.(http.Flusher).Flush()
+		close(halfway)
+		<-resume
+		w.Write(audio[len(audio)/2:])
+	})
+	srv.setFeed("News: Daily/Weekly", srv.feedItem("ep", `Who? What? "Why"`, 1))
+	m := newTestManager(t, srv)
+	url := srv.URL + "/feed.xml"
+	if _, err := m.Subscribe(url); err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+
+	var written, total int64
+	type result struct {
+		episode Episode
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		episode, err := m.Download(url, "ep", func(w, t int64) { written, total = w, t })
+		done <- result{episode, err}
+	}()
+
+	dir := filepath.Join(m.Dir(), "News_ Daily_Weekly")
+	<-halfway
+	// The client creates the .part file once the response arrives
+	var names []string
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(dir); err == nil {
+			if names = listDir(t, dir); len(names) > 0 {
+				break
+			}
+		}
+	}
+	if len(names) != 1 || !strings.HasSuffix(names[0], ".part") {
+		t.Errorf("got %q in the show's folder halfway through, want only a .part file", names)
+	}
+	if _, err := m.Download(url, "ep", nil); err == nil || !strings.Contains(err.Error(), "already downloading") {
+		t.Errorf("got error %v downloading twice at once", err)
+	}
+	close(resume)
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("error downloading: %v", r.err)
+	}
+	want := filepath.Join(dir, "2024-03-01 Who_ What_ _Why.mp3")
+	if r.episode.File != want {
+		t.Errorf("got file %s, want %s", r.episode.File, want)
+	}
+	if names := listDir(t, dir); !reflect.DeepEqual(names, []string{filepath.Base(want)}) {
+		t.Errorf("got %q in the show's folder, want only the episode", names)
+	}
+	if data, err := os.ReadFile(want); err != nil || !bytes.Equal(data, audio) {
+		t.Errorf("got %d bytes and error %v, want the %d sent", len(data), err, len(audio))
+	}
+	if written != int64(len(audio)) || total != int64(len(audio)) {
+		t.Errorf("got progress %d of %d, want %d of %d", written, total, len(audio), len(audio))
+	}
+	if show, _ := m.Show(url); show.Episodes[0].File != want {
+		t.Errorf("got stored file %q, want %s", show.Episodes[0].File, want)
+	}
+}
+
+func TestDownloadFailureLeavesNothing(t *testing.T) {
+	srv := newFeedServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// Promise more than is sent, as a dropped connection does
+		w.Header().Set("Content-Length", "1000")
+		w.Write([]byte("partial"))
+	})
+	srv.setFeed("Show", srv.feedItem("ep", "Episode", 1))
+	m := newTestManager(t, srv)
+	url := srv.URL + "/feed.xml"
+	if _, err := m.Subscribe(url); err != nil {
+		t.Fatalf("error subscribing: %v", err)
+	}
+
+	if _, err := m.Download(url, "ep", nil); err == nil {
+		t.Fatal("got no error for a broken download")
+	}
+	if names := listDir(t, filepath.Join(m.Dir(), "Show")); len(names) != 0 {
+		t.Errorf("got %q left in the show's folder", names)
+	}
+	if show, _ := m.Show(url); show.Episodes[0].Downloaded() {
+		t.Error("a broken download was recorded as downloaded")
+	}
+}
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error listing %s: %v", dir, err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestFileName(t *testing.T) {
+	long := strings.Repeat("Ω", 150)
+	tests := []struct {
+		title  string
+		isFile bool
+		want   string
+	}{
+		{"Plain Title", false, "Plain Title"},
+		{`AC/DC: Live? "Yes" <Maybe> | *`, false, "AC_DC_ Live_ _Yes_ _Maybe"},
+		{"Line\nbreaks  and\ttabs", false, "Line breaks and tabs"},
+		{"Bell\x07 and delete\x7f", false, "Bell and delete"},
+		{"  Trailing dots...  ", false, "Trailing dots"},
+		{"con", false, "con_"},
+		{"Aux.mp3", true, "Aux_.mp3"},
+		{"", false, "Unknown"},
+		{"....mp3", true, "Unknown.mp3"},
+		{long + ".mp3", true, strings.Repeat("Ω", 98) + ".mp3"},
+	}
+	for _, tt := range tests {
+		if got := fileName(tt.title, tt.isFile); got != tt.want {
+			t.Errorf("fileName(%q, %v) = %q, want %q", tt.title, tt.isFile, got, tt.want)
+		}
+	}
+}
+
+func TestEpisodeFileName(t *testing.T) {
+	episode := Episode{Title: "Episode 12: Q&A", Published: time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)}
+	if got, want := episodeFileName(episode), "2024-03-01 Episode 12_ Q&A.mp3"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	episode.Published = time.Time{}
+	if got, want := episodeFileName(episode), "Episode 12_ Q&A.mp3"; got != want {
+		t.Errorf("got %q without a date, want %q", got, want)
+	}
+}
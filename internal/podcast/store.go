@@ -0,0 +1,235 @@
+package podcast
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"clispot/internal/persist"
+)
+
+// Show is a subscribed podcast: its feed as last fetched and what has been
+// downloaded and played of it
+type Show struct {
+	// URL is the address of the feed
+	URL string `json:"url"`
+	Feed
+	Subscribed time.Time `json:"subscribed"`
+	Refreshed  time.Time `json:"refreshed"`
+}
+
+// Unplayed counts the episodes not played yet
+func (s Show) Unplayed() int {
+	count := 0
+	for _, episode := range s.Episodes {
+		if !episode.Played {
+			count++
+		}
+	}
+	return count
+}
+
+// Episode returns the episode with guid
+func (s Show) Episode(guid string) (Episode, bool) {
+	if i := indexOfEpisode(s.Episodes, guid); i >= 0 {
+		return s.Episodes[i], true
+	}
+	return Episode{}, false
+}
+
+// Store keeps the subscriptions, with each episode's played state and
+// resume position, in podcasts.json. It is safe for concurrent use, as
+// feeds are refreshed and episodes downloaded in the background.
+type Store struct {
+	path string
+
+	mu    sync.Mutex
+	shows []Show
+}
+
+func NewStore(configDir string) *Store {
+	store := &Store{path: filepath.Join(configDir, "podcasts.json")}
+
+	store.Load()
+
+	return store
+}
+
+// Load reads the podcasts file, falling back to its backup if it is corrupt
+func (s *Store) Load() error {
+	data, err := persist.ReadFile(s.path, validShows)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error loading podcasts: %v", err)
+	}
+
+	var shows []Show
+	if err := json.Unmarshal(data, &shows); err != nil {
+		return fmt.Errorf("error parsing podcasts: %v", err)
+	}
+	s.mu.Lock()
+	s.shows = shows
+	s.mu.Unlock()
+	return nil
+}
+
+// Shows returns the subscriptions sorted by title
+func (s *Store) Shows() []Show {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Show(nil), s.shows...)
+}
+
+// Show returns the subscription to the feed at url
+func (s *Store) Show(url string) (Show, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := indexOfShow(s.shows, url); i >= 0 {
+		return s.shows[i], true
+	}
+	return Show{}, false
+}
+
+// Find returns the show titled name, ignoring case, or else the only one
+// whose title starts with it
+func (s *Store) Find(name string) (Show, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matches []Show
+	for _, show := range s.shows {
+		if strings.EqualFold(show.Title, name) || show.URL == name {
+			return show, true
+		}
+		if strings.HasPrefix(strings.ToLower(show.Title), strings.ToLower(name)) {
+			matches = append(matches, show)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], true
+	}
+	return Show{}, false
+}
+
+// EpisodeByFile returns the downloaded episode stored at path and its show
+func (s *Store) EpisodeByFile(path string) (Show, Episode, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, show := range s.shows {
+		for _, episode := range show.Episodes {
+			if episode.File != "" && episode.File == path {
+				return show, episode, true
+			}
+		}
+	}
+	return Show{}, Episode{}, false
+}
+
+// Put saves show, replacing the subscription to the same feed
+func (s *Store) Put(show Show) error {
+	return s.update(func(shows []Show) ([]Show, error) {
+		if i := indexOfShow(shows, show.URL); i >= 0 {
+			shows[i] = show
+		} else {
+			shows = append(shows, show)
+		}
+		return shows, nil
+	})
+}
+
+// Remove forgets the subscription to the feed at url. Downloaded files are
+// left alone.
+func (s *Store) Remove(url string) error {
+	return s.update(func(shows []Show) ([]Show, error) {
+		i := indexOfShow(shows, url)
+		if i < 0 {
+			return nil, fmt.Errorf("not subscribed to %s", url)
+		}
+		return append(shows[:i], shows[i+1:]...), nil
+	})
+}
+
+// UpdateEpisode applies fn to one episode of the show at url
+func (s *Store) UpdateEpisode(url, guid string, fn func(*Episode)) error {
+	return s.update(func(shows []Show) ([]Show, error) {
+		i := indexOfShow(shows, url)
+		if i < 0 {
+			return nil, fmt.Errorf("not subscribed to %s", url)
+		}
+		j := indexOfEpisode(shows[i].Episodes, guid)
+		if j < 0 {
+			return nil, fmt.Errorf("no such episode in %s", shows[i].Title)
+		}
+		fn(&shows[i].Episodes[j])
+		return shows, nil
+	})
+}
+
+// SetPlayed marks an episode played or unplayed. Either way it starts from
+// the beginning next time.
+func (s *Store) SetPlayed(url, guid string, played bool) error {
+	return s.UpdateEpisode(url, guid, func(episode *Episode) {
+		episode.Played = played
+		episode.Position = 0
+	})
+}
+
+// SetPosition remembers where listening to the episode at file stopped
+func (s *Store) SetPosition(file string, position time.Duration) error {
+	show, episode, ok := s.EpisodeByFile(file)
+	if !ok {
+		return fmt.Errorf("%s is not a podcast episode", filepath.Base(file))
+	}
+	return s.UpdateEpisode(show.URL, episode.GUID, func(episode *Episode) {
+		episode.Position = position
+	})
+}
+
+// update applies fn to the shows on disk, so changes made by another
+// clispot instance in the meantime are kept
+func (s *Store) update(fn func([]Show) ([]Show, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return persist.Update(s.path, 0644, validShows, func(data []byte) ([]byte, error) {
+		var shows []Show
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &shows); err != nil {
+				return nil, fmt.Errorf("error parsing podcasts: %v", err)
+			}
+		}
+
+		shows, err := fn(shows)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(shows, func(i, j int) bool {
+			return strings.ToLower(shows[i].Title) < strings.ToLower(shows[j].Title)
+		})
+		s.shows = shows
+
+		return json.MarshalIndent(shows, "", "  ")
+	})
+}
+
+func indexOfShow(shows []Show, url string) int {
+	for i, show := range shows {
+		if show.URL == url {
+			return i
+		}
+	}
+	return -1
+}
+
+// validShows rejects files that do not decode, so Load falls back to the backup
+func validShows(data []byte) error {
+	var shows []Show
+	return json.Unmarshal(data, &shows)
+}
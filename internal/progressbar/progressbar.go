@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"clispot/internal/theme"
 )
 
 
@@ -14,6 +16,9 @@ type ProgressBar struct {
 	isVisible   bool
 	isDragging  bool
 	dragPos     float64
+	
+	// marks are chapter starts, drawn as ticks on the bar
+	marks       []time.Duration
 }
 
 
@@ -33,6 +38,12 @@ func (pb *ProgressBar) Update(position, duration time.Duration) {
 }
 
 
+// SetMarks sets the positions of the chapter ticks; nil clears them
+func (pb *ProgressBar) SetMarks(marks []time.Duration) {
+	pb.marks = marks
+}
+
+
 func (pb *ProgressBar) SetVisible(visible bool) {
 	pb.isVisible = visible
 }
@@ -70,24 +81,11 @@ func (pb *ProgressBar) Render() string {
 	}
 	
 	
-	currentTime := pb.position
-	totalTime := pb.duration
-	
-	if pb.isDragging && pb.duration > 0 {
-		currentTime = time.Duration(float64(pb.duration) * pb.dragPos)
-	}
-	
-	timeStr := fmt.Sprintf("[dim]%s[white] / [dim]%s[white]", 
-		formatDuration(currentTime), 
-		formatDuration(totalTime))
-	
-	
-	timeDisplayLen := len(strings.ReplaceAll(strings.ReplaceAll(timeStr, "[dim]", ""), "[white]", ""))
-	barWidth := pb.width - timeDisplayLen - 3 
+	th := theme.Current()
+	currentStr, totalStr := pb.timeStrings()
+	timeStr := fmt.Sprintf("%s / %s", th.Paint(th.Muted, currentStr), th.Paint(th.Muted, totalStr))
 	
-	if barWidth < 10 {
-		barWidth = 10
-	}
+	_, barWidth := pb.layout()
 	
 	
 	filledWidth := int(float64(barWidth) * progress)
@@ -96,21 +94,24 @@ func (pb *ProgressBar) Render() string {
 	result.WriteString(" [")
 	
 	
+	fill := th.ProgressFill
+	if pb.isDragging {
+		fill = th.ProgressDrag
+	}
+	ticks := pb.tickColumns(barWidth)
 	for i := 0; i < barWidth; i++ {
-		if i < filledWidth {
-			if pb.isDragging {
-				result.WriteString("[yellow]█[white]")
-			} else {
-				result.WriteString("[green]█[white]")
+		if ticks[i] {
+			color := th.ProgressEmpty
+			if i <= filledWidth {
+				color = fill
 			}
+			result.WriteString(th.Paint(color, "┃"))
+		} else if i < filledWidth {
+			result.WriteString(th.Paint(fill, "█"))
 		} else if i == filledWidth && filledWidth < barWidth {
-			if pb.isDragging {
-				result.WriteString("[yellow]▌[white]")
-			} else {
-				result.WriteString("[green]▌[white]")
-			}
+			result.WriteString(th.Paint(fill, "▌"))
 		} else {
-			result.WriteString("[dim]░[white]")
+			result.WriteString(th.Paint(th.ProgressEmpty, "░"))
 		}
 	}
 	
@@ -119,7 +120,7 @@ func (pb *ProgressBar) Render() string {
 	
 	if pb.duration > 0 {
 		percentage := int(progress * 100)
-		result.WriteString(fmt.Sprintf(" [dim]%d%%[white]", percentage))
+		result.WriteString(" " + th.Paint(th.Muted, fmt.Sprintf("%d%%", percentage)))
 	}
 	
 	return result.String()
@@ -127,43 +128,89 @@ func (pb *ProgressBar) Render() string {
 
 
 
-func (pb *ProgressBar) HandleClick(x, y, maxWidth int) (float64, bool) {
-	if !pb.isVisible || pb.duration == 0 {
-		return 0, false
+// tickColumns returns which columns of a bar barWidth wide hold a chapter
+// tick. The first chapter usually starts at 0, where a tick says nothing.
+func (pb *ProgressBar) tickColumns(barWidth int) map[int]bool {
+	ticks := make(map[int]bool)
+	if pb.duration <= 0 {
+		return ticks
 	}
-	
-	
-	timeStr := fmt.Sprintf("%s / %s", 
-		formatDuration(pb.position), 
-		formatDuration(pb.duration))
-	
-	timeDisplayLen := len(timeStr)
-	barStart := timeDisplayLen + 2 
-	
-	
-	barWidth := maxWidth - timeDisplayLen - 3
+	for _, mark := range pb.marks {
+		if mark <= 0 || mark >= pb.duration {
+			continue
+		}
+		ticks[int(float64(barWidth)*float64(mark)/float64(pb.duration))] = true
+	}
+	return ticks
+}
+
+
+// percentWidth is the room kept after the bar for " 100%"
+const percentWidth = 5
+
+
+// timeStrings returns the "current" and "total" times shown before the bar
+func (pb *ProgressBar) timeStrings() (string, string) {
+	currentTime := pb.position
+	if pb.isDragging && pb.duration > 0 {
+		currentTime = time.Duration(float64(pb.duration) * pb.dragPos)
+	}
+	return formatDuration(currentTime), formatDuration(pb.duration)
+}
+
+
+// layout returns the column where the bar starts and its width, matching
+// what Render draws: "<current> / <total> [<bar>] <percent>"
+func (pb *ProgressBar) layout() (barStart, barWidth int) {
+	currentStr, totalStr := pb.timeStrings()
+	timeDisplayLen := len(currentStr) + len(" / ") + len(totalStr)
+	barStart = timeDisplayLen + 2
+	
+	barWidth = pb.width - timeDisplayLen - 3 - percentWidth
 	if barWidth < 10 {
 		barWidth = 10
 	}
+	return barStart, barWidth
+}
+
+
+// HandleClick maps column x of a progress bar drawn maxWidth columns wide to
+// a position between 0 and 1; ok is false if x is outside the bar
+func (pb *ProgressBar) HandleClick(x, y, maxWidth int) (float64, bool) {
+	if !pb.isVisible || pb.duration == 0 {
+		return 0, false
+	}
 	
+	pb.width = maxWidth
+	barStart, barWidth := pb.layout()
 	
 	if x >= barStart && x < barStart+barWidth {
-		clickPos := x - barStart
-		percentage := float64(clickPos) / float64(barWidth)
-		
-		if percentage > 1.0 {
-			percentage = 1.0
-		} else if percentage < 0.0 {
-			percentage = 0.0
-		}
-		
-		return percentage, true
+		return pb.positionAt(x), true
 	}
 	
 	return 0, false
 }
 
 
+// DragTo moves an ongoing drag to column x, clamping to the ends of the bar
+// when the pointer leaves it
+func (pb *ProgressBar) DragTo(x int) {
+	pb.UpdateDrag(pb.positionAt(x))
+}
+
+
+func (pb *ProgressBar) positionAt(x int) float64 {
+	barStart, barWidth := pb.layout()
+	percentage := float64(x-barStart) / float64(barWidth)
+	if percentage > 1.0 {
+		percentage = 1.0
+	} else if percentage < 0.0 {
+		percentage = 0.0
+	}
+	return percentage
+}
+
+
 func (pb *ProgressBar) StartDrag(position float64) {
 	pb.isDragging = true
 	pb.dragPos = position
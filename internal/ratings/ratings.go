@@ -0,0 +1,155 @@
+package ratings
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"clispot/internal/persist"
+)
+
+// MaxRating is the highest star rating; 0 means unrated
+const MaxRating = 5
+
+// Entry is the rating and loved flag of one song
+type Entry struct {
+	Rating int  `json:"rating,omitempty"`
+	Loved  bool `json:"loved,omitempty"`
+}
+
+// IsZero reports whether the entry carries no information
+func (e Entry) IsZero() bool {
+	return e.Rating == 0 && !e.Loved
+}
+
+// Store keeps ratings keyed by file path in ratings.json
+type Store struct {
+	path    string
+	entries map[string]Entry
+}
+
+func NewStore(configDir string) *Store {
+	store := &Store{
+		path:    filepath.Join(configDir, "ratings.json"),
+		entries: make(map[string]Entry),
+	}
+
+	store.Load()
+
+	return store
+}
+
+// Load reads the ratings file, falling back to its backup if it is corrupt
+func (s *Store) Load() error {
+	data, err := persist.ReadFile(s.path, validRatings)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error loading ratings: %v", err)
+	}
+
+	entries := make(map[string]Entry)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("error parsing ratings: %v", err)
+	}
+	s.entries = entries
+	return nil
+}
+
+// Get returns the entry for filePath, which is zero if the song is unrated
+func (s *Store) Get(filePath string) Entry {
+	return s.entries[filePath]
+}
+
+// All returns every stored entry keyed by file path
+func (s *Store) All() map[string]Entry {
+	return s.entries
+}
+
+// SetRating stores a 0–5 star rating for filePath
+func (s *Store) SetRating(filePath string, rating int) (Entry, error) {
+	if rating < 0 || rating > MaxRating {
+		return s.Get(filePath), fmt.Errorf("rating must be between 0 and %d", MaxRating)
+	}
+	entry := s.Get(filePath)
+	entry.Rating = rating
+	return entry, s.set(filePath, entry)
+}
+
+// ToggleLoved flips the loved flag of filePath
+func (s *Store) ToggleLoved(filePath string) (Entry, error) {
+	entry := s.Get(filePath)
+	entry.Loved = !entry.Loved
+	return entry, s.set(filePath, entry)
+}
+
+// RenamePaths moves the entries of files that were moved to their new
+// paths; paths maps old paths to new ones. When a new path already has an
+// entry, as a kept duplicate can, the two are merged: the higher rating
+// wins and either loved flag counts.
+func (s *Store) RenamePaths(paths map[string]string) error {
+	return persist.Update(s.path, 0644, validRatings, func(data []byte) ([]byte, error) {
+		entries := make(map[string]Entry)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &entries); err != nil {
+				return nil, fmt.Errorf("error parsing ratings: %v", err)
+			}
+		}
+
+		for from, to := range paths {
+			if entry, ok := entries[from]; ok {
+				delete(entries, from)
+				existing := entries[to]
+				entry.Rating = max(entry.Rating, existing.Rating)
+				entry.Loved = entry.Loved || existing.Loved
+				entries[to] = entry
+			}
+		}
+		s.entries = entries
+
+		return json.MarshalIndent(entries, "", "  ")
+	})
+}
+
+// set writes a single entry, merging it into the file on disk so changes
+// made by another clispot instance in the meantime are kept
+func (s *Store) set(filePath string, entry Entry) error {
+	return persist.Update(s.path, 0644, validRatings, func(data []byte) ([]byte, error) {
+		entries := make(map[string]Entry)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &entries); err != nil {
+				return nil, fmt.Errorf("error parsing ratings: %v", err)
+			}
+		}
+
+		if entry.IsZero() {
+			delete(entries, filePath)
+		} else {
+			entries[filePath] = entry
+		}
+		s.entries = entries
+
+		return json.MarshalIndent(entries, "", "  ")
+	})
+}
+
+// validRatings rejects files that do not decode, so Load falls back to the backup
+func validRatings(data []byte) error {
+	var entries map[string]Entry
+	return json.Unmarshal(data, &entries)
+}
+
+// Stars renders a rating as filled and empty stars
+func Stars(rating int) string {
+	stars := ""
+	for i := 1; i <= MaxRating; i++ {
+		if i <= rating {
+			stars += "★"
+		} else {
+			stars += "☆"
+		}
+	}
+	return stars
+}
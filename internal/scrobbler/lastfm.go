@@ -0,0 +1,161 @@
+package scrobbler
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLastFMURL is the public Last.fm API
+const DefaultLastFMURL = "https://ws.audioscrobbler.com"
+
+// LastFM submits listens through the Last.fm 2.0 API, which compatible
+// servers such as Libre.fm and self-hosted scrobble relays also implement
+type LastFM struct {
+	baseURL    string
+	apiKey     string
+	apiSecret  string
+	sessionKey string
+	client     *http.Client
+}
+
+// NewLastFM creates a Last.fm client. An empty baseURL uses the public
+// server and a nil client uses http.DefaultClient.
+func NewLastFM(baseURL, apiKey, apiSecret, sessionKey string, client *http.Client) *LastFM {
+	if baseURL == "" {
+		baseURL = DefaultLastFMURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &LastFM{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		apiKey:     apiKey,
+		apiSecret:  apiSecret,
+		sessionKey: sessionKey,
+		client:     client,
+	}
+}
+
+func (lf *LastFM) Name() string {
+	return "lastfm"
+}
+
+func (lf *LastFM) MaxBatch() int {
+	return 50
+}
+
+func (lf *LastFM) NowPlaying(ctx context.Context, listen Listen) error {
+	params := url.Values{}
+	params.Set("method", "track.updateNowPlaying")
+	params.Set("artist", listen.Artist)
+	params.Set("track", listen.Title)
+	if listen.Album != "" {
+		params.Set("album", listen.Album)
+	}
+	if listen.AlbumArtist != "" {
+		params.Set("albumArtist", listen.AlbumArtist)
+	}
+	if listen.Track > 0 {
+		params.Set("trackNumber", strconv.Itoa(listen.Track))
+	}
+	if listen.Duration > 0 {
+		params.Set("duration", strconv.Itoa(int(listen.Duration.Seconds())))
+	}
+	return lf.call(ctx, params)
+}
+
+// Submit scrobbles listens using the indexed batch form of track.scrobble
+func (lf *LastFM) Submit(ctx context.Context, listens []Listen) error {
+	if len(listens) == 0 {
+		return nil
+	}
+	params := url.Values{}
+	params.Set("method", "track.scrobble")
+	for i, listen := range listens {
+		suffix := "[" + strconv.Itoa(i) + "]"
+		params.Set("artist"+suffix, listen.Artist)
+		params.Set("track"+suffix, listen.Title)
+		params.Set("timestamp"+suffix, strconv.FormatInt(listen.ListenedAt.Unix(), 10))
+		if listen.Album != "" {
+			params.Set("album"+suffix, listen.Album)
+		}
+		if listen.AlbumArtist != "" {
+			params.Set("albumArtist"+suffix, listen.AlbumArtist)
+		}
+		if listen.Track > 0 {
+			params.Set("trackNumber"+suffix, strconv.Itoa(listen.Track))
+		}
+		if listen.Duration > 0 {
+			params.Set("duration"+suffix, strconv.Itoa(int(listen.Duration.Seconds())))
+		}
+	}
+	return lf.call(ctx, params)
+}
+
+// sign adds the api_sig parameter: the MD5 of every parameter name and value
+// in name order, followed by the shared secret
+func (lf *LastFM) sign(params url.Values) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteString(params.Get(key))
+	}
+	b.WriteString(lf.apiSecret)
+
+	sum := md5.Sum([]byte(b.String()))
+	params.Set("api_sig", hex.EncodeToString(sum[:]))
+}
+
+type lastFMError struct {
+	Error   int    `json:"error"`
+	Message string `json:"message"`
+}
+
+func (lf *LastFM) call(ctx context.Context, params url.Values) error {
+	params.Set("api_key", lf.apiKey)
+	params.Set("sk", lf.sessionKey)
+	lf.sign(params)
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lf.baseURL+"/2.0/", strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := lf.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting Last.fm: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	// Last.fm reports some failures with a 200 status and an error body
+	var apiErr lastFMError
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != 0 {
+		err := fmt.Errorf("Last.fm error %d: %s", apiErr.Error, apiErr.Message)
+		if apiErr.Error == 6 {
+			// Invalid parameters: this batch will never be accepted
+			return &PermanentError{Err: err}
+		}
+		return err
+	}
+
+	return checkResponse(resp, body)
+}
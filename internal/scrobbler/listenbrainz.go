@@ -0,0 +1,129 @@
+package scrobbler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultListenBrainzURL is the public ListenBrainz API
+const DefaultListenBrainzURL = "https://api.listenbrainz.org"
+
+// ListenBrainz submits listens through the ListenBrainz JSON API
+type ListenBrainz struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewListenBrainz creates a ListenBrainz client. An empty baseURL uses the
+// public server and a nil client uses http.DefaultClient.
+func NewListenBrainz(baseURL, token string, client *http.Client) *ListenBrainz {
+	if baseURL == "" {
+		baseURL = DefaultListenBrainzURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ListenBrainz{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		client:  client,
+	}
+}
+
+func (lb *ListenBrainz) Name() string {
+	return "listenbrainz"
+}
+
+func (lb *ListenBrainz) MaxBatch() int {
+	return 100
+}
+
+type lbPayload struct {
+	ListenType string     `json:"listen_type"`
+	Payload    []lbListen `json:"payload"`
+}
+
+type lbListen struct {
+	ListenedAt    int64      `json:"listened_at,omitempty"`
+	TrackMetadata lbMetadata `json:"track_metadata"`
+}
+
+type lbMetadata struct {
+	ArtistName     string           `json:"artist_name"`
+	TrackName      string           `json:"track_name"`
+	ReleaseName    string           `json:"release_name,omitempty"`
+	AdditionalInfo lbAdditionalInfo `json:"additional_info"`
+}
+
+type lbAdditionalInfo struct {
+	DurationMs       int64  `json:"duration_ms,omitempty"`
+	TrackNumber      int    `json:"tracknumber,omitempty"`
+	SubmissionClient string `json:"submission_client"`
+}
+
+// NowPlaying sends a playing_now listen, which has no timestamp
+func (lb *ListenBrainz) NowPlaying(ctx context.Context, listen Listen) error {
+	item := lb.convert(listen)
+	item.ListenedAt = 0
+	return lb.post(ctx, lbPayload{ListenType: "playing_now", Payload: []lbListen{item}})
+}
+
+// Submit sends completed listens, as "single" for one and "import" for more
+func (lb *ListenBrainz) Submit(ctx context.Context, listens []Listen) error {
+	if len(listens) == 0 {
+		return nil
+	}
+	payload := lbPayload{ListenType: "import"}
+	if len(listens) == 1 {
+		payload.ListenType = "single"
+	}
+	for _, listen := range listens {
+		payload.Payload = append(payload.Payload, lb.convert(listen))
+	}
+	return lb.post(ctx, payload)
+}
+
+func (lb *ListenBrainz) convert(listen Listen) lbListen {
+	return lbListen{
+		ListenedAt: listen.ListenedAt.Unix(),
+		TrackMetadata: lbMetadata{
+			ArtistName:  listen.Artist,
+			TrackName:   listen.Title,
+			ReleaseName: listen.Album,
+			AdditionalInfo: lbAdditionalInfo{
+				DurationMs:       listen.Duration.Milliseconds(),
+				TrackNumber:      listen.Track,
+				SubmissionClient: "clispot",
+			},
+		},
+	}
+}
+
+func (lb *ListenBrainz) post(ctx context.Context, payload lbPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &PermanentError{Err: fmt.Errorf("error encoding listens: %v", err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lb.baseURL+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Token "+lb.token)
+
+	resp, err := lb.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting ListenBrainz: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	return checkResponse(resp, respBody)
+}
@@ -0,0 +1,101 @@
+package scrobbler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"clispot/internal/persist"
+)
+
+// Queue is a durable FIFO of listens waiting to be submitted, stored as a
+// JSON array and rewritten atomically on every change
+type Queue struct {
+	path string
+}
+
+// NewQueue returns the queue for the named service inside dir
+func NewQueue(dir, name string) *Queue {
+	safeName := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, strings.ToLower(name))
+	return &Queue{
+		path: filepath.Join(dir, "scrobble-queue-"+safeName+".json"),
+	}
+}
+
+// Push appends listen to the end of the queue
+func (q *Queue) Push(listen Listen) error {
+	return q.update(func(listens []Listen) []Listen {
+		return append(listens, listen)
+	})
+}
+
+// Peek returns up to n listens from the front of the queue; n <= 0 returns all
+func (q *Queue) Peek(n int) ([]Listen, error) {
+	data, err := persist.ReadFile(q.path, validQueue)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error reading scrobble queue: %v", err)
+	}
+
+	var listens []Listen
+	if err := json.Unmarshal(data, &listens); err != nil {
+		return nil, fmt.Errorf("error parsing scrobble queue: %v", err)
+	}
+	if n > 0 && len(listens) > n {
+		listens = listens[:n]
+	}
+	return listens, nil
+}
+
+// Remove drops submitted from the queue. Listens are matched by what they
+// are rather than by position, as another clispot instance may have pushed
+// or removed some since they were peeked.
+func (q *Queue) Remove(submitted []Listen) error {
+	return q.update(func(listens []Listen) []Listen {
+		kept := []Listen{}
+		removed := make([]bool, len(submitted))
+	next:
+		for _, listen := range listens {
+			for i, done := range submitted {
+				if !removed[i] && sameListen(listen, done) {
+					removed[i] = true
+					continue next
+				}
+			}
+			kept = append(kept, listen)
+		}
+		return kept
+	})
+}
+
+// sameListen reports whether a and b are the same play of the same track
+func sameListen(a, b Listen) bool {
+	return a.Artist == b.Artist && a.Title == b.Title && a.ListenedAt.Equal(b.ListenedAt)
+}
+
+func (q *Queue) update(fn func([]Listen) []Listen) error {
+	return persist.Update(q.path, 0600, validQueue, func(data []byte) ([]byte, error) {
+		var listens []Listen
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &listens); err != nil {
+				return nil, fmt.Errorf("error parsing scrobble queue: %v", err)
+			}
+		}
+		return json.MarshalIndent(fn(listens), "", "  ")
+	})
+}
+
+// validQueue rejects files that do not decode, so reads fall back to the backup
+func validQueue(data []byte) error {
+	var listens []Listen
+	return json.Unmarshal(data, &listens)
+}
@@ -0,0 +1,387 @@
+package scrobbler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"clispot/internal/library"
+	"clispot/internal/player"
+	"clispot/internal/settings"
+)
+
+// MinTrackLength is the shortest track that is ever scrobbled
+const MinTrackLength = 30 * time.Second
+
+// MaxRequiredListen caps the listening time needed for a scrobble
+const MaxRequiredListen = 4 * time.Minute
+
+// Listen is one track submission
+type Listen struct {
+	Artist string `json:"artist"`
+	Title  string `json:"title"`
+	Album  string `json:"album,omitempty"`
+	// AlbumArtist is only set when it differs from Artist
+	AlbumArtist string        `json:"album_artist,omitempty"`
+	Track       int           `json:"track,omitempty"`
+	Duration    time.Duration `json:"duration"`
+	ListenedAt  time.Time     `json:"listened_at"`
+}
+
+// Service submits listens to one scrobbling backend
+type Service interface {
+	Name() string
+	NowPlaying(ctx context.Context, listen Listen) error
+	Submit(ctx context.Context, listens []Listen) error
+	// MaxBatch is the largest number of listens accepted by one Submit
+	MaxBatch() int
+}
+
+// PermanentError marks a rejection that retrying will not fix, such as a
+// malformed listen. The rejected batch is dropped from the queue.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// ShouldScrobble applies the standard rule: the track must be longer than
+// 30 seconds and have played for half its length or four minutes,
+// whichever comes first
+func ShouldScrobble(duration, listened time.Duration) bool {
+	if duration <= MinTrackLength {
+		return false
+	}
+	required := duration / 2
+	if required > MaxRequiredListen {
+		required = MaxRequiredListen
+	}
+	return listened >= required
+}
+
+// checkResponse turns an HTTP status into an error, treating client errors
+// other than rate limiting as permanent
+func checkResponse(resp *http.Response, body []byte) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err := fmt.Errorf("server returned %s: %s", resp.Status, truncate(string(body), 200))
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		// Bad credentials are fixed by the user, not by dropping listens
+		return err
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return &PermanentError{Err: err}
+	}
+	return err
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…"
+}
+
+// Scrobbler feeds player transitions to one or more services. Completed
+// listens go through a durable queue per service and are retried with
+// exponential backoff while the service is unreachable.
+type Scrobbler struct {
+	queues  []*serviceQueue
+	lookup  func(path string) (library.Song, bool)
+	started map[string]time.Time
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+
+	// MinBackoff and MaxBackoff bound the delay between failed attempts
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnError is called with submission errors; it may be nil
+	OnError func(service string, err error)
+}
+
+type serviceQueue struct {
+	service   Service
+	queue     *Queue
+	failures  int
+	nextRetry time.Time
+}
+
+// New creates a scrobbler for services, keeping each service's queue in
+// queueDir. lookup supplies song details for the player's file paths.
+func New(queueDir string, services []Service, lookup func(path string) (library.Song, bool)) *Scrobbler {
+	s := &Scrobbler{
+		lookup:     lookup,
+		started:    make(map[string]time.Time),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		MinBackoff: 30 * time.Second,
+		MaxBackoff: 30 * time.Minute,
+	}
+	for _, service := range services {
+		s.queues = append(s.queues, &serviceQueue{
+			service: service,
+			queue:   NewQueue(queueDir, service.Name()),
+		})
+	}
+	return s
+}
+
+// Start launches the background submitter, which first flushes anything
+// left in the queues from earlier runs
+func (s *Scrobbler) Start() {
+	go s.run()
+	s.kick()
+}
+
+// Close stops the background submitter; queued listens stay on disk
+func (s *Scrobbler) Close() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	close(s.done)
+	<-s.stopped
+}
+
+// HandleEvent is a player listener; register it with player.AddListener
+func (s *Scrobbler) HandleEvent(event player.PlaybackEvent) {
+	switch event.Type {
+	case player.EventStart:
+		s.started[event.Song] = event.Time
+		listen, ok := s.listenFor(event.Song, event.Duration, event.Time)
+		if !ok {
+			return
+		}
+		go s.nowPlaying(listen)
+
+	case player.EventEnd:
+		startedAt, ok := s.started[event.Song]
+		if !ok {
+			startedAt = event.Time.Add(-event.Listened)
+		}
+		delete(s.started, event.Song)
+
+		if !ShouldScrobble(event.Duration, event.Listened) {
+			return
+		}
+		listen, ok := s.listenFor(event.Song, event.Duration, startedAt)
+		if !ok {
+			return
+		}
+		s.Enqueue(listen)
+	}
+}
+
+// Enqueue stores listen in every service's queue and wakes the submitter
+func (s *Scrobbler) Enqueue(listen Listen) {
+	for _, sq := range s.queues {
+		if err := sq.queue.Push(listen); err != nil {
+			s.reportError(sq.service.Name(), err)
+		}
+	}
+	s.kick()
+}
+
+func (s *Scrobbler) listenFor(path string, duration time.Duration, at time.Time) (Listen, bool) {
+	song, ok := s.lookup(path)
+	if !ok || song.Artist == "" || song.Title == "" || song.Artist == "Unknown Artist" {
+		return Listen{}, false
+	}
+	if song.Duration > 0 {
+		duration = song.Duration
+	}
+	album := song.Album
+	if album == "Unknown Album" {
+		album = ""
+	}
+	albumArtist := song.GroupArtist()
+	if albumArtist == song.Artist {
+		albumArtist = ""
+	}
+	return Listen{
+		AlbumArtist: albumArtist,
+		Artist:      song.Artist,
+		Title:       song.Title,
+		Album:       album,
+		Track:       song.Track,
+		Duration:    duration,
+		ListenedAt:  at,
+	}, true
+}
+
+func (s *Scrobbler) nowPlaying(listen Listen) {
+	for _, sq := range s.queues {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := sq.service.NowPlaying(ctx, listen); err != nil {
+			s.reportError(sq.service.Name(), err)
+		}
+		cancel()
+	}
+}
+
+func (s *Scrobbler) kick() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scrobbler) run() {
+	defer close(s.stopped)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+
+		next := s.flush()
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next.IsZero() {
+			timer.Reset(time.Hour)
+		} else {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// flush submits queued listens for every service that is not backing off
+// and returns the earliest pending retry time, or zero if none is pending
+func (s *Scrobbler) flush() time.Time {
+	var next time.Time
+	for _, sq := range s.queues {
+		if time.Now().Before(sq.nextRetry) {
+			next = earliest(next, sq.nextRetry)
+			continue
+		}
+		if err := s.flushQueue(sq); err != nil {
+			s.reportError(sq.service.Name(), err)
+			sq.failures++
+			sq.nextRetry = time.Now().Add(s.backoff(sq.failures))
+			next = earliest(next, sq.nextRetry)
+			continue
+		}
+		sq.failures = 0
+		sq.nextRetry = time.Time{}
+	}
+	return next
+}
+
+func (s *Scrobbler) flushQueue(sq *serviceQueue) error {
+	for {
+		select {
+		case <-s.done:
+			return nil
+		default:
+		}
+
+		batch, err := sq.queue.Peek(sq.service.MaxBatch())
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = sq.service.Submit(ctx, batch)
+		cancel()
+
+		var permanent *PermanentError
+		if err != nil && !errors.As(err, &permanent) {
+			return err
+		}
+		if err != nil {
+			s.reportError(sq.service.Name(), fmt.Errorf("dropping %d rejected listens: %v", len(batch), err))
+		}
+		if err := sq.queue.Remove(batch); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Scrobbler) backoff(failures int) time.Duration {
+	delay := s.MinBackoff
+	for i := 1; i < failures && delay < s.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.MaxBackoff {
+		delay = s.MaxBackoff
+	}
+	return delay
+}
+
+func (s *Scrobbler) reportError(service string, err error) {
+	if s.OnError != nil {
+		s.OnError(service, err)
+	}
+}
+
+// Pending returns the number of queued listens per service name
+func (s *Scrobbler) Pending() map[string]int {
+	pending := make(map[string]int)
+	for _, sq := range s.queues {
+		listens, err := sq.queue.Peek(0)
+		if err == nil {
+			pending[sq.service.Name()] = len(listens)
+		}
+	}
+	return pending
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// ServicesFromSettings builds the enabled services in configs. A nil client
+// uses http.DefaultClient.
+func ServicesFromSettings(configs []settings.ScrobblerConfig, client *http.Client) ([]Service, error) {
+	var services []Service
+	for _, cfg := range configs {
+		if !cfg.Enabled {
+			continue
+		}
+		switch strings.ToLower(cfg.Service) {
+		case "listenbrainz":
+			if cfg.Token == "" {
+				return nil, fmt.Errorf("listenbrainz scrobbler needs a token")
+			}
+			services = append(services, NewListenBrainz(cfg.BaseURL, cfg.Token, client))
+		case "lastfm", "last.fm":
+			if cfg.APIKey == "" || cfg.APISecret == "" || cfg.SessionKey == "" {
+				return nil, fmt.Errorf("lastfm scrobbler needs api_key, api_secret and session_key")
+			}
+			services = append(services, NewLastFM(cfg.BaseURL, cfg.APIKey, cfg.APISecret, cfg.SessionKey, client))
+		default:
+			return nil, fmt.Errorf("unknown scrobbler service %q", cfg.Service)
+		}
+	}
+	return services, nil
+}
@@ -0,0 +1,412 @@
+package scrobbler
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"clispot/internal/library"
+	"clispot/internal/player"
+)
+
+var testListen = Listen{
+	Artist:      "Miles Davis",
+	Title:       "So What",
+	Album:       "Kind of Blue",
+	AlbumArtist: "Various Artists",
+	Track:       1,
+	Duration:    9*time.Minute + 22*time.Second,
+	ListenedAt:  time.Unix(1700000000, 0),
+}
+
+// formServer records the form of every request it gets and answers with
+// status and body
+type formServer struct {
+	mu    sync.Mutex
+	forms []url.Values
+}
+
+func (f *formServer) start(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/2.0/" {
+			t.Errorf("got %s %s, want POST /2.0/", r.Method, r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("error parsing form: %v", err)
+		}
+		f.mu.Lock()
+		f.forms = append(f.forms, r.PostForm)
+		f.mu.Unlock()
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// lastFMSignature works out api_sig as the Last.fm documentation describes
+func lastFMSignature(form url.Values, secret string) string {
+	var keys []string
+	for key := range form {
+		if key != "api_sig" && key != "format" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	text := ""
+	for _, key := range keys {
+		text += key + form.Get(key)
+	}
+	sum := md5.Sum([]byte(text + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLastFMScrobble(t *testing.T) {
+	var f formServer
+	srv := f.start(t, http.StatusOK, `{"scrobbles":{}}`)
+	lf := NewLastFM(srv.URL, "key", "secret", "session", srv.Client())
+
+	second := testListen
+	second.Title = "Freddie Freeloader"
+	second.Album = ""
+	second.AlbumArtist = ""
+	second.Track = 0
+	second.ListenedAt = testListen.ListenedAt.Add(10 * time.Minute)
+	if err := lf.Submit(context.Background(), []Listen{testListen, second}); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	if len(f.forms) != 1 {
+		t.Fatalf("got %d requests, want 1", len(f.forms))
+	}
+	form := f.forms[0]
+	want := map[string]string{
+		"method":         "track.scrobble",
+		"api_key":        "key",
+		"sk":             "session",
+		"format":         "json",
+		"artist[0]":      "Miles Davis",
+		"track[0]":       "So What",
+		"album[0]":       "Kind of Blue",
+		"albumArtist[0]": "Various Artists",
+		"trackNumber[0]": "1",
+		"duration[0]":    "562",
+		"timestamp[0]":   "1700000000",
+		"artist[1]":      "Miles Davis",
+		"track[1]":       "Freddie Freeloader",
+		"timestamp[1]":   "1700000600",
+	}
+	for key, value := range want {
+		if got := form.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	for _, key := range []string{"album[1]", "albumArtist[1]", "trackNumber[1]"} {
+		if form.Has(key) {
+			t.Errorf("%s is set for a listen without it", key)
+		}
+	}
+	if got, want := form.Get("api_sig"), lastFMSignature(form, "secret"); got != want {
+		t.Errorf("api_sig = %s, want %s", got, want)
+	}
+}
+
+func TestLastFMNowPlaying(t *testing.T) {
+	var f formServer
+	srv := f.start(t, http.StatusOK, `{"nowplaying":{}}`)
+	lf := NewLastFM(srv.URL, "key", "secret", "session", srv.Client())
+
+	if err := lf.NowPlaying(context.Background(), testListen); err != nil {
+		t.Fatalf("NowPlaying: %v", err)
+	}
+	form := f.forms[0]
+	want := map[string]string{
+		"method":      "track.updateNowPlaying",
+		"artist":      "Miles Davis",
+		"track":       "So What",
+		"album":       "Kind of Blue",
+		"albumArtist": "Various Artists",
+		"trackNumber": "1",
+		"duration":    "562",
+	}
+	for key, value := range want {
+		if got := form.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if form.Has("timestamp") {
+		t.Error("now playing carries a timestamp")
+	}
+	if got, want := form.Get("api_sig"), lastFMSignature(form, "secret"); got != want {
+		t.Errorf("api_sig = %s, want %s", got, want)
+	}
+}
+
+func TestLastFMErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		permanent bool
+	}{
+		{"invalid parameters", http.StatusOK, `{"error":6,"message":"Invalid parameters"}`, true},
+		{"service offline", http.StatusOK, `{"error":11,"message":"Service Offline"}`, false},
+		{"bad session", http.StatusForbidden, `{"error":9,"message":"Invalid session key"}`, false},
+		{"server error", http.StatusBadGateway, `bad gateway`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f formServer
+			srv := f.start(t, tt.status, tt.body)
+			err := NewLastFM(srv.URL, "key", "secret", "session", srv.Client()).Submit(context.Background(), []Listen{testListen})
+			if err == nil {
+				t.Fatal("Submit succeeded")
+			}
+			var permanent *PermanentError
+			if got := errors.As(err, &permanent); got != tt.permanent {
+				t.Errorf("permanent = %v, want %v (%v)", got, tt.permanent, err)
+			}
+		})
+	}
+}
+
+// listenBrainzServer records the payloads posted to it. It fails with
+// 503 until fail requests have been made.
+type listenBrainzServer struct {
+	mu       sync.Mutex
+	payloads []map[string]any
+	requests atomic.Int32
+	fail     int32
+}
+
+func (l *listenBrainzServer) start(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/submit-listens" {
+			t.Errorf("got path %s, want /1/submit-listens", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Token secret-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if l.requests.Add(1) <= l.fail {
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+			return
+		}
+		var payload map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Errorf("error decoding payload: %v", err)
+		}
+		l.mu.Lock()
+		l.payloads = append(l.payloads, payload)
+		l.mu.Unlock()
+		w.Write([]byte(`{"status":"ok"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (l *listenBrainzServer) received() []map[string]any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]map[string]any(nil), l.payloads...)
+}
+
+func TestListenBrainzPayloads(t *testing.T) {
+	var l listenBrainzServer
+	srv := l.start(t)
+	lb := NewListenBrainz(srv.URL, "secret-token", srv.Client())
+	ctx := context.Background()
+
+	if err := lb.Submit(ctx, []Listen{testListen}); err != nil {
+		t.Fatalf("Submit one: %v", err)
+	}
+	if err := lb.NowPlaying(ctx, testListen); err != nil {
+		t.Fatalf("NowPlaying: %v", err)
+	}
+	if err := lb.Submit(ctx, []Listen{testListen, testListen, testListen}); err != nil {
+		t.Fatalf("Submit three: %v", err)
+	}
+
+	payloads := l.received()
+	if len(payloads) != 3 {
+		t.Fatalf("got %d payloads, want 3", len(payloads))
+	}
+	wantTypes := []string{"single", "playing_now", "import"}
+	wantCounts := []int{1, 1, 3}
+	for i, payload := range payloads {
+		if got := payload["listen_type"]; got != wantTypes[i] {
+			t.Errorf("payload %d: listen_type = %v, want %s", i, got, wantTypes[i])
+		}
+		listens := payload["payload"].([]any)
+		if len(listens) != wantCounts[i] {
+			t.Errorf("payload %d: %d listens, want %d", i, len(listens), wantCounts[i])
+		}
+	}
+
+	single := payloads[0]["payload"].([]any)[0].(map[string]any)
+	if got := single["listened_at"]; got != float64(1700000000) {
+		t.Errorf("listened_at = %v", got)
+	}
+	metadata := single["track_metadata"].(map[string]any)
+	if metadata["artist_name"] != "Miles Davis" || metadata["track_name"] != "So What" || metadata["release_name"] != "Kind of Blue" {
+		t.Errorf("track_metadata = %v", metadata)
+	}
+	info := metadata["additional_info"].(map[string]any)
+	if info["duration_ms"] != float64(562000) || info["tracknumber"] != float64(1) || info["submission_client"] != "clispot" {
+		t.Errorf("additional_info = %v", info)
+	}
+
+	playing := payloads[1]["payload"].([]any)[0].(map[string]any)
+	if _, ok := playing["listened_at"]; ok {
+		t.Error("playing_now carries listened_at")
+	}
+}
+
+func lookupSong(path string) (library.Song, bool) {
+	return library.Song{
+		FilePath: path,
+		Artist:   "Miles Davis",
+		Title:    "So What",
+		Album:    "Kind of Blue",
+		Duration: 9*time.Minute + 22*time.Second,
+	}, true
+}
+
+func TestQueuePersistsAndDrainsAfterBackoff(t *testing.T) {
+	dir := t.TempDir()
+	var l listenBrainzServer
+	l.fail = 2
+	srv := l.start(t)
+	services := []Service{NewListenBrainz(srv.URL, "secret-token", srv.Client())}
+
+	// Listens queued while no submitter runs stay on disk
+	first := New(dir, services, lookupSong)
+	first.Enqueue(testListen)
+	first.Enqueue(testListen)
+	if got := first.Pending()["listenbrainz"]; got != 2 {
+		t.Fatalf("pending = %d, want 2", got)
+	}
+
+	// A new scrobbler over the same folder finds them and, once the server
+	// recovers, submits them
+	second := New(dir, services, lookupSong)
+	second.MinBackoff = 10 * time.Millisecond
+	second.MaxBackoff = 20 * time.Millisecond
+	var errs atomic.Int32
+	second.OnError = func(service string, err error) { errs.Add(1) }
+	second.Start()
+	defer second.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for second.Pending()["listenbrainz"] > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue still holds %d listens", second.Pending()["listenbrainz"])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := errs.Load(); got != 2 {
+		t.Errorf("got %d errors, want one per failed attempt (2)", got)
+	}
+	payloads := l.received()
+	if len(payloads) != 1 || payloads[0]["listen_type"] != "import" {
+		t.Errorf("got payloads %v, want one import of both listens", payloads)
+	}
+}
+
+func TestQueueRemoveKeepsListensPushedSincePeek(t *testing.T) {
+	queue := NewQueue(t.TempDir(), "listenbrainz")
+	later := testListen
+	later.ListenedAt = testListen.ListenedAt.Add(10 * time.Minute)
+
+	if err := queue.Push(testListen); err != nil {
+		t.Fatal(err)
+	}
+	batch, err := queue.Peek(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance drains the queue and a new listen arrives before
+	// this one removes what it submitted
+	if err := queue.Remove(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Push(later); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Remove(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	listens, err := queue.Peek(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 1 || !listens[0].ListenedAt.Equal(later.ListenedAt) {
+		t.Errorf("queue holds %v, want only the later listen", listens)
+	}
+}
+
+func TestShouldScrobble(t *testing.T) {
+	tests := []struct {
+		duration, listened time.Duration
+		want               bool
+	}{
+		{30 * time.Second, 30 * time.Second, false},
+		{31 * time.Second, 15 * time.Second, false},
+		{31 * time.Second, 16 * time.Second, true},
+		{3 * time.Minute, 89 * time.Second, false},
+		{3 * time.Minute, 90 * time.Second, true},
+		{20 * time.Minute, 4*time.Minute - time.Second, false},
+		{20 * time.Minute, 4 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		if got := ShouldScrobble(tt.duration, tt.listened); got != tt.want {
+			t.Errorf("ShouldScrobble(%v, %v) = %v, want %v", tt.duration, tt.listened, got, tt.want)
+		}
+	}
+}
+
+func TestHandleEventQueuesFinishedListens(t *testing.T) {
+	var l listenBrainzServer
+	srv := l.start(t)
+	dir := t.TempDir()
+	s := New(dir, []Service{NewListenBrainz(srv.URL, "secret-token", srv.Client())}, lookupSong)
+
+	duration := 9*time.Minute + 22*time.Second
+	start := time.Unix(1700000000, 0)
+	for _, listened := range []time.Duration{3 * time.Minute, 4 * time.Minute} {
+		s.HandleEvent(player.PlaybackEvent{Type: player.EventStart, Song: "/music/so-what.mp3", Time: start, Duration: duration})
+		s.HandleEvent(player.PlaybackEvent{
+			Type:     player.EventEnd,
+			Song:     "/music/so-what.mp3",
+			Time:     start.Add(listened),
+			Duration: duration,
+			Listened: listened,
+		})
+	}
+
+	// Only the listen past four minutes counts
+	listens, err := NewQueue(dir, "listenbrainz").Peek(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listens) != 1 {
+		t.Fatalf("queued %d listens, want 1", len(listens))
+	}
+	if !listens[0].ListenedAt.Equal(start) {
+		t.Errorf("ListenedAt = %v, want the start %v", listens[0].ListenedAt, start)
+	}
+}
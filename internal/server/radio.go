@@ -0,0 +1,341 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"clispot/internal/library"
+	"clispot/internal/player"
+)
+
+const (
+	// radioLead is how far ahead of the player frames are sent, so
+	// listeners don't run dry between sends
+	radioLead = 500 * time.Millisecond
+	// radioDrift is how far the stream may get from the player, as after
+	// a seek, before it jumps to where the player is
+	radioDrift = 3 * time.Second
+	// radioBacklog is how many of the latest frames, about two seconds,
+	// a new listener gets at once so its player can start straight away
+	radioBacklog = 80
+	// listenerBuffer is how many frames a listener can fall behind before
+	// it misses some
+	listenerBuffer = 512
+	// icyMetaInt is how many bytes of audio go between ICY metadata blocks
+	icyMetaInt = 16000
+)
+
+// NowPlaying is what the local player is doing, as the radio follows it
+type NowPlaying struct {
+	// File is the audio file playing, empty when nothing is
+	File string
+	// Position is how far into File playback is; for a CUE track that
+	// includes the track's start
+	Position time.Duration
+	Playing  bool
+	// Title is sent to listeners that ask for ICY metadata
+	Title string
+}
+
+// Playing returns what the radio should play while song plays as state
+// reports, for Update
+func Playing(song library.Song, state player.PlaybackState) NowPlaying {
+	now := NowPlaying{
+		File:     song.AudioPath(),
+		Position: song.Start + state.Position,
+		Playing:  state.IsPlaying,
+		Title:    song.Title,
+	}
+	if song.Artist != "" {
+		now.Title = song.Artist + " - " + song.Title
+	}
+	return now
+}
+
+// Radio streams whatever the local player is playing as one endless MP3
+// stream. The frames of the file playing are passed through as they are,
+// in real time, with silence while nothing plays. The player reports to it
+// through Update.
+type Radio struct {
+	mu        sync.Mutex
+	now       NowPlaying
+	updated   time.Time
+	listeners map[chan []byte]bool
+	backlog   [][]byte
+	running   bool
+}
+
+func NewRadio() *Radio {
+	return &Radio{listeners: make(map[chan []byte]bool)}
+}
+
+// Update tells the radio what the player is doing. It is called every
+// time the player's position is read, and positions in between are
+// worked out from the clock.
+func (r *Radio) Update(now NowPlaying) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = now
+	r.updated = time.Now()
+}
+
+// Listeners returns how many clients are listening
+func (r *Radio) Listeners() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.listeners)
+}
+
+// current returns what the player should be doing by now
+func (r *Radio) current() NowPlaying {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now
+	if now.Playing {
+		now.Position += time.Since(r.updated)
+	}
+	return now
+}
+
+func (r *Radio) title() string {
+	return r.current().Title
+}
+
+// listen adds a listener, starting the broadcast if it is the first
+func (r *Radio) listen() chan []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	frames := make(chan []byte, listenerBuffer)
+	for _, frame := range r.backlog {
+		frames <- frame
+	}
+	r.listeners[frames] = true
+	if !r.running {
+		r.running = true
+		r.backlog = nil
+		go r.broadcast()
+	}
+	return frames
+}
+
+func (r *Radio) leave(frames chan []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.listeners, frames)
+}
+
+// send hands a frame to every listener, dropping it for those too far
+// behind. It reports false once nobody is listening, which ends the
+// broadcast.
+func (r *Radio) send(frame []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.listeners) == 0 {
+		r.running = false
+		r.backlog = nil
+		return false
+	}
+	for frames := range r.listeners {
+		select {
+		case frames <- frame:
+		default:
+		}
+	}
+	r.backlog = append(r.backlog, frame)
+	if len(r.backlog) > radioBacklog {
+		r.backlog = r.backlog[len(r.backlog)-radioBacklog:]
+	}
+	return true
+}
+
+// broadcast sends frames in real time for as long as anyone listens
+func (r *Radio) broadcast() {
+	var src radioSource
+	defer src.close()
+
+	next := time.Now()
+	for {
+		frame, length := src.frame(r.current())
+		if !r.send(frame) {
+			return
+		}
+		next = next.Add(length)
+		if wait := time.Until(next) - radioLead; wait > 0 {
+			time.Sleep(wait)
+		} else if wait < -time.Second {
+			// Fell behind, as when the machine was suspended
+			next = time.Now()
+		}
+	}
+}
+
+// radioSource reads the frames of the file the player is on, keeping up
+// with its position
+type radioSource struct {
+	path     string
+	file     *os.File
+	frames   *library.FrameReader
+	position time.Duration
+	ended    bool
+	last     []byte
+}
+
+// frame returns the next frame to send, given what the player is doing
+func (s *radioSource) frame(now NowPlaying) ([]byte, time.Duration) {
+	if !now.Playing || now.File == "" {
+		s.close()
+		return library.SilentFrame(s.last)
+	}
+
+	target := now.Position + radioLead
+	if now.File != s.path || s.position < target-radioDrift || s.position > target+radioDrift {
+		s.open(now.File, target)
+	}
+	if !s.ended {
+		frame, length, err := s.frames.Next()
+		if err == nil {
+			s.position += length
+			s.last = frame
+			return frame, length
+		}
+		s.ended = true
+	}
+
+	// Past the end of the file until the player moves on
+	frame, length := library.SilentFrame(s.last)
+	s.position += length
+	return frame, length
+}
+
+// open starts reading path at position
+func (s *radioSource) open(path string, position time.Duration) {
+	s.close()
+	s.path = path
+	s.ended = true
+
+	file, err := os.Open(path)
+	if err != nil {
+		s.position = position
+		return
+	}
+	s.file = file
+	s.frames = library.NewFrameReader(file)
+	skipped, err := s.frames.Skip(position)
+	s.position = skipped
+	s.ended = err != nil
+	if s.ended {
+		s.position = position
+	}
+}
+
+func (s *radioSource) close() {
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.path = ""
+	s.file = nil
+	s.frames = nil
+}
+
+// ServeHTTP streams the radio to one listener until it hangs up. Clients
+// that send "Icy-MetaData: 1" get the title of what plays in the stream.
+func (r *Radio) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	metaint := 0
+	if req.Header.Get("Icy-MetaData") == "1" {
+		metaint = icyMetaInt
+		w.Header().Set("icy-metaint", strconv.Itoa(metaint))
+	}
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("icy-name", "clispot")
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+
+	frames := r.listen()
+	defer r.leave(frames)
+
+	var out io.Writer = w
+	if metaint > 0 {
+		out = &icyWriter{w: w, metaint: metaint, left: metaint, title: r.title}
+	}
+	flusher, _ := w.(http.Flusher)
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case frame := <-frames:
+			if _, err := out.Write(frame); err != nil {
+				return
+			}
+			// Send what has piled up in one go
+			for len(frames) > 0 {
+				if _, err := out.Write(<-frames); err != nil {
+					return
+				}
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// icyWriter puts a metadata block after every metaint bytes of audio, as
+// Shoutcast and Icecast servers do. The block is empty unless the title
+// has changed since the last one.
+type icyWriter struct {
+	w       io.Writer
+	metaint int
+	left    int
+	title   func() string
+	sent    string
+}
+
+func (w *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(w.left, len(p))
+		if _, err := w.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		w.left -= n
+		p = p[n:]
+		if w.left == 0 {
+			if _, err := w.w.Write(w.metadata()); err != nil {
+				return written, err
+			}
+			w.left = w.metaint
+		}
+	}
+	return written, nil
+}
+
+// metadata returns the next block: a length byte counting 16 byte units,
+// then StreamTitle='…'; padded with zeros
+func (w *icyWriter) metadata() []byte {
+	title := w.title()
+	if title == w.sent {
+		return []byte{0}
+	}
+	w.sent = title
+
+	// The block can't be longer than 255 units
+	if len(title) > 4000 {
+		title = title[:4000]
+	}
+	text := "StreamTitle='" + strings.ReplaceAll(title, "'", "’") + "';"
+	units := (len(text) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], text)
+	return block
+}
@@ -0,0 +1,496 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"clispot/internal/library"
+	"clispot/internal/playlist"
+)
+
+// Options configures what a Server offers besides the library itself
+type Options struct {
+	// PlaylistDir holds the saved playlists. They are read again on every
+	// request, so playlists edited in the player show up straight away.
+	PlaylistDir string
+	// Radio, if not nil, is served at /radio
+	Radio *Radio
+	// Log, if not nil, gets a line for every request
+	Log *log.Logger
+}
+
+// Server serves a library over HTTP: a JSON index to browse it by, the
+// files themselves with range requests, their cover art and the saved
+// playlists as M3U. It only serves files that are songs in the library.
+type Server struct {
+	opts Options
+	mux  *http.ServeMux
+	root string
+
+	// lib browses a copy of the songs so the player's own browse state
+	// is left alone; mu guards it and songs, which are keyed by ID
+	mu    sync.Mutex
+	lib   *library.Library
+	songs map[string]library.Song
+}
+
+func New(root string, songs []library.Song, opts Options) *Server {
+	s := &Server{
+		opts: opts,
+		mux:  http.NewServeMux(),
+		root: root,
+		lib:  library.NewLibrary(root),
+	}
+	s.SetSongs(songs)
+
+	s.mux.HandleFunc("GET /{$}", s.handleIndex)
+	s.mux.HandleFunc("GET /api/songs", s.handleSongs)
+	s.mux.HandleFunc("GET /api/browse/{view}", s.handleBrowse)
+	s.mux.HandleFunc("GET /api/playlists", s.handlePlaylists)
+	s.mux.HandleFunc("GET /playlists/{name}", s.handlePlaylistM3U)
+	s.mux.HandleFunc("GET /stream/{id...}", s.handleStream)
+	s.mux.HandleFunc("GET /cover/{id...}", s.handleCover)
+	if opts.Radio != nil {
+		s.mux.Handle("GET /radio", opts.Radio)
+	}
+	return s
+}
+
+// SetSongs replaces the songs served, as after a rescan
+func (s *Server) SetSongs(songs []library.Song) {
+	index := make(map[string]library.Song, len(songs))
+	for _, song := range songs {
+		index[s.songID(song)] = song
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lib.SetSongs(songs)
+	s.songs = index
+}
+
+// Radio returns the radio served at /radio, nil if there is none
+func (s *Server) Radio() *Radio {
+	return s.opts.Radio
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Log != nil {
+		s.opts.Log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL.RequestURI())
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// songID names a song by its path under the library root, with slashes;
+// a CUE track keeps its "#03" suffix
+func (s *Server) songID(song library.Song) string {
+	rel, err := filepath.Rel(s.root, song.FilePath)
+	if err != nil {
+		rel = song.FilePath
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (s *Server) song(id string) (library.Song, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	song, ok := s.songs[id]
+	return song, ok
+}
+
+// songJSON is a song in the index. Times are in seconds.
+type songJSON struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Artist      string  `json:"artist"`
+	Album       string  `json:"album"`
+	AlbumArtist string  `json:"album_artist,omitempty"`
+	Year        string  `json:"year,omitempty"`
+	Genre       string  `json:"genre,omitempty"`
+	Track       int     `json:"track,omitempty"`
+	Disc        int     `json:"disc,omitempty"`
+	Duration    float64 `json:"duration"`
+	// Stream serves the whole file, so a CUE track also gives where in
+	// it the track plays, and Stream ends in a #t= media fragment
+	Start  float64 `json:"start,omitempty"`
+	End    float64 `json:"end,omitempty"`
+	Stream string  `json:"stream"`
+	Cover  string  `json:"cover"`
+}
+
+func (s *Server) songJSON(song library.Song) songJSON {
+	id := s.songID(song)
+	j := songJSON{
+		ID:          id,
+		Title:       song.Title,
+		Artist:      song.Artist,
+		Album:       song.Album,
+		AlbumArtist: song.AlbumArtist,
+		Year:        song.Year,
+		Genre:       song.Genre,
+		Track:       song.Track,
+		Disc:        song.Disc,
+		Duration:    song.Duration.Seconds(),
+		Stream:      "/stream/" + escapeID(id),
+		Cover:       "/cover/" + escapeID(id),
+	}
+	if song.IsCueTrack() {
+		j.Start = song.Start.Seconds()
+		j.End = song.End.Seconds()
+		j.Stream += mediaFragment(song)
+	}
+	return j
+}
+
+// escapeID escapes each segment of a song ID for use in a URL path
+func escapeID(id string) string {
+	segments := strings.Split(id, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// mediaFragment limits playback of a CUE track's file to the track, for
+// players such as browsers that understand "#t=start,end"
+func mediaFragment(song library.Song) string {
+	if song.End > 0 {
+		return fmt.Sprintf("#t=%g,%g", song.Start.Seconds(), song.End.Seconds())
+	}
+	return fmt.Sprintf("#t=%g", song.Start.Seconds())
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	count := len(s.songs)
+	s.mu.Unlock()
+
+	views := make(map[string]string)
+	for _, mode := range library.BrowseModes {
+		name := strings.ToLower(mode.String())
+		views[name] = "/api/browse/" + name
+	}
+	index := map[string]any{
+		"name":      "clispot",
+		"songs":     count,
+		"all_songs": "/api/songs",
+		"views":     views,
+		"playlists": "/api/playlists",
+	}
+	if s.opts.Radio != nil {
+		index["radio"] = "/radio"
+	}
+	writeJSON(w, index)
+}
+
+func (s *Server) handleSongs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	songs := append([]library.Song(nil), s.lib.GetSongs()...)
+	s.mu.Unlock()
+
+	library.SortTracks(songs)
+	list := make([]songJSON, 0, len(songs))
+	for _, song := range songs {
+		list = append(list, s.songJSON(song))
+	}
+	writeJSON(w, list)
+}
+
+// folderJSON is a folder, or a group of a tag view, that can be opened
+type folderJSON struct {
+	Name  string `json:"name"`
+	Songs int    `json:"songs"`
+	Href  string `json:"href"`
+	M3U   string `json:"m3u"`
+}
+
+// browseJSON is one node of a view
+type browseJSON struct {
+	View    string       `json:"view"`
+	Keys    []string     `json:"keys"`
+	Path    []string     `json:"path"`
+	Parent  string       `json:"parent,omitempty"`
+	M3U     string       `json:"m3u"`
+	Folders []folderJSON `json:"folders"`
+	Songs   []songJSON   `json:"songs"`
+}
+
+// handleBrowse lists a node of a view as the player's library panel shows
+// it. The node is chosen by repeated key parameters, one per level: the
+// folder names in the folders view, the group keys in the others. With
+// format=m3u every song under the node is returned as a playlist.
+func (s *Server) handleBrowse(w http.ResponseWriter, r *http.Request) {
+	mode, err := library.ParseBrowseMode(r.PathValue("view"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	keys := r.URL.Query()["key"]
+	view := strings.ToLower(mode.String())
+
+	s.mu.Lock()
+	items, crumbs, songs, err := s.browse(mode, keys)
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "m3u" {
+		title := strings.Join(append([]string{mode.String()}, crumbs...), " / ")
+		s.writeM3U(w, r, title, songs)
+		return
+	}
+
+	node := browseJSON{
+		View:    view,
+		Keys:    append([]string{}, keys...),
+		Path:    append([]string{}, crumbs...),
+		M3U:     withFormat(browseHref(view, keys), "m3u"),
+		Folders: []folderJSON{},
+		Songs:   []songJSON{},
+	}
+	if len(keys) > 0 {
+		node.Parent = browseHref(view, keys[:len(keys)-1])
+	}
+	for _, item := range items {
+		switch {
+		case item.Name == "..":
+		case item.Type == library.ItemTypeFolder:
+			child := append(append([]string(nil), keys...), itemKey(mode, item))
+			node.Folders = append(node.Folders, folderJSON{
+				Name:  item.Name,
+				Songs: item.SongCount,
+				Href:  browseHref(view, child),
+				M3U:   withFormat(browseHref(view, child), "m3u"),
+			})
+		case item.Song != nil:
+			node.Songs = append(node.Songs, s.songJSON(*item.Song))
+		}
+	}
+	writeJSON(w, node)
+}
+
+// browse opens the node at keys of mode in s.lib and returns its items,
+// its breadcrumb and every song under it. s.mu must be held.
+func (s *Server) browse(mode library.BrowseMode, keys []string) ([]library.LibraryItem, []string, []library.Song, error) {
+	s.lib.SetBrowseMode(mode)
+
+	var node library.LibraryItem
+	if mode == library.BrowseFolders {
+		for _, key := range keys {
+			if key == "" || key == "." || key == ".." || key == library.TrashDirName || strings.ContainsAny(key, `/\`) {
+				return nil, nil, nil, fmt.Errorf("no such folder")
+			}
+		}
+		dir := filepath.Join(append([]string{s.root}, keys...)...)
+		if err := s.lib.NavigateToFolder(dir); err != nil {
+			return nil, nil, nil, err
+		}
+		node = library.LibraryItem{Type: library.ItemTypeFolder, Path: dir}
+	} else {
+		if err := s.lib.SetVirtualPath(keys); err != nil {
+			return nil, nil, nil, err
+		}
+		node = library.LibraryItem{Type: library.ItemTypeFolder, Path: strings.Join(keys, "\x1f")}
+	}
+
+	items, err := s.lib.GetCurrentItems()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("no such folder")
+	}
+	var songs []library.Song
+	if len(keys) == 0 {
+		songs = append(songs, s.lib.GetSongs()...)
+		library.SortTracks(songs)
+	} else {
+		songs = s.lib.ItemSongs(node)
+		if mode == library.BrowseFolders {
+			library.SortTracks(songs)
+		}
+	}
+	return items, s.lib.Breadcrumb(), songs, nil
+}
+
+// itemKey returns the key that opens a folder item one level down
+func itemKey(mode library.BrowseMode, item library.LibraryItem) string {
+	if mode == library.BrowseFolders {
+		return filepath.Base(item.Path)
+	}
+	path := strings.Split(item.Path, "\x1f")
+	return path[len(path)-1]
+}
+
+func browseHref(view string, keys []string) string {
+	href := "/api/browse/" + view
+	if len(keys) == 0 {
+		return href
+	}
+	query := url.Values{"key": keys}
+	return href + "?" + query.Encode()
+}
+
+func withFormat(href, format string) string {
+	if strings.Contains(href, "?") {
+		return href + "&format=" + format
+	}
+	return href + "?format=" + format
+}
+
+// playlistJSON is a saved playlist. Songs no longer in the library are
+// left out.
+type playlistJSON struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	M3U         string     `json:"m3u"`
+	Songs       []songJSON `json:"songs"`
+}
+
+func (s *Server) handlePlaylists(w http.ResponseWriter, r *http.Request) {
+	list := []playlistJSON{}
+	for _, p := range s.playlists() {
+		j := playlistJSON{
+			Name:        p.Name,
+			Description: p.Description,
+			M3U:         "/playlists/" + url.PathEscape(p.Name+".m3u"),
+			Songs:       []songJSON{},
+		}
+		for _, song := range s.playlistSongs(p) {
+			j.Songs = append(j.Songs, s.songJSON(song))
+		}
+		list = append(list, j)
+	}
+	writeJSON(w, list)
+}
+
+func (s *Server) handlePlaylistM3U(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(r.PathValue("name"), ".m3u")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	for _, p := range s.playlists() {
+		if p.Name == name {
+			s.writeM3U(w, r, p.Name, s.playlistSongs(p))
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("playlist '%s' not found", name), http.StatusNotFound)
+}
+
+func (s *Server) playlists() []playlist.Playlist {
+	if s.opts.PlaylistDir == "" {
+		return nil
+	}
+	return playlist.NewManager(s.opts.PlaylistDir).GetAllPlaylists()
+}
+
+// playlistSongs returns the songs of p as they are in the library now
+func (s *Server) playlistSongs(p playlist.Playlist) []library.Song {
+	var songs []library.Song
+	for _, saved := range p.Songs {
+		if song, ok := s.song(s.songID(saved)); ok {
+			songs = append(songs, song)
+		}
+	}
+	return songs
+}
+
+// writeM3U writes songs as an extended M3U playlist of absolute URLs, so
+// it works once saved on a phone. CUE tracks also get the start and stop
+// options VLC reads.
+func (s *Server) writeM3U(w http.ResponseWriter, r *http.Request, title string, songs []library.Song) {
+	base := "http://" + r.Host
+	if r.TLS != nil {
+		base = "https://" + r.Host
+	}
+
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#PLAYLIST:%s\n", oneLine(title))
+	for _, song := range songs {
+		fmt.Fprintf(&b, "#EXTINF:%d,%s - %s\n", int(song.Duration.Seconds()), oneLine(song.Artist), oneLine(song.Title))
+		if song.IsCueTrack() {
+			fmt.Fprintf(&b, "#EXTVLCOPT:start-time=%g\n", song.Start.Seconds())
+			if song.End > 0 {
+				fmt.Fprintf(&b, "#EXTVLCOPT:stop-time=%g\n", song.End.Seconds())
+			}
+		}
+		b.WriteString(base + "/stream/" + escapeID(s.songID(song)) + "\n")
+	}
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// handleStream serves a song's file. http.ServeContent answers range
+// requests, so players can seek and resume.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	song, ok := s.song(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := os.Open(song.AudioPath())
+	if err != nil {
+		http.Error(w, "error opening file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, "error opening file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/mpeg")
+	http.ServeContent(w, r, filepath.Base(song.AudioPath()), info.ModTime(), file)
+}
+
+func (s *Server) handleCover(w http.ResponseWriter, r *http.Request) {
+	song, ok := s.song(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	data, mime, err := library.ReadCover(song)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var modTime time.Time
+	if info, err := os.Stat(song.AudioPath()); err == nil {
+		modTime = info.ModTime()
+	}
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("Cache-Control", "max-age=3600")
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+}
+
+// writeJSON writes v indented, leaving & < > alone so links stay readable
+func writeJSON(w http.ResponseWriter, v any) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, fmt.Sprintf("error marshaling response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,179 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"clispot/internal/persist"
+)
+
+// Snapshot is the state restored when clispot starts again
+type Snapshot struct {
+	Song        string        `json:"song,omitempty"`
+	Position    time.Duration `json:"position"`
+	Paused      bool          `json:"paused"`
+	FolderPath  string        `json:"folder_path,omitempty"`
+	BrowseMode  int           `json:"browse_mode"`
+	BrowsePath  []string      `json:"browse_path,omitempty"`
+	SearchQuery string        `json:"search_query,omitempty"`
+	SortOrder   int           `json:"sort_order"`
+	CurrentIdx  int           `json:"current_index"`
+	Queue       []string      `json:"queue,omitempty"`
+	SavedAt     time.Time     `json:"saved_at"`
+}
+
+// Resume is the saved position of one long file
+type Resume struct {
+	Position  time.Duration `json:"position"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Store keeps the session snapshot in session.json and per-file resume
+// positions in resume.json
+type Store struct {
+	sessionPath string
+	resumePath  string
+	resume      map[string]Resume
+}
+
+func NewStore(configDir string) *Store {
+	store := &Store{
+		sessionPath: filepath.Join(configDir, "session.json"),
+		resumePath:  filepath.Join(configDir, "resume.json"),
+		resume:      make(map[string]Resume),
+	}
+
+	store.loadResume()
+
+	return store
+}
+
+// Load returns the last saved snapshot; ok is false if there is none
+func (s *Store) Load() (Snapshot, bool) {
+	data, err := persist.ReadFile(s.sessionPath, validSnapshot)
+	if err != nil {
+		return Snapshot{}, false
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return Snapshot{}, false
+	}
+	return snapshot, true
+}
+
+// Save replaces the stored snapshot
+func (s *Store) Save(snapshot Snapshot) error {
+	snapshot.SavedAt = time.Now()
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling session: %v", err)
+	}
+	return persist.WriteFile(s.sessionPath, data, 0644, validSnapshot)
+}
+
+// ResumePosition returns where filePath was left off, if anywhere
+func (s *Store) ResumePosition(filePath string) (time.Duration, bool) {
+	resume, ok := s.resume[filePath]
+	return resume.Position, ok && resume.Position > 0
+}
+
+// SetResumePosition remembers position for filePath; a zero position
+// forgets it
+func (s *Store) SetResumePosition(filePath string, position time.Duration) error {
+	return persist.Update(s.resumePath, 0644, validResume, func(data []byte) ([]byte, error) {
+		resume := make(map[string]Resume)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &resume); err != nil {
+				return nil, fmt.Errorf("error parsing resume positions: %v", err)
+			}
+		}
+
+		if position > 0 {
+			resume[filePath] = Resume{Position: position, UpdatedAt: time.Now()}
+		} else {
+			delete(resume, filePath)
+		}
+		s.resume = resume
+
+		return json.MarshalIndent(resume, "", "  ")
+	})
+}
+
+// RenamePaths points the snapshot and resume positions at the new paths of
+// files that were moved; paths maps old paths to new ones. A new path that
+// already has a resume position, as a kept duplicate can, keeps its own.
+func (s *Store) RenamePaths(paths map[string]string) error {
+	err := persist.Update(s.sessionPath, 0644, validSnapshot, func(data []byte) ([]byte, error) {
+		if len(data) == 0 {
+			return nil, errNoSnapshot
+		}
+		var snapshot Snapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("error parsing session: %v", err)
+		}
+
+		if to, ok := paths[snapshot.Song]; ok {
+			snapshot.Song = to
+		}
+		for i, path := range snapshot.Queue {
+			if to, ok := paths[path]; ok {
+				snapshot.Queue[i] = to
+			}
+		}
+
+		return json.MarshalIndent(snapshot, "", "  ")
+	})
+	if err != nil && !errors.Is(err, errNoSnapshot) {
+		return err
+	}
+
+	return persist.Update(s.resumePath, 0644, validResume, func(data []byte) ([]byte, error) {
+		resume := make(map[string]Resume)
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &resume); err != nil {
+				return nil, fmt.Errorf("error parsing resume positions: %v", err)
+			}
+		}
+
+		for from, to := range paths {
+			if position, ok := resume[from]; ok {
+				delete(resume, from)
+				if _, taken := resume[to]; !taken {
+					resume[to] = position
+				}
+			}
+		}
+		s.resume = resume
+
+		return json.MarshalIndent(resume, "", "  ")
+	})
+}
+
+// errNoSnapshot keeps RenamePaths from writing a session that was never saved
+var errNoSnapshot = errors.New("no saved session")
+
+func (s *Store) loadResume() {
+	data, err := persist.ReadFile(s.resumePath, validResume)
+	if err != nil {
+		return
+	}
+	resume := make(map[string]Resume)
+	if json.Unmarshal(data, &resume) == nil {
+		s.resume = resume
+	}
+}
+
+// validSnapshot rejects files that do not decode, so Load falls back to the backup
+func validSnapshot(data []byte) error {
+	var snapshot Snapshot
+	return json.Unmarshal(data, &snapshot)
+}
+
+// validResume rejects files that do not decode, so reads fall back to the backup
+func validResume(data []byte) error {
+	var resume map[string]Resume
+	return json.Unmarshal(data, &resume)
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"clispot/internal/persist"
 )
 
 
@@ -31,21 +34,78 @@ func (r RepeatMode) String() string {
 }
 
 
+// ParseRepeatMode reads a mode name: "off", "one" or "all", or a name
+// String returns
+func ParseRepeatMode(name string) (RepeatMode, error) {
+	switch strings.ToLower(name) {
+	case "off", "none":
+		return RepeatNone, nil
+	case "one", "single":
+		return RepeatSingle, nil
+	case "all":
+		return RepeatAll, nil
+	}
+	return RepeatNone, fmt.Errorf("unknown repeat mode %q, use off, one or all", name)
+}
+
+
 type Settings struct {
 	
 	ShowProgressBar    bool       `json:"show_progress_bar"`
 	
 	
 	RepeatMode         RepeatMode `json:"repeat_mode"`
+	Shuffle            bool       `json:"shuffle"`
 	Volume             float64    `json:"volume"`
 	
+	// Restore the last song, position and view on startup
+	RestoreSession     bool       `json:"restore_session"`
+	// Files at least this long remember where they were left off
+	ResumeMinMinutes   int        `json:"resume_min_minutes"`
+	
 	
 	Theme              string     `json:"theme"`
 	CompactMode        bool       `json:"compact_mode"`
+	// Layout is "auto", "full", "compact" or "mini"; in auto, CompactMode
+	// keeps the full layout from being chosen
+	Layout             string     `json:"layout"`
 	
 	
 	BufferSize         int        `json:"buffer_size"`
 	UpdateInterval     int        `json:"update_interval_ms"`
+	
+	// Also store ratings in each file's ID3 POPM frame
+	WriteRatingsToID3  bool       `json:"write_ratings_to_id3"`
+	
+	// Fill tags missing from files from their path, trying FilenamePatterns
+	// before the built-in patterns, and optionally save what was found
+	InferTags          bool       `json:"infer_tags"`
+	FilenamePatterns   []string   `json:"filename_patterns,omitempty"`
+	WriteInferredTags  bool       `json:"write_inferred_tags"`
+	
+	
+	Scrobblers         []ScrobblerConfig `json:"scrobblers,omitempty"`
+	
+	// How many of a podcast's newest episodes are downloaded when
+	// subscribing, and how many new ones each refresh downloads
+	PodcastDownloads   int        `json:"podcast_downloads"`
+}
+
+// ScrobblerConfig configures one scrobbling service. Service is
+// "listenbrainz" or "lastfm"; BaseURL points at a self-hosted or local
+// compatible server and defaults to the public one when empty.
+type ScrobblerConfig struct {
+	Service    string `json:"service"`
+	BaseURL    string `json:"base_url,omitempty"`
+	Enabled    bool   `json:"enabled"`
+	
+	// ListenBrainz user token
+	Token      string `json:"token,omitempty"`
+	
+	// Last.fm API credentials and the session key from its auth flow
+	APIKey     string `json:"api_key,omitempty"`
+	APISecret  string `json:"api_secret,omitempty"`
+	SessionKey string `json:"session_key,omitempty"`
 }
 
 
@@ -54,8 +114,13 @@ func DefaultSettings() *Settings {
 		ShowProgressBar:   false,
 		RepeatMode:        RepeatNone,
 		Volume:            0.8,
+		RestoreSession:    true,
+		ResumeMinMinutes:  20,
 		Theme:             "default",
 		CompactMode:       false,
+		Layout:            "auto",
+		InferTags:         true,
+		PodcastDownloads:  3,
 		BufferSize:        4096,
 		UpdateInterval:    100,
 	}
@@ -68,8 +133,8 @@ type Manager struct {
 }
 
 
-func NewManager() *Manager {
-	
+// ConfigDir returns clispot's configuration directory, creating it if needed
+func ConfigDir() string {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		homeDir = "."
@@ -77,6 +142,12 @@ func NewManager() *Manager {
 	
 	configDir := filepath.Join(homeDir, ".config", "clispot")
 	os.MkdirAll(configDir, 0755)
+	return configDir
+}
+
+
+func NewManager() *Manager {
+	configDir := ConfigDir()
 	
 	configPath := filepath.Join(configDir, "settings.json")
 	
@@ -97,68 +168,104 @@ func (m *Manager) Get() *Settings {
 }
 
 
+// Update applies updateFunc to the settings on disk, read under their lock,
+// so a change another clispot instance saved in the meantime is kept
 func (m *Manager) Update(updateFunc func(*Settings)) error {
-	updateFunc(m.settings)
-	return m.Save()
+	applied := false
+	err := persist.Update(m.configPath, 0644, validSettings, func(data []byte) ([]byte, error) {
+		settings := *m.settings
+		if len(data) > 0 {
+			settings = *DefaultSettings()
+			if err := json.Unmarshal(data, &settings); err != nil {
+				return nil, fmt.Errorf("error parsing settings: %v", err)
+			}
+		}
+		
+		updateFunc(&settings)
+		*m.settings = settings
+		applied = true
+		
+		data, err := json.MarshalIndent(&settings, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling settings: %v", err)
+		}
+		return data, nil
+	})
+	
+	// The change still holds for this session when it cannot be saved
+	if !applied {
+		updateFunc(m.settings)
+	}
+	return err
 }
 
 
 func (m *Manager) ToggleProgressBar() bool {
-	m.settings.ShowProgressBar = !m.settings.ShowProgressBar
-	m.Save()
+	m.Update(func(s *Settings) {
+		s.ShowProgressBar = !s.ShowProgressBar
+	})
 	return m.settings.ShowProgressBar
 }
 
 
 func (m *Manager) CycleRepeatMode() RepeatMode {
-	switch m.settings.RepeatMode {
-	case RepeatNone:
-		m.settings.RepeatMode = RepeatSingle
-	case RepeatSingle:
-		m.settings.RepeatMode = RepeatAll
-	case RepeatAll:
-		m.settings.RepeatMode = RepeatNone
-	}
-	m.Save()
+	m.Update(func(s *Settings) {
+		switch s.RepeatMode {
+		case RepeatNone:
+			s.RepeatMode = RepeatSingle
+		case RepeatSingle:
+			s.RepeatMode = RepeatAll
+		case RepeatAll:
+			s.RepeatMode = RepeatNone
+		}
+	})
 	return m.settings.RepeatMode
 }
 
 
+// ToggleShuffle flips shuffle mode and returns the new state
+func (m *Manager) ToggleShuffle() bool {
+	m.Update(func(s *Settings) {
+		s.Shuffle = !s.Shuffle
+	})
+	return m.settings.Shuffle
+}
+
+
 func (m *Manager) SetVolume(volume float64) {
 	if volume < 0 {
 		volume = 0
 	} else if volume > 1 {
 		volume = 1
 	}
-	m.settings.Volume = volume
-	m.Save()
+	m.Update(func(s *Settings) {
+		s.Volume = volume
+	})
 }
 
 
 func (m *Manager) Load() error {
-	data, err := os.ReadFile(m.configPath)
+	data, err := persist.ReadFile(m.configPath, validSettings)
 	if err != nil {
-		
-		return nil
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error loading settings: %v", err)
 	}
-	
-	var settings Settings
-	if err := json.Unmarshal(data, &settings); err != nil {
+	settings := DefaultSettings()
+	if err := json.Unmarshal(data, settings); err != nil {
 		return fmt.Errorf("error parsing settings: %v", err)
 	}
 	
-	m.settings = &settings
+	m.settings = settings
 	return nil
 }
 
 
-func (m *Manager) Save() error {
-	data, err := json.MarshalIndent(m.settings, "", "  ")
-	if err != nil {
-		return fmt.Errorf("error marshaling settings: %v", err)
-	}
-	
-	return os.WriteFile(m.configPath, data, 0644)
+// validSettings rejects files that do not decode, so Load falls back to the backup
+func validSettings(data []byte) error {
+	var settings Settings
+	return json.Unmarshal(data, &settings)
 }
 
 
@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"clispot/internal/library"
+)
+
+// icyReader strips the ICY metadata blocks that Shoutcast and Icecast
+// servers interleave with the audio when asked with Icy-MetaData: 1. Every
+// metaint bytes of audio are followed by one length byte, counting 16
+// byte units, and a block of that many units.
+type icyReader struct {
+	r        io.Reader
+	metaint  int
+	left     int
+	onTitle  func(string)
+	metadata []byte
+}
+
+func newICYReader(r io.Reader, metaint int, onTitle func(string)) *icyReader {
+	return &icyReader{r: r, metaint: metaint, left: metaint, onTitle: onTitle}
+}
+
+func (r *icyReader) Read(p []byte) (int, error) {
+	if r.left == 0 {
+		if err := r.readMetadata(); err != nil {
+			return 0, err
+		}
+		r.left = r.metaint
+	}
+	if len(p) > r.left {
+		p = p[:r.left]
+	}
+	n, err := r.r.Read(p)
+	r.left -= n
+	return n, err
+}
+
+func (r *icyReader) readMetadata() error {
+	var length [1]byte
+	if _, err := io.ReadFull(r.r, length[:]); err != nil {
+		return err
+	}
+	if length[0] == 0 {
+		return nil
+	}
+
+	size := int(length[0]) * 16
+	if cap(r.metadata) < size {
+		r.metadata = make([]byte, size)
+	}
+	block := r.metadata[:size]
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		return err
+	}
+	if title, ok := ParseMetadata(block)["StreamTitle"]; ok && r.onTitle != nil {
+		r.onTitle(title)
+	}
+	return nil
+}
+
+// metadataKey matches the start of a key='value' pair
+var metadataKey = regexp.MustCompile(`^\s*([A-Za-z]+)='`)
+
+// ParseMetadata reads the key='value'; pairs of an ICY metadata block,
+// such as StreamTitle='Artist - Title';StreamUrl='http://…';. Values may hold
+// quotes and semicolons of their own, so a value only ends at a "';"
+// that is followed by another key or the end of the block. Blocks that
+// are not UTF-8 are read as Latin-1.
+func ParseMetadata(block []byte) map[string]string {
+	text := strings.TrimRight(string(block), "\x00")
+	if !utf8.ValidString(text) {
+		text = library.Latin1([]byte(text))
+	}
+
+	fields := make(map[string]string)
+	for {
+		m := metadataKey.FindStringSubmatchIndex(text)
+		if m == nil {
+			break
+		}
+		key := text[m[2]:m[3]]
+		rest := text[m[1]:]
+
+		end := -1
+		for i := 0; ; {
+			j := strings.Index(rest[i:], "';")
+			if j < 0 {
+				break
+			}
+			after := rest[i+j+2:]
+			if strings.TrimSpace(after) == "" || metadataKey.MatchString(after) {
+				end = i + j
+				break
+			}
+			i += j + 2
+		}
+		if end < 0 {
+			// An unterminated value runs to the end of the block
+			fields[key] = strings.TrimSuffix(strings.TrimSpace(rest), "'")
+			break
+		}
+		fields[key] = rest[:end]
+		text = rest[end+2:]
+	}
+	return fields
+}
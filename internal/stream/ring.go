@@ -0,0 +1,134 @@
+package stream
+
+import (
+	"io"
+	"sync"
+)
+
+// Ring is a fixed-size byte buffer between a goroutine that fills it from
+// the network and one that drains it. Writes block while it is full and
+// Read blocks while it is empty, so a stalled reader holds back the
+// connection rather than growing memory.
+type Ring struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	buf   []byte
+	start int
+	size  int
+	err   error
+}
+
+func NewRing(size int) *Ring {
+	r := &Ring{buf: make([]byte, size)}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// Write copies all of p into the ring, waiting for room as needed. It
+// fails once the ring is closed.
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		for r.size == len(r.buf) && r.err == nil {
+			r.cond.Wait()
+		}
+		if r.err != nil {
+			return written, io.ErrClosedPipe
+		}
+		n := r.put(p)
+		p = p[n:]
+		written += n
+		r.cond.Broadcast()
+	}
+	return written, nil
+}
+
+// Read waits until the ring holds data and reads up to len(p) bytes of it.
+// After the ring is closed it returns what is left, then the close error.
+func (r *Ring) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for r.size == 0 && r.err == nil {
+		r.cond.Wait()
+	}
+	return r.take(p)
+}
+
+// ReadAvailable is Read without the wait: it returns 0, nil when the ring
+// is empty but still open
+func (r *Ring) ReadAvailable(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.take(p)
+}
+
+func (r *Ring) take(p []byte) (int, error) {
+	if r.size == 0 {
+		return 0, r.err
+	}
+	n := 0
+	for n < len(p) && r.size > 0 {
+		chunk := min(r.size, len(r.buf)-r.start, len(p)-n)
+		copy(p[n:], r.buf[r.start:r.start+chunk])
+		r.start = (r.start + chunk) % len(r.buf)
+		r.size -= chunk
+		n += chunk
+	}
+	r.cond.Broadcast()
+	return n, nil
+}
+
+func (r *Ring) put(p []byte) int {
+	n := 0
+	for n < len(p) && r.size < len(r.buf) {
+		end := (r.start + r.size) % len(r.buf)
+		chunk := min(len(r.buf)-r.size, len(r.buf)-end, len(p)-n)
+		copy(r.buf[end:end+chunk], p[n:])
+		r.size += chunk
+		n += chunk
+	}
+	return n
+}
+
+// Len returns the number of buffered bytes
+func (r *Ring) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.size
+}
+
+// Cap returns the size of the ring
+func (r *Ring) Cap() int {
+	return len(r.buf)
+}
+
+// Closed reports whether the ring has been closed
+func (r *Ring) Closed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err != nil
+}
+
+// CloseWithError closes the ring. Readers get err, io.EOF if it is nil,
+// once the buffered data is drained; blocked writers fail at once.
+func (r *Ring) CloseWithError(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.cond.Broadcast()
+}
+
+// Close closes the ring with io.EOF
+func (r *Ring) Close() error {
+	r.CloseWithError(nil)
+	return nil
+}
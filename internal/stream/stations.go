@@ -0,0 +1,289 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"clispot/internal/library"
+	"clispot/internal/persist"
+)
+
+// Station is a saved internet radio station
+type Station struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// IsURL reports whether path names an HTTP stream rather than a file
+func IsURL(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// StationStore keeps the saved stations in stations.json, sorted by name
+type StationStore struct {
+	path     string
+	stations []Station
+}
+
+func NewStationStore(configDir string) *StationStore {
+	store := &StationStore{path: filepath.Join(configDir, "stations.json")}
+
+	store.Load()
+
+	return store
+}
+
+// Load reads the stations file, falling back to its backup if it is corrupt
+func (s *StationStore) Load() error {
+	data, err := persist.ReadFile(s.path, validStations)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error loading stations: %v", err)
+	}
+
+	var stations []Station
+	if err := json.Unmarshal(data, &stations); err != nil {
+		return fmt.Errorf("error parsing stations: %v", err)
+	}
+	s.stations = stations
+	return nil
+}
+
+// List returns the saved stations
+func (s *StationStore) List() []Station {
+	return s.stations
+}
+
+// Find returns the station called name, ignoring case, or else the only
+// one whose name starts with it
+func (s *StationStore) Find(name string) (Station, bool) {
+	var matches []Station
+	for _, station := range s.stations {
+		if strings.EqualFold(station.Name, name) {
+			return station, true
+		}
+		if strings.HasPrefix(strings.ToLower(station.Name), strings.ToLower(name)) {
+			matches = append(matches, station)
+		}
+	}
+	if len(matches) == 1 {
+		return matches[0], true
+	}
+	return Station{}, false
+}
+
+// Add saves stations, replacing any saved under the same name. It returns
+// how many were new.
+func (s *StationStore) Add(stations ...Station) (int, error) {
+	for _, station := range stations {
+		if strings.TrimSpace(station.Name) == "" {
+			return 0, fmt.Errorf("station has no name")
+		}
+		if !IsURL(station.URL) {
+			return 0, fmt.Errorf("%q is not an http or https URL", station.URL)
+		}
+	}
+
+	added := 0
+	err := s.update(func(saved []Station) []Station {
+		for _, station := range stations {
+			i := indexOfStation(saved, station.Name)
+			if i < 0 {
+				saved = append(saved, station)
+				added++
+			} else {
+				saved[i] = station
+			}
+		}
+		return saved
+	})
+	return added, err
+}
+
+// Remove deletes the station called name
+func (s *StationStore) Remove(name string) error {
+	found := false
+	err := s.update(func(saved []Station) []Station {
+		if i := indexOfStation(saved, name); i >= 0 {
+			found = true
+			return append(saved[:i], saved[i+1:]...)
+		}
+		return saved
+	})
+	if err == nil && !found {
+		return fmt.Errorf("no station called %q", name)
+	}
+	return err
+}
+
+// update applies fn to the stations on disk, so changes made by another
+// clispot instance in the meantime are kept
+func (s *StationStore) update(fn func([]Station) []Station) error {
+	return persist.Update(s.path, 0644, validStations, func(data []byte) ([]byte, error) {
+		var stations []Station
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &stations); err != nil {
+				return nil, fmt.Errorf("error parsing stations: %v", err)
+			}
+		}
+
+		stations = fn(stations)
+		sort.SliceStable(stations, func(i, j int) bool {
+			return strings.ToLower(stations[i].Name) < strings.ToLower(stations[j].Name)
+		})
+		s.stations = stations
+
+		return json.MarshalIndent(stations, "", "  ")
+	})
+}
+
+func indexOfStation(stations []Station, name string) int {
+	for i, station := range stations {
+		if strings.EqualFold(station.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// validStations rejects files that do not decode, so Load falls back to the backup
+func validStations(data []byte) error {
+	var stations []Station
+	return json.Unmarshal(data, &stations)
+}
+
+// ReadStationFile reads the stations listed in a .pls or .m3u file.
+// Entries that are not http or https URLs are skipped; those without a
+// title are named after the file.
+func ReadStationFile(path string) ([]Station, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	stations, err := ParseStationList(data, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filepath.Base(path), err)
+	}
+	return stations, nil
+}
+
+// ParseStationList reads a PLS or M3U playlist of streams, telling them
+// apart by the PLS [playlist] header. Entries without a title are named
+// after fallback, numbered when there are several.
+func ParseStationList(data []byte, fallback string) ([]Station, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !utf8.Valid(data) {
+		data = []byte(library.Latin1(data))
+	}
+
+	var stations []Station
+	var err error
+	if bytes.HasPrefix(bytes.ToLower(bytes.TrimSpace(data)), []byte("[playlist]")) {
+		stations, err = parsePLS(data)
+	} else {
+		stations, err = parseM3U(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(stations) == 0 {
+		return nil, fmt.Errorf("no stream URLs found")
+	}
+
+	for i := range stations {
+		if stations[i].Name != "" {
+			continue
+		}
+		stations[i].Name = fallback
+		if len(stations) > 1 {
+			stations[i].Name = fmt.Sprintf("%s %d", fallback, i+1)
+		}
+	}
+	return stations, nil
+}
+
+// parsePLS reads the FileN= and TitleN= entries of a PLS playlist
+func parsePLS(data []byte) ([]Station, error) {
+	files := make(map[int]string)
+	titles := make(map[int]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		lower := strings.ToLower(key)
+		for prefix, entries := range map[string]map[int]string{"file": files, "title": titles} {
+			if n, err := strconv.Atoi(strings.TrimPrefix(lower, prefix)); err == nil && strings.HasPrefix(lower, prefix) {
+				entries[n] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading playlist: %v", err)
+	}
+
+	numbers := make([]int, 0, len(files))
+	for n := range files {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	var stations []Station
+	for _, n := range numbers {
+		if IsURL(files[n]) {
+			stations = append(stations, Station{Name: titles[n], URL: files[n]})
+		}
+	}
+	return stations, nil
+}
+
+// parseM3U reads the URLs of an M3U playlist, named by the #EXTINF line
+// before each
+func parseM3U(data []byte) ([]Station, error) {
+	var stations []Station
+	title := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			if _, name, ok := strings.Cut(line, ","); ok {
+				title = strings.TrimSpace(name)
+			}
+		case line == "" || strings.HasPrefix(line, "#"):
+		default:
+			if IsURL(line) {
+				stations = append(stations, Station{Name: title, URL: line})
+			}
+			title = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading playlist: %v", err)
+	}
+	return stations, nil
+}
+
+// isStationList reports whether a response with the given content type and
+// URL is a playlist pointing at the stream rather than the stream itself
+func isStationList(contentType, url string) bool {
+	switch strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0])) {
+	case "audio/x-scpls", "audio/scpls", "audio/x-mpegurl", "audio/mpegurl":
+		return true
+	}
+	path := strings.ToLower(strings.SplitN(url, "?", 2)[0])
+	return strings.HasSuffix(path, ".pls") || strings.HasSuffix(path, ".m3u")
+}
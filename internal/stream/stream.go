@@ -0,0 +1,314 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by Read after the stream has been closed
+var ErrClosed = errors.New("stream closed")
+
+// Options configures a stream. Zero fields take the defaults.
+type Options struct {
+	// Client makes the requests; nil uses http.DefaultClient. Streams
+	// never end, so it should not set an overall Timeout.
+	Client *http.Client
+	// BufferSize is how many bytes of compressed audio are read ahead,
+	// 256 KiB by default: about 16 seconds at 128 kbps
+	BufferSize int
+	// Retries is how many reconnects in a row are tried after the
+	// connection drops before the stream fails, 5 by default
+	Retries int
+	// RetryDelay is the wait before the first reconnect, doubled for each
+	// one after, 1 second by default
+	RetryDelay time.Duration
+	// ReadTimeout is how long the server may send nothing before the
+	// connection counts as dropped, 15 seconds by default
+	ReadTimeout time.Duration
+	UserAgent   string
+}
+
+func (o Options) withDefaults() Options {
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = 256 << 10
+	}
+	if o.Retries <= 0 {
+		o.Retries = 5
+	}
+	if o.RetryDelay <= 0 {
+		o.RetryDelay = time.Second
+	}
+	if o.ReadTimeout <= 0 {
+		o.ReadTimeout = 15 * time.Second
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = "clispot"
+	}
+	return o
+}
+
+// Info is what the server tells about a stream and what it is playing
+type Info struct {
+	// Name, Genre and Bitrate come from the icy-name, icy-genre and icy-br
+	// headers and are empty when the server does not send them
+	Name    string
+	Genre   string
+	Bitrate int
+	// Title is the latest StreamTitle, usually "Artist - Title"
+	Title string
+	// Reconnects counts the times the connection was re-established
+	Reconnects int
+}
+
+// Stream is an MP3 stream read ahead into a ring buffer by a background
+// goroutine, which reconnects when the connection drops. Read returns the
+// audio with the ICY metadata taken out.
+type Stream struct {
+	url    string
+	opts   Options
+	ring   *Ring
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	info Info
+	err  error
+}
+
+// conn is one HTTP connection to the stream
+type conn struct {
+	body    io.ReadCloser
+	metaint int
+	cancel  context.CancelFunc
+	// idle cancels the request when the server sends nothing for the
+	// read timeout
+	idle *time.Timer
+}
+
+// Open connects to the stream at url and starts reading it ahead. A URL
+// serving a .pls or .m3u playlist is followed to the first stream in it.
+// Only MP3 streams are supported; AAC and Ogg streams are refused.
+func Open(url string, opts Options) (*Stream, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Stream{
+		url:    url,
+		opts:   opts.withDefaults(),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	s.ring = NewRing(s.opts.BufferSize)
+
+	c, err := s.dial(url, true)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go s.run(c)
+	return s, nil
+}
+
+// dial requests the stream, following a playlist to its first entry when
+// follow is set
+func (s *Stream) dial(url string, follow bool) (*conn, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	idle := time.AfterFunc(s.opts.ReadTimeout, cancel)
+	fail := func(err error) (*conn, error) {
+		idle.Stop()
+		cancel()
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fail(fmt.Errorf("bad stream URL: %v", err))
+	}
+	req.Header.Set("Icy-MetaData", "1")
+	req.Header.Set("User-Agent", s.opts.UserAgent)
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return fail(fmt.Errorf("error connecting to stream: %v", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return fail(fmt.Errorf("stream server returned %s", resp.Status))
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if follow && isStationList(contentType, url) {
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		idle.Stop()
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("error reading playlist: %v", err)
+		}
+		stations, err := ParseStationList(data, "")
+		if err != nil {
+			return nil, fmt.Errorf("error reading playlist: %v", err)
+		}
+		s.url = stations[0].URL
+		return s.dial(s.url, false)
+	}
+	if err := checkContentType(contentType); err != nil {
+		resp.Body.Close()
+		return fail(err)
+	}
+
+	metaint, _ := strconv.Atoi(resp.Header.Get("icy-metaint"))
+	bitrate, _ := strconv.Atoi(strings.Split(resp.Header.Get("icy-br"), ",")[0])
+	s.mu.Lock()
+	s.info.Name = resp.Header.Get("icy-name")
+	s.info.Genre = resp.Header.Get("icy-genre")
+	s.info.Bitrate = bitrate
+	s.mu.Unlock()
+
+	return &conn{body: resp.Body, metaint: metaint, cancel: cancel, idle: idle}, nil
+}
+
+// checkContentType refuses streams that are known not to be MP3. Servers
+// that send no type or a generic one are given the benefit of the doubt.
+func checkContentType(contentType string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	switch mediaType {
+	case "audio/mpeg", "audio/mp3", "audio/mpeg3", "audio/x-mpeg", "audio/x-mp3", "application/octet-stream":
+		return nil
+	}
+	return fmt.Errorf("unsupported stream format %s, only MP3 streams can be played", mediaType)
+}
+
+// run copies the stream into the ring, reconnecting with a growing delay
+// when the connection drops, until the stream is closed or runs out of
+// retries
+func (s *Stream) run(c *conn) {
+	failures := 0
+	for {
+		n, err := s.copy(c)
+		if s.ctx.Err() != nil {
+			return
+		}
+		if n > 0 {
+			failures = 0
+		}
+
+		for {
+			failures++
+			if failures > s.opts.Retries {
+				s.fail(fmt.Errorf("lost connection to stream: %v", err))
+				return
+			}
+			select {
+			case <-time.After(s.opts.RetryDelay << (failures - 1)):
+			case <-s.ctx.Done():
+				return
+			}
+			if c, err = s.dial(s.url, false); err == nil {
+				break
+			}
+		}
+		s.mu.Lock()
+		s.info.Reconnects++
+		s.mu.Unlock()
+	}
+}
+
+// copy reads one connection into the ring until it fails. It returns how
+// many bytes of audio it read and why it stopped.
+func (s *Stream) copy(c *conn) (int64, error) {
+	defer c.cancel()
+	defer c.idle.Stop()
+	defer c.body.Close()
+
+	var r io.Reader = &idleReader{r: c.body, idle: c.idle, timeout: s.opts.ReadTimeout}
+	if c.metaint > 0 {
+		r = newICYReader(r, c.metaint, s.setTitle)
+	}
+	n, err := io.Copy(s.ring, r)
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (s *Stream) setTitle(title string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info.Title = strings.TrimSpace(title)
+}
+
+func (s *Stream) fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	s.ring.CloseWithError(err)
+}
+
+// Read reads audio from the buffer, waiting for more when it is empty.
+// Once the stream has failed it returns the error that ended it.
+func (s *Stream) Read(p []byte) (int, error) {
+	return s.ring.Read(p)
+}
+
+// Buffered returns how many bytes have been read ahead
+func (s *Stream) Buffered() int {
+	return s.ring.Len()
+}
+
+// Info returns what is known about the stream and what it is playing
+func (s *Stream) Info() Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.info
+}
+
+// URL returns the address being streamed, which differs from the one
+// opened when that was a playlist
+func (s *Stream) URL() string {
+	return s.url
+}
+
+// Err returns the error that ended the stream, nil while it is playing
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close disconnects and stops the background reader
+func (s *Stream) Close() error {
+	s.cancel()
+	s.ring.CloseWithError(ErrClosed)
+	return nil
+}
+
+// idleReader restarts the idle timer of a connection on every read
+type idleReader struct {
+	r       io.Reader
+	idle    *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.idle.Reset(r.timeout)
+	}
+	return n, err
+}
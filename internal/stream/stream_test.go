@@ -0,0 +1,420 @@
+package stream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// testAudio returns frames MPEG-1 Layer III frames at 128 kbps and
+// 44.1 kHz, each 417 bytes long and filled with its own number so
+// misplaced bytes show up
+func testAudio(frames int) []byte {
+	var audio []byte
+	for i := 0; i < frames; i++ {
+		frame := bytes.Repeat([]byte{byte(i)}, 417)
+		copy(frame, []byte{0xff, 0xfb, 0x90, 0x64})
+		audio = append(audio, frame...)
+	}
+	return audio
+}
+
+// icyEncode interleaves audio with a metadata block every metaint bytes, as
+// a Shoutcast server does. title gives the StreamTitle of the nth block;
+// an empty title sends an empty block.
+func icyEncode(audio []byte, metaint int, title func(n int) string) []byte {
+	var out []byte
+	for n := 0; len(audio) > 0; n++ {
+		chunk := audio[:min(metaint, len(audio))]
+		audio = audio[len(chunk):]
+		out = append(out, chunk...)
+		if len(chunk) < metaint {
+			break
+		}
+		out = append(out, icyBlock(title(n))...)
+	}
+	return out
+}
+
+// icyBlock encodes one metadata block with its length byte
+func icyBlock(title string) []byte {
+	if title == "" {
+		return []byte{0}
+	}
+	text := []byte("StreamTitle='" + title + "';")
+	units := (len(text) + 15) / 16
+	block := make([]byte, 1+units*16)
+	block[0] = byte(units)
+	copy(block[1:], text)
+	return block
+}
+
+func TestICYReader(t *testing.T) {
+	audio := testAudio(10)
+	encoded := icyEncode(audio, 1000, func(n int) string {
+		if n%2 == 1 {
+			return ""
+		}
+		return fmt.Sprintf("Artist - Song %d", n)
+	})
+
+	for name, r := range map[string]func(io.Reader) io.Reader{
+		"whole":    func(r io.Reader) io.Reader { return r },
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var titles []string
+			icy := newICYReader(r(bytes.NewReader(encoded)), 1000, func(title string) {
+				titles = append(titles, title)
+			})
+			got, err := io.ReadAll(icy)
+			if err != nil {
+				t.Fatalf("error reading: %v", err)
+			}
+			if !bytes.Equal(got, audio) {
+				t.Errorf("got %d bytes of audio that differ from the %d sent", len(got), len(audio))
+			}
+			want := []string{"Artist - Song 0", "Artist - Song 2"}
+			if !reflect.DeepEqual(titles, want) {
+				t.Errorf("got titles %q, want %q", titles, want)
+			}
+		})
+	}
+}
+
+func TestICYReaderTruncatedMetadata(t *testing.T) {
+	encoded := append(testAudio(1)[:100], 2, 'S', 't')
+	_, err := io.ReadAll(newICYReader(bytes.NewReader(encoded), 100, nil))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+		want  map[string]string
+	}{
+		{
+			name:  "title and url",
+			block: "StreamTitle='Artist - Title';StreamUrl='http://example.com/';",
+			want:  map[string]string{"StreamTitle": "Artist - Title", "StreamUrl": "http://example.com/"},
+		},
+		{
+			name:  "padding",
+			block: "StreamTitle='Artist - Title';\x00\x00\x00\x00",
+			want:  map[string]string{"StreamTitle": "Artist - Title"},
+		},
+		{
+			name:  "quotes in value",
+			block: "StreamTitle='Guns N' Roses - Sweet Child O' Mine';",
+			want:  map[string]string{"StreamTitle": "Guns N' Roses - Sweet Child O' Mine"},
+		},
+		{
+			name:  "semicolons in value",
+			block: "StreamTitle='Artist; Other - Title;Part 2';StreamUrl='';",
+			want:  map[string]string{"StreamTitle": "Artist; Other - Title;Part 2", "StreamUrl": ""},
+		},
+		{
+			name:  "quote and semicolon in value",
+			block: "StreamTitle='Rock 'n' Roll';Radio - Live';",
+			want:  map[string]string{"StreamTitle": "Rock 'n' Roll';Radio - Live"},
+		},
+		{
+			name:  "unterminated",
+			block: "StreamTitle='Artist - Title",
+			want:  map[string]string{"StreamTitle": "Artist - Title"},
+		},
+		{
+			name:  "latin-1",
+			block: "StreamTitle='Bj\xf6rk - J\xf3ga';",
+			want:  map[string]string{"StreamTitle": "Björk - Jóga"},
+		},
+		{
+			name:  "empty",
+			block: "\x00\x00\x00\x00",
+			want:  map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMetadata([]byte(tt.block)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testOptions keeps reconnects quick
+var testOptions = Options{RetryDelay: 10 * time.Millisecond, ReadTimeout: 5 * time.Second}
+
+// serveStream writes the ICY headers of a stream with the given metaint
+func serveStream(w http.ResponseWriter, metaint int) {
+	w.Header().Set("Content-Type", "audio/mpeg")
+	w.Header().Set("icy-name", "Test FM")
+	w.Header().Set("icy-genre", "Jazz")
+	w.Header().Set("icy-br", "128")
+	w.Header().Set("icy-metaint", fmt.Sprint(metaint))
+	w.WriteHeader(http.StatusOK)
+}
+
+// readAudio reads n bytes from s, failing the test if that takes too long
+func readAudio(t *testing.T, s *Stream, n int) []byte {
+	t.Helper()
+	got := make([]byte, n)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(s, got)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("error reading stream: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out reading stream")
+	}
+	return got
+}
+
+func TestOpen(t *testing.T) {
+	audio := testAudio(20)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Icy-MetaData") != "1" {
+			t.Errorf("got Icy-MetaData %q, want 1", r.Header.Get("Icy-MetaData"))
+		}
+		serveStream(w, 2048)
+		w.Write(icyEncode(audio, 2048, func(int) string { return "Artist - Title" }))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	s, err := Open(srv.URL, testOptions)
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer s.Close()
+
+	if got := readAudio(t, s, len(audio)); !bytes.Equal(got, audio) {
+		t.Error("audio read from the stream differs from the audio sent")
+	}
+	info := s.Info()
+	want := Info{Name: "Test FM", Genre: "Jazz", Bitrate: 128, Title: "Artist - Title"}
+	if info != want {
+		t.Errorf("got info %+v, want %+v", info, want)
+	}
+}
+
+func TestOpenRefusesOtherFormats(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/aacp")
+	}))
+	defer srv.Close()
+
+	if s, err := Open(srv.URL, testOptions); err == nil {
+		s.Close()
+		t.Fatal("opened an AAC stream")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	audio := testAudio(20)
+	half := len(audio) / 2
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveStream(w, 1024)
+		if requests.Add(1) == 1 {
+			// Drop the connection halfway through
+			w.Write(icyEncode(audio[:half], 1024, func(int) string { return "First" }))
+			return
+		}
+		w.Write(icyEncode(audio[half:], 1024, func(int) string { return "Second" }))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	s, err := Open(srv.URL, testOptions)
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer s.Close()
+
+	if got := readAudio(t, s, len(audio)); !bytes.Equal(got, audio) {
+		t.Error("audio read across the reconnect differs from the audio sent")
+	}
+	info := s.Info()
+	if info.Reconnects != 1 || info.Title != "Second" {
+		t.Errorf("got %d reconnects and title %q, want 1 and Second", info.Reconnects, info.Title)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("got error %v after reconnecting", err)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	audio := testAudio(4)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			http.Error(w, "gone", http.StatusServiceUnavailable)
+			return
+		}
+		serveStream(w, 0)
+		w.Write(audio)
+	}))
+	defer srv.Close()
+
+	opts := testOptions
+	opts.Retries = 2
+	opts.RetryDelay = time.Millisecond
+	s, err := Open(srv.URL, opts)
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer s.Close()
+
+	readAudio(t, s, len(audio))
+	if _, err := io.ReadAll(s); err == nil || !strings.Contains(err.Error(), "lost connection") {
+		t.Errorf("got error %v, want a lost connection", err)
+	}
+	if got := requests.Load(); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+}
+
+func TestOpenFollowsPlaylist(t *testing.T) {
+	audio := testAudio(2)
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	mux.HandleFunc("/listen.pls", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/x-scpls")
+		fmt.Fprintf(w, "[playlist]\nNumberOfEntries=1\nFile1=%s/live\nTitle1=Test FM\n", srv.URL)
+	})
+	mux.HandleFunc("/live", func(w http.ResponseWriter, r *http.Request) {
+		serveStream(w, 0)
+		w.Write(audio)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	s, err := Open(srv.URL+"/listen.pls", testOptions)
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	defer s.Close()
+
+	if got := readAudio(t, s, len(audio)); !bytes.Equal(got, audio) {
+		t.Error("audio read from the stream differs from the audio sent")
+	}
+	if s.URL() != srv.URL+"/live" {
+		t.Errorf("got URL %s, want %s/live", s.URL(), srv.URL)
+	}
+}
+
+func TestParseStationList(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Station
+	}{
+		{
+			name: "pls",
+			data: "[playlist]\nNumberOfEntries=3\n" +
+				"File10=http://example.com/third\n" +
+				"File1=http://example.com/first\nTitle1=First FM\n" +
+				"File2=http://example.com/second\nLength2=-1\n" +
+				"Version=2\n",
+			want: []Station{
+				{Name: "First FM", URL: "http://example.com/first"},
+				{Name: "Radio 2", URL: "http://example.com/second"},
+				{Name: "Radio 3", URL: "http://example.com/third"},
+			},
+		},
+		{
+			name: "pls header case and spaces",
+			data: "\ufeff  [Playlist]\r\nfile1=https://example.com/live\r\ntitle1=Live \r\n",
+			want: []Station{{Name: "Live", URL: "https://example.com/live"}},
+		},
+		{
+			name: "m3u",
+			data: "#EXTM3U\n#EXTINF:-1,Jazz FM\nhttp://example.com/jazz\n\n" +
+				"# a comment\nhttp://example.com/untitled\n" +
+				"#EXTINF:-1,Local File\n/music/song.mp3\n" +
+				"#EXTINF:-1,Rock, Live\nhttps://example.com/rock\n",
+			want: []Station{
+				{Name: "Jazz FM", URL: "http://example.com/jazz"},
+				{Name: "Radio 2", URL: "http://example.com/untitled"},
+				{Name: "Rock, Live", URL: "https://example.com/rock"},
+			},
+		},
+		{
+			name: "single untitled",
+			data: "http://example.com/live\n",
+			want: []Station{{Name: "Radio", URL: "http://example.com/live"}},
+		},
+		{
+			name: "latin-1",
+			data: "#EXTINF:-1,Radio M\xfcnchen\nhttp://example.com/muc\n",
+			want: []Station{{Name: "Radio München", URL: "http://example.com/muc"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStationList([]byte(tt.data), "Radio")
+			if err != nil {
+				t.Fatalf("error parsing: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseStationList([]byte("#EXTM3U\n/music/song.mp3\n"), "Radio"); err == nil {
+		t.Error("got no error for a playlist without stream URLs")
+	}
+}
+
+func TestImportStations(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Jazz Stations.m3u")
+	data := "http://example.com/one\nhttp://example.com/two\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stations, err := ReadStationFile(path)
+	if err != nil {
+		t.Fatalf("error reading station file: %v", err)
+	}
+	if stations[0].Name != "Jazz Stations 1" || stations[1].Name != "Jazz Stations 2" {
+		t.Errorf("got names %q and %q, want them named after the file", stations[0].Name, stations[1].Name)
+	}
+
+	store := NewStationStore(dir)
+	if added, err := store.Add(stations...); err != nil || added != 2 {
+		t.Fatalf("got %d added and error %v, want 2 and none", added, err)
+	}
+	if added, err := store.Add(stations...); err != nil || added != 0 {
+		t.Errorf("got %d added importing again, want 0", added)
+	}
+	if got := NewStationStore(dir).List(); !reflect.DeepEqual(got, stations) {
+		t.Errorf("got %+v after reloading, want %+v", got, stations)
+	}
+}
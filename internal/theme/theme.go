@@ -0,0 +1,284 @@
+package theme
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Reset ends a colored span and returns to the widget's default style
+const Reset = "[-:-:-]"
+
+// Theme maps semantic roles to tview styles. Each value is the inside of a
+// tview color tag: a color name or #rrggbb, optionally followed by
+// background and attributes, e.g. "yellow", "#ff8800", "-::b" or "white:red:b".
+// "-" keeps the terminal's default color.
+type Theme struct {
+	Name string `json:"name"`
+
+	Text   string `json:"text"`
+	Accent string `json:"accent"`
+	Label  string `json:"label"`
+	Muted  string `json:"muted"`
+	Error  string `json:"error"`
+	Folder string `json:"folder"`
+	Info   string `json:"info"`
+	Border string `json:"border"`
+	Title  string `json:"title"`
+
+	Playing string `json:"playing"`
+	Paused  string `json:"paused"`
+	Stopped string `json:"stopped"`
+	Loved   string `json:"loved"`
+	Enabled string `json:"enabled"`
+
+	ProgressFill  string `json:"progress_fill"`
+	ProgressDrag  string `json:"progress_drag"`
+	ProgressEmpty string `json:"progress_empty"`
+
+	// Album art shades from darkest to brightest, plus the note glyphs
+	ArtShadow    string `json:"art_shadow"`
+	ArtMid       string `json:"art_mid"`
+	ArtLight     string `json:"art_light"`
+	ArtHighlight string `json:"art_highlight"`
+	ArtNote      string `json:"art_note"`
+}
+
+var builtins = map[string]Theme{
+	"default": {
+		Name:          "default",
+		Text:          "white",
+		Accent:        "yellow",
+		Label:         "green",
+		Muted:         "::d",
+		Error:         "red",
+		Folder:        "blue",
+		Info:          "cyan",
+		Border:        "white",
+		Title:         "white",
+		Playing:       "yellow",
+		Paused:        "yellow",
+		Stopped:       "red",
+		Loved:         "red",
+		Enabled:       "green",
+		ProgressFill:  "green",
+		ProgressDrag:  "yellow",
+		ProgressEmpty: "::d",
+		ArtShadow:     "gray",
+		ArtMid:        "darkgray",
+		ArtLight:      "lightgray",
+		ArtHighlight:  "white",
+		ArtNote:       "yellow",
+	},
+	"dark": {
+		Name:          "dark",
+		Text:          "#d0d0d0",
+		Accent:        "#ffaf5f",
+		Label:         "#87afd7",
+		Muted:         "#6c6c6c",
+		Error:         "#ff5f5f",
+		Folder:        "#5fafff",
+		Info:          "#5fd7d7",
+		Border:        "#4e4e4e",
+		Title:         "#ffaf5f",
+		Playing:       "#87d787",
+		Paused:        "#ffd75f",
+		Stopped:       "#ff5f5f",
+		Loved:         "#ff5f87",
+		Enabled:       "#87d787",
+		ProgressFill:  "#87d787",
+		ProgressDrag:  "#ffaf5f",
+		ProgressEmpty: "#3a3a3a",
+		ArtShadow:     "#444444",
+		ArtMid:        "#767676",
+		ArtLight:      "#a8a8a8",
+		ArtHighlight:  "#eeeeee",
+		ArtNote:       "#ffaf5f",
+	},
+	"light": {
+		Name:          "light",
+		Text:          "black",
+		Accent:        "#af5f00",
+		Label:         "#005f87",
+		Muted:         "#808080",
+		Error:         "#d70000",
+		Folder:        "#0000af",
+		Info:          "#008787",
+		Border:        "#808080",
+		Title:         "black",
+		Playing:       "#008700",
+		Paused:        "#af8700",
+		Stopped:       "#d70000",
+		Loved:         "#d7005f",
+		Enabled:       "#008700",
+		ProgressFill:  "#008700",
+		ProgressDrag:  "#af5f00",
+		ProgressEmpty: "#c6c6c6",
+		ArtShadow:     "#d0d0d0",
+		ArtMid:        "#9e9e9e",
+		ArtLight:      "#626262",
+		ArtHighlight:  "black",
+		ArtNote:       "#af5f00",
+	},
+	"monochrome": {
+		Name:          "monochrome",
+		Text:          "-",
+		Accent:        "-::b",
+		Label:         "-::b",
+		Muted:         "-::d",
+		Error:         "-::r",
+		Folder:        "-::b",
+		Info:          "-::u",
+		Border:        "-",
+		Title:         "-::b",
+		Playing:       "-::b",
+		Paused:        "-::d",
+		Stopped:       "-::d",
+		Loved:         "-::b",
+		Enabled:       "-::b",
+		ProgressFill:  "-",
+		ProgressDrag:  "-::r",
+		ProgressEmpty: "-::d",
+		ArtShadow:     "-::d",
+		ArtMid:        "-::d",
+		ArtLight:      "-",
+		ArtHighlight:  "-::b",
+		ArtNote:       "-::b",
+	},
+}
+
+var (
+	mu      sync.RWMutex
+	current = builtins["default"]
+)
+
+// Default returns the built-in default theme
+func Default() Theme {
+	return builtins["default"]
+}
+
+// Current returns the theme components render with
+func Current() Theme {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// SetCurrent makes t the active theme and applies its base colors to tview's
+// global styles, which new widgets pick up
+func SetCurrent(t Theme) {
+	mu.Lock()
+	current = t
+	mu.Unlock()
+
+	tview.Styles.PrimaryTextColor = t.Color(t.Text)
+	tview.Styles.SecondaryTextColor = t.Color(t.Accent)
+	tview.Styles.TertiaryTextColor = t.Color(t.Label)
+	tview.Styles.BorderColor = t.Color(t.Border)
+	tview.Styles.GraphicsColor = t.Color(t.Border)
+	tview.Styles.TitleColor = t.Color(t.Title)
+}
+
+// Dir returns the directory custom theme files are loaded from
+func Dir(configDir string) string {
+	return filepath.Join(configDir, "themes")
+}
+
+// Load returns the theme called name: a built-in or dir/<name>.json.
+// Roles missing from a custom theme are taken from the default theme.
+func Load(name, dir string) (Theme, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Default(), nil
+	}
+	if t, ok := builtins[strings.ToLower(name)]; ok {
+		return t, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, filepath.Base(name)+".json"))
+	if err != nil {
+		return Default(), fmt.Errorf("theme %q not found", name)
+	}
+
+	t := Default()
+	if err := json.Unmarshal(data, &t); err != nil {
+		return Default(), fmt.Errorf("error parsing theme %q: %v", name, err)
+	}
+	t.Name = name
+	return t, nil
+}
+
+// Names lists the built-in themes followed by the custom themes in dir
+func Names(dir string) []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return names
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		name = strings.TrimSuffix(name, ".json")
+		if _, ok := builtins[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Tag returns the tview tag for a role value, e.g. t.Tag(t.Accent)
+func (t Theme) Tag(role string) string {
+	if role == "" {
+		return Reset
+	}
+	return "[" + role + "]"
+}
+
+// Paint wraps text in the role's style and resets afterwards
+func (t Theme) Paint(role, text string) string {
+	return t.Tag(role) + text + Reset
+}
+
+// Color returns the foreground color of a role value
+func (t Theme) Color(role string) tcell.Color {
+	fg, _, _ := strings.Cut(role, ":")
+	if fg == "" || fg == "-" {
+		return tcell.ColorDefault
+	}
+	return tcell.GetColor(fg)
+}
+
+// Render replaces semantic tags in markup with this theme's styles. The
+// tags are the role names in brackets, e.g. "[label]Title:[/] %s", where
+// [/] resets to the default style.
+func (t Theme) Render(markup string) string {
+	return strings.NewReplacer(
+		"[/]", Reset,
+		"[text]", t.Tag(t.Text),
+		"[accent]", t.Tag(t.Accent),
+		"[label]", t.Tag(t.Label),
+		"[muted]", t.Tag(t.Muted),
+		"[error]", t.Tag(t.Error),
+		"[folder]", t.Tag(t.Folder),
+		"[info]", t.Tag(t.Info),
+		"[playing]", t.Tag(t.Playing),
+		"[paused]", t.Tag(t.Paused),
+		"[stopped]", t.Tag(t.Stopped),
+		"[loved]", t.Tag(t.Loved),
+		"[enabled]", t.Tag(t.Enabled),
+	).Replace(markup)
+}
@@ -0,0 +1,82 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"clispot/internal/command"
+	"clispot/internal/library"
+)
+
+// setBrowseMode switches the browser to another view tab
+func (a *App) setBrowseMode(mode library.BrowseMode) {
+	a.radioVisible = false
+	a.podcast.visible = false
+	a.library.SetBrowseMode(mode)
+	a.reloadItems()
+	a.songList.SetCurrentItem(0)
+}
+
+func (a *App) nextView() {
+	a.setBrowseMode(a.library.BrowseMode().Next())
+}
+
+func (a *App) previousView() {
+	a.setBrowseMode(a.library.BrowseMode().Previous())
+}
+
+func (a *App) viewCommand(args []string) (string, error) {
+	if err := command.Expect(args, 0, 1, "view [folders|artists|albums|genres|years]"); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		a.nextView()
+		return "", nil
+	}
+
+	mode, err := library.ParseBrowseMode(args[0])
+	if err != nil {
+		return "", err
+	}
+	a.setBrowseMode(mode)
+	return "", nil
+}
+
+func completeView(args []string) []string {
+	if len(args) != 1 {
+		return nil
+	}
+	var names []string
+	for _, mode := range library.BrowseModes {
+		names = append(names, strings.ToLower(mode.String()))
+	}
+	return names
+}
+
+// albumDetails returns the info panel lines for the album artist, disc and
+// composer of song, each labelled with the style tag and skipped when empty
+func albumDetails(song library.Song, style string) string {
+	var lines strings.Builder
+	line := func(label, value string) {
+		lines.WriteString(markup(fmt.Sprintf("[%s]%s:[/] ", style, label)) + value + "\n")
+	}
+	if artist := song.GroupArtist(); artist != song.Artist {
+		line("Album Artist", artist)
+	}
+	if song.Disc > 0 {
+		line("Disc", fmt.Sprintf("%d", song.Disc))
+	}
+	if song.Composer != "" {
+		line("Composer", song.Composer)
+	}
+	return lines.String()
+}
+
+// tagSourceText names the tags the song's metadata was read from
+func tagSourceText(song library.Song) string {
+	sources := song.TagSources()
+	if len(sources) == 0 {
+		return "none"
+	}
+	return strings.Join(sources, ", ")
+}
@@ -0,0 +1,126 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"clispot/internal/library"
+)
+
+// chapterRestart is how far into a chapter previous-chapter goes back to
+// its start rather than to the chapter before
+const chapterRestart = 3 * time.Second
+
+// chapterListSize is how many chapters the info panel lists at once;
+// audiobooks can have hundreds
+const chapterListSize = 9
+
+// chapterStarts returns the start of each of the song's chapters, for the
+// progress bar's ticks
+func chapterStarts(song library.Song) []time.Duration {
+	if len(song.Chapters) == 0 {
+		return nil
+	}
+	starts := make([]time.Duration, len(song.Chapters))
+	for i, chapter := range song.Chapters {
+		starts[i] = chapter.Start
+	}
+	return starts
+}
+
+// chapterText lists the song's chapters for the info panel, marking the
+// one at index current and showing only those around it when there are
+// many; it is empty when the song has none
+func chapterText(song library.Song, current int, label string) string {
+	if len(song.Chapters) == 0 {
+		return ""
+	}
+
+	var text strings.Builder
+	text.WriteString(markup(fmt.Sprintf("\n\n[%s]Chapters:[/]", label)))
+	first := min(max(current-chapterListSize/2, 0), max(len(song.Chapters)-chapterListSize, 0))
+	last := min(first+chapterListSize, len(song.Chapters))
+	if first > 0 {
+		text.WriteString(markup(fmt.Sprintf("\n[muted]  … %d earlier[/]", first)))
+	}
+	for i := first; i < last; i++ {
+		chapter := song.Chapters[i]
+		line := fmt.Sprintf("%s %s", formatChapterTime(chapter.Start), tview.Escape(chapter.Title))
+		if i == current {
+			text.WriteString(markup("\n[playing]▶ " + line + "[/]"))
+		} else {
+			text.WriteString("\n  " + line)
+		}
+	}
+	if last < len(song.Chapters) {
+		text.WriteString(markup(fmt.Sprintf("\n[muted]  … %d more[/]", len(song.Chapters)-last)))
+	}
+	return text.String()
+}
+
+// formatChapterTime shows a chapter start as m:ss, or h:mm:ss in long files
+func formatChapterTime(d time.Duration) string {
+	total := int(d.Seconds())
+	if total >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", total/3600, total/60%60, total%60)
+	}
+	return fmt.Sprintf("%d:%02d", total/60, total%60)
+}
+
+// playingChapters returns the playing song and the index of its current
+// chapter, or an error when nothing with chapters is playing
+func (a *App) playingChapters() (library.Song, int, error) {
+	state := a.player.GetState()
+	if state.CurrentSong == "" {
+		return library.Song{}, 0, fmt.Errorf("nothing playing")
+	}
+	song, ok := a.library.FindSong(state.CurrentSong)
+	if !ok || len(song.Chapters) == 0 {
+		return library.Song{}, 0, fmt.Errorf("the current song has no chapters")
+	}
+	return song, song.ChapterAt(state.Position), nil
+}
+
+// nextChapter seeks to the start of the chapter after the current one
+func (a *App) nextChapter() {
+	song, current, err := a.playingChapters()
+	if err != nil {
+		a.showError(err.Error())
+		return
+	}
+	if current+1 >= len(song.Chapters) {
+		a.flashStatus("Last chapter")
+		return
+	}
+	a.seekToChapter(song, current+1)
+}
+
+// previousChapter seeks to the start of the current chapter, or to the one
+// before when the current chapter has only just started
+func (a *App) previousChapter() {
+	song, current, err := a.playingChapters()
+	if err != nil {
+		a.showError(err.Error())
+		return
+	}
+	target := current
+	if current < 0 || a.player.GetState().Position-song.Chapters[current].Start < chapterRestart {
+		target = current - 1
+	}
+	a.seekToChapter(song, max(target, 0))
+}
+
+func (a *App) seekToChapter(song library.Song, index int) {
+	if err := a.player.Seek(song.Chapters[index].Start); err != nil {
+		a.showError(err.Error())
+		return
+	}
+	state := a.player.GetState()
+	a.progressBar.Update(state.Position, state.Duration)
+	a.updateProgressPanel()
+	a.updateInfoPanel()
+	a.flashStatus(fmt.Sprintf("Chapter %d/%d: %s", index+1, len(song.Chapters), tview.Escape(song.Chapters[index].Title)))
+}
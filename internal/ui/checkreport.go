@@ -0,0 +1,134 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"clispot/internal/library"
+	"clispot/internal/settings"
+	"clispot/internal/theme"
+)
+
+// RunCheckReport shows the results of a library check in a panel: the
+// files with problems on the left and the selected file's issues on the
+// right. q or Esc closes it.
+func RunCheckReport(root string, results []library.CheckResult) error {
+	th, _ := theme.Load(settings.NewManager().Get().Theme, theme.Dir(settings.ConfigDir()))
+	theme.SetCurrent(th)
+
+	var problems []library.CheckResult
+	for _, result := range results {
+		if len(result.Issues) > 0 {
+			problems = append(problems, result)
+		}
+	}
+
+	app := tview.NewApplication()
+	files := tview.NewList().ShowSecondaryText(false).SetHighlightFullLine(true)
+	details := tview.NewTextView().SetDynamicColors(true).SetWordWrap(true)
+	summary := tview.NewTextView().SetDynamicColors(true)
+
+	for _, box := range []*tview.Box{files.Box, details.Box} {
+		box.SetBorder(true).
+			SetBorderColor(th.Color(th.Border)).
+			SetTitleColor(th.Color(th.Title))
+	}
+	files.SetTitle(" Files With Problems ")
+	files.SetMainTextColor(th.Color(th.Text))
+	details.SetTextColor(th.Color(th.Text))
+	details.SetTitle(" Issues ")
+	summary.SetTextColor(th.Color(th.Text))
+
+	showDetails := func(idx int) {
+		if idx < 0 || idx >= len(problems) {
+			return
+		}
+		details.SetText(checkDetails(root, problems[idx]))
+		details.ScrollToBeginning()
+	}
+	for _, result := range problems {
+		role := "paused"
+		if result.HasErrors() {
+			role = "error"
+		}
+		files.AddItem(markup(fmt.Sprintf("[%s]%s[/] %s", role, severityMark(result), tview.Escape(relativeTo(root, result.Path)))), "", 0, nil)
+	}
+	files.SetChangedFunc(func(idx int, _, _ string, _ rune) {
+		showDetails(idx)
+	})
+
+	errors := 0
+	for _, result := range problems {
+		if result.HasErrors() {
+			errors++
+		}
+	}
+	summary.SetText(markup(fmt.Sprintf(" Checked %d files: [error]%d with errors[/], [paused]%d with warnings[/]   [muted]↑/↓ select, Tab switch panes, q quit[/]",
+		len(results), errors, len(problems)-errors)))
+
+	if len(problems) == 0 {
+		details.SetText(markup("[playing]No problems found[/]"))
+	} else {
+		showDetails(0)
+	}
+
+	panes := []tview.Primitive{files, details}
+	focused := 0
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(tview.NewFlex().
+			AddItem(files, 0, 1, true).
+			AddItem(details, 0, 1, false), 0, 1, true).
+		AddItem(summary, 1, 0, false)
+
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape, event.Rune() == 'q':
+			app.Stop()
+			return nil
+		case event.Key() == tcell.KeyTab:
+			focused = (focused + 1) % len(panes)
+			app.SetFocus(panes[focused])
+			return nil
+		}
+		return event
+	})
+	return app.SetRoot(layout, true).EnableMouse(true).Run()
+}
+
+// checkDetails describes the issues of one file for the details pane
+func checkDetails(root string, result library.CheckResult) string {
+	var b strings.Builder
+	b.WriteString(markup(fmt.Sprintf("[label]File:[/] %s\n", tview.Escape(relativeTo(root, result.Path)))))
+	if result.Duration > 0 {
+		seconds := int(result.Duration)
+		b.WriteString(markup(fmt.Sprintf("[label]Length:[/] %d:%02d\n", seconds/60, seconds%60)))
+	}
+	b.WriteString("\n")
+	for _, issue := range result.Issues {
+		role := "paused"
+		if issue.Severity == library.SeverityError {
+			role = "error"
+		}
+		b.WriteString(markup(fmt.Sprintf("[%s]%s[/] ", role, issue.Kind)))
+		b.WriteString(tview.Escape(issue.Message) + "\n")
+	}
+	return b.String()
+}
+
+func severityMark(result library.CheckResult) string {
+	if result.HasErrors() {
+		return "✗"
+	}
+	return "!"
+}
+
+func relativeTo(root, path string) string {
+	if rel, err := filepath.Rel(root, path); err == nil {
+		return rel
+	}
+	return path
+}
@@ -0,0 +1,525 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"clispot/internal/command"
+	"clispot/internal/keymap"
+	"clispot/internal/library"
+	"clispot/internal/settings"
+	"clispot/internal/theme"
+)
+
+// scriptName is the command script run at startup, if present
+const scriptName = "clispotrc"
+
+// newCommands builds the command registry: every keymap action becomes a
+// command without arguments, and commands with arguments are added on top.
+// Commands that are not keymap actions are registered with the keymap so
+// keys can be bound to them as well.
+func (a *App) newCommands() *command.Registry {
+	registry := command.NewRegistry()
+
+	for name, fn := range a.actionHandlers() {
+		fn := fn
+		registry.Register(command.Command{
+			Name:        name,
+			Usage:       name,
+			Description: keymap.Describe(name),
+			Run: func(args []string) (string, error) {
+				if len(args) > 0 {
+					return "", fmt.Errorf("%s takes no arguments", name)
+				}
+				fn()
+				return "", nil
+			},
+		})
+	}
+
+	registry.Register(command.Command{
+		Name:        "seek",
+		Usage:       "seek <[h:]m:ss|seconds|+s|-s>",
+		Description: "Jump to a position in the current song",
+		Run:         a.seekCommand,
+	})
+	registry.Register(command.Command{
+		Name:        "vol",
+		Aliases:     []string{"volume"},
+		Usage:       "vol <0-100|+n|-n>",
+		Description: "Set the volume",
+		Run:         a.volumeCommand,
+	})
+	registry.Register(command.Command{
+		Name:        "repeat",
+		Usage:       "repeat [off|one|all]",
+		Description: "Set or cycle the repeat mode",
+		Run:         a.repeatCommand,
+		Complete: func(args []string) []string {
+			if len(args) == 1 {
+				return []string{"off", "one", "all"}
+			}
+			return nil
+		},
+	})
+	registry.Register(command.Command{
+		Name:        "playlist",
+		Usage:       "playlist add|create|play|list [name]",
+		Description: "Manage playlists",
+		Run:         a.playlistCommand,
+		Complete:    a.completePlaylist,
+	})
+	registry.Register(command.Command{
+		Name:        "goto",
+		Aliases:     []string{"cd"},
+		Usage:       "goto <folder>",
+		Description: "Browse a folder relative to the library root",
+		Run:         a.gotoCommand,
+		Complete:    a.completeFolder,
+	})
+	registry.Register(command.Command{
+		Name:        "view",
+		Usage:       "view [folders|artists|albums|genres|years]",
+		Description: "Browse by folder or by tags",
+		Run:         a.viewCommand,
+		Complete:    completeView,
+	})
+	registry.Register(command.Command{
+		Name:        "theme",
+		Usage:       "theme [name]",
+		Description: "Switch or cycle the color theme",
+		Run:         a.themeCommand,
+		Complete: func(args []string) []string {
+			if len(args) == 1 {
+				return theme.Names(theme.Dir(settings.ConfigDir()))
+			}
+			return nil
+		},
+	})
+	registry.Register(command.Command{
+		Name:        "layout",
+		Usage:       "layout [auto|full|compact|mini]",
+		Description: "Set or cycle the screen layout",
+		Run:         a.layoutCommand,
+		Complete: func(args []string) []string {
+			if len(args) == 1 {
+				return append([]string{"auto"}, layoutNames...)
+			}
+			return nil
+		},
+	})
+	registry.Register(command.Command{
+		Name:        "tag",
+		Usage:       "tag [all | <field> <value>]",
+		Description: "Edit the tags of the selection or of every listed song, or set one field",
+		Run:         a.tagCommand,
+		Complete:    completeTag,
+	})
+	registry.Register(command.Command{
+		Name:        "lyrics",
+		Usage:       "lyrics [offset <seconds>]",
+		Description: "Toggle the lyrics panel, or shift the current song's lyrics",
+		Run:         a.lyricsCommand,
+		Complete: func(args []string) []string {
+			if len(args) == 1 {
+				return []string{"offset"}
+			}
+			return nil
+		},
+	})
+	registry.Register(command.Command{
+		Name:        "radio",
+		Usage:       radioUsage,
+		Description: "Show the radio stations, play one or a stream URL, or manage the list",
+		Run:         a.radioCommand,
+		Complete:    a.completeRadio,
+	})
+	registry.Register(command.Command{
+		Name:        "podcast",
+		Usage:       podcastUsage,
+		Description: "Show the podcasts, subscribe to a feed, refresh, download or mark episodes",
+		Run:         a.podcastCommand,
+		Complete:    a.completePodcast,
+	})
+	registry.Register(command.Command{
+		Name:        "rescan",
+		Usage:       "rescan",
+		Description: "Scan the library folder again",
+		Run:         a.rescanCommand,
+	})
+	registry.Register(command.Command{
+		Name:        "search",
+		Usage:       "search [query]",
+		Description: "Filter the library; without a query, open the search field",
+		Run: func(args []string) (string, error) {
+			if len(args) == 0 {
+				a.enterSearchMode()
+				return "", nil
+			}
+			a.applySearch(strings.Join(args, " "))
+			return fmt.Sprintf("%d songs", len(a.filteredSongs)), nil
+		},
+	})
+	registry.Register(command.Command{
+		Name:        "help",
+		Usage:       "help [command]",
+		Description: "Show how to use a command",
+		Run:         a.helpCommand,
+		Complete: func(args []string) []string {
+			if len(args) != 1 {
+				return nil
+			}
+			var names []string
+			for _, cmd := range a.commands.Commands() {
+				names = append(names, cmd.Name)
+			}
+			return names
+		},
+	})
+	registry.Register(command.Command{
+		Name:        "q",
+		Usage:       "q",
+		Description: keymap.Describe("quit"),
+		Run: func(args []string) (string, error) {
+			a.app.Stop()
+			return "", nil
+		},
+	})
+
+	for _, cmd := range registry.Commands() {
+		keymap.RegisterAction(cmd.Name, cmd.Description)
+	}
+	return registry
+}
+
+// runCommand executes a command line and reports the outcome in the status bar
+func (a *App) runCommand(line string) {
+	message, err := a.commands.Execute(line)
+	if err != nil {
+		a.showError(err.Error())
+		return
+	}
+	if message != "" {
+		a.flashStatus(message)
+	}
+}
+
+// runScript runs the startup command script from the config directory
+func (a *App) runScript() {
+	file, err := os.Open(filepath.Join(settings.ConfigDir(), scriptName))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	if err := a.commands.RunScript(file); err != nil {
+		a.showError(fmt.Sprintf("%s: %v", scriptName, err))
+	}
+}
+
+func (a *App) enterCommandMode() {
+	a.isCommandMode = true
+	a.commandInput.SetText("")
+	a.bottomBar.SwitchToPage("command")
+	a.app.SetFocus(a.commandInput)
+}
+
+func (a *App) exitCommandMode() {
+	a.isCommandMode = false
+	a.commandInput.SetText("")
+	a.bottomBar.SwitchToPage("status")
+	a.app.SetFocus(a.songList)
+}
+
+func (a *App) submitCommand() {
+	line := a.commandInput.GetText()
+	a.exitCommandMode()
+	a.updateStatusBar()
+	a.runCommand(line)
+}
+
+// completeCommand completes the command line to the longest common prefix
+// of the candidates; pressing Tab again cycles through them
+func (a *App) completeCommand() {
+	text := a.commandInput.GetText()
+
+	if n := len(a.completions); n > 0 && text == a.completions[a.completionIdx] {
+		a.completionIdx = (a.completionIdx + 1) % n
+		a.commandInput.SetText(a.completions[a.completionIdx])
+		return
+	}
+
+	candidates := a.commands.Complete(text)
+	a.completions, a.completionIdx = nil, 0
+	switch {
+	case len(candidates) == 1:
+		a.commandInput.SetText(candidates[0] + " ")
+	case len(candidates) > 1:
+		if prefix := command.CommonPrefix(candidates); len(prefix) > len(text) {
+			a.commandInput.SetText(prefix)
+			return
+		}
+		a.completions = candidates
+		a.commandInput.SetText(candidates[0])
+	}
+}
+
+func (a *App) seekCommand(args []string) (string, error) {
+	if err := command.Expect(args, 1, 1, "seek <[h:]m:ss|seconds|+s|-s>"); err != nil {
+		return "", err
+	}
+	position, relative, err := command.ParseTime(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	state := a.player.GetState()
+	if relative {
+		position += state.Position
+	}
+	if err := a.player.Seek(position); err != nil {
+		return "", err
+	}
+
+	state = a.player.GetState()
+	a.progressBar.Update(state.Position, state.Duration)
+	a.updateProgressPanel()
+	return fmt.Sprintf("Position: %s", a.formatDuration(state.Position)), nil
+}
+
+func (a *App) volumeCommand(args []string) (string, error) {
+	if err := command.Expect(args, 1, 1, "vol <0-100|+n|-n>"); err != nil {
+		return "", err
+	}
+	level, relative, err := command.ParseLevel(args[0])
+	if err != nil {
+		return "", err
+	}
+	if relative {
+		level += int(a.player.GetVolume()*100 + 0.5)
+	}
+
+	a.player.SetVolume(float64(level) / 100)
+	return fmt.Sprintf("Volume: %.0f%%", a.player.GetVolume()*100), nil
+}
+
+func (a *App) repeatCommand(args []string) (string, error) {
+	if err := command.Expect(args, 0, 1, "repeat [off|one|all]"); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		a.cycleRepeatMode()
+		return "", nil
+	}
+
+	mode, err := settings.ParseRepeatMode(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	a.player.SetRepeatMode(mode)
+	a.settingsManager.Update(func(s *settings.Settings) {
+		s.RepeatMode = mode
+	})
+	return fmt.Sprintf("Repeat: %s", tview.Escape(mode.String())), nil
+}
+
+func (a *App) playlistCommand(args []string) (string, error) {
+	usage := "playlist add|create|play|list [name]"
+	if err := command.Expect(args, 1, 2, usage); err != nil {
+		return "", err
+	}
+
+	if args[0] == "list" {
+		var names []string
+		for _, p := range a.playlists.GetAllPlaylists() {
+			names = append(names, fmt.Sprintf("%s (%d)", p.Name, len(p.Songs)))
+		}
+		if len(names) == 0 {
+			return "No playlists", nil
+		}
+		return strings.Join(names, ", "), nil
+	}
+
+	if len(args) != 2 {
+		return "", fmt.Errorf("usage: %s", usage)
+	}
+	name := args[1]
+
+	switch args[0] {
+	case "create":
+		if _, err := a.playlists.CreatePlaylist(name, ""); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Created playlist %s", name), nil
+
+	case "add":
+		song := a.selectedSong()
+		if song == nil {
+			current, ok := a.library.FindSong(a.player.GetCurrentSong())
+			if !ok {
+				return "", fmt.Errorf("no song selected")
+			}
+			song = &current
+		}
+		if _, err := a.playlists.GetPlaylist(name); err != nil {
+			if _, err := a.playlists.CreatePlaylist(name, ""); err != nil {
+				return "", err
+			}
+		}
+		if err := a.playlists.AddSongToPlaylist(name, *song); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Added %s to %s", song.Title, name), nil
+
+	case "play":
+		p, err := a.playlists.GetPlaylist(name)
+		if err != nil {
+			return "", err
+		}
+		if len(p.Songs) == 0 {
+			return "", fmt.Errorf("playlist %s is empty", name)
+		}
+		a.filteredSongs = append([]library.Song(nil), p.Songs...)
+		a.currentItems = songItems(a.filteredSongs)
+		a.currentIdx = 0
+		a.populateLibraryList()
+		a.songList.SetCurrentItem(0)
+		a.playSpecificSong(&a.filteredSongs[0])
+		return fmt.Sprintf("Playing %s", name), nil
+	}
+	return "", fmt.Errorf("usage: %s", usage)
+}
+
+func (a *App) completePlaylist(args []string) []string {
+	switch len(args) {
+	case 1:
+		return []string{"add", "create", "play", "list"}
+	case 2:
+		var names []string
+		for _, p := range a.playlists.GetAllPlaylists() {
+			names = append(names, p.Name)
+		}
+		return names
+	}
+	return nil
+}
+
+// libraryPath resolves a folder given relative to the library root
+func (a *App) libraryPath(folder string) string {
+	root := a.library.GetRootPath()
+	return filepath.Join(root, filepath.FromSlash(strings.TrimPrefix(folder, "/")))
+}
+
+func (a *App) gotoCommand(args []string) (string, error) {
+	if err := command.Expect(args, 1, 1, "goto <folder>"); err != nil {
+		return "", err
+	}
+	if err := a.library.NavigateToFolder(a.libraryPath(args[0])); err != nil {
+		return "", err
+	}
+	a.library.SetBrowseMode(library.BrowseFolders)
+	a.reloadItems()
+	return "", nil
+}
+
+// completeFolder lists library folders matching the typed path
+func (a *App) completeFolder(args []string) []string {
+	if len(args) != 1 {
+		return nil
+	}
+
+	typed := args[0]
+	if !strings.HasPrefix(typed, "/") {
+		typed = "/" + typed
+	}
+	parent := typed[:strings.LastIndex(typed, "/")+1]
+
+	entries, err := os.ReadDir(a.libraryPath(parent))
+	if err != nil {
+		return nil
+	}
+	var folders []string
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			folders = append(folders, parent+entry.Name())
+		}
+	}
+	return folders
+}
+
+func (a *App) themeCommand(args []string) (string, error) {
+	if err := command.Expect(args, 0, 1, "theme [name]"); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		a.cycleTheme()
+		return "", nil
+	}
+	return "", a.setTheme(args[0])
+}
+
+// rescanCommand scans the library in the background and swaps in the result
+func (a *App) rescanCommand(args []string) (string, error) {
+	if err := command.Expect(args, 0, 0, "rescan"); err != nil {
+		return "", err
+	}
+
+	go func() {
+		started := time.Now()
+		// Scan aside and swap the songs in on the UI goroutine, which reads
+		// the library throughout
+		songs, problems, err := a.library.Scan()
+		a.app.QueueUpdateDraw(func() {
+			if err != nil {
+				a.showError(fmt.Sprintf("Error scanning library: %v", err))
+				return
+			}
+			a.library.SetSongs(songs)
+			a.applyLibraryData()
+			a.songs = a.library.GetSongs()
+			if a.server != nil {
+				a.server.SetSongs(a.songs)
+			}
+			if items, err := a.library.GetCurrentItems(); err == nil {
+				a.currentItems = items
+				library.SortItems(a.currentItems, a.sortOrder)
+			}
+			if a.searchQuery != "" {
+				a.applySearch(a.searchQuery)
+			} else {
+				a.filteredSongs = a.songs
+				a.populateLibraryList()
+			}
+			message := fmt.Sprintf("Scanned %d songs in %s", len(songs), time.Since(started).Round(time.Millisecond))
+			switch len(problems) {
+			case 0:
+				a.flashStatus(message)
+			case 1:
+				a.showError(fmt.Sprintf("%s; %v", message, problems[0]))
+			default:
+				a.showError(fmt.Sprintf("%s; %d files had problems, such as: %v", message, len(problems), problems[0]))
+			}
+		})
+	}()
+	return "Scanning library…", nil
+}
+
+func (a *App) helpCommand(args []string) (string, error) {
+	if err := command.Expect(args, 0, 1, "help [command]"); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return "Commands: seek, vol, repeat, playlist, goto, view, theme, layout, rescan, search, help — Tab completes", nil
+	}
+	cmd, ok := a.commands.Lookup(args[0])
+	if !ok {
+		return "", fmt.Errorf("unknown command: %s", args[0])
+	}
+	return fmt.Sprintf("%s — %s", cmd.Usage, cmd.Description), nil
+}
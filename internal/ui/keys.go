@@ -0,0 +1,342 @@
+package ui
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"clispot/internal/keymap"
+)
+
+// sequenceTimeout is how long a partly typed key sequence like "g g" waits
+// for its next key
+const sequenceTimeout = time.Second
+
+// seekStep is how far the seek actions move
+const seekStep = 10 * time.Second
+
+// helpRow is one entry of the Controls panel. keys is shown before any
+// bound keys, for keys the widgets handle themselves.
+type helpRow struct {
+	label   string
+	keys    string
+	context string
+	actions []string
+}
+
+var helpRows = []helpRow{
+	{label: "Play/Pause", actions: []string{"play-pause"}},
+	{label: "Play/Open", actions: []string{"select"}},
+	{label: "Navigate", keys: "↑/↓", actions: []string{"up", "down"}},
+	{label: "Next song", actions: []string{"next"}},
+	{label: "Previous song", actions: []string{"previous"}},
+	{label: "Stop", actions: []string{"stop"}},
+	{label: "Seek -/+10s", actions: []string{"seek-backward", "seek-forward"}},
+	{label: "Chapter", actions: []string{"previous-chapter", "next-chapter"}},
+	{label: "Add to queue", actions: []string{"enqueue"}},
+	{label: "Search", actions: []string{"search"}},
+	{label: "Command line", actions: []string{"command-line"}},
+	{label: "Exit search", context: keymap.Search, actions: []string{"search-cancel"}},
+	{label: "Go back", actions: []string{"back"}},
+	{label: "Browse view", actions: []string{"next-view"}},
+	{label: "Volume", actions: []string{"volume-up", "volume-down"}},
+	{label: "Repeat mode", actions: []string{"repeat"}},
+	{label: "Shuffle", actions: []string{"shuffle"}},
+	{label: "Progress bar", actions: []string{"toggle-progress"}},
+	{label: "Sort order", actions: []string{"sort"}},
+	{label: "Stats", actions: []string{"stats"}},
+	{label: "Rate song", actions: []string{"rate-0", "rate-1", "rate-2", "rate-3", "rate-4", "rate-5"}},
+	{label: "Love song", actions: []string{"love"}},
+	{label: "Edit tags", actions: []string{"edit-tags"}},
+	{label: "Lyrics", actions: []string{"toggle-lyrics"}},
+	{label: "Radio", actions: []string{"toggle-radio"}},
+	{label: "Podcasts", actions: []string{"toggle-podcasts"}},
+	{label: "Lyrics offset", actions: []string{"lyrics-earlier", "lyrics-later"}},
+	{label: "Cycle theme", actions: []string{"cycle-theme"}},
+	{label: "Layout", actions: []string{"cycle-layout"}},
+	{label: "Settings info", actions: []string{"settings-info"}},
+	{label: "Quit", actions: []string{"quit"}},
+}
+
+// actionHandlers maps every keymap action to what it does; newCommands
+// turns each into a command
+func (a *App) actionHandlers() map[string]func() {
+	return map[string]func(){
+		"quit":               a.app.Stop,
+		"play-pause":         a.togglePlayPause,
+		"select":             a.handleSelection,
+		"next":               a.nextSong,
+		"previous":           a.previousSong,
+		"stop":               a.stopPlayback,
+		"seek-forward":       func() { a.seekBy(seekStep) },
+		"seek-backward":      func() { a.seekBy(-seekStep) },
+		"next-chapter":       a.nextChapter,
+		"previous-chapter":   a.previousChapter,
+		"enqueue":            a.enqueueSelected,
+		"search":             a.enterSearchMode,
+		"back":               a.navigateBack,
+		"next-view":          a.nextView,
+		"previous-view":      a.previousView,
+		"volume-up":          a.increaseVolume,
+		"volume-down":        a.decreaseVolume,
+		"repeat":             a.cycleRepeatMode,
+		"shuffle":            a.toggleShuffle,
+		"toggle-progress":    a.toggleProgressBar,
+		"sort":               a.cycleSortOrder,
+		"stats":              a.cycleStatsView,
+		"rate-0":             func() { a.rateSelected(0) },
+		"rate-1":             func() { a.rateSelected(1) },
+		"rate-2":             func() { a.rateSelected(2) },
+		"rate-3":             func() { a.rateSelected(3) },
+		"rate-4":             func() { a.rateSelected(4) },
+		"rate-5":             func() { a.rateSelected(5) },
+		"love":               a.toggleLovedSelected,
+		"edit-tags":          a.editTags,
+		"toggle-lyrics":      a.toggleLyrics,
+		"toggle-radio":       a.toggleRadio,
+		"toggle-podcasts":    a.togglePodcasts,
+		"lyrics-scroll-down": func() { a.scrollLyrics(3) },
+		"lyrics-scroll-up":   func() { a.scrollLyrics(-3) },
+		"lyrics-earlier":     func() { a.shiftLyrics(-lyricsOffsetStep) },
+		"lyrics-later":       func() { a.shiftLyrics(lyricsOffsetStep) },
+		"cycle-theme":        a.cycleTheme,
+		"cycle-layout":       a.cycleLayout,
+		"settings-info":      a.showSettingsInfo,
+		"down":               func() { a.moveSelection(1) },
+		"up":                 func() { a.moveSelection(-1) },
+		"page-down":          func() { a.moveSelection(pageSize) },
+		"page-up":            func() { a.moveSelection(-pageSize) },
+		"top":                func() { a.songList.SetCurrentItem(0) },
+		"bottom":             func() { a.songList.SetCurrentItem(-1) },
+		"search-submit": func() {
+			a.performSearch()
+			a.exitSearchMode()
+		},
+		"search-cancel":    a.exitSearchMode,
+		"command-line":     a.enterCommandMode,
+		"command-submit":   a.submitCommand,
+		"command-complete": a.completeCommand,
+		"command-cancel":   a.exitCommandMode,
+		"editor-save":      a.saveTags,
+		"editor-cancel":    a.closeTagEditor,
+	}
+}
+
+// pageSize is how many items the page-up and page-down actions move
+const pageSize = 10
+
+// keyContext returns the keymap context for the current UI mode
+func (a *App) keyContext() string {
+	if a.tagEditor != nil {
+		return keymap.Editor
+	}
+	if a.isCommandMode {
+		return keymap.Command
+	}
+	if a.isSearchMode {
+		return keymap.Search
+	}
+	return keymap.Browser
+}
+
+func (a *App) setupKeyBindings() {
+	a.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		a.ignoreSelect = false
+		actions, consumed := a.keys.Feed(a.keyContext(), keymap.KeyName(event))
+		if a.keys.Pending() {
+			a.waitForSequence()
+		}
+		for _, action := range actions {
+			a.runAction(action)
+		}
+		if !consumed {
+			return event
+		}
+		return nil
+	})
+
+	a.songList.SetSelectedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
+		if a.ignoreSelect {
+			a.ignoreSelect = false
+			return
+		}
+		a.handleSelection()
+	})
+}
+
+// waitForSequence gives up on a partly typed sequence after sequenceTimeout,
+// running whatever its keys so far are bound to
+func (a *App) waitForSequence() {
+	a.keyWait++
+	wait := a.keyWait
+	time.AfterFunc(sequenceTimeout, func() {
+		a.app.QueueUpdateDraw(func() {
+			if wait != a.keyWait || !a.keys.Pending() {
+				return
+			}
+			a.runAction(a.keys.Flush())
+		})
+	})
+}
+
+// runAction runs a bound action, which is a command name or a command line
+// starting with ":"
+func (a *App) runAction(action string) {
+	if action == "" {
+		return
+	}
+	a.runCommand(action)
+}
+
+// moveSelection moves the browser cursor by delta items
+func (a *App) moveSelection(delta int) {
+	count := a.songList.GetItemCount()
+	if count == 0 {
+		return
+	}
+	idx := a.songList.GetCurrentItem() + delta
+	if idx < 0 {
+		idx = 0
+	} else if idx >= count {
+		idx = count - 1
+	}
+	a.songList.SetCurrentItem(idx)
+}
+
+// seekBy moves the playback position of the current song by delta
+func (a *App) seekBy(delta time.Duration) {
+	state := a.player.GetState()
+	if state.CurrentSong == "" {
+		return
+	}
+	if err := a.player.Seek(state.Position + delta); err != nil {
+		a.showError(err.Error())
+		return
+	}
+	state = a.player.GetState()
+	a.progressBar.Update(state.Position, state.Duration)
+	a.updateProgressPanel()
+}
+
+// helpEntry is one "keys - label" cell of the Controls panel
+type helpEntry struct {
+	keys  string
+	label string
+}
+
+// helpEntries lists the Controls panel cells for the active keymap
+func (a *App) helpEntries() []helpEntry {
+	k := a.keys.Keymap()
+
+	var entries []helpEntry
+	for _, row := range helpRows {
+		context := row.context
+		if context == "" {
+			context = keymap.Browser
+		}
+
+		var keys []string
+		if row.keys != "" {
+			keys = append(keys, row.keys)
+		}
+		for _, action := range row.actions {
+			if key := preferredKey(k.KeysFor(context, action)); key != "" {
+				keys = append(keys, helpKeyName(key))
+			}
+		}
+		if len(keys) > 0 {
+			entries = append(entries, helpEntry{keys: joinKeys(keys), label: row.label})
+		}
+	}
+	return entries
+}
+
+// helpMarkup returns the contents of the Controls panel laid out in as many
+// columns as fit in width, and the number of lines it takes
+func (a *App) helpMarkup(width int) (string, int) {
+	entries := a.helpEntries()
+
+	columns := 1
+	for n := len(entries); n > 1; n-- {
+		if helpTableWidth(entries, n) <= width {
+			columns = n
+			break
+		}
+	}
+	widths := helpColumnWidths(entries, columns)
+
+	var help strings.Builder
+	help.WriteString(markup("[accent]Controls:[/]"))
+	lines := 1
+	for i, entry := range entries {
+		column := i % columns
+		if column == 0 {
+			help.WriteString("\n")
+			lines++
+		}
+		// Keys such as [ and ] are escaped after the theme tags are expanded,
+		// so "[/]" isn't taken for a closing tag
+		help.WriteString(markup("[label]") + tview.Escape(entry.keys) + markup("[/] - "+entry.label))
+		if column < columns-1 {
+			help.WriteString(strings.Repeat(" ", widths[column]-entry.width()))
+		}
+	}
+	return help.String(), lines
+}
+
+// helpColumnGap separates the columns of the Controls panel
+const helpColumnGap = 2
+
+// helpColumnWidths returns the width of each column, including the gap
+// after it, when entries are laid out row by row in the given columns
+func helpColumnWidths(entries []helpEntry, columns int) []int {
+	widths := make([]int, columns)
+	for i, entry := range entries {
+		widths[i%columns] = max(widths[i%columns], entry.width()+helpColumnGap)
+	}
+	return widths
+}
+
+func helpTableWidth(entries []helpEntry, columns int) int {
+	total := 0
+	for _, w := range helpColumnWidths(entries, columns) {
+		total += w
+	}
+	return total - helpColumnGap
+}
+
+func (e helpEntry) width() int {
+	return utf8.RuneCountInString(e.keys) + len(" - ") + utf8.RuneCountInString(e.label)
+}
+
+// preferredKey picks the key to show for an action, preferring "q" over its
+// uppercase twin "Q"
+func preferredKey(keys []string) string {
+	for _, key := range keys {
+		r, size := utf8.DecodeRuneInString(key)
+		if size != len(key) || !unicode.IsUpper(r) {
+			return key
+		}
+	}
+	if len(keys) > 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+// helpKeyName shortens long key names for the Controls panel
+func helpKeyName(key string) string {
+	return strings.NewReplacer("Backspace", "Bksp", "Ctrl+", "^").Replace(key)
+}
+
+// joinKeys shows a run of single keys such as 0 to 5 as "0-5"
+func joinKeys(keys []string) string {
+	if len(keys) > 2 {
+		return keys[0] + "-" + keys[len(keys)-1]
+	}
+	return strings.Join(keys, "/")
+}
@@ -0,0 +1,246 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"clispot/internal/command"
+	"clispot/internal/settings"
+)
+
+// layoutMode is how much of the interface fits on screen
+type layoutMode int
+
+const (
+	// layoutFull shows the browser, Now Playing and Controls panels
+	layoutFull layoutMode = iota
+	// layoutCompact drops the side panels for a one-line Now Playing
+	layoutCompact
+	// layoutMini is a two to three line player for tmux splits
+	layoutMini
+)
+
+var layoutNames = []string{"full", "compact", "mini"}
+
+func (m layoutMode) String() string {
+	return layoutNames[m]
+}
+
+// parseLayout reads a layout name; ok is false for "auto" and unknown names
+func parseLayout(name string) (layoutMode, bool) {
+	for i, layoutName := range layoutNames {
+		if strings.EqualFold(name, layoutName) {
+			return layoutMode(i), true
+		}
+	}
+	return layoutFull, false
+}
+
+// Terminal sizes below which auto layout falls back to a smaller mode
+const (
+	fullMinWidth     = 100
+	fullMinHeight    = 30
+	compactMinWidth  = 40
+	compactMinHeight = 12
+)
+
+// chooseLayout returns the layout set in the settings, or in auto mode the
+// largest one that fits the terminal
+func (a *App) chooseLayout() layoutMode {
+	if mode, ok := parseLayout(a.settingsManager.Get().Layout); ok {
+		return mode
+	}
+
+	mode := layoutFull
+	if a.screenWidth > 0 {
+		switch {
+		case a.screenWidth < compactMinWidth || a.screenHeight < compactMinHeight:
+			mode = layoutMini
+		case a.screenWidth < fullMinWidth || a.screenHeight < fullMinHeight:
+			mode = layoutCompact
+		}
+	}
+	if mode == layoutFull && a.settingsManager.Get().CompactMode {
+		mode = layoutCompact
+	}
+	return mode
+}
+
+// autoLayout runs before each draw and switches layouts when the terminal
+// is resized across a size threshold
+func (a *App) autoLayout(screen tcell.Screen) bool {
+	width, height := screen.Size()
+	if width == a.screenWidth && height == a.screenHeight {
+		return false
+	}
+	a.screenWidth, a.screenHeight = width, height
+
+	// The Controls panel reflows with the width too, so always rebuild.
+	// QueueUpdateDraw waits for the event loop, which is busy drawing.
+	go a.app.QueueUpdateDraw(a.applyLayout)
+	return false
+}
+
+// applyLayout arranges the existing widgets for the current layout mode.
+// Only the containers are rebuilt, so list contents, selection and input
+// text are kept.
+func (a *App) applyLayout() {
+	a.layout = a.chooseLayout()
+	showProgress := a.settingsManager.Get().ShowProgressBar
+
+	root := tview.NewFlex().SetDirection(tview.FlexRow)
+	switch a.layout {
+	case layoutFull:
+		leftPanel := tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(a.breadcrumb, 3, 0, false).
+			AddItem(a.songList, 0, 3, true).
+			AddItem(a.searchInput, 3, 0, false)
+
+		help, lines := a.helpMarkup(a.helpWidth())
+		a.helpText.SetText(help)
+
+		var infoPanel tview.Primitive = a.infoPanel
+		if a.lyrics.visible {
+			infoPanel = a.lyrics.panel
+		}
+		rightPanel := tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(infoPanel, 0, 2, false).
+			AddItem(a.helpText, lines+2, 0, false)
+
+		mainPanel := tview.NewFlex().SetDirection(tview.FlexColumn).
+			AddItem(leftPanel, 0, 2, true).
+			AddItem(rightPanel, 0, 1, false)
+
+		root.AddItem(mainPanel, 0, 1, true)
+
+	case layoutCompact:
+		// The lyrics take the list's place when shown
+		var list tview.Primitive = a.songList
+		if a.lyrics.visible {
+			list = a.lyrics.panel
+		}
+		root.AddItem(a.breadcrumb, 3, 0, false).
+			AddItem(list, 0, 1, true).
+			AddItem(a.searchInput, 3, 0, false).
+			AddItem(a.nowPlaying, 1, 0, false)
+
+	case layoutMini:
+		root.AddItem(a.nowPlaying, 1, 0, false)
+	}
+
+	if showProgress {
+		root.AddItem(a.progressPanel, 1, 0, false)
+	}
+	root.AddItem(a.bottomBar, 1, 0, false)
+
+	if a.tagEditor != nil {
+		pages := tview.NewPages().
+			AddPage("main", root, true, true).
+			AddPage("editor", a.tagEditorPage(), true, true)
+		a.app.SetRoot(pages, true)
+		a.app.SetFocus(a.tagEditor.form)
+		a.updateNowPlaying()
+		return
+	}
+
+	a.app.SetRoot(root, true)
+	switch {
+	case a.isCommandMode:
+		a.app.SetFocus(a.commandInput)
+	case a.isSearchMode:
+		a.app.SetFocus(a.searchInput)
+	default:
+		a.app.SetFocus(a.songList)
+	}
+	a.updateNowPlaying()
+}
+
+// helpWidth estimates the inner width of the Controls panel, which gets a
+// third of the screen in the full layout
+func (a *App) helpWidth() int {
+	if a.screenWidth == 0 {
+		return 38
+	}
+	return a.screenWidth/3 - 2
+}
+
+// setLayout saves the layout setting ("auto" or a mode name) and applies it
+func (a *App) setLayout(name string) error {
+	name = strings.ToLower(name)
+	if _, ok := parseLayout(name); !ok && name != "auto" {
+		return fmt.Errorf("unknown layout %q, use auto, full, compact or mini", name)
+	}
+
+	a.settingsManager.Update(func(s *settings.Settings) {
+		s.Layout = name
+	})
+	a.applyLayout()
+	return nil
+}
+
+// cycleLayout steps through full, compact and mini, then back to auto
+func (a *App) cycleLayout() {
+	next := "full"
+	if mode, ok := parseLayout(a.settingsManager.Get().Layout); ok {
+		if mode == layoutMini {
+			next = "auto"
+		} else {
+			next = layoutNames[mode+1]
+		}
+	}
+	if err := a.setLayout(next); err != nil {
+		a.showError(err.Error())
+		return
+	}
+	a.flashStatus(fmt.Sprintf("Layout: %s", next))
+}
+
+func (a *App) layoutCommand(args []string) (string, error) {
+	if err := command.Expect(args, 0, 1, "layout [auto|full|compact|mini]"); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		a.cycleLayout()
+		return "", nil
+	}
+	if err := a.setLayout(args[0]); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Layout: %s", strings.ToLower(args[0])), nil
+}
+
+// updateNowPlaying fills the one-line Now Playing bar used by the compact
+// and mini layouts
+func (a *App) updateNowPlaying() {
+	if a.nowPlaying == nil || a.layout == layoutFull {
+		return
+	}
+
+	state := a.player.GetState()
+	if state.CurrentSong == "" {
+		a.nowPlaying.SetText(markup(" [muted]Nothing playing[/]"))
+		return
+	}
+
+	status := markup("[playing]▶[/]")
+	if state.IsPaused {
+		status = markup("[paused]⏸[/]")
+	} else if !state.IsPlaying {
+		status = markup("[stopped]■[/]")
+	}
+
+	title := state.CurrentSong
+	if info, ok := a.player.StreamInfo(); ok {
+		title = fmt.Sprintf(markup("[accent]%s[/] [muted]· %s[/]"),
+			tview.Escape(info.Title), tview.Escape(a.stationName(state.CurrentSong)))
+	} else if show, episode, ok := a.podcasts.EpisodeByFile(state.CurrentSong); ok {
+		title = fmt.Sprintf(markup("[accent]%s[/] [muted]· %s[/]"), tview.Escape(episode.Title), tview.Escape(show.Title))
+	} else if song, ok := a.library.FindSong(state.CurrentSong); ok {
+		title = fmt.Sprintf(markup("[accent]%s[/] — %s [muted]· %s[/]"),
+			tview.Escape(song.Title), tview.Escape(song.Artist), tview.Escape(song.Album))
+	}
+	a.nowPlaying.SetText(fmt.Sprintf(" %s %s"+markup(" [muted]%.0f%%[/]"), status, title, state.Volume*100))
+}
@@ -0,0 +1,230 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"clispot/internal/command"
+	"clispot/internal/lyrics"
+)
+
+// lyricsOffsetStep is how far one press of the offset keys moves the lyrics
+const lyricsOffsetStep = 250 * time.Millisecond
+
+// lyricsScrollHold is how long a manual scroll pauses following the
+// current line of synced lyrics
+const lyricsScrollHold = 5 * time.Second
+
+// lyricsState is the lyrics panel and what it shows
+type lyricsState struct {
+	panel   *tview.TextView
+	visible bool
+	offsets *lyrics.OffsetStore
+
+	// path is the song the lyrics belong to
+	path   string
+	lyrics *lyrics.Lyrics
+	err    error
+	offset time.Duration
+
+	// rows maps each lyrics line to its first row once wrapped to width
+	rows    []int
+	width   int
+	current int
+	hold    time.Time
+}
+
+func (a *App) setupLyricsPanel() {
+	a.lyrics.panel = tview.NewTextView().
+		SetDynamicColors(true).
+		SetWrap(false).
+		SetTextAlign(tview.AlignCenter)
+	a.lyrics.panel.SetBorder(true).SetTitle(" Lyrics ")
+	a.lyrics.current = -1
+}
+
+// toggleLyrics shows or hides the lyrics panel in place of Now Playing
+func (a *App) toggleLyrics() {
+	a.lyrics.visible = !a.lyrics.visible
+	a.applyLayout()
+	a.updateLyricsPanel()
+	a.flashStatus(fmt.Sprintf("Lyrics: %s", boolToOnOff(a.lyrics.visible)))
+}
+
+// updateLyricsPanel loads the playing song's lyrics when it changes and
+// keeps the current line of synced lyrics highlighted and centered
+func (a *App) updateLyricsPanel() {
+	if !a.lyrics.visible {
+		return
+	}
+	state := a.player.GetState()
+	if state.CurrentSong != a.lyrics.path {
+		a.loadLyrics(state.CurrentSong)
+	}
+
+	_, _, width, height := a.lyrics.panel.GetInnerRect()
+	current := a.lyrics.lyrics.LineAt(state.Position, a.lyrics.offset)
+	if width != a.lyrics.width || current != a.lyrics.current {
+		a.lyrics.width = width
+		a.lyrics.current = current
+		a.renderLyrics()
+	}
+
+	if current >= 0 && time.Now().After(a.lyrics.hold) {
+		a.lyrics.panel.ScrollTo(max(a.lyrics.rows[current]-height/2, 0), 0)
+	}
+}
+
+// loadLyrics reads the lyrics of the song at path, if there are any
+func (a *App) loadLyrics(path string) {
+	a.lyrics.path = path
+	a.lyrics.lyrics, a.lyrics.err = nil, nil
+	a.lyrics.offset = 0
+	a.lyrics.current = -1
+	a.lyrics.width = -1
+	a.lyrics.hold = time.Time{}
+	a.lyrics.panel.ScrollToBeginning()
+
+	// Tracks of a CUE sheet share one file, so any lyrics in it or next
+	// to it aren't theirs
+	if song, ok := a.library.FindSong(path); ok && song.IsCueTrack() {
+		return
+	}
+	if path != "" {
+		a.lyrics.lyrics, a.lyrics.err = lyrics.Load(path)
+		a.lyrics.offset = a.lyrics.offsets.Get(path)
+	}
+}
+
+// renderLyrics fills the panel, wrapping long lines to its width and
+// coloring sung, current and coming lines differently
+func (a *App) renderLyrics() {
+	l := a.lyrics.lyrics
+	a.lyrics.panel.SetTitle(a.lyricsTitle())
+
+	switch {
+	case a.lyrics.path == "":
+		a.lyrics.panel.SetText(markup("[muted]Nothing playing[/]"))
+		return
+	case a.lyrics.err != nil:
+		a.lyrics.panel.SetText(markup("[error]" + tview.Escape(a.lyrics.err.Error()) + "[/]"))
+		return
+	case l == nil || len(l.Lines) == 0:
+		a.lyrics.panel.SetText(markup("[muted]No lyrics for this song\n\nPut a .lrc file next to it to add some[/]"))
+		return
+	}
+
+	width := a.lyrics.width
+	if width <= 0 {
+		width = 40
+	}
+	var text strings.Builder
+	a.lyrics.rows = a.lyrics.rows[:0]
+	row := 0
+	for i, line := range l.Lines {
+		a.lyrics.rows = append(a.lyrics.rows, row)
+		role := "text"
+		switch {
+		case !l.Synced:
+		case i == a.lyrics.current:
+			role = "accent"
+		case i < a.lyrics.current:
+			role = "muted"
+		}
+
+		wrapped := tview.WordWrap(line.Text, width)
+		if len(wrapped) == 0 {
+			wrapped = []string{""}
+		}
+		for _, part := range wrapped {
+			if role == "text" {
+				text.WriteString(tview.Escape(part) + "\n")
+			} else {
+				text.WriteString(markup(fmt.Sprintf("[%s]%s[/]", role, tview.Escape(part))) + "\n")
+			}
+			row++
+		}
+	}
+	a.lyrics.panel.SetText(text.String())
+}
+
+// lyricsTitle names the panel after where the lyrics came from and the
+// offset in use
+func (a *App) lyricsTitle() string {
+	title := " Lyrics "
+	if l := a.lyrics.lyrics; l != nil {
+		kind := "unsynced"
+		if l.Synced {
+			kind = "synced"
+		}
+		title = fmt.Sprintf(" Lyrics · %s %s ", l.Source, kind)
+	}
+	if a.lyrics.offset != 0 {
+		title += fmt.Sprintf("%+.2fs ", a.lyrics.offset.Seconds())
+	}
+	return title
+}
+
+// scrollLyrics moves the lyrics panel by delta rows. For synced lyrics it
+// pauses following the current line for a moment.
+func (a *App) scrollLyrics(delta int) {
+	if !a.lyrics.visible {
+		return
+	}
+	row, _ := a.lyrics.panel.GetScrollOffset()
+	a.lyrics.panel.ScrollTo(max(row+delta, 0), 0)
+	a.lyrics.hold = time.Now().Add(lyricsScrollHold)
+}
+
+// shiftLyrics changes the playing song's lyrics offset by delta and saves it
+func (a *App) shiftLyrics(delta time.Duration) {
+	if err := a.setLyricsOffset(a.lyrics.offset + delta); err != nil {
+		a.showError(err.Error())
+	}
+}
+
+// setLyricsOffset sets how much later than their time stamps the playing
+// song's lyrics are shown, and remembers it for the song
+func (a *App) setLyricsOffset(offset time.Duration) error {
+	path := a.player.GetCurrentSong()
+	if path == "" {
+		return fmt.Errorf("nothing playing")
+	}
+	if path != a.lyrics.path {
+		a.loadLyrics(path)
+	}
+	if a.lyrics.lyrics == nil || !a.lyrics.lyrics.Synced {
+		return fmt.Errorf("the current song has no synced lyrics")
+	}
+
+	a.lyrics.offset = offset
+	a.lyrics.hold = time.Time{}
+	a.lyrics.width = -1
+	a.updateLyricsPanel()
+	a.flashStatus(fmt.Sprintf("Lyrics offset: %+.2fs", offset.Seconds()))
+	return a.lyrics.offsets.Set(path, offset)
+}
+
+// lyricsCommand toggles the panel, or with "offset" sets the offset in
+// seconds: "lyrics offset 1.5", "lyrics offset -0.25" or "lyrics offset 0"
+func (a *App) lyricsCommand(args []string) (string, error) {
+	if err := command.Expect(args, 0, 2, "lyrics [offset <seconds>]"); err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		a.toggleLyrics()
+		return "", nil
+	}
+	if args[0] != "offset" || len(args) != 2 {
+		return "", fmt.Errorf("usage: lyrics [offset <seconds>]")
+	}
+
+	seconds, err := time.ParseDuration(strings.TrimSuffix(args[1], "s") + "s")
+	if err != nil {
+		return "", fmt.Errorf("bad offset %q, use seconds such as 1.5 or -0.25", args[1])
+	}
+	return "", a.setLyricsOffset(seconds.Round(time.Millisecond))
+}
@@ -0,0 +1,80 @@
+package ui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// setupMouse enables mouse input: clicking or dragging the progress bar
+// seeks, double-clicking a list item plays or opens it and scrolling over
+// the Now Playing panel changes the volume
+func (a *App) setupMouse() {
+	a.app.EnableMouse(true)
+
+	a.app.SetMouseCapture(func(event *tcell.EventMouse, action tview.MouseAction) (*tcell.EventMouse, tview.MouseAction) {
+		x, y := event.Position()
+
+		// A drag follows the pointer even when it leaves the progress bar
+		if a.progressBar.IsDragging() {
+			panelX, _, _, _ := a.progressPanel.GetInnerRect()
+			switch action {
+			case tview.MouseMove:
+				a.progressBar.DragTo(x - panelX)
+				a.updateProgressPanel()
+			case tview.MouseLeftUp:
+				a.endProgressDrag()
+			}
+			return nil, action
+		}
+
+		// tview keeps the last rect of a widget taken out of the layout, so
+		// only widgets on screen are hit-tested
+		overProgress := a.settingsManager.Get().ShowProgressBar && a.progressPanel.InRect(x, y)
+		overInfo := a.layout == layoutFull && !a.lyrics.visible && a.infoPanel.InRect(x, y)
+
+		switch {
+		case overProgress && action == tview.MouseLeftDown:
+			panelX, panelY, width, _ := a.progressPanel.GetInnerRect()
+			if position, ok := a.progressBar.HandleClick(x-panelX, y-panelY, width); ok {
+				a.progressBar.StartDrag(position)
+				a.updateProgressPanel()
+				return nil, action
+			}
+		case overInfo && action == tview.MouseScrollUp:
+			a.increaseVolume()
+			a.updateInfoPanel()
+			return nil, action
+		case overInfo && action == tview.MouseScrollDown:
+			a.decreaseVolume()
+			a.updateInfoPanel()
+			return nil, action
+		}
+		return event, action
+	})
+
+	// A single click only moves the cursor; the list's selected func runs on
+	// every click, so it is told to ignore this one
+	a.songList.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+		switch action {
+		case tview.MouseLeftClick:
+			a.ignoreSelect = true
+		case tview.MouseLeftDoubleClick:
+			a.handleSelection()
+			return action, nil
+		}
+		return action, event
+	})
+}
+
+// endProgressDrag seeks to where the progress bar was released
+func (a *App) endProgressDrag() {
+	position, ok := a.progressBar.EndDrag()
+	if ok && a.player.GetCurrentSong() != "" {
+		if err := a.player.Seek(position); err != nil {
+			a.showError(err.Error())
+		}
+	}
+	state := a.player.GetState()
+	a.progressBar.Update(state.Position, state.Duration)
+	a.updateProgressPanel()
+}
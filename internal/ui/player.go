@@ -0,0 +1,58 @@
+package ui
+
+import (
+	"time"
+
+	"clispot/internal/player"
+	"clispot/internal/settings"
+	"clispot/internal/stream"
+)
+
+// Player is what the app plays through: the audio player in this process,
+// or the player of a running daemon
+type Player interface {
+	Play(filePath string) error
+	Load(filePath string, position time.Duration) error
+	Seek(position time.Duration) error
+	Stop()
+	TogglePlayPause()
+	SetVolume(volume float64)
+	GetVolume() float64
+	GetState() player.PlaybackState
+	GetCurrentSong() string
+	IsFinished() bool
+	SetNext(song string)
+	SetSections(fn player.SectionFunc)
+	AddListener(fn func(player.PlaybackEvent))
+
+	SetRepeatMode(mode settings.RepeatMode)
+	CycleRepeatMode() settings.RepeatMode
+	ShouldRepeat() bool
+	ShouldRepeatPlaylist() bool
+
+	IsStream() bool
+	StreamInfo() (stream.Info, bool)
+	IsBuffering() bool
+	StreamError() error
+}
+
+// daemonPlayer is a Player in a daemon, which plays on through a list by
+// itself so music continues when the app closes. The app hands it the list
+// and the queue instead of stepping through them, and leaves recording
+// plays and scrobbling to it.
+type daemonPlayer interface {
+	Player
+	PlayList(paths []string, index int) error
+	Next() error
+	Previous() error
+	// Enqueue adds songs to the daemon's queue and returns its length
+	Enqueue(paths ...string) (int, error)
+	Queued() int
+}
+
+// streamConnector is a Player that connects to a stream apart from playing
+// it, so the wait on the network can be kept off the UI goroutine
+type streamConnector interface {
+	ConnectStream(url string) (*player.StreamConnection, error)
+	PlayStream(c *player.StreamConnection) error
+}
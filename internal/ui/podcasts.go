@@ -0,0 +1,595 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"clispot/internal/library"
+	"clispot/internal/player"
+	"clispot/internal/podcast"
+)
+
+const podcastUsage = "podcast [add <url>|remove <show>|refresh [show]|download|played|unplayed]"
+
+// podcastRefreshAge is how old a feed may get before it is refreshed on
+// startup
+const podcastRefreshAge = 6 * time.Hour
+
+// podcastView is where the podcast browser is: the list of shows, or the
+// episodes of one of them
+type podcastView struct {
+	visible bool
+	// show is the feed URL of the open show, empty on the list of shows
+	show string
+	// progress is how far along each downloading episode is, in percent,
+	// by GUID
+	progress map[string]int
+}
+
+// podcastItems lists the shows, or the episodes of the open show. Shows
+// are folders keyed by feed URL and episodes songs keyed by GUID; an
+// episode has a song only once it is downloaded.
+func (a *App) podcastItems() []library.LibraryItem {
+	if a.podcast.show == "" {
+		shows := a.podcasts.Shows()
+		items := make([]library.LibraryItem, 0, len(shows))
+		for _, show := range shows {
+			items = append(items, library.LibraryItem{
+				Type:      library.ItemTypeFolder,
+				Name:      show.Title,
+				Path:      show.URL,
+				SongCount: len(show.Episodes),
+			})
+		}
+		return items
+	}
+
+	show, ok := a.podcasts.Show(a.podcast.show)
+	if !ok {
+		return nil
+	}
+	items := []library.LibraryItem{{Type: library.ItemTypeFolder, Name: ".."}}
+	for _, episode := range show.Episodes {
+		item := library.LibraryItem{Type: library.ItemTypeSong, Name: episode.Title, Path: episode.GUID}
+		if episode.Downloaded() {
+			item.Song = &library.Song{
+				FilePath: episode.File,
+				Title:    episode.Title,
+				Artist:   show.Title,
+				Album:    show.Title,
+				Duration: episode.Duration,
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// togglePodcasts switches the browser between the podcasts and the library
+func (a *App) togglePodcasts() {
+	if a.podcast.visible {
+		a.hidePodcasts()
+		return
+	}
+	a.radioVisible = false
+	a.podcast.visible = true
+	a.podcast.show = ""
+	a.showPodcastItems()
+	if len(a.currentItems) == 0 {
+		a.flashStatus("No podcasts yet — subscribe with :podcast add <feed url>")
+	}
+}
+
+// hidePodcasts returns the browser from the podcasts to the library
+func (a *App) hidePodcasts() {
+	a.podcast.visible = false
+	a.podcast.show = ""
+	a.reloadItems()
+}
+
+// showPodcastItems fills the browser with the podcast view from the top
+func (a *App) showPodcastItems() {
+	a.currentItems = a.podcastItems()
+	a.populateLibraryList()
+	a.songList.SetCurrentItem(0)
+	a.updateBreadcrumb()
+	a.updateInfoPanel()
+}
+
+// refreshPodcastList redraws the podcast view after the store changed,
+// keeping the selection where it was
+func (a *App) refreshPodcastList() {
+	if !a.podcast.visible {
+		return
+	}
+	selected := a.songList.GetCurrentItem()
+	a.currentItems = a.podcastItems()
+	a.populateLibraryList()
+	if selected < len(a.currentItems) {
+		a.songList.SetCurrentItem(selected)
+	}
+	a.updateInfoPanel()
+}
+
+// podcastBack goes from a show's episodes to the shows, and from there
+// back to the library
+func (a *App) podcastBack() {
+	if a.podcast.show == "" {
+		a.hidePodcasts()
+		return
+	}
+	url := a.podcast.show
+	a.podcast.show = ""
+	a.showPodcastItems()
+	for i, item := range a.currentItems {
+		if item.Path == url {
+			a.songList.SetCurrentItem(i)
+			break
+		}
+	}
+}
+
+// populatePodcastList draws the shows with their unplayed counts, or the
+// episodes with their date, length and download state. Unplayed episodes
+// are marked with a dot.
+func (a *App) populatePodcastList() {
+	a.songList.Clear()
+	if a.podcast.show == "" {
+		for _, item := range a.currentItems {
+			show, _ := a.podcasts.Show(item.Path)
+			secondary := fmt.Sprintf("%d episodes", len(show.Episodes))
+			if unplayed := show.Unplayed(); unplayed > 0 {
+				secondary += fmt.Sprintf(" · %d unplayed", unplayed)
+			}
+			a.songList.AddItem(markup("[folder]🎙 ")+tview.Escape(item.Name)+markup("[/]"), secondary, 0, nil)
+		}
+		return
+	}
+
+	show, _ := a.podcasts.Show(a.podcast.show)
+	for i, item := range a.currentItems {
+		if item.Name == ".." {
+			a.songList.AddItem(markup("[folder]📁 .. (Back)[/]"), "", 0, nil)
+			continue
+		}
+		episode, _ := show.Episode(item.Path)
+		main := tview.Escape(episode.Title)
+		switch {
+		case episode.Downloaded() && a.player.GetCurrentSong() == episode.File:
+			main = markup("[playing]♪ ") + main + markup("[/]")
+			a.songList.SetCurrentItem(i)
+		case episode.Played:
+			main = markup("[muted]  ") + main + markup("[/]")
+		default:
+			main = markup("[accent]●[/] ") + main
+		}
+		a.songList.AddItem(main, a.episodeSecondaryText(episode), 0, nil)
+	}
+}
+
+// episodeSecondaryText sums up an episode for its line in the list
+func (a *App) episodeSecondaryText(episode podcast.Episode) string {
+	var parts []string
+	if !episode.Published.IsZero() {
+		parts = append(parts, episode.Published.Format("2006-01-02"))
+	}
+	if episode.Duration > 0 {
+		parts = append(parts, a.formatDuration(episode.Duration))
+	}
+	switch percent, downloading := a.podcast.progress[episode.GUID]; {
+	case downloading:
+		parts = append(parts, fmt.Sprintf("downloading %d%%", percent))
+	case episode.Downloaded():
+		parts = append(parts, "downloaded")
+	case !episode.Playable():
+		parts = append(parts, "not MP3")
+	default:
+		parts = append(parts, "Enter to download")
+	}
+	if episode.Position > 0 && !episode.Played {
+		parts = append(parts, "left at "+a.formatDuration(episode.Position))
+	}
+	return strings.Join(parts, " · ")
+}
+
+// selectPodcastItem opens a show, or plays or downloads an episode
+func (a *App) selectPodcastItem(item library.LibraryItem) {
+	if item.Name == ".." {
+		a.podcastBack()
+		return
+	}
+	if a.podcast.show == "" {
+		a.podcast.show = item.Path
+		a.showPodcastItems()
+		return
+	}
+
+	show, ok := a.podcasts.Show(a.podcast.show)
+	if !ok {
+		return
+	}
+	episode, ok := show.Episode(item.Path)
+	if !ok {
+		return
+	}
+	if !episode.Downloaded() {
+		a.downloadEpisodes(show, []podcast.Episode{episode})
+		return
+	}
+	if err := a.player.Play(episode.File); err != nil {
+		a.showError(fmt.Sprintf("Error playing %s: %v", tview.Escape(episode.Title), err))
+		return
+	}
+	a.populateLibraryList()
+	a.updateInfoPanel()
+}
+
+// selectedEpisode returns the episode selected in the podcast view
+func (a *App) selectedEpisode() (podcast.Show, podcast.Episode, bool) {
+	current := a.songList.GetCurrentItem()
+	if !a.podcast.visible || a.podcast.show == "" || current < 0 || current >= len(a.currentItems) {
+		return podcast.Show{}, podcast.Episode{}, false
+	}
+	show, ok := a.podcasts.Show(a.podcast.show)
+	if !ok {
+		return podcast.Show{}, podcast.Episode{}, false
+	}
+	episode, ok := show.Episode(a.currentItems[current].Path)
+	return show, episode, ok
+}
+
+// downloadEpisodes downloads episodes of show one after another in the
+// background, showing their progress in the list
+func (a *App) downloadEpisodes(show podcast.Show, episodes []podcast.Episode) {
+	if len(episodes) == 0 {
+		return
+	}
+	for _, episode := range episodes {
+		a.podcast.progress[episode.GUID] = 0
+	}
+	a.refreshPodcastList()
+	a.flashStatus(fmt.Sprintf("Downloading %d episodes of %s…", len(episodes), tview.Escape(show.Title)))
+
+	go func() {
+		downloaded := 0
+		var failed error
+		for _, episode := range episodes {
+			guid := episode.GUID
+			last := 0
+			_, err := a.podcasts.Download(show.URL, guid, func(written, total int64) {
+				if total <= 0 {
+					return
+				}
+				// Redraw only when the percentage changes
+				if percent := int(written * 100 / total); percent != last {
+					last = percent
+					a.app.QueueUpdateDraw(func() {
+						a.podcast.progress[guid] = percent
+						a.refreshPodcastList()
+					})
+				}
+			})
+			if err != nil {
+				failed = err
+			} else {
+				downloaded++
+			}
+			a.app.QueueUpdateDraw(func() {
+				delete(a.podcast.progress, guid)
+				a.refreshPodcastList()
+			})
+		}
+
+		a.app.QueueUpdateDraw(func() {
+			if failed != nil {
+				a.showError(tview.Escape(failed.Error()))
+				return
+			}
+			a.flashStatus(fmt.Sprintf("Downloaded %d episodes of %s", downloaded, tview.Escape(show.Title)))
+		})
+	}()
+}
+
+// subscribePodcast subscribes to the feed at url in the background and
+// downloads its newest episodes
+func (a *App) subscribePodcast(url string) {
+	go func() {
+		show, err := a.podcasts.Subscribe(url)
+		a.app.QueueUpdateDraw(func() {
+			if err != nil {
+				a.showError(tview.Escape(err.Error()))
+				return
+			}
+			a.refreshPodcastList()
+			a.flashStatus(fmt.Sprintf("Subscribed to %s (%d episodes)", tview.Escape(show.Title), len(show.Episodes)))
+			a.downloadEpisodes(show, podcast.ToDownload(show.Episodes, a.settingsManager.Get().PodcastDownloads))
+		})
+	}()
+}
+
+// refreshPodcasts refreshes the feeds at urls in the background and
+// downloads the episodes that are new. When quiet is set only errors and
+// new episodes are reported.
+func (a *App) refreshPodcasts(urls []string, quiet bool) {
+	if len(urls) == 0 {
+		return
+	}
+	go func() {
+		total := 0
+		for _, url := range urls {
+			added, err := a.podcasts.Refresh(url)
+			total += len(added)
+			a.app.QueueUpdateDraw(func() {
+				if err != nil {
+					a.showError(tview.Escape(err.Error()))
+					return
+				}
+				a.refreshPodcastList()
+				if show, ok := a.podcasts.Show(url); ok {
+					a.downloadEpisodes(show, podcast.ToDownload(added, a.settingsManager.Get().PodcastDownloads))
+				}
+			})
+		}
+		if total > 0 || !quiet {
+			a.app.QueueUpdateDraw(func() {
+				a.flashStatus(fmt.Sprintf("Refreshed %d podcasts: %d new episodes", len(urls), total))
+			})
+		}
+	}()
+}
+
+// refreshStalePodcasts refreshes the feeds that have not been checked for
+// a while, as clispot starts
+func (a *App) refreshStalePodcasts() {
+	var urls []string
+	for _, show := range a.podcasts.Shows() {
+		if time.Since(show.Refreshed) > podcastRefreshAge {
+			urls = append(urls, show.URL)
+		}
+	}
+	a.refreshPodcasts(urls, true)
+}
+
+// trackPodcastEpisode is a player listener that continues episodes where
+// they were left off and marks them played once they finish
+func (a *App) trackPodcastEpisode(event player.PlaybackEvent) {
+	show, episode, ok := a.podcasts.EpisodeByFile(event.Song)
+	if !ok {
+		return
+	}
+
+	switch event.Type {
+	case player.EventStart:
+		if episode.Position > 0 && !episode.Played {
+			if err := a.player.Seek(episode.Position); err == nil {
+				a.flashStatus(fmt.Sprintf("Resuming at %s", a.formatDuration(episode.Position)))
+			}
+		}
+	case player.EventEnd:
+		if event.Finished {
+			a.podcasts.SetPlayed(show.URL, episode.GUID, true)
+		} else {
+			a.podcasts.SetPosition(episode.File, event.Position)
+		}
+	}
+}
+
+// handleEpisodeFinished moves on to the queue after an episode, but not
+// through the library
+func (a *App) handleEpisodeFinished() {
+	a.player.Stop()
+	if song, ok := a.dequeue(); ok {
+		a.playSpecificSong(&song)
+		return
+	}
+	a.populateLibraryList()
+	a.updateInfoPanel()
+}
+
+// podcastItemText describes the show or episode selected in the browser
+func (a *App) podcastItemText(item library.LibraryItem) string {
+	if item.Name == ".." {
+		return markup("[folder]📁 All podcasts[/]\n\n[muted]Press Enter to go back[/]")
+	}
+
+	var text strings.Builder
+	line := func(label, value string) {
+		if value != "" {
+			text.WriteString(markup(fmt.Sprintf("[label]%s:[/] ", label)) + value + "\n")
+		}
+	}
+
+	if a.podcast.show == "" {
+		show, _ := a.podcasts.Show(item.Path)
+		text.WriteString(markup("[accent]Podcast:[/]\n\n"))
+		line("Title", tview.Escape(show.Title))
+		line("Author", tview.Escape(show.Author))
+		line("Episodes", fmt.Sprintf("%d, %d unplayed", len(show.Episodes), show.Unplayed()))
+		line("Refreshed", show.Refreshed.Format("2006-01-02 15:04"))
+		line("Feed", tview.Escape(show.URL))
+		if description := podcast.PlainText(show.Description); description != "" {
+			text.WriteString("\n" + tview.Escape(description) + "\n")
+		}
+		text.WriteString(markup("\n[muted]Press Enter to list the episodes[/]"))
+		return text.String()
+	}
+
+	show, _ := a.podcasts.Show(a.podcast.show)
+	episode, _ := show.Episode(item.Path)
+	text.WriteString(markup("[accent]Episode:[/]\n\n"))
+	a.writeEpisodeDetails(line, show, episode)
+	text.WriteString(episodeNotes(episode))
+	if episode.Downloaded() {
+		text.WriteString(markup("\n[muted]Press Enter to play[/]"))
+	} else {
+		text.WriteString(markup("\n[muted]Press Enter to download[/]"))
+	}
+	return text.String()
+}
+
+// episodeInfoText describes the playing episode for the info panel
+func (a *App) episodeInfoText(state player.PlaybackState, show podcast.Show, episode podcast.Episode) string {
+	status := markup("[stopped]Stopped[/]")
+	if state.IsPlaying {
+		status = markup("[playing]♪ Playing[/]")
+	} else if state.IsPaused {
+		status = markup("[paused]⏸ Paused[/]")
+	}
+
+	var text strings.Builder
+	text.WriteString(status + markup(" [muted]· Podcast[/]\n\n"))
+	line := func(label, value string) {
+		if value != "" {
+			text.WriteString(markup(fmt.Sprintf("[accent]%s:[/] ", label)) + value + "\n")
+		}
+	}
+	a.writeEpisodeDetails(line, show, episode)
+	line("Position", a.formatDuration(state.Position))
+	line("Volume", fmt.Sprintf("%.0f%%", state.Volume*100))
+	text.WriteString(episodeNotes(episode))
+	return text.String()
+}
+
+// writeEpisodeDetails writes what is known about an episode as labelled
+// lines
+func (a *App) writeEpisodeDetails(line func(label, value string), show podcast.Show, episode podcast.Episode) {
+	line("Title", tview.Escape(episode.Title))
+	line("Show", tview.Escape(show.Title))
+	if episode.Season > 0 || episode.Number > 0 {
+		line("Episode", episodeNumber(episode))
+	}
+	if !episode.Published.IsZero() {
+		line("Published", episode.Published.Format("2006-01-02"))
+	}
+	if episode.Duration > 0 {
+		line("Duration", a.formatDuration(episode.Duration))
+	}
+	switch {
+	case episode.Played:
+		line("Status", "Played")
+	case episode.Position > 0:
+		line("Status", "Left at "+a.formatDuration(episode.Position))
+	default:
+		line("Status", "Unplayed")
+	}
+}
+
+// episodeNotes returns an episode's show notes as plain text, set off by
+// a blank line
+func episodeNotes(episode podcast.Episode) string {
+	description := podcast.PlainText(episode.Description)
+	if description == "" {
+		return ""
+	}
+	return "\n" + tview.Escape(description) + "\n"
+}
+
+// episodeNumber formats an episode's season and number as "S2 E14"
+func episodeNumber(episode podcast.Episode) string {
+	var parts []string
+	if episode.Season > 0 {
+		parts = append(parts, fmt.Sprintf("S%d", episode.Season))
+	}
+	if episode.Number > 0 {
+		parts = append(parts, fmt.Sprintf("E%d", episode.Number))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (a *App) podcastCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		a.togglePodcasts()
+		return "", nil
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 2 {
+			return "", fmt.Errorf("usage: podcast add <feed url>")
+		}
+		a.subscribePodcast(args[1])
+		return "Fetching feed…", nil
+
+	case "remove":
+		if len(args) < 2 {
+			return "", fmt.Errorf("usage: podcast remove <show>")
+		}
+		show, err := a.findShow(strings.Join(args[1:], " "))
+		if err != nil {
+			return "", err
+		}
+		if err := a.podcasts.Remove(show.URL); err != nil {
+			return "", err
+		}
+		if a.podcast.show == show.URL {
+			a.podcast.show = ""
+		}
+		if a.podcast.visible {
+			a.showPodcastItems()
+		}
+		return fmt.Sprintf("Unsubscribed from %s; downloaded episodes were kept", tview.Escape(show.Title)), nil
+
+	case "refresh":
+		var urls []string
+		if len(args) > 1 {
+			show, err := a.findShow(strings.Join(args[1:], " "))
+			if err != nil {
+				return "", err
+			}
+			urls = append(urls, show.URL)
+		} else {
+			for _, show := range a.podcasts.Shows() {
+				urls = append(urls, show.URL)
+			}
+		}
+		if len(urls) == 0 {
+			return "", fmt.Errorf("no podcasts to refresh")
+		}
+		a.refreshPodcasts(urls, false)
+		return "Refreshing podcasts…", nil
+
+	case "download", "played", "unplayed":
+		show, episode, ok := a.selectedEpisode()
+		if !ok {
+			return "", fmt.Errorf("select an episode in the podcast view first")
+		}
+		if args[0] == "download" {
+			if episode.Downloaded() {
+				return "", fmt.Errorf("%s is already downloaded", tview.Escape(episode.Title))
+			}
+			a.downloadEpisodes(show, []podcast.Episode{episode})
+			return "", nil
+		}
+		if err := a.podcasts.SetPlayed(show.URL, episode.GUID, args[0] == "played"); err != nil {
+			return "", err
+		}
+		a.refreshPodcastList()
+		return fmt.Sprintf("Marked %s %s", tview.Escape(episode.Title), args[0]), nil
+	}
+	return "", fmt.Errorf("usage: %s", podcastUsage)
+}
+
+// findShow looks up a subscribed show by title, title prefix or feed URL
+func (a *App) findShow(name string) (podcast.Show, error) {
+	show, ok := a.podcasts.Find(name)
+	if !ok {
+		return podcast.Show{}, fmt.Errorf("no podcast called %q", name)
+	}
+	return show, nil
+}
+
+func (a *App) completePodcast(args []string) []string {
+	if len(args) == 1 {
+		return []string{"add", "remove", "refresh", "download", "played", "unplayed"}
+	}
+	var names []string
+	if len(args) == 2 && (args[0] == "remove" || args[0] == "refresh") {
+		for _, show := range a.podcasts.Shows() {
+			names = append(names, show.Title)
+		}
+	}
+	return names
+}
@@ -0,0 +1,77 @@
+package ui
+
+import (
+	"fmt"
+
+	"clispot/internal/library"
+)
+
+// enqueueSelected adds the selected song to the up-next queue, which plays
+// before the rest of the list
+func (a *App) enqueueSelected() {
+	song := a.selectedSong()
+	if song == nil {
+		return
+	}
+	if daemon, ok := a.player.(daemonPlayer); ok {
+		queued, err := daemon.Enqueue(song.FilePath)
+		if err != nil {
+			a.showError(err.Error())
+			return
+		}
+		a.flashStatus(fmt.Sprintf("Queued %s (%d up next)", song.Title, queued))
+		return
+	}
+	a.queue = append(a.queue, *song)
+	a.flashStatus(fmt.Sprintf("Queued %s (%d up next)", song.Title, len(a.queue)))
+}
+
+// dequeue removes and returns the next queued song
+func (a *App) dequeue() (library.Song, bool) {
+	if len(a.queue) == 0 {
+		return library.Song{}, false
+	}
+	song := a.queue[0]
+	a.queue = a.queue[1:]
+	return song, true
+}
+
+// queued returns how many songs are up next, in the daemon's queue when
+// playing through one
+func (a *App) queued() int {
+	if daemon, ok := a.player.(daemonPlayer); ok {
+		return daemon.Queued()
+	}
+	return len(a.queue)
+}
+
+// queuePaths returns the queued songs' paths for the session snapshot
+func (a *App) queuePaths() []string {
+	paths := make([]string, 0, len(a.queue))
+	for _, song := range a.queue {
+		paths = append(paths, song.FilePath)
+	}
+	return paths
+}
+
+// restoreQueue refills the queue from saved paths, skipping songs that are
+// no longer in the library
+func (a *App) restoreQueue(paths []string) {
+	for _, path := range paths {
+		if song, ok := a.library.FindSong(path); ok {
+			a.queue = append(a.queue, song)
+		}
+	}
+}
+
+// upcomingSong returns the song nextSong will play when the current one
+// ends, or "" when that isn't known ahead, as with shuffle
+func (a *App) upcomingSong() string {
+	if len(a.queue) > 0 {
+		return a.queue[0].FilePath
+	}
+	if a.player.ShouldRepeat() || a.settingsManager.Get().Shuffle || len(a.filteredSongs) == 0 {
+		return ""
+	}
+	return a.filteredSongs[(a.currentIdx+1)%len(a.filteredSongs)].FilePath
+}
@@ -0,0 +1,263 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rivo/tview"
+
+	"clispot/internal/command"
+	"clispot/internal/library"
+	"clispot/internal/player"
+	"clispot/internal/stream"
+)
+
+const radioUsage = "radio [<station>|<url>|add <url> <name>|remove <station>|import <file>]"
+
+// stationItems lists the saved stations in the browser. Each is a song
+// whose path is the stream's URL, so it plays, queues and shows up as
+// playing like any other.
+func (a *App) stationItems() []library.LibraryItem {
+	stations := a.stations.List()
+	items := make([]library.LibraryItem, 0, len(stations))
+	for _, station := range stations {
+		items = append(items, library.LibraryItem{
+			Type: library.ItemTypeSong,
+			Name: station.Name,
+			Path: station.URL,
+			Song: &library.Song{FilePath: station.URL, Title: station.Name},
+		})
+	}
+	return items
+}
+
+// toggleRadio switches the browser between the saved stations and the
+// library
+func (a *App) toggleRadio() {
+	if a.radioVisible {
+		a.hideRadio()
+		return
+	}
+	a.radioVisible = true
+	a.podcast.visible = false
+	a.currentItems = a.stationItems()
+	a.populateLibraryList()
+	a.songList.SetCurrentItem(0)
+	a.updateBreadcrumb()
+	a.updateInfoPanel()
+	if len(a.currentItems) == 0 {
+		a.flashStatus("No stations saved — add one with :radio add <url> <name>")
+	}
+}
+
+// hideRadio returns the browser from the stations to the library
+func (a *App) hideRadio() {
+	a.radioVisible = false
+	a.reloadItems()
+}
+
+// playStream starts an internet radio stream. Connecting waits on the
+// network, so it runs in the background and the stream starts once it
+// is done; the status bar shows what is happening in the meantime.
+func (a *App) playStream(url, name string) {
+	a.flashStatus(fmt.Sprintf("Connecting to %s…", tview.Escape(name)))
+	started := func(err error) {
+		if err != nil {
+			a.showError(fmt.Sprintf("Error playing %s: %v", tview.Escape(name), err))
+			return
+		}
+		a.flashStatus(fmt.Sprintf("Playing %s", tview.Escape(name)))
+		a.populateLibraryList()
+		a.updateInfoPanel()
+	}
+
+	connector, ok := a.player.(streamConnector)
+	if !ok {
+		// A daemon connects to the stream itself, and its player may be
+		// asked to from any goroutine
+		go func() {
+			err := a.player.Play(url)
+			a.app.QueueUpdateDraw(func() {
+				started(err)
+			})
+		}()
+		return
+	}
+	a.connecting = url
+	go func() {
+		conn, err := connector.ConnectStream(url)
+		a.app.QueueUpdateDraw(func() {
+			if a.connecting != url {
+				// Something else was played in the meantime
+				if conn != nil {
+					conn.Close()
+				}
+				return
+			}
+			a.connecting = ""
+			if err == nil {
+				err = connector.PlayStream(conn)
+			}
+			started(err)
+		})
+	}()
+}
+
+// stationName returns the saved name of the station at url, or the url
+func (a *App) stationName(url string) string {
+	for _, station := range a.stations.List() {
+		if station.URL == url {
+			return station.Name
+		}
+	}
+	return url
+}
+
+// handleStreamFinished stops a stream that could not be kept going rather
+// than moving on through the library
+func (a *App) handleStreamFinished() {
+	err := a.player.StreamError()
+	a.player.Stop()
+	a.populateLibraryList()
+	a.updateInfoPanel()
+	if err == nil {
+		err = fmt.Errorf("the stream ended")
+	}
+	a.showError(tview.Escape(err.Error()))
+}
+
+// streamInfoText describes the playing stream for the info panel
+func (a *App) streamInfoText(state player.PlaybackState, info stream.Info) string {
+	status := markup("[stopped]Stopped[/]")
+	switch {
+	case a.player.IsBuffering() && state.IsPlaying:
+		status = markup("[paused]… Buffering[/]")
+	case state.IsPlaying:
+		status = markup("[playing]♪ Playing[/]")
+	case state.IsPaused:
+		status = markup("[paused]⏸ Paused[/]")
+	}
+
+	title := info.Title
+	if title == "" {
+		title = markup("[muted]No title sent[/]")
+	} else {
+		title = tview.Escape(title)
+	}
+	station := a.stationName(state.CurrentSong)
+	if info.Name != "" && info.Name != station {
+		station += markup(" [muted]·[/] ") + info.Name
+	}
+
+	var text strings.Builder
+	text.WriteString(status + markup(" [muted]· Internet radio[/]\n\n"))
+	line := func(label, value string) {
+		text.WriteString(markup(fmt.Sprintf("[accent]%s:[/] ", label)) + value + "\n")
+	}
+	line("Now", title)
+	line("Station", tview.Escape(station))
+	if info.Genre != "" {
+		line("Genre", tview.Escape(info.Genre))
+	}
+	if info.Bitrate > 0 {
+		line("Bitrate", fmt.Sprintf("%d kbps", info.Bitrate))
+	}
+	line("Listening", a.formatDuration(state.Position))
+	line("Volume", fmt.Sprintf("%.0f%%", state.Volume*100))
+	if info.Reconnects > 0 {
+		line("Reconnects", fmt.Sprintf("%d", info.Reconnects))
+	}
+	line("URL", tview.Escape(state.CurrentSong))
+	return text.String()
+}
+
+// stationText describes a station selected in the browser
+func stationText(song library.Song) string {
+	return markup("[accent]Station:[/]\n\n") +
+		markup("[label]Name:[/] ") + tview.Escape(song.Title) + "\n" +
+		markup("[label]URL:[/] ") + tview.Escape(song.FilePath) + "\n\n" +
+		markup("[muted]Press Enter to listen[/]")
+}
+
+func (a *App) radioCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		a.toggleRadio()
+		return "", nil
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) < 3 {
+			return "", fmt.Errorf("usage: radio add <url> <name>")
+		}
+		station := stream.Station{URL: args[1], Name: strings.Join(args[2:], " ")}
+		if _, err := a.stations.Add(station); err != nil {
+			return "", err
+		}
+		a.refreshStations()
+		return fmt.Sprintf("Saved station %s", tview.Escape(station.Name)), nil
+
+	case "remove":
+		if len(args) < 2 {
+			return "", fmt.Errorf("usage: radio remove <station>")
+		}
+		station, ok := a.stations.Find(strings.Join(args[1:], " "))
+		if !ok {
+			return "", fmt.Errorf("no station called %q", strings.Join(args[1:], " "))
+		}
+		if err := a.stations.Remove(station.Name); err != nil {
+			return "", err
+		}
+		a.refreshStations()
+		return fmt.Sprintf("Removed station %s", tview.Escape(station.Name)), nil
+
+	case "import":
+		if err := command.Expect(args, 2, 2, "radio import <file.pls|file.m3u>"); err != nil {
+			return "", err
+		}
+		stations, err := stream.ReadStationFile(expandHome(args[1]))
+		if err != nil {
+			return "", err
+		}
+		added, err := a.stations.Add(stations...)
+		if err != nil {
+			return "", err
+		}
+		a.refreshStations()
+		return fmt.Sprintf("Imported %d stations (%d new)", len(stations), added), nil
+	}
+
+	target := strings.Join(args, " ")
+	if stream.IsURL(target) {
+		a.playStream(target, a.stationName(target))
+		return "", nil
+	}
+	station, ok := a.stations.Find(target)
+	if !ok {
+		return "", fmt.Errorf("no station called %q", target)
+	}
+	a.playStream(station.URL, station.Name)
+	return "", nil
+}
+
+// refreshStations redraws the station list after it changed
+func (a *App) refreshStations() {
+	if a.radioVisible {
+		a.currentItems = a.stationItems()
+		a.populateLibraryList()
+		a.updateInfoPanel()
+	}
+}
+
+func (a *App) completeRadio(args []string) []string {
+	var names []string
+	if len(args) == 1 {
+		names = append(names, "add", "remove", "import")
+	}
+	if len(args) == 1 || len(args) == 2 && args[0] == "remove" {
+		for _, station := range a.stations.List() {
+			names = append(names, station.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,139 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"clispot/internal/library"
+	"clispot/internal/ratings"
+)
+
+// selectedSong returns the song under the cursor in the browser, if any
+func (a *App) selectedSong() *library.Song {
+	idx := a.songList.GetCurrentItem()
+	if idx < 0 || idx >= len(a.currentItems) {
+		return nil
+	}
+	item := a.currentItems[idx]
+	if item.Type != library.ItemTypeSong || item.Song == nil {
+		return nil
+	}
+	return item.Song
+}
+
+func (a *App) rateSelected(rating int) {
+	song := a.selectedSong()
+	if song == nil {
+		return
+	}
+
+	entry, err := a.ratings.SetRating(song.FilePath, rating)
+	if err != nil {
+		a.showError(fmt.Sprintf("Error saving rating: %v", err))
+		return
+	}
+	a.applyRating(song.FilePath, entry)
+
+	if a.settingsManager.Get().WriteRatingsToID3 && !song.IsCueTrack() {
+		if err := library.WriteRatingTag(song.FilePath, rating); err != nil {
+			a.showError(fmt.Sprintf("Error writing rating tag: %v", err))
+			return
+		}
+	}
+	a.flashStatus(fmt.Sprintf("Rated %s: %s", song.Title, ratings.Stars(rating)))
+}
+
+func (a *App) toggleLovedSelected() {
+	song := a.selectedSong()
+	if song == nil {
+		return
+	}
+
+	entry, err := a.ratings.ToggleLoved(song.FilePath)
+	if err != nil {
+		a.showError(fmt.Sprintf("Error saving loved flag: %v", err))
+		return
+	}
+	a.applyRating(song.FilePath, entry)
+
+	if entry.Loved {
+		a.flashStatus(fmt.Sprintf("Loved %s", song.Title))
+	} else {
+		a.flashStatus(fmt.Sprintf("Unloved %s", song.Title))
+	}
+}
+
+// applyRating copies a stored rating into every in-memory copy of the song
+func (a *App) applyRating(filePath string, entry ratings.Entry) {
+	a.library.SetRating(filePath, entry.Rating, entry.Loved)
+	a.updateSong(filePath, func(song *library.Song) {
+		song.Rating = entry.Rating
+		song.Loved = entry.Loved
+	})
+	a.populateLibraryList()
+	a.updateInfoPanel()
+}
+
+// updateSong applies fn to the browser items and filtered list entries for
+// filePath, which hold their own copies of the library's songs
+func (a *App) updateSong(filePath string, fn func(*library.Song)) {
+	for i := range a.currentItems {
+		if song := a.currentItems[i].Song; song != nil && song.FilePath == filePath {
+			fn(song)
+		}
+	}
+	for i := range a.filteredSongs {
+		if a.filteredSongs[i].FilePath == filePath {
+			fn(&a.filteredSongs[i])
+		}
+	}
+}
+
+func (a *App) toggleShuffle() {
+	if a.settingsManager.ToggleShuffle() {
+		a.flashStatus("Shuffle: on")
+	} else {
+		a.flashStatus("Shuffle: off")
+	}
+}
+
+// flashStatus shows message in the status bar for a couple of seconds
+func (a *App) flashStatus(message string) {
+	a.statusBar.SetText(fmt.Sprintf(markup(" [accent]%s[/]"), message))
+	a.statusHold = time.Now().Add(flashDuration)
+	go func() {
+		time.Sleep(flashDuration)
+		a.app.QueueUpdateDraw(a.updateStatusBar)
+	}()
+}
+
+// How long status bar messages stay up before the regular status returns
+const (
+	flashDuration = 2 * time.Second
+	errorDuration = 4 * time.Second
+)
+
+func ratingBadge(song library.Song) string {
+	badge := ""
+	if song.Rating > 0 {
+		badge = ratings.Stars(song.Rating)
+	}
+	if song.Loved {
+		if badge != "" {
+			badge += " "
+		}
+		badge += "♥"
+	}
+	return badge
+}
+
+func ratingText(song library.Song) string {
+	if song.Rating == 0 && !song.Loved {
+		return markup("[muted]Unrated[/]")
+	}
+	text := ratings.Stars(song.Rating)
+	if song.Loved {
+		text += markup(" [loved]♥[/]")
+	}
+	return text
+}
@@ -0,0 +1,31 @@
+package ui
+
+import (
+	"clispot/internal/player"
+	"clispot/internal/server"
+)
+
+// SetServer hands the app the HTTP server started with -serve, so the
+// server's copy of the library follows rescans and its radio follows the
+// player
+func (a *App) SetServer(srv *server.Server) {
+	a.server = srv
+}
+
+// updateRadio tells the server's radio what the player is doing. Internet
+// radio streams are not passed on, so listeners hear silence during them.
+func (a *App) updateRadio(state player.PlaybackState) {
+	if a.server == nil || a.server.Radio() == nil {
+		return
+	}
+
+	var now server.NowPlaying
+	if state.CurrentSong != "" && !a.player.IsStream() {
+		song, ok := a.library.FindSong(state.CurrentSong)
+		if !ok {
+			song.FilePath = state.CurrentSong
+		}
+		now = server.Playing(song, state)
+	}
+	a.server.Radio().Update(now)
+}
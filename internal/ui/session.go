@@ -0,0 +1,135 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"clispot/internal/library"
+	"clispot/internal/player"
+	"clispot/internal/session"
+)
+
+// sessionSaveTicks is how many update loop ticks pass between periodic
+// session snapshots (60 ticks of 500ms is 30 seconds)
+const sessionSaveTicks = 60
+
+// snapshot captures what is needed to pick up where this session left off
+func (a *App) snapshot() session.Snapshot {
+	state := a.player.GetState()
+	return session.Snapshot{
+		Song:        state.CurrentSong,
+		Position:    state.Position,
+		Paused:      !state.IsPlaying,
+		FolderPath:  a.library.GetCurrentPath(),
+		BrowseMode:  int(a.library.BrowseMode()),
+		BrowsePath:  a.library.VirtualPath(),
+		SearchQuery: a.searchQuery,
+		SortOrder:   int(a.sortOrder),
+		CurrentIdx:  a.currentIdx,
+		Queue:       a.queuePaths(),
+	}
+}
+
+// saveSession writes the session snapshot and, for long files, the resume
+// position of the current song
+func (a *App) saveSession() {
+	if err := a.session.Save(a.snapshot()); err != nil {
+		a.showError(fmt.Sprintf("Error saving session: %v", err))
+	}
+
+	state := a.player.GetState()
+	if state.CurrentSong != "" && a.isLongFile(state.Duration) {
+		a.session.SetResumePosition(state.CurrentSong, state.Position)
+	}
+}
+
+// restoreSession reopens the previous folder, search and sort order, and
+// loads the previous song paused at its saved position
+func (a *App) restoreSession() {
+	snapshot, ok := a.session.Load()
+	if !ok {
+		return
+	}
+
+	if snapshot.FolderPath != "" {
+		a.library.NavigateToFolder(snapshot.FolderPath)
+	}
+	a.library.SetBrowseMode(library.BrowseMode(snapshot.BrowseMode))
+	a.library.SetVirtualPath(snapshot.BrowsePath)
+	if items, err := a.library.GetCurrentItems(); err == nil {
+		a.currentItems = items
+	}
+
+	a.sortOrder = library.SortOrder(snapshot.SortOrder)
+	library.SortItems(a.currentItems, a.sortOrder)
+	if snapshot.SearchQuery != "" {
+		a.applySearch(snapshot.SearchQuery)
+	} else {
+		a.populateLibraryList()
+	}
+	a.updateBreadcrumb()
+
+	a.restoreQueue(snapshot.Queue)
+
+	if snapshot.CurrentIdx >= 0 && snapshot.CurrentIdx < len(a.filteredSongs) {
+		a.currentIdx = snapshot.CurrentIdx
+	}
+
+	if snapshot.Song == "" {
+		return
+	}
+	// A CUE track's path names a track, not a file
+	audioPath := snapshot.Song
+	if song, ok := a.library.FindSong(snapshot.Song); ok {
+		audioPath = song.AudioPath()
+	}
+	if _, err := os.Stat(audioPath); err != nil {
+		return
+	}
+	// A daemon that is already playing carries on with that
+	if _, ok := a.player.(daemonPlayer); ok && a.player.GetCurrentSong() != "" {
+		return
+	}
+	if err := a.player.Load(snapshot.Song, snapshot.Position); err != nil {
+		a.showError(fmt.Sprintf("Error restoring %s: %v", snapshot.Song, err))
+		return
+	}
+
+	a.populateLibraryList()
+	a.updateInfoPanel()
+	a.flashStatus(fmt.Sprintf("Restored at %s — press Space to resume", a.formatDuration(snapshot.Position)))
+}
+
+// isLongFile reports whether a file is long enough to keep a resume position
+func (a *App) isLongFile(duration time.Duration) bool {
+	minutes := a.settingsManager.Get().ResumeMinMinutes
+	return minutes > 0 && duration >= time.Duration(minutes)*time.Minute
+}
+
+// trackResumePosition is a player listener that continues long files where
+// they were left off and remembers where they stop
+func (a *App) trackResumePosition(event player.PlaybackEvent) {
+	if !a.isLongFile(event.Duration) {
+		return
+	}
+	// Podcast episodes keep their own positions
+	if _, _, ok := a.podcasts.EpisodeByFile(event.Song); ok {
+		return
+	}
+
+	switch event.Type {
+	case player.EventStart:
+		if position, ok := a.session.ResumePosition(event.Song); ok {
+			if err := a.player.Seek(position); err == nil {
+				a.flashStatus(fmt.Sprintf("Resuming at %s", a.formatDuration(position)))
+			}
+		}
+	case player.EventEnd:
+		if event.Finished {
+			a.session.SetResumePosition(event.Song, 0)
+		} else {
+			a.session.SetResumePosition(event.Song, event.Position)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rivo/tview"
+
+	"clispot/internal/library"
+	"clispot/internal/theme"
+)
+
+// tagEditor is the form for editing the tags of one song, or of several at
+// once. In bulk mode fields whose value differs between the songs start
+// empty, and only fields that are changed get written.
+type tagEditor struct {
+	view    *tview.Flex
+	form    *tview.Form
+	songs   []library.Song
+	fields  map[library.TagField]*tview.InputField
+	initial map[library.TagField]string
+	mixed   map[library.TagField]bool
+	cover   *tview.InputField
+}
+
+// Size of the tag editor dialog
+const (
+	editorWidth  = 64
+	editorHeight = 17
+)
+
+// editTags opens the editor for the selected song, or for every song in the
+// selected folder or group
+func (a *App) editTags() {
+	idx := a.songList.GetCurrentItem()
+	if idx < 0 || idx >= len(a.currentItems) {
+		return
+	}
+	songs := a.library.ItemSongs(a.currentItems[idx])
+	if len(songs) == 0 {
+		a.showError("No songs to edit")
+		return
+	}
+	a.openTagEditor(songs)
+}
+
+// listedSongs returns the songs shown in the browser, which are the search
+// results while a search is active
+func (a *App) listedSongs() []library.Song {
+	var songs []library.Song
+	for _, item := range a.currentItems {
+		if item.Type == library.ItemTypeSong && item.Song != nil {
+			songs = append(songs, *item.Song)
+		}
+	}
+	return songs
+}
+
+func (a *App) openTagEditor(songs []library.Song) {
+	songs = taggableSongs(songs)
+	if len(songs) == 0 {
+		a.showError(cueTagsMessage)
+		return
+	}
+	editor := &tagEditor{
+		form:    tview.NewForm().SetItemPadding(0),
+		songs:   songs,
+		fields:  make(map[library.TagField]*tview.InputField),
+		initial: make(map[library.TagField]string),
+		mixed:   make(map[library.TagField]bool),
+	}
+
+	for _, field := range library.TagFields {
+		value, same := commonTagValue(songs, field)
+		input := tview.NewInputField().
+			SetLabel(field.Label()).
+			SetText(value).
+			SetFieldWidth(0)
+		if !same {
+			input.SetPlaceholder("(mixed — leave empty to keep)")
+		}
+		editor.fields[field] = input
+		editor.initial[field] = value
+		editor.mixed[field] = !same
+		editor.form.AddFormItem(input)
+	}
+	editor.cover = tview.NewInputField().
+		SetLabel("Cover image").
+		SetPlaceholder("JPEG or PNG file to embed").
+		SetFieldWidth(0)
+	editor.form.AddFormItem(editor.cover)
+
+	editor.form.
+		AddButton("Save", a.saveTags).
+		AddButton("Cancel", a.closeTagEditor)
+
+	title := fmt.Sprintf(" Edit tags: %s ", songs[0].Title)
+	if len(songs) > 1 {
+		title = fmt.Sprintf(" Edit tags: %d songs ", len(songs))
+	}
+	hint := tview.NewTextView().
+		SetDynamicColors(true).
+		SetTextAlign(tview.AlignCenter).
+		SetText(markup("[muted]Tab next field · Ctrl+S save · Esc cancel[/]"))
+	editor.view = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(editor.form, 0, 1, true).
+		AddItem(hint, 1, 0, false)
+	editor.view.SetBorder(true).SetTitle(title)
+
+	a.tagEditor = editor
+	a.applyTagEditorColors()
+	a.applyLayout()
+}
+
+// commonTagValue returns the value field has in every song, or "" and
+// false when the songs disagree
+func commonTagValue(songs []library.Song, field library.TagField) (string, bool) {
+	value := library.TagValue(songs[0], field)
+	for _, song := range songs[1:] {
+		if library.TagValue(song, field) != value {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// edit collects the changed fields and the cover image into a TagEdit
+func (e *tagEditor) edit() (library.TagEdit, error) {
+	edit := library.TagEdit{Values: make(map[library.TagField]string)}
+	for _, field := range library.TagFields {
+		text := strings.TrimSpace(e.fields[field].GetText())
+		if text == e.initial[field] {
+			continue
+		}
+		if text == "" && e.mixed[field] {
+			continue
+		}
+		edit.Values[field] = text
+	}
+
+	if path := strings.TrimSpace(e.cover.GetText()); path != "" {
+		cover, mime, err := library.LoadCover(e.coverPath(path))
+		if err != nil {
+			return edit, err
+		}
+		edit.Cover = cover
+		edit.CoverMIME = mime
+	}
+	return edit, edit.Validate()
+}
+
+// coverPath expands ~ and resolves relative paths against the first song's
+// folder, so "cover.jpg" picks up the album's own image
+func (e *tagEditor) coverPath(path string) string {
+	path = expandHome(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(e.songs[0].FilePath), path)
+	}
+	return path
+}
+
+// expandHome replaces a leading ~ in path with the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// saveTags writes the form to the files in the background and updates the
+// in-memory songs once done
+func (a *App) saveTags() {
+	if a.tagEditor == nil {
+		return
+	}
+	edit, err := a.tagEditor.edit()
+	if err != nil {
+		a.showError(err.Error())
+		return
+	}
+	songs := a.tagEditor.songs
+	a.closeTagEditor()
+	if edit.IsEmpty() {
+		a.flashStatus("No changes")
+		return
+	}
+
+	a.writeTags(songs, edit)
+}
+
+// cueTagsMessage explains why tracks of a CUE sheet can't be edited
+const cueTagsMessage = "Tracks of a CUE sheet are tagged in their .cue file"
+
+// taggableSongs leaves out tracks of CUE sheets, which have no tags of
+// their own to write
+func taggableSongs(songs []library.Song) []library.Song {
+	var taggable []library.Song
+	for _, song := range songs {
+		if !song.IsCueTrack() {
+			taggable = append(taggable, song)
+		}
+	}
+	return taggable
+}
+
+// writeTags writes edit to songs off the UI goroutine, then rereads the
+// saved files into the library and every copy the UI holds
+func (a *App) writeTags(songs []library.Song, edit library.TagEdit) {
+	songs = taggableSongs(songs)
+	if len(songs) == 0 {
+		a.showError(cueTagsMessage)
+		return
+	}
+	if len(songs) == 1 {
+		a.flashStatus("Saving tags…")
+	} else {
+		a.flashStatus(fmt.Sprintf("Saving tags of %d songs…", len(songs)))
+	}
+	go func() {
+		var written []string
+		var failed []string
+		for _, song := range songs {
+			if err := library.WriteTags(song.FilePath, edit); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", filepath.Base(song.FilePath), err))
+				continue
+			}
+			written = append(written, song.FilePath)
+		}
+
+		a.app.QueueUpdateDraw(func() {
+			for _, path := range written {
+				song, err := a.library.Reload(path)
+				if err != nil {
+					failed = append(failed, fmt.Sprintf("%s: %v", filepath.Base(path), err))
+					continue
+				}
+				a.applyEditedSong(song)
+			}
+			a.refreshAfterEdit()
+
+			switch {
+			case len(failed) == 1:
+				a.showError(fmt.Sprintf("Error writing tags: %s", failed[0]))
+			case len(failed) > 1:
+				a.showError(fmt.Sprintf("Error writing tags of %d songs, first %s", len(failed), failed[0]))
+			case len(written) == 1:
+				a.flashStatus("Saved tags")
+			default:
+				a.flashStatus(fmt.Sprintf("Saved tags of %d songs", len(written)))
+			}
+		})
+	}()
+}
+
+// applyEditedSong replaces the browser, search, queue and playlist copies of
+// a song whose tags were rewritten
+func (a *App) applyEditedSong(song library.Song) {
+	a.updateSong(song.FilePath, func(s *library.Song) {
+		*s = song
+	})
+	for i := range a.queue {
+		if a.queue[i].FilePath == song.FilePath {
+			a.queue[i] = song
+		}
+	}
+	if err := a.playlists.UpdateSong(song); err != nil {
+		a.showError(fmt.Sprintf("Error updating playlists: %v", err))
+	}
+}
+
+// refreshAfterEdit redraws the browser with the new tags; edited albums and
+// artists may have moved to other groups, so the items are rebuilt
+func (a *App) refreshAfterEdit() {
+	idx := a.songList.GetCurrentItem()
+	if a.searchQuery != "" {
+		a.populateSongList()
+		a.updateInfoPanel()
+	} else {
+		a.reloadItems()
+	}
+	if idx < a.songList.GetItemCount() {
+		a.songList.SetCurrentItem(idx)
+	}
+}
+
+func (a *App) closeTagEditor() {
+	a.tagEditor = nil
+	a.applyLayout()
+}
+
+// tagEditorPage centers the editor form over the rest of the screen
+func (a *App) tagEditorPage() tview.Primitive {
+	width, height := editorWidth, editorHeight
+	if a.screenWidth > 0 && a.screenWidth < width {
+		width = a.screenWidth
+	}
+	if a.screenHeight > 0 && a.screenHeight < height {
+		height = a.screenHeight
+	}
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(a.tagEditor.view, height, 0, true).
+			AddItem(nil, 0, 1, false), width, 0, true).
+		AddItem(nil, 0, 1, false)
+}
+
+func (a *App) applyTagEditorColors() {
+	if a.tagEditor == nil {
+		return
+	}
+	th := theme.Current()
+	a.tagEditor.view.SetBorderColor(th.Color(th.Border))
+	a.tagEditor.view.SetTitleColor(th.Color(th.Title))
+	a.tagEditor.form.SetLabelColor(th.Color(th.Label))
+}
+
+// tagCommand opens the editor or sets one field without it:
+// "tag" edits the selected item, "tag all" every listed song, and
+// "tag <field> <value>" writes a field to the selected item's songs
+func (a *App) tagCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		a.editTags()
+		return "", nil
+	}
+	if len(args) == 1 && args[0] == "all" {
+		songs := a.listedSongs()
+		if len(songs) == 0 {
+			return "", fmt.Errorf("no songs listed")
+		}
+		a.openTagEditor(songs)
+		return "", nil
+	}
+
+	field, ok := library.ParseTagField(args[0])
+	if !ok {
+		return "", fmt.Errorf("unknown tag %q", args[0])
+	}
+	idx := a.songList.GetCurrentItem()
+	if idx < 0 || idx >= len(a.currentItems) {
+		return "", fmt.Errorf("nothing selected")
+	}
+	songs := a.library.ItemSongs(a.currentItems[idx])
+	if len(songs) == 0 {
+		return "", fmt.Errorf("no songs to edit")
+	}
+	edit := library.TagEdit{Values: map[library.TagField]string{
+		field: strings.Join(args[1:], " "),
+	}}
+	if err := edit.Validate(); err != nil {
+		return "", err
+	}
+	a.writeTags(songs, edit)
+	return "", nil
+}
+
+func completeTag(args []string) []string {
+	if len(args) != 1 {
+		return nil
+	}
+	names := []string{"all"}
+	for _, field := range library.TagFields {
+		names = append(names, string(field))
+	}
+	return names
+}
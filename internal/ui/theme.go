@@ -0,0 +1,77 @@
+package ui
+
+import (
+	"fmt"
+
+	"github.com/rivo/tview"
+
+	"clispot/internal/settings"
+	"clispot/internal/theme"
+)
+
+// markup expands semantic color tags such as [accent] and [/] with the
+// active theme; see theme.Render
+func markup(text string) string {
+	return theme.Current().Render(text)
+}
+
+// setTheme switches to the named theme, saves the choice and repaints
+func (a *App) setTheme(name string) error {
+	t, err := theme.Load(name, theme.Dir(settings.ConfigDir()))
+	if err != nil {
+		return err
+	}
+
+	theme.SetCurrent(t)
+	a.settingsManager.Update(func(s *settings.Settings) {
+		s.Theme = t.Name
+	})
+
+	a.applyThemeColors()
+	a.applyLayout()
+	a.populateLibraryList()
+	a.updateBreadcrumb()
+	a.updateInfoPanel()
+	a.updateProgressPanel()
+	a.flashStatus(fmt.Sprintf("Theme: %s", t.Name))
+	return nil
+}
+
+// applyThemeColors recolors widgets that already exist; widgets created
+// later pick the colors up from tview.Styles
+func (a *App) applyThemeColors() {
+	th := theme.Current()
+	border := th.Color(th.Border)
+	title := th.Color(th.Title)
+	text := th.Color(th.Text)
+
+	for _, box := range []*tview.Box{a.songList.Box, a.infoPanel.Box, a.helpText.Box, a.searchInput.Box, a.breadcrumb.Box, a.lyrics.panel.Box} {
+		box.SetBorderColor(border)
+		box.SetTitleColor(title)
+	}
+
+	for _, view := range []*tview.TextView{a.infoPanel, a.statusBar, a.helpText, a.progressPanel, a.breadcrumb, a.nowPlaying, a.lyrics.panel} {
+		view.SetTextColor(text)
+	}
+	a.songList.SetMainTextColor(text)
+	a.songList.SetSecondaryTextColor(th.Color(th.Label))
+	a.searchInput.SetLabelColor(th.Color(th.Accent))
+	a.commandInput.SetLabelColor(th.Color(th.Accent))
+	a.applyTagEditorColors()
+}
+
+// cycleTheme switches to the theme after the current one, built-ins first
+func (a *App) cycleTheme() {
+	names := theme.Names(theme.Dir(settings.ConfigDir()))
+	current := theme.Current().Name
+	next := names[0]
+	for i, name := range names {
+		if name == current {
+			next = names[(i+1)%len(names)]
+			break
+		}
+	}
+	if err := a.setTheme(next); err != nil {
+		a.showError(err.Error())
+	}
+}
@@ -6,20 +6,31 @@ import (
 	"strings"
 	"time"
 
-	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	
+	"clispot/internal/command"
+	"clispot/internal/history"
+	"clispot/internal/keymap"
 	"clispot/internal/library"
+	"clispot/internal/lyrics"
 	"clispot/internal/player"
+	"clispot/internal/playlist"
+	"clispot/internal/podcast"
+	"clispot/internal/server"
 	"clispot/internal/settings"
 	"clispot/internal/progressbar"
+	"clispot/internal/ratings"
+	"clispot/internal/scrobbler"
+	"clispot/internal/session"
+	"clispot/internal/stream"
+	"clispot/internal/theme"
 )
 
 
 type App struct {
 	app         *tview.Application
 	songs       []library.Song
-	player      *player.Player
+	player      Player
 	currentIdx  int
 	
 		library      *library.Library
@@ -37,13 +48,68 @@ type App struct {
 	breadcrumb      *tview.TextView
 	
 		isSearchMode bool
+	searchQuery   string
 	filteredSongs []library.Song
+	sortOrder     library.SortOrder
+	
+	history      *history.Store
+	ratings      *ratings.Store
+	statsVisible bool
+	statsPeriod  history.Period
+	// stats caches what the stats view shows until a play is recorded or
+	// the period changes
+	stats        *history.Stats
+	
+	scrobbler    *scrobbler.Scrobbler
+	scrobblerErr error
+	
+	session      *session.Store
+	themeErr     error
+	
+	keys      *keymap.Dispatcher
+	keymapErr error
+	keyWait   int
+	queue     []library.Song
+	
+	commands      *command.Registry
+	commandInput  *tview.InputField
+	bottomBar     *tview.Pages
+	isCommandMode bool
+	completions   []string
+	completionIdx int
+	playlists     *playlist.Manager
+	
+	ignoreSelect bool
+	
+	nowPlaying   *tview.TextView
+	layout       layoutMode
+	screenWidth  int
+	screenHeight int
+	statusHold   time.Time
+	
+	tagEditor *tagEditor
+	lyrics    lyricsState
+	
+	stations     *stream.StationStore
+	radioVisible bool
+	// connecting is the URL of the stream being connected to, if any
+	connecting   string
+	
+	podcasts *podcast.Manager
+	podcast  podcastView
+	
+	server *server.Server
 }
 
 
-func NewApp(songs []library.Song, audioPlayer *player.Player, lib *library.Library) *App {
+func NewApp(songs []library.Song, audioPlayer Player, lib *library.Library) *App {
 		settingsManager := settings.NewManager()
 	
+	activeTheme, themeErr := theme.Load(settingsManager.Get().Theme, theme.Dir(settings.ConfigDir()))
+	theme.SetCurrent(activeTheme)
+	historyStore := history.NewStore(settings.ConfigDir())
+	ratingsStore := ratings.NewStore(settings.ConfigDir())
+	
 	app := &App{
 		app:             tview.NewApplication(),
 		songs:           songs,
@@ -52,7 +118,70 @@ func NewApp(songs []library.Song, audioPlayer *player.Player, lib *library.Libra
 		filteredSongs:   songs,
 		library:         lib,
 		settingsManager: settingsManager,
-		progressBar:     progressbar.NewProgressBar(80),  	}
+		progressBar:     progressbar.NewProgressBar(80),
+		history:         historyStore,
+		ratings:         ratingsStore,
+		session:         session.NewStore(settings.ConfigDir()),
+		themeErr:        themeErr,
+		playlists:       playlist.NewManager(filepath.Join(settings.ConfigDir(), "playlists")),
+		lyrics:          lyricsState{offsets: lyrics.NewOffsetStore(settings.ConfigDir())},
+		stations:        stream.NewStationStore(settings.ConfigDir()),
+		podcasts:        podcast.NewManager(podcast.NewStore(settings.ConfigDir()), lib.GetRootPath(), podcast.Options{}),
+		podcast:         podcastView{progress: make(map[string]int)},
+	}
+	
+	// The keymap is loaded after the commands exist so keys can be bound
+	// to any of them
+	app.commands = app.newCommands()
+	keys, keymapErr := keymap.Load(keymap.Path(settings.ConfigDir()))
+	app.keys = keymap.NewDispatcher(keys)
+	app.keymapErr = keymapErr
+	
+	app.applyLibraryData()
+	
+	audioPlayer.SetSections(lib.Section)
+	audioPlayer.AddListener(app.trackResumePosition)
+	audioPlayer.AddListener(app.trackPodcastEpisode)
+	audioPlayer.AddListener(func(event player.PlaybackEvent) {
+		// Whatever starts playing takes the place of a stream still
+		// connecting
+		if event.Type == player.EventStart {
+			app.connecting = ""
+		}
+	})
+	
+	// A daemon records plays and scrobbles them itself, also while no app
+	// is open, and has done so by the time a song is seen to end
+	if _, ok := audioPlayer.(daemonPlayer); ok {
+		audioPlayer.AddListener(func(event player.PlaybackEvent) {
+			if event.Type == player.EventEnd {
+				app.stats = nil
+			}
+		})
+	} else {
+		// Record plays and keep the in-memory play counts current
+		recorder := history.NewRecorder(historyStore, lib.FindSong)
+		recorder.OnPlay(func(play history.Play) {
+			if play.Counts() {
+				lib.IncrementPlayCount(play.Path)
+			}
+			app.stats = nil
+		})
+		audioPlayer.AddListener(recorder.HandleEvent)
+		
+		services, err := scrobbler.ServicesFromSettings(settingsManager.Get().Scrobblers, nil)
+		if err != nil {
+			app.scrobblerErr = err
+		} else if len(services) > 0 {
+			app.scrobbler = scrobbler.New(settings.ConfigDir(), services, lib.FindSong)
+			app.scrobbler.OnError = func(service string, err error) {
+				app.app.QueueUpdateDraw(func() {
+					app.showError(fmt.Sprintf("Scrobbling to %s: %v", service, err))
+				})
+			}
+			audioPlayer.AddListener(app.scrobbler.HandleEvent)
+		}
+	}
 	
 		items, err := lib.GetCurrentItems()
 	if err != nil {
@@ -73,6 +202,19 @@ func NewApp(songs []library.Song, audioPlayer *player.Player, lib *library.Libra
 }
 
 
+// applyLibraryData copies ratings and play counts from clispot's own stores
+// onto the scanned songs. Stored ratings win over POPM ratings from tags.
+func (a *App) applyLibraryData() {
+	for path, entry := range a.ratings.All() {
+		if song, ok := a.library.FindSong(path); ok && entry.Rating == 0 {
+			entry.Rating = song.Rating
+		}
+		a.library.SetRating(path, entry.Rating, entry.Loved)
+	}
+	a.library.SetPlayCounts(a.history.PlayCounts())
+}
+
+
 func (a *App) Run() error {
 	a.setupUI()
 	a.setupKeyBindings()
@@ -81,9 +223,31 @@ func (a *App) Run() error {
 	a.updateInfoPanel()
 	a.updateStatusBar()
 	
+	if a.themeErr != nil {
+		a.showError(a.themeErr.Error())
+	}
+	if a.keymapErr != nil {
+		a.showError(a.keymapErr.Error())
+	}
+	if a.scrobblerErr != nil {
+		a.showError(fmt.Sprintf("Scrobbling disabled: %v", a.scrobblerErr))
+	}
+	if a.scrobbler != nil {
+		a.scrobbler.Start()
+		defer a.scrobbler.Close()
+	}
+	
+	if a.settingsManager.Get().RestoreSession {
+		a.restoreSession()
+	}
+	a.runScript()
+	a.refreshStalePodcasts()
+	
 		go a.updateLoop()
 	
-	return a.app.Run()
+	err := a.app.Run()
+	a.saveSession()
+	return err
 }
 
 
@@ -96,6 +260,11 @@ func (a *App) setupUI() {
 	a.searchInput = tview.NewInputField().SetLabel("Search: ")
 	a.progressPanel = tview.NewTextView().SetDynamicColors(true)
 	a.breadcrumb = tview.NewTextView().SetDynamicColors(true)
+	a.nowPlaying = tview.NewTextView().SetDynamicColors(true)
+	a.commandInput = tview.NewInputField().SetLabel(":")
+	a.bottomBar = tview.NewPages().
+		AddPage("status", a.statusBar, true, true).
+		AddPage("command", a.commandInput, true, false)
 	
 	
 	a.songList.SetBorder(true).SetTitle(" Library Browser ")
@@ -107,120 +276,17 @@ func (a *App) setupUI() {
 	a.breadcrumb.SetBorder(true).SetTitle(" Location ")
 	
 	
-	helpStr := `[yellow]Controls:[white]
-[green]Space[white] - Play/Pause    [green]Enter[white] - Play/Browse
-[green]↑/↓[white] - Navigate        [green]n[white] - Next song
-[green]p[white] - Previous song     [green]/[white] - Search
-[green]Esc[white] - Exit search     [green]q[white] - Quit
-[green]+/-[white] - Volume up/down  [green]s[white] - Stop
-[green]L[white] - Repeat mode       [green]B[white] - Toggle progress
-[green]Backspace[white] - Go back   [green]?[white] - Settings info`
-	
-	a.helpText.SetText(helpStr)
-	
-	
 	a.searchInput.SetText("")
 	
 	
 	a.updateComponentVisibility()
+	a.setupLyricsPanel()
 	
 	
-	leftPanel := tview.NewFlex().SetDirection(tview.FlexRow).
-		AddItem(a.breadcrumb, 2, 0, false).
-		AddItem(a.songList, 0, 3, true).
-		AddItem(a.searchInput, 3, 0, false)
-	
-	rightPanel := tview.NewFlex().SetDirection(tview.FlexRow).
-		AddItem(a.infoPanel, 0, 2, false).
-		AddItem(a.helpText, 12, 0, false)
-	
-	mainPanel := tview.NewFlex().SetDirection(tview.FlexColumn).
-		AddItem(leftPanel, 0, 2, true).
-		AddItem(rightPanel, 0, 1, false)
-	
-	
-	var root tview.Primitive
-	if a.settingsManager.Get().ShowProgressBar {
-		root = tview.NewFlex().SetDirection(tview.FlexRow).
-			AddItem(mainPanel, 0, 1, true).
-			AddItem(a.progressPanel, 1, 0, false).
-			AddItem(a.statusBar, 1, 0, false)
-	} else {
-		root = tview.NewFlex().SetDirection(tview.FlexRow).
-			AddItem(mainPanel, 0, 1, true).
-			AddItem(a.statusBar, 1, 0, false)
-	}
-	
-	a.app.SetRoot(root, true)
-}
-
-
-func (a *App) setupKeyBindings() {
-	a.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		if a.isSearchMode {
-			switch event.Key() {
-			case tcell.KeyEscape:
-				a.exitSearchMode()
-				return nil
-			case tcell.KeyEnter:
-				a.performSearch()
-				a.exitSearchMode()
-				return nil
-			}
-			return event 
-		}
-		
-		switch event.Key() {
-		case tcell.KeyRune:
-			switch event.Rune() {
-			case 'q', 'Q':
-				a.app.Stop()
-				return nil
-			case ' ':
-				a.togglePlayPause()
-				return nil
-			case 'n', 'N':
-				a.nextSong()
-				return nil
-			case 'p', 'P':
-				a.previousSong()
-				return nil
-			case 's', 'S':
-				a.stopPlayback()
-				return nil
-			case '/':
-				a.enterSearchMode()
-				return nil
-			case '+', '=':
-				a.increaseVolume()
-				return nil
-			case '-':
-				a.decreaseVolume()
-				return nil
-			case 'l', 'L':
-				a.cycleRepeatMode()
-				return nil
-			case 'b', 'B':
-				a.toggleProgressBar()
-				return nil
-			case '?':
-				a.showSettingsInfo()
-				return nil
-				return nil
-			}
-		case tcell.KeyEnter:
-			a.handleSelection()
-			return nil
-		case tcell.KeyBackspace, tcell.KeyBackspace2:
-			a.navigateBack()
-			return nil
-		}
-		return event
-	})
-	
-		a.songList.SetSelectedFunc(func(index int, mainText, secondaryText string, shortcut rune) {
-		a.handleSelection()
-	})
+	a.applyThemeColors()
+	a.setupMouse()
+	a.app.SetBeforeDrawFunc(a.autoLayout)
+	a.applyLayout()
 }
 
 
@@ -229,11 +295,11 @@ func (a *App) populateSongList() {
 	
 	for i, song := range a.filteredSongs {
 		mainText := fmt.Sprintf("%s - %s", song.Artist, song.Title)
-		secondaryText := fmt.Sprintf("%s | %s", song.Album, a.formatDuration(song.Duration))
+		secondaryText := a.songSecondaryText(song)
 		
 		
 		if a.player.GetCurrentSong() == song.FilePath {
-			mainText = fmt.Sprintf("[yellow]♪ %s[white]", mainText)
+			mainText = fmt.Sprintf(markup("[playing]♪ %s[/]"), mainText)
 		}
 		
 		a.songList.AddItem(mainText, secondaryText, 0, nil)
@@ -247,6 +313,12 @@ func (a *App) populateSongList() {
 
 
 func (a *App) updateInfoPanel() {
+	a.updateNowPlaying()
+	if a.statsVisible {
+		a.infoPanel.SetText(a.statsText())
+		return
+	}
+	
 	state := a.player.GetState()
 	
 	if state.CurrentSong == "" {
@@ -254,45 +326,56 @@ func (a *App) updateInfoPanel() {
 		if currentIdx >= 0 && currentIdx < len(a.currentItems) {
 			item := a.currentItems[currentIdx]
 			
-			if item.Type == library.ItemTypeFolder {
+			if a.podcast.visible {
+				a.infoPanel.SetText(a.podcastItemText(item))
+			} else if item.Type == library.ItemTypeFolder {
 								var info strings.Builder
 				if item.Name == ".." {
-					info.WriteString("[blue]📁 Parent Directory[white]\n\n")
-					info.WriteString("[dim]Press Enter to go back[white]")
+					info.WriteString(markup("[folder]📁 Parent Directory[/]\n\n"))
+					info.WriteString(markup("[muted]Press Enter to go back[/]"))
 				} else {
-					info.WriteString(fmt.Sprintf("[blue]📁 Playlist: %s[white]\n\n", item.Name))
-					info.WriteString(fmt.Sprintf("[green]Songs:[white] %d\n\n", item.SongCount))
-					info.WriteString("[dim]Press Enter to browse[white]")
+					info.WriteString(fmt.Sprintf(markup("[folder]📁 Playlist: %s[/]\n\n"), item.Name))
+					info.WriteString(fmt.Sprintf(markup("[label]Songs:[/] %d\n\n"), item.SongCount))
+					info.WriteString(markup("[muted]Press Enter to browse[/]"))
 				}
 				a.infoPanel.SetText(info.String())
+			} else if item.Song != nil && stream.IsURL(item.Song.FilePath) {
+				a.infoPanel.SetText(stationText(*item.Song))
 			} else if item.Song != nil {
 								song := item.Song
 				var info strings.Builder
-				info.WriteString("[yellow]Selected:[white]\n")
+				info.WriteString(markup("[accent]Selected:[/]\n"))
 				
 								if song.AlbumArt != nil {
 					info.WriteString(song.AlbumArt.GetColorizedASCII())
 					info.WriteString("\n\n")
 				}
 				
-				info.WriteString(fmt.Sprintf(`[green]Title:[white] %s
-[green]Artist:[white] %s
-[green]Album:[white] %s
-[green]Year:[white] %s
-[green]Genre:[white] %s
-[green]Duration:[white] %s
-[green]File:[white] %s
-
-[dim]Press Enter to play[white]`,
-					song.Title, song.Artist, song.Album, song.Year, 
+				info.WriteString(fmt.Sprintf(markup(`[label]Title:[/] %s
+[label]Artist:[/] %s
+[label]Album:[/] %s
+%s[label]Year:[/] %s
+[label]Genre:[/] %s
+[label]Duration:[/] %s
+[label]Rating:[/] %s
+[label]File:[/] %s
+[label]Tags:[/] %s%s
+
+[muted]Press Enter to play, 0-5 to rate, f to love[/]`),
+					song.Title, song.Artist, song.Album, albumDetails(*song, "label"), song.Year, 
 					song.Genre, a.formatDuration(song.Duration),
-					filepath.Base(song.FilePath)))
+					ratingText(*song), filepath.Base(song.FilePath), tagSourceText(*song),
+					chapterText(*song, -1, "label")))
 				
 				a.infoPanel.SetText(info.String())
 			}
 		} else {
-			a.infoPanel.SetText("[red]No item selected[white]")
+			a.infoPanel.SetText(markup("[error]No item selected[/]"))
 		}
+	} else if info, ok := a.player.StreamInfo(); ok {
+		a.infoPanel.SetText(a.streamInfoText(state, info))
+	} else if show, episode, ok := a.podcasts.EpisodeByFile(state.CurrentSong); ok {
+		a.infoPanel.SetText(a.episodeInfoText(state, show, episode))
 	} else {
 				var currentSong *library.Song
 		for _, song := range a.songs {
@@ -303,11 +386,11 @@ func (a *App) updateInfoPanel() {
 		}
 		
 		if currentSong != nil {
-			status := "[red]Stopped[white]"
+			status := markup("[stopped]Stopped[/]")
 			if state.IsPlaying {
-				status = "[yellow]♪ Playing[white]"
+				status = markup("[playing]♪ Playing[/]")
 			} else if state.IsPaused {
-				status = "[yellow]⏸ Paused[white]"
+				status = markup("[paused]⏸ Paused[/]")
 			}
 			
 			
@@ -319,18 +402,21 @@ func (a *App) updateInfoPanel() {
 				info.WriteString("\n\n")
 			}
 			
-			info.WriteString(fmt.Sprintf(`[yellow]Title:[white] %s
-[yellow]Artist:[white] %s
-[yellow]Album:[white] %s
-[yellow]Year:[white] %s
-[yellow]Genre:[white] %s
-[yellow]Duration:[white] %s
-[yellow]Volume:[white] %.0f%%
-[yellow]Repeat:[white] %s
-[yellow]File:[white] %s`,
+			info.WriteString(fmt.Sprintf(markup(`[accent]Title:[/] %s
+[accent]Artist:[/] %s
+[accent]Album:[/] %s
+%s[accent]Year:[/] %s
+[accent]Genre:[/] %s
+[accent]Duration:[/] %s
+[accent]Rating:[/] %s
+[accent]Volume:[/] %.0f%%
+[accent]Repeat:[/] %s
+[accent]File:[/] %s
+[accent]Tags:[/] %s%s`),
 				currentSong.Title, currentSong.Artist, currentSong.Album,
-				currentSong.Year, currentSong.Genre, a.formatDuration(currentSong.Duration),
-				state.Volume*100, repeatModeToString(state.RepeatMode), filepath.Base(currentSong.FilePath)))
+				albumDetails(*currentSong, "accent"), currentSong.Year, currentSong.Genre, a.formatDuration(currentSong.Duration),
+				ratingText(*currentSong), state.Volume*100, repeatModeToString(state.RepeatMode), filepath.Base(currentSong.FilePath),
+				tagSourceText(*currentSong), chapterText(*currentSong, currentSong.ChapterAt(state.Position), "accent")))
 			
 			a.infoPanel.SetText(info.String())
 		}
@@ -339,6 +425,11 @@ func (a *App) updateInfoPanel() {
 
 
 func (a *App) updateStatusBar() {
+	// Leave messages up until they have been seen
+	if time.Now().Before(a.statusHold) {
+		return
+	}
+	
 	totalSongs := len(a.songs)
 	filteredSongs := len(a.filteredSongs)
 	
@@ -359,9 +450,13 @@ func (a *App) updateStatusBar() {
 		statusText += progress
 	}
 	
+	if queued := a.queued(); queued > 0 {
+		statusText += fmt.Sprintf(" | %d queued", queued)
+	}
+	
 	
 	if a.isSearchMode {
-		statusText += " | [yellow]SEARCH MODE[white]"
+		statusText += markup(" | [accent]SEARCH MODE[/]")
 	}
 	
 	a.statusBar.SetText(statusText)
@@ -374,6 +469,19 @@ func (a *App) playSelected() {
 		song := a.filteredSongs[currentIdx]
 		a.currentIdx = currentIdx
 		
+		if daemon, ok := a.player.(daemonPlayer); ok {
+			paths := make([]string, len(a.filteredSongs))
+			for i, song := range a.filteredSongs {
+				paths[i] = song.FilePath
+			}
+			if err := daemon.PlayList(paths, currentIdx); err != nil {
+				a.showError(fmt.Sprintf("Error playing %s: %v", song.Title, err))
+			} else {
+				a.populateSongList()
+			}
+			return
+		}
+		
 		if err := a.player.Play(song.FilePath); err != nil {
 			a.showError(fmt.Sprintf("Error playing %s: %v", song.Title, err))
 		} else {
@@ -394,17 +502,37 @@ func (a *App) togglePlayPause() {
 
 
 func (a *App) nextSong() {
+	if daemon, ok := a.player.(daemonPlayer); ok {
+		if err := daemon.Next(); err != nil {
+			a.showError(err.Error())
+		}
+		return
+	}
+	if song, ok := a.dequeue(); ok {
+		a.playSpecificSong(&song)
+		return
+	}
 	if len(a.filteredSongs) == 0 {
 		return
 	}
 	
-	a.currentIdx = (a.currentIdx + 1) % len(a.filteredSongs)
+	if a.settingsManager.Get().Shuffle {
+		a.currentIdx = library.WeightedPick(a.filteredSongs, a.currentIdx)
+	} else {
+		a.currentIdx = (a.currentIdx + 1) % len(a.filteredSongs)
+	}
 	a.songList.SetCurrentItem(a.currentIdx)
 	a.playSelected()
 }
 
 
 func (a *App) previousSong() {
+	if daemon, ok := a.player.(daemonPlayer); ok {
+		if err := daemon.Previous(); err != nil {
+			a.showError(err.Error())
+		}
+		return
+	}
 	if len(a.filteredSongs) == 0 {
 		return
 	}
@@ -443,6 +571,10 @@ func (a *App) decreaseVolume() {
 
 
 func (a *App) enterSearchMode() {
+	if a.layout == layoutMini {
+		a.flashStatus("Search needs a larger layout")
+		return
+	}
 	a.isSearchMode = true
 	a.app.SetFocus(a.searchInput)
 	a.searchInput.SetText("")
@@ -457,23 +589,26 @@ func (a *App) exitSearchMode() {
 
 
 func (a *App) performSearch() {
-	query := strings.TrimSpace(a.searchInput.GetText())
+	a.applySearch(strings.TrimSpace(a.searchInput.GetText()))
+}
+
+
+// applySearch filters the song list by query; an empty query shows every song
+func (a *App) applySearch(query string) {
+	a.searchQuery = query
+	a.radioVisible = false
+	a.podcast.visible = false
 	
 	if query == "" {
 		
 		a.filteredSongs = a.songs
 	} else {
 		
-		a.filteredSongs = make([]library.Song, 0)
-		queryLower := strings.ToLower(query)
+		a.filteredSongs = library.ParseQuery(query).Filter(a.songs)
+		library.SortSongs(a.filteredSongs, a.sortOrder)
 		
-		for _, song := range a.songs {
-			if strings.Contains(strings.ToLower(song.Title), queryLower) ||
-			   strings.Contains(strings.ToLower(song.Artist), queryLower) ||
-			   strings.Contains(strings.ToLower(song.Album), queryLower) {
-				a.filteredSongs = append(a.filteredSongs, song)
-			}
-		}
+		// Keep selection in step with the list so Enter plays the match
+		a.currentItems = songItems(a.filteredSongs)
 	}
 	
 	a.populateSongList()
@@ -493,7 +628,8 @@ func (a *App) formatDuration(d time.Duration) string {
 func (a *App) showError(message string) {
 	
 	
-	a.statusBar.SetText(fmt.Sprintf(" [red]ERROR:[white] %s", message))
+	a.statusBar.SetText(fmt.Sprintf(markup(" [error]ERROR:[/] %s"), message))
+	a.statusHold = time.Now().Add(errorDuration)
 }
 
 
@@ -501,19 +637,27 @@ func (a *App) updateLoop() {
 	ticker := time.NewTicker(500 * time.Millisecond) 
 	defer ticker.Stop()
 	
+	ticks := 0
 	for {
 		select {
 		case <-ticker.C:
+			ticks++
+			if ticks%sessionSaveTicks == 0 {
+				a.app.QueueUpdate(a.saveSession)
+			}
 			a.app.QueueUpdateDraw(func() {
 				state := a.player.GetState()
 				
 				
 				a.progressBar.Update(state.Position, state.Duration)
 				a.updateProgressPanel()
+				a.player.SetNext(a.upcomingSong())
 				
 				
 				a.updateInfoPanel()
+				a.updateLyricsPanel()
 				a.updateStatusBar()
+				a.updateRadio(state)
 				
 				
 				if a.player.GetCurrentSong() != "" && a.player.IsFinished() {
@@ -526,6 +670,14 @@ func (a *App) updateLoop() {
 
 
 func (a *App) handleSongFinished() {
+	if a.player.IsStream() {
+		a.handleStreamFinished()
+		return
+	}
+	if _, _, ok := a.podcasts.EpisodeByFile(a.player.GetCurrentSong()); ok {
+		a.handleEpisodeFinished()
+		return
+	}
 	state := a.player.GetState()
 	
 	
@@ -570,23 +722,15 @@ func (a *App) cycleRepeatMode() {
 	}
 	
 	
-	originalUpdate := a.updateStatusBar
-	a.statusBar.SetText(fmt.Sprintf(" [yellow]%s[white]", modeText))
-	go func() {
-		time.Sleep(2 * time.Second)
-		a.app.QueueUpdateDraw(originalUpdate)
-	}()
+	a.flashStatus(modeText)
 }
 
 
 func (a *App) toggleProgressBar() {
 	a.settingsManager.ToggleProgressBar()
 	a.updateComponentVisibility()
-	a.setupUI() 
-	
-	
-	a.populateSongList()
-	a.updateInfoPanel()
+	a.applyLayout()
+	a.updateProgressPanel()
 	
 	
 	status := "off"
@@ -594,12 +738,7 @@ func (a *App) toggleProgressBar() {
 		status = "on"
 	}
 	
-	originalUpdate := a.updateStatusBar
-	a.statusBar.SetText(fmt.Sprintf(" [yellow]Progress bar: %s[white]", status))
-	go func() {
-		time.Sleep(2 * time.Second)
-		a.app.QueueUpdateDraw(originalUpdate)
-	}()
+	a.flashStatus(fmt.Sprintf("Progress bar: %s", status))
 }
 
 
@@ -615,6 +754,11 @@ func (a *App) updateComponentVisibility() {
 
 func (a *App) updateProgressPanel() {
 	if a.progressPanel != nil && a.settingsManager.Get().ShowProgressBar {
+		if _, _, width, _ := a.progressPanel.GetInnerRect(); width > 0 {
+			a.progressBar.SetWidth(width)
+		}
+		song, _ := a.library.FindSong(a.player.GetCurrentSong())
+		a.progressBar.SetMarks(chapterStarts(song))
 		content := a.progressBar.Render()
 		a.progressPanel.SetText(content)
 	}
@@ -626,7 +770,7 @@ func (a *App) showSettingsInfo() {
 	state := a.player.GetState()
 	
 	var info strings.Builder
-	info.WriteString("[yellow]Settings:[white]\n\n")
+	info.WriteString(markup("[accent]Settings:[/]\n\n"))
 	info.WriteString(fmt.Sprintf("Progress Bar: %s\n", boolToOnOff(settings.ShowProgressBar)))
 	info.WriteString(fmt.Sprintf("Repeat Mode: %s\n", repeatModeToString(state.RepeatMode)))
 	info.WriteString(fmt.Sprintf("Volume: %.0f%%\n", state.Volume*100))
@@ -646,25 +790,29 @@ func (a *App) showSettingsInfo() {
 
 func boolToOnOff(b bool) string {
 	if b {
-		return "[green]On[white]"
+		return markup("[enabled]On[/]")
 	}
-	return "[red]Off[white]"
+	return markup("[muted]Off[/]")
 }
 
 func repeatModeToString(mode settings.RepeatMode) string {
 	switch mode {
 	case settings.RepeatNone:
-		return "[dim]None[white]"
+		return markup("[muted]None[/]")
 	case settings.RepeatSingle:
-		return "[yellow]Single[white]"
+		return markup("[accent]Single[/]")
 	case settings.RepeatAll:
-		return "[green]All[white]"
+		return markup("[enabled]All[/]")
 	default:
-		return "[dim]Unknown[white]"
+		return markup("[muted]Unknown[/]")
 	}
 }
 
 func (a *App) populateLibraryList() {
+	if a.podcast.visible {
+		a.populatePodcastList()
+		return
+	}
 	a.songList.Clear()
 	
 	for i, item := range a.currentItems {
@@ -672,19 +820,23 @@ func (a *App) populateLibraryList() {
 		
 		if item.Type == library.ItemTypeFolder {
 			if item.Name == ".." {
-				mainText = "[blue]📁 .. (Back)[white]"
+				mainText = markup("[folder]📁 .. (Back)[/]")
 				secondaryText = ""
 			} else {
-				mainText = fmt.Sprintf("[blue]📁 %s[white]", item.Name)
+				mainText = fmt.Sprintf(markup("[folder]📁 %s[/]"), item.Name)
 				secondaryText = fmt.Sprintf("%d songs", item.SongCount)
 			}
 		} else {
 						song := item.Song
 			mainText = fmt.Sprintf("%s - %s", song.Artist, song.Title)
-			secondaryText = fmt.Sprintf("%s | %s", song.Album, a.formatDuration(song.Duration))
+			secondaryText = a.songSecondaryText(*song)
+			if stream.IsURL(song.FilePath) {
+				mainText = "📻 " + tview.Escape(song.Title)
+				secondaryText = song.FilePath
+			}
 			
 						if a.player.GetCurrentSong() == song.FilePath {
-				mainText = fmt.Sprintf("[yellow]♪ %s[white]", mainText)
+				mainText = fmt.Sprintf(markup("[playing]♪ %s[/]"), mainText)
 				a.songList.SetCurrentItem(i)
 			}
 		}
@@ -695,16 +847,46 @@ func (a *App) populateLibraryList() {
 
 func (a *App) updateBreadcrumb() {
 	if a.library == nil {
-		a.breadcrumb.SetText(" [dim]No library loaded[white]")
+		a.breadcrumb.SetText(markup(" [muted]No library loaded[/]"))
+		return
+	}
+	
+	var text strings.Builder
+	text.WriteString(" ")
+	if a.radioVisible {
+		text.WriteString(markup("[accent]") + tview.Escape("[Radio]") + markup("[/] [muted]>[/] [accent]Stations[/]"))
+		a.breadcrumb.SetText(text.String())
+		return
+	}
+	if a.podcast.visible {
+		text.WriteString(markup("[accent]") + tview.Escape("[Podcasts]") + markup("[/]"))
+		if show, ok := a.podcasts.Show(a.podcast.show); ok {
+			text.WriteString(markup(" [muted]>[/] [accent]") + tview.Escape(show.Title) + markup("[/]"))
+		} else {
+			text.WriteString(markup(" [muted]>[/] [accent]Shows[/]"))
+		}
+		a.breadcrumb.SetText(text.String())
 		return
 	}
+	current := a.library.BrowseMode()
+	for _, mode := range library.BrowseModes {
+		if mode == current {
+			text.WriteString(markup("[accent]") + tview.Escape(fmt.Sprintf("[%s]", mode)) + markup("[/] "))
+		} else {
+			text.WriteString(fmt.Sprintf(markup("[muted]%s[/] "), mode))
+		}
+	}
 	
-	relativePath := a.library.GetRelativePath()
-	if relativePath == "/" {
-		a.breadcrumb.SetText(" [cyan]Music Library[white] [dim]>[white] [yellow]Root[white]")
+	crumbs := a.library.Breadcrumb()
+	if len(crumbs) == 0 {
+		text.WriteString(markup(" [info]Music Library[/] [muted]>[/] [accent]Root[/]"))
 	} else {
-		a.breadcrumb.SetText(fmt.Sprintf(" [cyan]Music Library[white] [dim]>[white] [yellow]%s[white]", relativePath))
+		text.WriteString(markup(" [info]Music Library[/]"))
+		for _, crumb := range crumbs {
+			text.WriteString(fmt.Sprintf(markup(" [muted]>[/] [accent]%s[/]"), tview.Escape(crumb)))
+		}
 	}
+	a.breadcrumb.SetText(text.String())
 }
 
 func (a *App) handleSelection() {
@@ -715,23 +897,14 @@ func (a *App) handleSelection() {
 	
 	item := a.currentItems[currentIdx]
 	
-	if item.Type == library.ItemTypeFolder {
-				err := a.library.NavigateToFolder(item.Path)
-		if err != nil {
-			a.statusBar.SetText(fmt.Sprintf(" [red]Error: %v[white]", err))
+	if a.podcast.visible {
+		a.selectPodcastItem(item)
+	} else if item.Type == library.ItemTypeFolder {
+		if err := a.library.Open(item); err != nil {
+			a.statusBar.SetText(fmt.Sprintf(markup(" [error]Error: %v[/]"), err))
 			return
 		}
-		
-				items, err := a.library.GetCurrentItems()
-		if err != nil {
-			a.statusBar.SetText(fmt.Sprintf(" [red]Error loading folder: %v[white]", err))
-			return
-		}
-		
-		a.currentItems = items
-		a.populateLibraryList()
-		a.updateBreadcrumb()
-		a.updateInfoPanel()
+		a.reloadItems()
 		
 	} else {
 				a.playSelectedSong(item.Song)
@@ -739,26 +912,36 @@ func (a *App) handleSelection() {
 }
 
 func (a *App) navigateBack() {
+	if a.radioVisible {
+		a.hideRadio()
+		return
+	}
+	if a.podcast.visible {
+		a.podcastBack()
+		return
+	}
 	if a.library == nil || !a.library.CanGoBack() {
 		return
 	}
 	
-	currentPath := a.library.GetCurrentPath()
-	parentPath := filepath.Dir(currentPath)
-	
-	err := a.library.NavigateToFolder(parentPath)
-	if err != nil {
-		a.statusBar.SetText(fmt.Sprintf(" [red]Error: %v[white]", err))
+	if err := a.library.GoBack(); err != nil {
+		a.statusBar.SetText(fmt.Sprintf(markup(" [error]Error: %v[/]"), err))
 		return
 	}
-	
-		items, err := a.library.GetCurrentItems()
+	a.reloadItems()
+}
+
+// reloadItems refills the browser with the items at the library's current
+// location
+func (a *App) reloadItems() {
+	items, err := a.library.GetCurrentItems()
 	if err != nil {
-		a.statusBar.SetText(fmt.Sprintf(" [red]Error loading folder: %v[white]", err))
+		a.statusBar.SetText(fmt.Sprintf(markup(" [error]Error loading folder: %v[/]"), err))
 		return
 	}
 	
 	a.currentItems = items
+	library.SortItems(a.currentItems, a.sortOrder)
 	a.populateLibraryList()
 	a.updateBreadcrumb()
 	a.updateInfoPanel()
@@ -768,6 +951,10 @@ func (a *App) playSelectedSong(song *library.Song) {
 	if song == nil {
 		return
 	}
+	if stream.IsURL(song.FilePath) {
+		a.playStream(song.FilePath, song.Title)
+		return
+	}
 	
 		for i, s := range a.songs {
 		if s.FilePath == song.FilePath {
@@ -778,11 +965,12 @@ func (a *App) playSelectedSong(song *library.Song) {
 	
 		err := a.player.Play(song.FilePath)
 	if err != nil {
-		a.statusBar.SetText(fmt.Sprintf(" [red]Error playing: %v[white]", err))
+		a.statusBar.SetText(fmt.Sprintf(markup(" [error]Error playing: %v[/]"), err))
 		return
 	}
 	
-	a.populateLibraryList() 	a.updateInfoPanel()
+	a.populateLibraryList()
+	a.updateInfoPanel()
 }
 
 func (a *App) getCurrentPlayableSongs() []library.Song {
@@ -804,10 +992,14 @@ func (a *App) playSpecificSong(song *library.Song) {
 	if song == nil {
 		return
 	}
+	if stream.IsURL(song.FilePath) {
+		a.playStream(song.FilePath, song.Title)
+		return
+	}
 	
 	err := a.player.Play(song.FilePath)
 	if err != nil {
-		a.statusBar.SetText(fmt.Sprintf(" [red]Error playing: %v[white]", err))
+		a.statusBar.SetText(fmt.Sprintf(markup(" [error]Error playing: %v[/]"), err))
 		return
 	}
 	
@@ -820,4 +1012,95 @@ func (a *App) playSpecificSong(song *library.Song) {
 			break
 		}
 	}
-}
\ No newline at end of file
+}
+
+
+// songItems wraps songs as library items for the browser list
+func songItems(songs []library.Song) []library.LibraryItem {
+	items := make([]library.LibraryItem, 0, len(songs))
+	for i := range songs {
+		song := songs[i]
+		items = append(items, library.LibraryItem{
+			Type: library.ItemTypeSong,
+			Name: fmt.Sprintf("%s - %s", song.Artist, song.Title),
+			Path: song.FilePath,
+			Song: &song,
+		})
+	}
+	return items
+}
+
+func (a *App) songSecondaryText(song library.Song) string {
+	text := fmt.Sprintf("%s | %s", song.Album, a.formatDuration(song.Duration))
+	if badge := ratingBadge(song); badge != "" {
+		text += " | " + badge
+	}
+	if song.PlayCount > 0 {
+		text += fmt.Sprintf(" | %d plays", song.PlayCount)
+	}
+	return text
+}
+
+func (a *App) cycleSortOrder() {
+	a.sortOrder = a.sortOrder.Next()
+	
+	library.SortItems(a.currentItems, a.sortOrder)
+	library.SortSongs(a.filteredSongs, a.sortOrder)
+	a.populateLibraryList()
+	a.updateInfoPanel()
+	
+	a.flashStatus(fmt.Sprintf("Sort: %s", a.sortOrder))
+}
+
+// cycleStatsView steps the info panel through weekly, monthly and all-time
+// stats and back to the normal view
+func (a *App) cycleStatsView() {
+	switch {
+	case !a.statsVisible:
+		a.statsVisible = true
+		a.statsPeriod = history.PeriodWeek
+	case a.statsPeriod == history.PeriodAllTime:
+		a.statsVisible = false
+	default:
+		a.statsPeriod++
+	}
+	a.stats = nil
+	a.updateInfoPanel()
+}
+
+func (a *App) statsText() string {
+	if a.stats == nil {
+		stats, err := a.history.Stats(a.statsPeriod, 5)
+		if err != nil {
+			return fmt.Sprintf(markup("[error]Error loading history: %v[/]"), err)
+		}
+		a.stats = &stats
+	}
+	stats := a.stats
+	
+	var info strings.Builder
+	info.WriteString(fmt.Sprintf(markup("[accent]Listening Stats — %s[/]\n\n"), stats.Period))
+	info.WriteString(fmt.Sprintf(markup("[label]Plays:[/] %d\n"), stats.Plays))
+	info.WriteString(fmt.Sprintf(markup("[label]Listening time:[/] %.1f hours\n"), stats.TotalHours))
+	
+	sections := []struct {
+		title   string
+		entries []history.Entry
+	}{
+		{"Top Artists", stats.TopArtists},
+		{"Top Albums", stats.TopAlbums},
+		{"Top Tracks", stats.TopTracks},
+	}
+	for _, section := range sections {
+		info.WriteString(fmt.Sprintf(markup("\n[accent]%s[/]\n"), section.title))
+		if len(section.entries) == 0 {
+			info.WriteString(markup("[muted]Nothing played yet[/]\n"))
+		}
+		for i, entry := range section.entries {
+			info.WriteString(fmt.Sprintf(markup("%d. %s [muted](%d)[/]\n"), i+1, entry.Name, entry.Plays))
+		}
+	}
+	
+	info.WriteString(markup("\n[muted]Press t for the next period[/]"))
+	return info.String()
+}